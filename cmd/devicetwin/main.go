@@ -20,30 +20,110 @@
 package main
 
 import (
+	"context"
 	"github.com/canonical/iot-devicetwin/config"
 	"github.com/canonical/iot-devicetwin/service/controller"
 	"github.com/canonical/iot-devicetwin/service/devicetwin"
 	"github.com/canonical/iot-devicetwin/service/factory"
-	"github.com/canonical/iot-devicetwin/service/mqtt"
+	"github.com/canonical/iot-devicetwin/service/leader"
 	"github.com/canonical/iot-devicetwin/web"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	// Run the schema migrations on demand
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(os.Args[2:]); err != nil {
+			log.Fatalf("Error migrating the database schema: %v", err)
+		}
+		return
+	}
+
+	// Re-encrypt the sensitive fields with the current secret on demand
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		if err := rotateKeys(os.Args[2:]); err != nil {
+			log.Fatalf("Error rotating the encryption keys: %v", err)
+		}
+		return
+	}
+
+	// Copy the records of an organization between data stores on demand
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := exportOrg(os.Args[2:]); err != nil {
+			log.Fatalf("Error exporting the organization: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := importOrg(os.Args[2:]); err != nil {
+			log.Fatalf("Error importing the organization: %v", err)
+		}
+		return
+	}
+
 	// Set up the dependency chain
 	settings := config.ParseArgs()
 	db, err := factory.CreateDataStore(settings)
 	if err != nil {
 		log.Fatalf("Error connecting to data store: %v", err)
 	}
-	m, err := mqtt.GetConnection(settings)
+	m, err := factory.CreateMQTT(settings)
 	if err != nil {
 		log.Fatalf("Error connecting to MQTT broker: %v", err)
 	}
-	twin := devicetwin.NewService(settings, db)
+	bus, err := factory.CreateEventBus(settings)
+	if err != nil {
+		log.Fatalf("Error creating the event bus: %v", err)
+	}
+	elector, err := factory.CreateElector(settings)
+	if err != nil {
+		log.Fatalf("Error creating the leader election: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	twin := devicetwin.NewServiceWithEvents(settings, db, bus)
+
+	// Run the background jobs, which write the buffered heartbeats when they are stopped
+	jobs := leader.NewJobs(elector)
+	twin.RegisterJobs(jobs)
+	jobsDone := make(chan struct{})
+	go func() {
+		jobs.Run(ctx)
+		close(jobsDone)
+	}()
 	ctrl := controller.NewService(settings, m, twin)
 
 	// Start the web API service
 	w := web.NewService(settings, ctrl)
-	log.Fatal(w.Run())
+	errc := make(chan error, 1)
+	go func() { errc <- w.Run() }()
+
+	// Run until the service fails or is stopped
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err = <-errc:
+	case s := <-stop:
+		log.Printf("Stopping the service on %v", s)
+	}
+
+	// Stop handling the messages from the devices, and write the buffered heartbeats
+	m.Close()
+	ctrl.Close()
+	cancel()
+	<-jobsDone
+	if e := elector.Close(); e != nil {
+		log.Printf("Error closing the leader election: %v", e)
+	}
+	if e := bus.Close(); e != nil {
+		log.Printf("Error closing the event bus: %v", e)
+	}
+	if e := closeDataStore(db); e != nil {
+		log.Printf("Error closing the data store: %v", e)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
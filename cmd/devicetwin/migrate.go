@@ -0,0 +1,74 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/datastore/postgres"
+	"github.com/canonical/iot-devicetwin/datastore/sqlite"
+	"github.com/canonical/iot-devicetwin/datastore/sqlstore"
+)
+
+// sqlDrivers opens the databases of the data repository drivers that use a SQL database
+var sqlDrivers = map[string]func(dataSource string) (*sqlstore.DataStore, error){
+	"postgres": postgres.Open,
+	"sqlite":   sqlite.Open,
+}
+
+// migrate upgrades or downgrades the database schema, without starting the service
+func migrate(args []string) error {
+	var (
+		driver     string
+		datasource string
+		version    int
+	)
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.StringVar(&driver, "driver", "postgres", "The data repository driver")
+	flags.StringVar(&datasource, "datasource", config.DefaultDataSource, "The data repository data source")
+	flags.IntVar(&version, "version", -1, "The schema version to migrate to (default the latest)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	open, ok := sqlDrivers[driver]
+	if !ok {
+		return fmt.Errorf("the `%s` driver does not have a versioned schema", driver)
+	}
+
+	db, err := open(datasource)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version < 0 {
+		version = db.LatestSchemaVersion()
+	}
+	log.Printf("Migrating the schema from version %d to %d\n", current, version)
+
+	return db.MigrateTo(version)
+}
@@ -0,0 +1,69 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/datastore/crypt"
+	"github.com/canonical/iot-devicetwin/service/factory"
+)
+
+// rotateKeys re-encrypts the sensitive fields with the current secret, so that the previous
+// secrets can be removed from the secrets file
+func rotateKeys(args []string) error {
+	var (
+		driver     string
+		datasource string
+		configDir  string
+	)
+	flags := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	flags.StringVar(&driver, "driver", config.DefaultDriver, "The data repository driver: memory, postgres or sqlite")
+	flags.StringVar(&datasource, "datasource", config.DefaultDataSource, "The data repository data source")
+	flags.StringVar(&configDir, "configdir", config.DefaultConfigPath, "Directory path to the config file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	secret, oldSecrets, err := config.ReadSecrets(config.SecretPath(configDir))
+	if err != nil {
+		return err
+	}
+	keys, err := crypt.NewKeyring(append([]string{secret}, oldSecrets...)...)
+	if err != nil {
+		return err
+	}
+
+	// Open the data store without encryption, to read the stored values
+	db, err := factory.CreateDataStore(&config.Settings{Driver: driver, DataSource: datasource})
+	if err != nil {
+		return err
+	}
+
+	n, err := crypt.NewStore(db, keys).Rotate(context.Background())
+	if err != nil {
+		return err
+	}
+	log.Printf("Re-encrypted %d fields with the current secret\n", n)
+	return nil
+}
@@ -0,0 +1,221 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/datastore"
+	"github.com/canonical/iot-devicetwin/datastore/crypt"
+	"github.com/canonical/iot-devicetwin/domain"
+	"github.com/canonical/iot-devicetwin/service/devicetwin"
+	"github.com/canonical/iot-devicetwin/service/factory"
+)
+
+// transferFlags are the options of the export and import subcommands
+type transferFlags struct {
+	driver     string
+	datasource string
+	configDir  string
+	orgID      string
+	format     string
+	file       string
+}
+
+// parseTransferFlags parses the options of the export or import subcommand
+func parseTransferFlags(name, fileUsage string, args []string) (transferFlags, error) {
+	f := transferFlags{}
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.StringVar(&f.driver, "driver", config.DefaultDriver, "The data repository driver: memory, postgres or sqlite")
+	flags.StringVar(&f.datasource, "datasource", config.DefaultDataSource, "The data repository data source")
+	flags.StringVar(&f.configDir, "configdir", config.DefaultConfigPath, "Directory path to the config file")
+	flags.StringVar(&f.orgID, "org", "", "The organization ID")
+	flags.StringVar(&f.format, "format", "json", "The format of the data: json, or csv for just the devices")
+	flags.StringVar(&f.file, "file", "-", fileUsage)
+	if err := flags.Parse(args); err != nil {
+		return f, err
+	}
+
+	if f.format != "json" && f.format != "csv" {
+		return f, fmt.Errorf("invalid format `%s`, expected json or csv", f.format)
+	}
+	return f, nil
+}
+
+// openStore opens the data store with the encryption secrets from the config directory,
+// returning a function to close it
+func (f transferFlags) openStore() (*devicetwin.Service, func(), error) {
+	settings := &config.Settings{Driver: f.driver, DataSource: f.datasource}
+	secret, oldSecrets, err := config.ReadSecrets(config.SecretPath(f.configDir))
+	switch {
+	case err == nil:
+		settings.KeySecret, settings.OldSecrets = secret, oldSecrets
+	case os.IsNotExist(err):
+		log.Printf("The secrets file is missing, so the sensitive fields are not encrypted\n")
+	default:
+		return nil, nil, err
+	}
+
+	db, err := factory.CreateDataStore(settings)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	closeStore := func() {
+		if err := closeDataStore(db); err != nil {
+			log.Printf("Error closing the data store: %v\n", err)
+		}
+	}
+	return devicetwin.NewService(settings, db), closeStore, nil
+}
+
+// closeDataStore closes the store under the encryption, e.g. to snapshot a memory store
+func closeDataStore(db datastore.DataStore) error {
+	if s, ok := db.(*crypt.Store); ok {
+		db = s.DataStore
+	}
+	if c, ok := db.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// exportOrg writes the records of an organization as versioned JSON, or the devices as CSV
+func exportOrg(args []string) error {
+	f, err := parseTransferFlags("export", "The file to write the export to, - for standard output", args)
+	if err != nil {
+		return err
+	}
+	if len(f.orgID) == 0 {
+		return fmt.Errorf("the organization ID is required")
+	}
+
+	twin, closeStore, err := f.openStore()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	w := os.Stdout
+	if f.file != "-" {
+		if w, err = os.OpenFile(f.file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600); err != nil {
+			return err
+		}
+	}
+
+	buf := bufio.NewWriter(w)
+	enc := &exportCounter{ExportEncoder: devicetwin.NewExportEncoder(buf, f.orgID, f.format)}
+	err = twin.Export(context.Background(), f.orgID, enc)
+	if err == nil {
+		err = enc.Close()
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		_ = w.Close()
+		return err
+	}
+	log.Printf("Exported %d devices, %d groups, %d links and %d actions\n", enc.devices, enc.groups, enc.links, enc.actions)
+	return w.Close()
+}
+
+// exportCounter counts the records written to an export encoder
+type exportCounter struct {
+	devicetwin.ExportEncoder
+	devices, groups, links, actions int
+}
+
+func (c *exportCounter) WriteDevice(d domain.ExportDevice) error {
+	c.devices++
+	return c.ExportEncoder.WriteDevice(d)
+}
+
+func (c *exportCounter) WriteGroup(g domain.Group) error {
+	c.groups++
+	return c.ExportEncoder.WriteGroup(g)
+}
+
+func (c *exportCounter) WriteLink(l domain.GroupLink) error {
+	c.links++
+	return c.ExportEncoder.WriteLink(l)
+}
+
+func (c *exportCounter) WriteAction(a domain.Action) error {
+	c.actions++
+	return c.ExportEncoder.WriteAction(a)
+}
+
+// importOrg creates the records of an organization from versioned JSON, or the devices from CSV.
+// The organization of a JSON export is used unless another organization ID is given
+func importOrg(args []string) error {
+	f, err := parseTransferFlags("import", "The file to read the export from, - for standard input", args)
+	if err != nil {
+		return err
+	}
+
+	r := os.Stdin
+	if f.file != "-" {
+		if r, err = os.Open(f.file); err != nil {
+			return err
+		}
+	}
+	defer r.Close()
+
+	data := domain.Export{}
+	if f.format == "csv" {
+		data.Version = domain.ExportVersion
+		data.Devices, err = devicetwin.ReadDevicesCSV(r)
+	} else {
+		err = json.NewDecoder(r).Decode(&data)
+	}
+	if err != nil {
+		return err
+	}
+
+	orgID := f.orgID
+	if len(orgID) == 0 {
+		orgID = data.OrganizationID
+	}
+	if len(orgID) == 0 {
+		return fmt.Errorf("the organization ID is required")
+	}
+
+	twin, closeStore, err := f.openStore()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	summary, err := twin.Import(context.Background(), orgID, data)
+	if err != nil {
+		return err
+	}
+	log.Printf("Imported %d devices, %d groups, %d links and %d actions into `%s`\n", summary.Devices, summary.Groups, summary.Links, summary.Actions, orgID)
+	return nil
+}
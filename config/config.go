@@ -24,29 +24,53 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/canonical/iot-identity/service/cert"
 )
 
 // Default settings
 const (
-	DefaultPort       = "8040"
-	DefaultDriver     = "memory"
-	DefaultDataSource = ""
-	DefaultMQTTURL    = "mqtt.example.com"
-	DefaultMQTTPort   = "8883"
-	DefaultCertsPath  = "certs"
-	DefaultConfigPath = "certs"
-	keyFilename       = ".secret"
-	rootCA            = "ca.crt"
-	clientCert        = "server.crt"
-	clientKey         = "server.key"
-	prefix            = "devicetwin"
+	DefaultPort        = "8040"
+	DefaultDriver      = "memory"
+	DefaultDataSource  = ""
+	DefaultMQTTURL     = "mqtt.example.com"
+	DefaultMQTTPort    = "8883"
+	DefaultTransport   = "ssl"
+	DefaultMQTTPath    = "/mqtt"
+	DefaultMQTTVersion = 3
+	DefaultTimeout     = 30 * time.Second
+	DefaultRetention   = ""
+	DefaultPurge       = time.Hour
+	DefaultHeartbeat   = 10 * time.Second
+	DefaultCacheSize   = 10000
+	DefaultCacheTTL    = 30 * time.Second
+	DefaultWorkers     = 8
+	DefaultQueueSize   = 100
+	DefaultCertsPath   = "certs"
+	DefaultConfigPath  = "certs"
+	keyFilename        = ".secret"
+	rootCA             = "ca.crt"
+	clientCert         = "server.crt"
+	clientKey          = "server.key"
+	prefix             = "devicetwin"
 )
 
-var drivers = []string{"memory", "postgres"}
+var drivers = []string{"memory", "postgres", "sqlite"}
+
+// Transports maps the transports to the MQTT broker to whether they use TLS
+var Transports = map[string]bool{
+	"ssl": true,
+	"tcp": false,
+	"ws":  false,
+	"wss": true,
+}
+
+// MQTTVersions are the versions of the MQTT protocol: 3 for v3.1.1 and 5 for v5
+var MQTTVersions = []int{3, 5}
 
 // MQTTConnect holds the credentials for MQTT connection
 type MQTTConnect struct {
@@ -54,37 +78,98 @@ type MQTTConnect struct {
 	RootCA     []byte
 	ClientCert []byte
 	ClientKey  []byte
+	ServerName string // name in the certificate of the broker, when it is not the host name of the URL
+	Username   string
+	Password   string
 }
 
 // Settings defines the application configuration
 type Settings struct {
-	Port        string
-	Driver      string
-	DataSource  string
-	MQTTUrl     string
-	MQTTPort    string
-	KeySecret   string
-	MQTTConnect MQTTConnect
+	Port         string
+	Driver       string
+	DataSource   string
+	MQTTUrl      string
+	MQTTPort     string
+	Transport    string   // transport to the MQTT broker: ssl, tcp, ws or wss
+	ShareGroup   string   // group of the shared subscriptions of the replicas, empty for each to get every message
+	MQTTPath     string   // path of the websocket of the MQTT broker
+	MQTTVersion  int      // version of the MQTT protocol: 3 for v3.1.1 or 5 for v5
+	KeySecret    string   // secret for the encryption of sensitive fields
+	OldSecrets   []string // previous secrets, to decrypt the fields until the keys are rotated
+	MQTTConnect  MQTTConnect
+	Timeout      time.Duration // deadline for handling an API request or a message from a device
+	ActionExpiry time.Duration // time after which the broker discards an action not yet delivered, with MQTT v5
+	Heartbeat    time.Duration // interval for writing the device heartbeats in batches, zero to write each one
+	CacheSize    int           // number of devices in the device cache, zero to disable it
+	CacheTTL     time.Duration // time a device stays in the cache, which bounds how stale it can be
+	Workers      int           // number of workers handling the messages from the devices, zero to handle them in the MQTT client
+	QueueSize    int           // number of messages queued for each worker before the MQTT client waits
+	Retention    Retention
+	Topics       Topics // templates of the MQTT topics of the devices
 }
 
 // ParseArgs checks the command line arguments
 func ParseArgs() *Settings {
 	var (
-		port       string
-		driver     string
-		datasource string
-		mqttURL    string
-		mqttPort   string
-		certsDir   string
-		configDir  string
+		port        string
+		driver      string
+		datasource  string
+		mqttURL     string
+		mqttPort    string
+		transport   string
+		mqttPath    string
+		mqttVersion int
+		serverName  string
+		username    string
+		password    string
+		clientID    string
+		shareGroup  string
+		certsDir    string
+		configDir   string
+		timeout     time.Duration
+		expiry      time.Duration
+		retention   string
+		purge       time.Duration
+		archiveDir  string
+		heartbeat   time.Duration
+		cacheSize   int
+		cacheTTL    time.Duration
+		workers     int
+		queueSize   int
+		mqttPrefix  string
+		topicPub    string
+		topicHealth string
+		topicSub    string
 	)
 	flag.StringVar(&port, "port", DefaultPort, "The port the service listens on")
-	flag.StringVar(&driver, "driver", DefaultDriver, "The data repository driver")
+	flag.StringVar(&driver, "driver", DefaultDriver, "The data repository driver: memory, postgres or sqlite")
 	flag.StringVar(&datasource, "datasource", DefaultDataSource, "The data repository data source")
 	flag.StringVar(&mqttURL, "mqtturl", DefaultMQTTURL, "URL of the MQTT broker")
 	flag.StringVar(&mqttPort, "mqttport", DefaultMQTTPort, "Port of the MQTT broker")
+	flag.StringVar(&transport, "mqtttransport", DefaultTransport, "Transport to the MQTT broker: ssl, tcp, ws or wss")
+	flag.StringVar(&mqttPath, "mqttpath", DefaultMQTTPath, "Path of the websocket of the MQTT broker, for the ws and wss transports")
+	flag.IntVar(&mqttVersion, "mqttversion", DefaultMQTTVersion, "Version of the MQTT protocol: 3 for v3.1.1 or 5 for v5")
+	flag.StringVar(&serverName, "mqttservername", "", "Name in the certificate of the MQTT broker, when it is not the host name of the URL")
+	flag.StringVar(&username, "mqttusername", "", "Username for the MQTT broker")
+	flag.StringVar(&password, "mqttpassword", os.Getenv("MQTTPASSWORD"), "Password for the MQTT broker, defaults to the MQTTPASSWORD environment variable")
+	flag.StringVar(&clientID, "mqttclientid", "", "Client ID for the MQTT broker, unique to each replica e.g. the pod name, generated when empty")
+	flag.StringVar(&shareGroup, "mqttsharegroup", "", "Group of the shared subscriptions to the device topics, so each message is handled by one of the replicas")
 	flag.StringVar(&certsDir, "certsdir", DefaultCertsPath, "Directory path to the certificates")
 	flag.StringVar(&configDir, "configdir", DefaultConfigPath, "Directory path to the config file")
+	flag.DurationVar(&timeout, "timeout", DefaultTimeout, "Deadline for handling an API request or a message from a device")
+	flag.DurationVar(&expiry, "actionexpiry", 0, "How long the MQTT broker keeps an action for a disconnected device, with MQTT v5, 0 to keep it until it is delivered")
+	flag.StringVar(&retention, "retention", DefaultRetention, "Retention of the action log as `[org:]limit` items, with a limit in days (30d) or actions per device (500)")
+	flag.DurationVar(&purge, "purge", DefaultPurge, "How often the expired actions are purged")
+	flag.StringVar(&archiveDir, "archivedir", "", "Directory path to archive the purged actions, empty to discard them")
+	flag.IntVar(&cacheSize, "cachesize", DefaultCacheSize, "Number of devices in the device cache, 0 to disable the cache")
+	flag.DurationVar(&cacheTTL, "cachettl", DefaultCacheTTL, "How long a device stays in the device cache")
+	flag.IntVar(&workers, "workers", DefaultWorkers, "Number of workers handling the messages from the devices in parallel, 0 to handle them one at a time as they arrive")
+	flag.IntVar(&queueSize, "queuesize", DefaultQueueSize, "Number of messages queued for each worker, before the service stops reading from the MQTT broker")
+	flag.DurationVar(&heartbeat, "heartbeat", DefaultHeartbeat, "How often the device heartbeats are written in batches, 0 to write each one as it arrives")
+	flag.StringVar(&mqttPrefix, "mqttprefix", "", "Prefix of the MQTT topics, to share the broker with other services")
+	flag.StringVar(&topicPub, "mqtttopicpub", DefaultTopicActions, "Template of the MQTT topic of the action responses, with the {id} and optional {org} levels")
+	flag.StringVar(&topicHealth, "mqtttopichealth", DefaultTopicHealth, "Template of the MQTT topic of the health messages, with the {id} and optional {org} levels")
+	flag.StringVar(&topicSub, "mqtttopicsub", DefaultTopicSubscribe, "Template of the MQTT topic of the actions sent to a device, with the {id} and optional {org} levels")
 	flag.Parse()
 
 	// Validate the driver
@@ -99,66 +184,165 @@ func ParseArgs() *Settings {
 		log.Fatalf("The database driver must be one of: %s", strings.Join(drivers, ", "))
 	}
 
+	// Validate the MQTT transport
+	secure, ok := Transports[transport]
+	if !ok {
+		log.Fatalf("The MQTT transport must be one of: ssl, tcp, ws, wss")
+	}
+
+	// Validate the MQTT version
+	found = false
+	for _, v := range MQTTVersions {
+		if v == mqttVersion {
+			found = true
+			break
+		}
+	}
+	if !found {
+		log.Fatalf("The MQTT version must be 3 (v3.1.1) or 5 (v5)")
+	}
+	if expiry < 0 {
+		log.Fatalf("The action expiry must not be negative")
+	}
+
+	// Validate the workers
+	if workers < 0 || queueSize < 0 {
+		log.Fatalf("The number of workers and the queue size must not be negative")
+	}
+
+	// Validate the action log retention
+	policy, orgs, err := ParseRetention(retention)
+	if err != nil {
+		log.Fatalf("Error in the action log retention: %v", err)
+	}
+
+	// Validate the MQTT topic templates
+	var topics Topics
+	for _, t := range []struct {
+		topic    *Topic
+		template string
+	}{{&topics.Actions, topicPub}, {&topics.Health, topicHealth}, {&topics.Subscribe, topicSub}} {
+		if *t.topic, err = ParseTopic(mqttPrefix, t.template); err != nil {
+			log.Fatalf("Error in the MQTT topics: %v", err)
+		}
+	}
+
+	if err := ValidShareGroup(shareGroup); err != nil {
+		log.Fatalf("Error in the MQTT topics: %v", err)
+	}
+
 	// Get/set the encryption secret
-	p := path.Join(configDir, keyFilename)
-	secret, err := getSecret(p)
+	secret, oldSecrets, err := getSecret(SecretPath(configDir))
 	if err != nil {
 		log.Fatalf("Error generating encryption secret: %v", err)
 	}
 
-	// Get the certificates for the MQTT broker
+	// Get the certificates for the MQTT broker, which are only needed with TLS
 	m, err := readCerts(certsDir)
-	if err != nil {
+	if err != nil && secure {
 		log.Fatalf("Error reading certificates: %v", err)
 	}
+	if len(clientID) > 0 {
+		m.ClientID = clientID
+	}
+	m.ServerName = serverName
+	m.Username = username
+	m.Password = password
 
 	return &Settings{
-		Port:        port,
-		Driver:      driver,
-		DataSource:  datasource,
-		MQTTUrl:     mqttURL,
-		MQTTPort:    mqttPort,
-		KeySecret:   secret,
-		MQTTConnect: m,
+		Port:         port,
+		Driver:       driver,
+		DataSource:   datasource,
+		MQTTUrl:      mqttURL,
+		MQTTPort:     mqttPort,
+		Transport:    transport,
+		MQTTPath:     mqttPath,
+		MQTTVersion:  mqttVersion,
+		ShareGroup:   shareGroup,
+		KeySecret:    secret,
+		OldSecrets:   oldSecrets,
+		MQTTConnect:  m,
+		Timeout:      timeout,
+		ActionExpiry: expiry,
+		Heartbeat:    heartbeat,
+		CacheSize:    cacheSize,
+		CacheTTL:     cacheTTL,
+		Workers:      workers,
+		QueueSize:    queueSize,
+		Retention: Retention{
+			Default:    policy,
+			Orgs:       orgs,
+			Interval:   purge,
+			ArchiveDir: archiveDir,
+		},
+		Topics: topics,
 	}
 }
 
-func getSecret(p string) (string, error) {
+func getSecret(p string) (string, []string, error) {
 	// Attempt to open the secrets file
-	source, err := ioutil.ReadFile(p)
+	secret, oldSecrets, err := ReadSecrets(p)
 	if err == nil {
-		return string(source), nil
+		return secret, oldSecrets, nil
 	}
 
 	// No secret file, so generate a secret
 	s, err := cert.CreateSecret(32)
 	if err != nil {
-		return s, fmt.Errorf("error creating secret: %v", err)
+		return s, nil, fmt.Errorf("error creating secret: %v", err)
 	}
 
 	err = ioutil.WriteFile(p, []byte(s), 0600)
-	return s, err
+	return s, nil, err
+}
+
+// SecretPath is the path of the encryption secrets file in the config directory
+func SecretPath(configDir string) string {
+	return path.Join(configDir, keyFilename)
+}
+
+// ReadSecrets reads the encryption secrets file. The first line is the current secret, and
+// the following lines are the previous secrets that are kept while the keys are rotated
+func ReadSecrets(p string) (string, []string, error) {
+	source, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", nil, err
+	}
+
+	secrets := []string{}
+	for _, line := range strings.Split(string(source), "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			secrets = append(secrets, line)
+		}
+	}
+	if len(secrets) == 0 {
+		return "", nil, fmt.Errorf("the secrets file `%s` is empty", p)
+	}
+	return secrets[0], secrets[1:], nil
 }
 
-// readCerts reads the certificates from the file system
+// readCerts reads the certificates from the file system. The client certificate and key are
+// optional, for brokers that authenticate the service with a username and password
 func readCerts(certsDir string) (MQTTConnect, error) {
-	c := MQTTConnect{}
+	c := MQTTConnect{ClientID: generateClientID()}
 	rootCA, err := ioutil.ReadFile(path.Join(certsDir, rootCA))
 	if err != nil {
 		return c, err
 	}
+	c.RootCA = rootCA
 
 	certFile, err := ioutil.ReadFile(path.Join(certsDir, clientCert))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
 	if err != nil {
 		return c, err
 	}
 
 	key, err := ioutil.ReadFile(path.Join(certsDir, clientKey))
 
-	c.RootCA = rootCA
 	c.ClientKey = key
 	c.ClientCert = certFile
-	c.ClientID = generateClientID()
 
 	return c, err
 }
@@ -20,6 +20,7 @@
 package config
 
 import (
+	"io/ioutil"
 	"os"
 	"path"
 	"testing"
@@ -41,6 +42,7 @@ func TestParseArgs(t *testing.T) {
 	_, _ = os.Create(path.Join(DefaultConfigPath, clientKey))
 
 	for _, tt := range tests {
+		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 			{
@@ -60,3 +62,39 @@ func TestParseArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestReadSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devicetwin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		secret  string
+		old     []string
+		wantErr bool
+	}{
+		{"valid", "current", "current", []string{}, false},
+		{"valid-previous", "current\nprevious\n\noldest\n", "current", []string{"previous", "oldest"}, false},
+		{"invalid-empty", "\n", "", nil, true},
+		{"invalid-missing", "", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := path.Join(dir, tt.name)
+			if len(tt.content) > 0 {
+				_ = ioutil.WriteFile(p, []byte(tt.content), 0600)
+			}
+
+			secret, old, err := ReadSecrets(p)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadSecrets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.secret, secret, tt.name)
+			assert.Equal(t, tt.old, old, tt.name)
+		})
+	}
+}
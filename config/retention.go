@@ -0,0 +1,102 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy defines how long the action log of a device is kept. A zero limit is not applied
+type RetentionPolicy struct {
+	Days    int // remove the actions older than this number of days
+	Actions int // keep this number of the most recent actions for each device
+}
+
+// Enabled checks if the policy removes any actions
+func (p RetentionPolicy) Enabled() bool {
+	return p.Days > 0 || p.Actions > 0
+}
+
+// Retention defines the retention of the action log, with optional policies per organization
+type Retention struct {
+	Default    RetentionPolicy
+	Orgs       map[string]RetentionPolicy
+	Interval   time.Duration // how often the expired actions are purged
+	ArchiveDir string        // directory to archive the purged actions, empty to discard them
+}
+
+// Policy returns the retention policy for an organization. The policy of an organization
+// replaces the default policy, rather than adding to it
+func (r Retention) Policy(orgID string) RetentionPolicy {
+	if p, ok := r.Orgs[orgID]; ok {
+		return p
+	}
+	return r.Default
+}
+
+// ParseRetention parses a comma-separated list of retention limits in the form `[org:]limit`.
+// The limit is a number of days, such as `30d`, or a number of actions per device, such as `500`.
+// An organization can have both limits, e.g. `abc:30d,abc:500`
+func ParseRetention(s string) (RetentionPolicy, map[string]RetentionPolicy, error) {
+	policy := RetentionPolicy{}
+	orgs := map[string]RetentionPolicy{}
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		orgID, limit := "", item
+		if i := strings.LastIndex(item, ":"); i >= 0 {
+			orgID, limit = item[:i], item[i+1:]
+			if len(orgID) == 0 {
+				return policy, nil, fmt.Errorf("invalid retention `%s`: the organization is empty", item)
+			}
+		}
+
+		p := policy
+		if len(orgID) > 0 {
+			p = orgs[orgID]
+		}
+
+		days := strings.HasSuffix(limit, "d")
+		n, err := strconv.Atoi(strings.TrimSuffix(limit, "d"))
+		if err != nil || n <= 0 {
+			return policy, nil, fmt.Errorf("invalid retention `%s`: expected a number of days or actions", item)
+		}
+		if days {
+			p.Days = n
+		} else {
+			p.Actions = n
+		}
+
+		if len(orgID) > 0 {
+			orgs[orgID] = p
+		} else {
+			policy = p
+		}
+	}
+
+	return policy, orgs, nil
+}
@@ -0,0 +1,70 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Identity Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		policy  RetentionPolicy
+		orgs    map[string]RetentionPolicy
+		wantErr bool
+	}{
+		{"empty", "", RetentionPolicy{}, map[string]RetentionPolicy{}, false},
+		{"days", "30d", RetentionPolicy{Days: 30}, map[string]RetentionPolicy{}, false},
+		{"actions", "500", RetentionPolicy{Actions: 500}, map[string]RetentionPolicy{}, false},
+		{"default-both", "30d, 500", RetentionPolicy{Days: 30, Actions: 500}, map[string]RetentionPolicy{}, false},
+		{"org", "90d,abc:7d,abc:100,xyz:1000", RetentionPolicy{Days: 90},
+			map[string]RetentionPolicy{"abc": {Days: 7, Actions: 100}, "xyz": {Actions: 1000}}, false},
+		{"invalid-limit", "30days", RetentionPolicy{}, nil, true},
+		{"invalid-zero", "0d", RetentionPolicy{}, nil, true},
+		{"invalid-negative", "abc:-5", RetentionPolicy{}, nil, true},
+		{"invalid-org", ":30d", RetentionPolicy{}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, orgs, err := ParseRetention(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRetention() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			assert.Equal(t, tt.policy, policy, tt.name)
+			assert.Equal(t, tt.orgs, orgs, tt.name)
+		})
+	}
+}
+
+func TestRetention_Policy(t *testing.T) {
+	r := Retention{
+		Default: RetentionPolicy{Days: 90},
+		Orgs:    map[string]RetentionPolicy{"abc": {Actions: 100}},
+	}
+	assert.Equal(t, RetentionPolicy{Actions: 100}, r.Policy("abc"))
+	assert.Equal(t, RetentionPolicy{Days: 90}, r.Policy("xyz"))
+	assert.False(t, Retention{}.Policy("abc").Enabled())
+}
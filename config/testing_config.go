@@ -93,12 +93,17 @@ Qmery+icg5rZtFUNYs0WDeeYRVic+fwpBXCjrSpG3KglcceN6uOZVFI=
 // TestConfig creates config settings for testing
 func TestConfig() *Settings {
 	return &Settings{
-		Driver: DefaultDriver,
+		Driver:      DefaultDriver,
+		Timeout:     DefaultTimeout,
+		Transport:   DefaultTransport,
+		MQTTPath:    DefaultMQTTPath,
+		MQTTVersion: DefaultMQTTVersion,
 		MQTTConnect: MQTTConnect{
 			ClientID:   "aaa",
 			RootCA:     []byte(testCA),
 			ClientCert: []byte(testServerCert),
 			ClientKey:  []byte(testServerKey),
 		},
+		Topics: DefaultTopics(""),
 	}
 }
@@ -0,0 +1,176 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The placeholders of the topic templates
+const (
+	TopicOrg    = "{org}"
+	TopicDevice = "{id}"
+)
+
+// Default topic templates, which keep the topics of the devices without a namespace
+const (
+	DefaultTopicActions   = "devices/pub/{id}"
+	DefaultTopicHealth    = "devices/health/{id}"
+	DefaultTopicSubscribe = "devices/sub/{id}"
+)
+
+// Topics holds the templates of the MQTT topics for the devices
+type Topics struct {
+	Actions   Topic // action responses published by the devices
+	Health    Topic // health messages published by the devices
+	Subscribe Topic // actions sent to the devices
+}
+
+// Topic is an MQTT topic template, with the device ID and optionally the organization ID as
+// placeholders that are whole levels of the topic, e.g. `{org}/devices/{id}/pub`
+type Topic struct {
+	levels []string
+}
+
+// ParseTopic parses a topic template, adding the levels of the prefix in front of it
+func ParseTopic(prefix, template string) (Topic, error) {
+	t := strings.Trim(prefix, "/")
+	if len(t) > 0 {
+		t += "/"
+	}
+	t += template
+
+	topic := Topic{levels: strings.Split(t, "/")}
+	devices, orgs := 0, 0
+	for _, level := range topic.levels {
+		switch {
+		case level == TopicDevice:
+			devices++
+		case level == TopicOrg:
+			orgs++
+		case len(level) == 0:
+			return Topic{}, fmt.Errorf("invalid topic `%s`: a level is empty", t)
+		case strings.ContainsAny(level, "+#{}"):
+			return Topic{}, fmt.Errorf("invalid topic `%s`: the level `%s` has a wildcard or an unknown placeholder", t, level)
+		}
+	}
+	if devices != 1 {
+		return Topic{}, fmt.Errorf("invalid topic `%s`: it needs one %s level", t, TopicDevice)
+	}
+	if orgs > 1 {
+		return Topic{}, fmt.Errorf("invalid topic `%s`: it can only have one %s level", t, TopicOrg)
+	}
+	return topic, nil
+}
+
+// MustParseTopic parses a topic template that is known to be valid
+func MustParseTopic(prefix, template string) Topic {
+	topic, err := ParseTopic(prefix, template)
+	if err != nil {
+		panic(err)
+	}
+	return topic
+}
+
+// DefaultTopics returns the default topic templates under a prefix
+func DefaultTopics(prefix string) Topics {
+	return Topics{
+		Actions:   MustParseTopic(prefix, DefaultTopicActions),
+		Health:    MustParseTopic(prefix, DefaultTopicHealth),
+		Subscribe: MustParseTopic(prefix, DefaultTopicSubscribe),
+	}
+}
+
+// HasOrg checks if the organization ID is in the topic
+func (t Topic) HasOrg() bool {
+	for _, level := range t.levels {
+		if level == TopicOrg {
+			return true
+		}
+	}
+	return false
+}
+
+// Format returns the topic of a device
+func (t Topic) Format(orgID, deviceID string) string {
+	levels := make([]string, len(t.levels))
+	for i, level := range t.levels {
+		switch level {
+		case TopicOrg:
+			levels[i] = orgID
+		case TopicDevice:
+			levels[i] = deviceID
+		default:
+			levels[i] = level
+		}
+	}
+	return strings.Join(levels, "/")
+}
+
+// Filter returns the subscription filter for the topics of all the devices
+func (t Topic) Filter() string {
+	return t.Format("+", "+")
+}
+
+// SharedFilter returns the subscription filter for the topics of all the devices, shared with the
+// other subscribers of the group so each message is delivered to one of them
+func (t Topic) SharedFilter(group string) string {
+	if len(group) == 0 {
+		return t.Filter()
+	}
+	return fmt.Sprintf("$share/%s/%s", group, t.Filter())
+}
+
+// ValidShareGroup checks the name of a shared subscription group, which is a single topic level
+func ValidShareGroup(group string) error {
+	if strings.ContainsAny(group, "/+#") {
+		return fmt.Errorf("invalid shared subscription group `%s`: it cannot contain `/`, `+` or `#`", group)
+	}
+	return nil
+}
+
+// Match gets the organization and device IDs from a topic, checking the other levels match
+// the template. The organization ID is empty when it is not in the template
+func (t Topic) Match(topic string) (orgID, deviceID string, ok bool) {
+	levels := strings.Split(topic, "/")
+	if len(levels) != len(t.levels) {
+		return "", "", false
+	}
+
+	for i, level := range t.levels {
+		switch level {
+		case TopicOrg:
+			orgID = levels[i]
+		case TopicDevice:
+			deviceID = levels[i]
+		default:
+			if levels[i] != level {
+				return "", "", false
+			}
+		}
+	}
+	return orgID, deviceID, len(deviceID) > 0
+}
+
+// String returns the topic template
+func (t Topic) String() string {
+	return strings.Join(t.levels, "/")
+}
@@ -0,0 +1,111 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTopic(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefix   string
+		template string
+		want     string
+		filter   string
+		hasOrg   bool
+		wantErr  bool
+	}{
+		{"default", "", DefaultTopicActions, "devices/pub/{id}", "devices/pub/+", false, false},
+		{"prefix", "/acme/", DefaultTopicHealth, "acme/devices/health/{id}", "acme/devices/health/+", false, false},
+		{"org", "", "{org}/devices/{id}/pub", "{org}/devices/{id}/pub", "+/devices/+/pub", true, false},
+		{"prefix-org", "acme/iot", "{org}/devices/{id}/sub", "acme/iot/{org}/devices/{id}/sub", "acme/iot/+/devices/+/sub", true, false},
+		{"invalid-no-id", "", "devices/pub", "", "", false, true},
+		{"invalid-two-ids", "", "{id}/devices/{id}", "", "", false, true},
+		{"invalid-two-orgs", "", "{org}/{org}/{id}", "", "", false, true},
+		{"invalid-empty-level", "", "devices//{id}", "", "", false, true},
+		{"invalid-wildcard", "", "devices/+/{id}", "", "", false, true},
+		{"invalid-placeholder", "", "devices/{name}/{id}", "", "", false, true},
+		{"invalid-partial-level", "", "devices/pub-{id}", "", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTopic(tt.prefix, tt.template)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTopic() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			assert.Equal(t, tt.want, got.String(), tt.name)
+			assert.Equal(t, tt.filter, got.Filter(), tt.name)
+			assert.Equal(t, tt.hasOrg, got.HasOrg(), tt.name)
+		})
+	}
+}
+
+func TestTopic_Match(t *testing.T) {
+	withOrg := MustParseTopic("acme", "{org}/devices/{id}/pub")
+	tests := []struct {
+		name   string
+		topic  Topic
+		value  string
+		org    string
+		device string
+		ok     bool
+	}{
+		{"default", DefaultTopics("").Actions, "devices/pub/a111", "", "a111", true},
+		{"default-other-kind", DefaultTopics("").Actions, "devices/health/a111", "", "", false},
+		{"default-too-long", DefaultTopics("").Actions, "acme/devices/pub/a111", "", "", false},
+		{"org", withOrg, "acme/abc/devices/a111/pub", "abc", "a111", true},
+		{"org-other-prefix", withOrg, "other/abc/devices/a111/pub", "", "", false},
+		{"org-empty-device", withOrg, "acme/abc/devices//pub", "abc", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			org, device, ok := tt.topic.Match(tt.value)
+			assert.Equal(t, tt.ok, ok, tt.name)
+			if !ok {
+				return
+			}
+			assert.Equal(t, tt.org, org, tt.name)
+			assert.Equal(t, tt.device, device, tt.name)
+		})
+	}
+}
+
+func TestTopic_SharedFilter(t *testing.T) {
+	topic := DefaultTopics("").Actions
+	assert.Equal(t, "devices/pub/+", topic.SharedFilter(""))
+	assert.Equal(t, "$share/devicetwin/devices/pub/+", topic.SharedFilter("devicetwin"))
+
+	assert.NoError(t, ValidShareGroup(""))
+	assert.NoError(t, ValidShareGroup("devicetwin"))
+	assert.Error(t, ValidShareGroup("device/twin"))
+	assert.Error(t, ValidShareGroup("+"))
+}
+
+func TestTopic_Format(t *testing.T) {
+	assert.Equal(t, "devices/sub/a111", DefaultTopics("").Subscribe.Format("abc", "a111"))
+	assert.Equal(t, "acme/devices/sub/a111", DefaultTopics("acme").Subscribe.Format("abc", "a111"))
+	assert.Equal(t, "acme/abc/devices/a111/sub", MustParseTopic("acme", "{org}/devices/{id}/sub").Format("abc", "a111"))
+}
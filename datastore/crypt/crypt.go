@@ -0,0 +1,170 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package crypt
+
+import (
+	"context"
+	"github.com/canonical/iot-devicetwin/datastore"
+)
+
+// Store encrypts the sensitive fields of the records before they are written to the data
+// store, and decrypts them when they are read: the device key and the snap config
+type Store struct {
+	datastore.DataStore
+	Keys *Keyring
+}
+
+// NewStore wraps a data store to encrypt the sensitive fields at rest
+func NewStore(db datastore.DataStore, keys *Keyring) *Store {
+	return &Store{DataStore: db, Keys: keys}
+}
+
+// DeviceCreate creates a device with an encrypted device key
+func (s *Store) DeviceCreate(ctx context.Context, device datastore.Device) (int64, error) {
+	key, err := s.Keys.Seal(datastore.SecretDeviceKey, device.DeviceID, device.DeviceKey)
+	if err != nil {
+		return 0, err
+	}
+	device.DeviceKey = key
+	return s.DataStore.DeviceCreate(ctx, device)
+}
+
+// DeviceGet fetches a device with its device key decrypted
+func (s *Store) DeviceGet(ctx context.Context, id string) (datastore.Device, error) {
+	device, err := s.DataStore.DeviceGet(ctx, id)
+	if err != nil {
+		return device, err
+	}
+	device.DeviceKey, err = s.Keys.Open(datastore.SecretDeviceKey, device.DeviceID, device.DeviceKey)
+	return device, err
+}
+
+// DeviceList lists the devices with their device keys decrypted
+func (s *Store) DeviceList(ctx context.Context, orgID string, query datastore.DeviceQuery) ([]datastore.Device, error) {
+	return s.openDevices(s.DataStore.DeviceList(ctx, orgID, query))
+}
+
+// GroupGetDevices lists the devices of a group with their device keys decrypted
+func (s *Store) GroupGetDevices(ctx context.Context, orgID, name string, query datastore.DeviceQuery) ([]datastore.Device, error) {
+	return s.openDevices(s.DataStore.GroupGetDevices(ctx, orgID, name, query))
+}
+
+// GroupGetExcludedDevices lists the devices not in a group with their device keys decrypted
+func (s *Store) GroupGetExcludedDevices(ctx context.Context, orgID, name string, query datastore.DeviceQuery) ([]datastore.Device, error) {
+	return s.openDevices(s.DataStore.GroupGetExcludedDevices(ctx, orgID, name, query))
+}
+
+// openDevices decrypts the device keys of a device listing
+func (s *Store) openDevices(devices []datastore.Device, err error) ([]datastore.Device, error) {
+	if err != nil {
+		return devices, err
+	}
+	for i := range devices {
+		devices[i].DeviceKey, err = s.Keys.Open(datastore.SecretDeviceKey, devices[i].DeviceID, devices[i].DeviceKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return devices, nil
+}
+
+// DeviceSnapList lists the snaps of a device with their config decrypted
+func (s *Store) DeviceSnapList(ctx context.Context, id int64) ([]datastore.DeviceSnap, error) {
+	snaps, err := s.DataStore.DeviceSnapList(ctx, id)
+	if err != nil {
+		return snaps, err
+	}
+	for i := range snaps {
+		snaps[i].Config, err = s.Keys.Open(datastore.SecretSnapConfig, datastore.SnapConfigOwner(id, snaps[i].Name), snaps[i].Config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return snaps, nil
+}
+
+// DeviceSnapListForOrg lists the snaps of the devices of an organization with their config decrypted
+func (s *Store) DeviceSnapListForOrg(ctx context.Context, orgID string) ([]datastore.DeviceSnap, error) {
+	snaps, err := s.DataStore.DeviceSnapListForOrg(ctx, orgID)
+	if err != nil {
+		return snaps, err
+	}
+	for i := range snaps {
+		snaps[i].Config, err = s.Keys.Open(datastore.SecretSnapConfig, datastore.SnapConfigOwner(snaps[i].DeviceID, snaps[i].Name), snaps[i].Config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return snaps, nil
+}
+
+// DeviceSnapUpsert creates or updates a snap of a device with an encrypted config
+func (s *Store) DeviceSnapUpsert(ctx context.Context, ds datastore.DeviceSnap) error {
+	conf, err := s.Keys.Seal(datastore.SecretSnapConfig, datastore.SnapConfigOwner(ds.DeviceID, ds.Name), ds.Config)
+	if err != nil {
+		return err
+	}
+	ds.Config = conf
+	return s.DataStore.DeviceSnapUpsert(ctx, ds)
+}
+
+// DeviceSnapReplace replaces the snaps of a device, encrypting their config
+func (s *Store) DeviceSnapReplace(ctx context.Context, id int64, snaps []datastore.DeviceSnap) error {
+	sealed := make([]datastore.DeviceSnap, 0, len(snaps))
+	for _, ds := range snaps {
+		conf, err := s.Keys.Seal(datastore.SecretSnapConfig, datastore.SnapConfigOwner(id, ds.Name), ds.Config)
+		if err != nil {
+			return err
+		}
+		ds.Config = conf
+		sealed = append(sealed, ds)
+	}
+	return s.DataStore.DeviceSnapReplace(ctx, id, sealed)
+}
+
+// Rotate re-encrypts the sensitive fields that are not encrypted with the primary key, including
+// those stored before encryption was enabled. Returns the number of fields that were updated
+func (s *Store) Rotate(ctx context.Context) (int, error) {
+	secrets, err := s.DataStore.SecretList(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	updated := []datastore.Secret{}
+	for _, secret := range secrets {
+		if s.Keys.Current(secret.Value) {
+			continue
+		}
+
+		value, err := s.Keys.Open(secret.Kind, secret.Owner, secret.Value)
+		if err != nil {
+			return 0, err
+		}
+		if secret.Value, err = s.Keys.Seal(secret.Kind, secret.Owner, value); err != nil {
+			return 0, err
+		}
+		updated = append(updated, secret)
+	}
+
+	if len(updated) == 0 {
+		return 0, nil
+	}
+	return len(updated), s.DataStore.SecretUpdate(ctx, updated)
+}
@@ -0,0 +1,125 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package crypt
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/canonical/iot-devicetwin/datastore"
+	"github.com/canonical/iot-devicetwin/datastore/memory"
+)
+
+func TestStore_Encryption(t *testing.T) {
+	ctx := context.Background()
+	mem := memory.NewEmptyStore()
+	keys, _ := NewKeyring("secret")
+	db := NewStore(mem, keys)
+
+	id, err := db.DeviceCreate(ctx, datastore.Device{OrganisationID: "abc", DeviceID: "a111", DeviceKey: "AAAAAAAAA"})
+	if err != nil {
+		t.Fatalf("Store.DeviceCreate() error = %v", err)
+	}
+	if err := db.DeviceSnapReplace(ctx, id, []datastore.DeviceSnap{{Name: "helloworld", Config: `{"title":"hello"}`}, {Name: "core"}}); err != nil {
+		t.Fatalf("Store.DeviceSnapReplace() error = %v", err)
+	}
+	if err := db.DeviceSnapUpsert(ctx, datastore.DeviceSnap{DeviceID: id, Name: "mqtt", Config: `{"port":1883}`}); err != nil {
+		t.Fatalf("Store.DeviceSnapUpsert() error = %v", err)
+	}
+
+	// The fields are encrypted in the underlying store
+	if !strings.HasPrefix(mem.Devices[0].DeviceKey, sealedPrefix) {
+		t.Errorf("Store.DeviceCreate() stored device key %v", mem.Devices[0].DeviceKey)
+	}
+	for _, ds := range mem.Snaps {
+		if (ds.Name == "core") != (len(ds.Config) == 0) || (len(ds.Config) > 0 && !strings.HasPrefix(ds.Config, sealedPrefix)) {
+			t.Errorf("Store.DeviceSnapReplace() stored snap config %v", ds.Config)
+		}
+	}
+
+	// and decrypted when they are read
+	device, err := db.DeviceGet(ctx, "a111")
+	if err != nil || device.DeviceKey != "AAAAAAAAA" {
+		t.Errorf("Store.DeviceGet() = %v, %v", device.DeviceKey, err)
+	}
+	devices, err := db.DeviceList(ctx, "abc", datastore.DeviceQuery{})
+	if err != nil || len(devices) != 1 || devices[0].DeviceKey != "AAAAAAAAA" {
+		t.Errorf("Store.DeviceList() = %v, %v", devices, err)
+	}
+	snaps, err := db.DeviceSnapList(ctx, id)
+	if err != nil {
+		t.Fatalf("Store.DeviceSnapList() error = %v", err)
+	}
+	configs := map[string]string{}
+	for _, ds := range snaps {
+		configs[ds.Name] = ds.Config
+	}
+	if configs["helloworld"] != `{"title":"hello"}` || configs["mqtt"] != `{"port":1883}` || configs["core"] != "" {
+		t.Errorf("Store.DeviceSnapList() = %v", configs)
+	}
+
+	// A different secret cannot read the fields
+	other, _ := NewKeyring("other-secret")
+	if _, err := NewStore(mem, other).DeviceGet(ctx, "a111"); err == nil {
+		t.Error("Store.DeviceGet() expected error with the wrong secret")
+	}
+}
+
+func TestStore_Rotate(t *testing.T) {
+	ctx := context.Background()
+
+	// Start with plain text values, stored before encryption was enabled
+	mem := memory.NewEmptyStore()
+	id, _ := mem.DeviceCreate(ctx, datastore.Device{OrganisationID: "abc", DeviceID: "a111", DeviceKey: "AAAAAAAAA"})
+	_ = mem.DeviceSnapReplace(ctx, id, []datastore.DeviceSnap{{Name: "helloworld", Config: `{"title":"hello"}`}})
+
+	steps := []struct {
+		name    string
+		secrets []string
+		want    int
+	}{
+		{"encrypt-plain-text", []string{"first"}, 2},
+		{"already-current", []string{"first"}, 0},
+		{"rotate", []string{"second", "first"}, 2},
+		{"retire-previous", []string{"second"}, 0},
+	}
+	for _, tt := range steps {
+		keys, _ := NewKeyring(tt.secrets...)
+		db := NewStore(mem, keys)
+
+		got, err := db.Rotate(ctx)
+		if err != nil {
+			t.Fatalf("%s: Store.Rotate() error = %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: Store.Rotate() = %v, want %v", tt.name, got, tt.want)
+		}
+
+		device, err := db.DeviceGet(ctx, "a111")
+		if err != nil || device.DeviceKey != "AAAAAAAAA" {
+			t.Errorf("%s: Store.DeviceGet() = %v, %v", tt.name, device.DeviceKey, err)
+		}
+		snaps, err := db.DeviceSnapList(ctx, id)
+		if err != nil || snaps[0].Config != `{"title":"hello"}` {
+			t.Errorf("%s: Store.DeviceSnapList() = %v, %v", tt.name, snaps, err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// sealedPrefix marks an encrypted value, followed by the ID of the key and the encoded
+// nonce and ciphertext. Values without the prefix were stored before encryption was enabled
+const sealedPrefix = "enc:v1:"
+
+// keyContext separates the data encryption keys from other uses of the secret
+const keyContext = "iot-devicetwin data encryption"
+
+// Keyring holds the keys for the authenticated encryption of sensitive fields. New values are
+// encrypted with the primary key, and the previous keys are kept to decrypt older values
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring creates the keys from the secrets, the first secret being the current one
+func NewKeyring(secrets ...string) (*Keyring, error) {
+	if len(secrets) == 0 {
+		return nil, fmt.Errorf("no secret for the encryption key")
+	}
+
+	k := &Keyring{keys: map[string]cipher.AEAD{}}
+	for i, secret := range secrets {
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("the secret for the encryption key is empty")
+		}
+
+		id, aead, err := newKey(secret)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			k.primary = id
+		}
+		k.keys[id] = aead
+	}
+	return k, nil
+}
+
+// newKey derives an AES-256-GCM key from a secret, identified by a hash of the key
+func newKey(secret string) (string, cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(keyContext))
+	key := mac.Sum(nil)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", nil, err
+	}
+
+	hash := sha256.Sum256(key)
+	return hex.EncodeToString(hash[:4]), aead, nil
+}
+
+// additionalData binds a value to its field and record, so it cannot be moved to another
+func additionalData(kind, owner string) []byte {
+	return []byte(kind + "\x00" + owner)
+}
+
+// Seal encrypts the value of a field with the primary key. An empty value is not encrypted
+func (k *Keyring) Seal(kind, owner, value string) (string, error) {
+	if len(value) == 0 {
+		return value, nil
+	}
+
+	aead := k.keys[k.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(value), additionalData(kind, owner))
+	return sealedPrefix + k.primary + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts the value of a field. A value that is not encrypted is returned as it is
+func (k *Keyring) Open(kind, owner, value string) (string, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, sealedPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid encrypted %s for `%s`", kind, owner)
+	}
+	aead, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("cannot decrypt the %s for `%s`: unknown key `%s`", kind, owner, parts[0])
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("invalid encrypted %s for `%s`", kind, owner)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, additionalData(kind, owner))
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt the %s for `%s`: %v", kind, owner, err)
+	}
+	return string(plain), nil
+}
+
+// Current checks if a value is empty or encrypted with the primary key
+func (k *Keyring) Current(value string) bool {
+	return len(value) == 0 || strings.HasPrefix(value, sealedPrefix+k.primary+":")
+}
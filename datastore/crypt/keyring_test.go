@@ -0,0 +1,106 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package crypt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeyring_SealOpen(t *testing.T) {
+	keys, err := NewKeyring("current-secret", "previous-secret")
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	previous, _ := NewKeyring("previous-secret")
+	other, _ := NewKeyring("other-secret")
+
+	sealed, err := keys.Seal("device-key", "a111", "AAAAAAAAA")
+	if err != nil {
+		t.Fatalf("Keyring.Seal() error = %v", err)
+	}
+	if !strings.HasPrefix(sealed, sealedPrefix) || strings.Contains(sealed, "AAAAAAAAA") {
+		t.Fatalf("Keyring.Seal() = %v, expected an encrypted value", sealed)
+	}
+	if again, _ := keys.Seal("device-key", "a111", "AAAAAAAAA"); again == sealed {
+		t.Error("Keyring.Seal() expected a different nonce for each value")
+	}
+	oldSealed, _ := previous.Seal("device-key", "a111", "AAAAAAAAA")
+	tampered := sealed[:len(sealed)-2] + "AA"
+	if tampered == sealed {
+		tampered = sealed[:len(sealed)-2] + "BB"
+	}
+
+	tests := []struct {
+		name    string
+		keys    *Keyring
+		kind    string
+		owner   string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"valid", keys, "device-key", "a111", sealed, "AAAAAAAAA", false},
+		{"valid-previous-key", keys, "device-key", "a111", oldSealed, "AAAAAAAAA", false},
+		{"valid-plain-text", keys, "device-key", "a111", "BBBBBBBBB", "BBBBBBBBB", false},
+		{"valid-empty", keys, "device-key", "a111", "", "", false},
+		{"invalid-owner", keys, "device-key", "b222", sealed, "", true},
+		{"invalid-kind", keys, "snap-config", "a111", sealed, "", true},
+		{"invalid-tampered", keys, "device-key", "a111", tampered, "", true},
+		{"invalid-unknown-key", other, "device-key", "a111", sealed, "", true},
+		{"invalid-format", keys, "device-key", "a111", sealedPrefix + "abc", "", true},
+		{"invalid-encoding", keys, "device-key", "a111", sealedPrefix + keys.primary + ":!!", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.keys.Open(tt.kind, tt.owner, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Keyring.Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Keyring.Open() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if !keys.Current(sealed) || keys.Current(oldSealed) || keys.Current("AAAAAAAAA") || !keys.Current("") {
+		t.Error("Keyring.Current() expected only values sealed with the primary key to be current")
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		secrets []string
+		wantErr bool
+	}{
+		{"valid", []string{"secret"}, false},
+		{"valid-previous", []string{"secret", "previous"}, false},
+		{"invalid-none", nil, true},
+		{"invalid-empty", []string{""}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeyring(tt.secrets...); (err != nil) != tt.wantErr {
+				t.Errorf("NewKeyring() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
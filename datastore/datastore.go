@@ -20,33 +20,55 @@
 package datastore
 
 import (
+	"context"
 	"time"
 )
 
 // DataStore is the interfaces for the data repository
 type DataStore interface {
-	DeviceList(orgID string) ([]Device, error)
-	DeviceGet(id string) (Device, error)
-	DevicePing(id string, refresh time.Time) error
-	DeviceCreate(Device) (int64, error)
-
-	DeviceSnapList(id int64) ([]DeviceSnap, error)
-	DeviceSnapDelete(id int64) error
-	DeviceSnapUpsert(ds DeviceSnap) error
-
-	ActionCreate(act Action) (int64, error)
-	ActionUpdate(actionID, status, message string) error
-	ActionListForDevice(orgID, deviceID string) ([]Action, error)
-
-	DeviceVersionGet(deviceID int64) (DeviceVersion, error)
-	DeviceVersionUpsert(dv DeviceVersion) error
-	DeviceVersionDelete(id int64) error
-
-	GroupCreate(orgID, name string) (int64, error)
-	GroupList(orgID string) ([]Group, error)
-	GroupGet(orgID, name string) (Group, error)
-	GroupLinkDevice(orgID, name, deviceID string) error
-	GroupUnlinkDevice(orgID, name, deviceID string) error
-	GroupGetDevices(orgID, name string) ([]Device, error)
-	GroupGetExcludedDevices(orgID, name string) ([]Device, error)
+	DeviceList(ctx context.Context, orgID string, query DeviceQuery) ([]Device, error)
+	DeviceGet(ctx context.Context, id string) (Device, error)
+	DevicePing(ctx context.Context, id string, refresh time.Time) error
+	DevicePingBatch(ctx context.Context, refreshes map[string]time.Time) error
+	DeviceCreate(ctx context.Context, device Device) (int64, error)
+
+	DeviceLabelList(ctx context.Context, id int64) ([]DeviceLabel, error)
+	DeviceLabelListForOrg(ctx context.Context, orgID string) ([]DeviceLabel, error)
+	DeviceLabelSet(ctx context.Context, id int64, key, value string) error
+	DeviceLabelDelete(ctx context.Context, id int64, key string) error
+
+	DeviceSnapList(ctx context.Context, id int64) ([]DeviceSnap, error)
+	DeviceSnapListForOrg(ctx context.Context, orgID string) ([]DeviceSnap, error)
+	DeviceSnapDelete(ctx context.Context, id int64) error
+	DeviceSnapUpsert(ctx context.Context, ds DeviceSnap) error
+	DeviceSnapReplace(ctx context.Context, id int64, snaps []DeviceSnap) error
+	SnapInventory(ctx context.Context, orgID string) ([]SnapCount, error)
+
+	ActionCreate(ctx context.Context, act Action) (int64, error)
+	ActionUpdate(ctx context.Context, actionID, status, message string) error
+	ActionGet(ctx context.Context, actionID string) (Action, error)
+	ActionListForDevice(ctx context.Context, orgID, deviceID string, query ActionQuery) ([]Action, error)
+	ActionListForOrg(ctx context.Context, orgID string) ([]Action, error)
+	ActionOrgList(ctx context.Context) ([]string, error)
+	ActionListExpired(ctx context.Context, orgID string, before time.Time, keep, limit int) ([]Action, error)
+	ActionDelete(ctx context.Context, ids []int64) error
+
+	DeviceVersionGet(ctx context.Context, deviceID int64) (DeviceVersion, error)
+	DeviceVersionListForOrg(ctx context.Context, orgID string) ([]DeviceVersion, error)
+	DeviceVersionUpsert(ctx context.Context, dv DeviceVersion) error
+	DeviceVersionDelete(ctx context.Context, id int64) error
+
+	GroupCreate(ctx context.Context, grp Group) (int64, error)
+	GroupList(ctx context.Context, orgID string) ([]Group, error)
+	GroupGet(ctx context.Context, orgID, name string) (Group, error)
+	GroupUpdate(ctx context.Context, grp Group) error
+	GroupDelete(ctx context.Context, orgID, name string) error
+	GroupLinkDevice(ctx context.Context, orgID, name, deviceID string) error
+	GroupUnlinkDevice(ctx context.Context, orgID, name, deviceID string) error
+	GroupLinkList(ctx context.Context, orgID string) ([]GroupDeviceLink, error)
+	GroupGetDevices(ctx context.Context, orgID, name string, query DeviceQuery) ([]Device, error)
+	GroupGetExcludedDevices(ctx context.Context, orgID, name string, query DeviceQuery) ([]Device, error)
+
+	SecretList(ctx context.Context) ([]Secret, error)
+	SecretUpdate(ctx context.Context, secrets []Secret) error
 }
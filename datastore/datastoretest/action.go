@@ -0,0 +1,166 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastoretest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-devicetwin/datastore"
+)
+
+// createActions logs the actions, returning their IDs by action ID
+func createActions(t *testing.T, db datastore.DataStore, actions []datastore.Action) map[string]int64 {
+	ids := map[string]int64{}
+	for _, a := range actions {
+		id, err := db.ActionCreate(context.Background(), a)
+		check(t, "ActionCreate()", err)
+		ids[a.ActionID] = id
+	}
+	return ids
+}
+
+// actionIDs lists the action IDs in order
+func actionIDs(actions []datastore.Action) []string {
+	ids := []string{}
+	for _, a := range actions {
+		ids = append(ids, a.ActionID)
+	}
+	return ids
+}
+
+func testActions(t *testing.T, db datastore.DataStore) {
+	ctx := context.Background()
+	ids := createActions(t, db, []datastore.Action{
+		{OrganizationID: "abc", DeviceID: "a111", ActionID: "a1", Action: "list", Status: "requested", Created: date(1)},
+		{OrganizationID: "abc", DeviceID: "a111", ActionID: "a2", Action: "install", Status: "requested", Created: date(3)},
+		{OrganizationID: "abc", DeviceID: "a111", ActionID: "a3", Action: "remove", Status: "requested", Created: date(3)},
+		{OrganizationID: "abc", DeviceID: "a111", ActionID: "a4", Action: "refresh", Status: "requested", Created: date(5)},
+		{OrganizationID: "abc", DeviceID: "b222", ActionID: "b1", Action: "list", Status: "requested", Created: date(2)},
+		{OrganizationID: "xyz", DeviceID: "a111", ActionID: "x1", Action: "list", Status: "requested", Created: date(4)},
+	})
+
+	// The log of an organization is listed oldest first
+	orgActions, err := db.ActionListForOrg(ctx, "abc")
+	check(t, "ActionListForOrg()", err)
+	checkEqual(t, "ActionListForOrg()", actionIDs(orgActions), []string{"a1", "b1", "a2", "a3", "a4"})
+	if ids["a1"] <= 0 || ids["a2"] <= ids["a1"] {
+		t.Errorf("ActionCreate() IDs = %v, want increasing positive IDs", ids)
+	}
+
+	check(t, "ActionUpdate()", db.ActionUpdate(ctx, "a2", "complete", "done"))
+
+	tests := []struct {
+		name     string
+		orgID    string
+		deviceID string
+		query    datastore.ActionQuery
+		want     []string
+	}{
+		{"all", "abc", "a111", datastore.ActionQuery{}, []string{"a4", "a3", "a2", "a1"}},
+		{"other-device", "abc", "b222", datastore.ActionQuery{}, []string{"b1"}},
+		{"other-org", "xyz", "a111", datastore.ActionQuery{}, []string{"x1"}},
+		{"unknown-device", "abc", "c333", datastore.ActionQuery{}, []string{}},
+		{"from", "abc", "a111", datastore.ActionQuery{From: date(3)}, []string{"a4", "a3", "a2"}},
+		{"to", "abc", "a111", datastore.ActionQuery{To: date(3)}, []string{"a1"}},
+		{"range", "abc", "a111", datastore.ActionQuery{From: date(2), To: date(5)}, []string{"a3", "a2"}},
+		{"limit", "abc", "a111", datastore.ActionQuery{Limit: 2}, []string{"a4", "a3"}},
+		{"range-limit", "abc", "a111", datastore.ActionQuery{From: date(1), To: date(4), Limit: 2}, []string{"a3", "a2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.ActionListForDevice(ctx, tt.orgID, tt.deviceID, tt.query)
+			check(t, "ActionListForDevice()", err)
+			checkEqual(t, "ActionListForDevice()", actionIDs(got), tt.want)
+		})
+	}
+
+	action, err := db.ActionGet(ctx, "a2")
+	check(t, "ActionGet()", err)
+	if action.ID != ids["a2"] || action.Status != "complete" || action.Message != "done" || action.DeviceID != "a111" || action.Action != "install" {
+		t.Errorf("ActionGet() = %+v, want the updated action", action)
+	}
+	if _, err := db.ActionGet(ctx, "unknown"); !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("ActionGet() error = %v, want not found", err)
+	}
+
+	got, err := db.ActionListForDevice(ctx, "abc", "a111", datastore.ActionQuery{From: date(3), To: date(4)})
+	check(t, "ActionListForDevice()", err)
+	for _, a := range got {
+		if a.ActionID == "a2" && (a.Status != "complete" || a.Message != "done" || a.ID != ids["a2"]) {
+			t.Errorf("ActionUpdate() = %+v, want the updated status", a)
+		}
+		if a.ActionID == "a3" && (a.Status != "requested" || a.Action != "remove" || !a.Created.Equal(date(3))) {
+			t.Errorf("ActionUpdate() = %+v, want an unchanged action", a)
+		}
+	}
+}
+
+func testActionRetention(t *testing.T, db datastore.DataStore) {
+	ctx := context.Background()
+	ids := createActions(t, db, []datastore.Action{
+		{OrganizationID: "abc", DeviceID: "a111", ActionID: "a1", Action: "list", Created: date(1)},
+		{OrganizationID: "abc", DeviceID: "a111", ActionID: "a2", Action: "list", Created: date(5)},
+		{OrganizationID: "abc", DeviceID: "a111", ActionID: "a3", Action: "list", Created: date(9)},
+		{OrganizationID: "abc", DeviceID: "b222", ActionID: "b1", Action: "list", Created: date(2)},
+		{OrganizationID: "xyz", DeviceID: "x999", ActionID: "x1", Action: "list", Created: date(1)},
+	})
+
+	orgs, err := db.ActionOrgList(ctx)
+	check(t, "ActionOrgList()", err)
+	checkEqual(t, "ActionOrgList()", orgs, []string{"abc", "xyz"})
+
+	tests := []struct {
+		name   string
+		orgID  string
+		before time.Time
+		keep   int
+		limit  int
+		want   []string
+	}{
+		{"no-limits", "abc", time.Time{}, 0, 0, []string{}},
+		{"before", "abc", date(4), 0, 0, []string{"a1", "b1"}},
+		{"keep", "abc", time.Time{}, 1, 0, []string{"a1", "a2"}},
+		{"before-or-keep", "abc", date(2), 2, 0, []string{"a1"}},
+		{"before-page", "abc", date(4), 0, 1, []string{"a1"}},
+		{"keep-page", "abc", time.Time{}, 1, 1, []string{"a1"}},
+		{"other-org", "xyz", date(4), 0, 0, []string{"x1"}},
+		{"unknown-org", "unknown", date(4), 1, 0, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.ActionListExpired(ctx, tt.orgID, tt.before, tt.keep, tt.limit)
+			check(t, "ActionListExpired()", err)
+			checkEqual(t, "ActionListExpired()", actionIDs(got), tt.want)
+		})
+	}
+
+	check(t, "ActionDelete()", db.ActionDelete(ctx, []int64{ids["a1"], ids["x1"]}))
+	check(t, "ActionDelete() empty", db.ActionDelete(ctx, []int64{}))
+	got, err := db.ActionListForDevice(ctx, "abc", "a111", datastore.ActionQuery{})
+	check(t, "ActionListForDevice()", err)
+	checkEqual(t, "ActionDelete()", actionIDs(got), []string{"a3", "a2"})
+
+	orgs, err = db.ActionOrgList(ctx)
+	check(t, "ActionOrgList()", err)
+	checkEqual(t, "ActionOrgList() after delete", orgs, []string{"abc"})
+}
@@ -0,0 +1,127 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package datastoretest provides the conformance tests that every implementation of
+// datastore.DataStore must pass, so that the drivers behave the same way.
+package datastoretest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-devicetwin/datastore"
+)
+
+// Open creates a fresh, empty instance of a data store, with a function to close it
+type Open func(t *testing.T) (datastore.DataStore, func())
+
+// Run runs the conformance tests against a data store driver. Each test has its own instance
+func Run(t *testing.T, open Open) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, db datastore.DataStore)
+	}{
+		{"DeviceCreate", testDeviceCreate},
+		{"DevicePing", testDevicePing},
+		{"DevicePingBatch", testDevicePingBatch},
+		{"DeviceList", testDeviceList},
+		{"DeviceListPage", testDeviceListPage},
+		{"DeviceLabels", testDeviceLabels},
+		{"DeviceSnaps", testDeviceSnaps},
+		{"SnapInventory", testSnapInventory},
+		{"DeviceVersion", testDeviceVersion},
+		{"Actions", testActions},
+		{"ActionRetention", testActionRetention},
+		{"Groups", testGroups},
+		{"GroupDelete", testGroupDelete},
+		{"GroupLinks", testGroupLinks},
+		{"GroupDevices", testGroupDevices},
+		{"Secrets", testSecrets},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			db, closeStore := open(t)
+			defer closeStore()
+			tt.test(t, db)
+		})
+	}
+}
+
+// Devices created by seed
+var devices = []datastore.Device{
+	{OrganisationID: "abc", DeviceID: "a111", Brand: "example", Model: "drone-1000", SerialNumber: "DR1000A111", StoreID: "example-store", DeviceKey: "AAAAAAAAA"},
+	{OrganisationID: "abc", DeviceID: "b222", Brand: "example", Model: "drone-1000", SerialNumber: "DR1000B222", StoreID: "example-store", DeviceKey: "BBBBBBBBB"},
+	{OrganisationID: "abc", DeviceID: "c333", Brand: "canonical", Model: "ubuntu-core-18-amd64", SerialNumber: "UC18C333", DeviceKey: "CCCCCCCCC"},
+	{OrganisationID: "xyz", DeviceID: "x999", Brand: "example", Model: "drone-1000", SerialNumber: "DR1000X999", StoreID: "example-store", DeviceKey: "XXXXXXXXX"},
+}
+
+// seed creates the test devices, returning their IDs by device ID
+func seed(t *testing.T, db datastore.DataStore) map[string]int64 {
+	ids := map[string]int64{}
+	for _, d := range devices {
+		id, err := db.DeviceCreate(context.Background(), d)
+		if err != nil {
+			t.Fatalf("DeviceCreate() error = %v", err)
+		}
+		ids[d.DeviceID] = id
+	}
+	return ids
+}
+
+// check fails the test if there is an error
+func check(t *testing.T, name string, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s error = %v", name, err)
+	}
+}
+
+// checkError fails the test if the error is not of the expected kind
+func checkError(t *testing.T, name string, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Errorf("%s error = %v, want %v", name, err, want)
+	}
+}
+
+// checkEqual fails the test if the values are different
+func checkEqual(t *testing.T, name string, got, want interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %v, want %v", name, got, want)
+	}
+}
+
+// deviceIDs lists the device IDs in order
+func deviceIDs(devices []datastore.Device) []string {
+	ids := []string{}
+	for _, d := range devices {
+		ids = append(ids, d.DeviceID)
+	}
+	return ids
+}
+
+// date creates a timestamp that every driver stores without loss
+func date(day int) time.Time {
+	return time.Date(2020, 1, day, 12, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,353 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastoretest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-devicetwin/datastore"
+)
+
+func testDeviceCreate(t *testing.T, db datastore.DataStore) {
+	ctx := context.Background()
+	ids := seed(t, db)
+
+	seen := map[int64]bool{}
+	for _, id := range ids {
+		if id <= 0 || seen[id] {
+			t.Errorf("DeviceCreate() IDs = %v, want unique positive IDs", ids)
+		}
+		seen[id] = true
+	}
+
+	for _, want := range devices {
+		got, err := db.DeviceGet(ctx, want.DeviceID)
+		check(t, "DeviceGet()", err)
+		want.ID = ids[want.DeviceID]
+		want.Active = true
+		got.Created, got.LastRefresh = time.Time{}, time.Time{}
+		checkEqual(t, "DeviceGet()", got, want)
+	}
+
+	// The times are set when they are given
+	id, err := db.DeviceCreate(ctx, datastore.Device{OrganisationID: "xyz", DeviceID: "y888", Brand: "example", Model: "drone-1000", Created: date(1), LastRefresh: date(2)})
+	check(t, "DeviceCreate()", err)
+	got, err := db.DeviceGet(ctx, "y888")
+	check(t, "DeviceGet()", err)
+	if got.ID != id || !got.Created.Equal(date(1)) || !got.LastRefresh.Equal(date(2)) {
+		t.Errorf("DeviceCreate() with times = %+v", got)
+	}
+
+	_, err = db.DeviceCreate(ctx, datastore.Device{OrganisationID: "xyz", DeviceID: "a111", Brand: "example", Model: "drone-1000"})
+	checkError(t, "DeviceCreate() duplicate", err, datastore.ErrConflict)
+
+	_, err = db.DeviceGet(ctx, "does-not-exist")
+	checkError(t, "DeviceGet() missing", err, datastore.ErrNotFound)
+}
+
+func testDevicePing(t *testing.T, db datastore.DataStore) {
+	ctx := context.Background()
+	seed(t, db)
+
+	check(t, "DevicePing()", db.DevicePing(ctx, "a111", date(2)))
+	got, err := db.DeviceGet(ctx, "a111")
+	check(t, "DeviceGet()", err)
+	if !got.LastRefresh.Equal(date(2)) {
+		t.Errorf("DevicePing() last refresh = %v, want %v", got.LastRefresh, date(2))
+	}
+
+	checkError(t, "DevicePing() missing", db.DevicePing(ctx, "does-not-exist", date(2)), datastore.ErrNotFound)
+}
+
+func testDevicePingBatch(t *testing.T, db datastore.DataStore) {
+	ctx := context.Background()
+	seed(t, db)
+	before, err := db.DeviceGet(ctx, "b222")
+	check(t, "DeviceGet()", err)
+
+	// Enough devices for more than one statement
+	refreshes := map[string]time.Time{"a111": date(2), "c333": date(3), "does-not-exist": date(4)}
+	for i := 0; i < 1200; i++ {
+		id := fmt.Sprintf("bulk%04d", i)
+		_, err := db.DeviceCreate(ctx, datastore.Device{OrganisationID: "bulk", DeviceID: id, Brand: "example", Model: "drone-1000", Created: date(1)})
+		check(t, "DeviceCreate()", err)
+		refreshes[id] = date(5)
+	}
+	check(t, "DevicePingBatch()", db.DevicePingBatch(ctx, refreshes))
+
+	want := map[string]time.Time{"a111": date(2), "b222": before.LastRefresh, "c333": date(3), "bulk0000": date(5), "bulk1199": date(5)}
+	for id, refresh := range want {
+		got, err := db.DeviceGet(ctx, id)
+		check(t, "DeviceGet()", err)
+		if !got.LastRefresh.Equal(refresh) {
+			t.Errorf("DevicePingBatch() last refresh of %s = %v, want %v", id, got.LastRefresh, refresh)
+		}
+	}
+
+	check(t, "DevicePingBatch() empty", db.DevicePingBatch(ctx, map[string]time.Time{}))
+}
+
+func testDeviceList(t *testing.T, db datastore.DataStore) {
+	ctx := context.Background()
+	ids := seed(t, db)
+
+	// Set up the device details to filter on
+	now := time.Now()
+	check(t, "DevicePing()", db.DevicePing(ctx, "a111", now))
+	check(t, "DevicePing()", db.DevicePing(ctx, "b222", date(2)))
+	check(t, "DevicePing()", db.DevicePing(ctx, "c333", date(3)))
+	check(t, "DeviceLabelSet()", db.DeviceLabelSet(ctx, ids["a111"], "site", "berlin"))
+	check(t, "DeviceLabelSet()", db.DeviceLabelSet(ctx, ids["b222"], "site", "paris"))
+	check(t, "DeviceSnapUpsert()", db.DeviceSnapUpsert(ctx, datastore.DeviceSnap{DeviceID: ids["b222"], Name: "helloworld", Revision: 29}))
+	check(t, "DeviceVersionUpsert()", db.DeviceVersionUpsert(ctx, datastore.DeviceVersion{DeviceID: ids["c333"], Version: "1", Series: "18"}))
+	_, err := db.GroupCreate(ctx, datastore.Group{OrganisationID: "abc", Name: "workshop"})
+	check(t, "GroupCreate()", err)
+	check(t, "GroupLinkDevice()", db.GroupLinkDevice(ctx, "abc", "workshop", "b222"))
+
+	selector, err := datastore.ParseLabelSelector("site=berlin")
+	check(t, "ParseLabelSelector()", err)
+
+	tests := []struct {
+		name    string
+		orgID   string
+		query   datastore.DeviceQuery
+		want    []string
+		wantErr error
+	}{
+		{"default-order", "abc", datastore.DeviceQuery{}, []string{"c333", "a111", "b222"}, nil},
+		{"other-org", "xyz", datastore.DeviceQuery{}, []string{"x999"}, nil},
+		{"unknown-org", "unknown", datastore.DeviceQuery{}, []string{}, nil},
+		{"brand", "abc", datastore.DeviceQuery{Brand: "example"}, []string{"a111", "b222"}, nil},
+		{"model", "abc", datastore.DeviceQuery{Model: "ubuntu-core-18-amd64"}, []string{"c333"}, nil},
+		{"series", "abc", datastore.DeviceQuery{Series: "18"}, []string{"c333"}, nil},
+		{"snap", "abc", datastore.DeviceQuery{Snap: "helloworld"}, []string{"b222"}, nil},
+		{"snap-revision", "abc", datastore.DeviceQuery{Snap: "helloworld", SnapRevision: 30}, []string{}, nil},
+		{"group", "abc", datastore.DeviceQuery{Group: "workshop"}, []string{"b222"}, nil},
+		{"labels", "abc", datastore.DeviceQuery{Labels: selector}, []string{"a111"}, nil},
+		{"online", "abc", datastore.DeviceQuery{Presence: datastore.PresenceOnline, PresenceCutoff: now.Add(-time.Hour)}, []string{"a111"}, nil},
+		{"offline", "abc", datastore.DeviceQuery{Presence: datastore.PresenceOffline, PresenceCutoff: now.Add(-time.Hour)}, []string{"c333", "b222"}, nil},
+		{"sort-serial", "abc", datastore.DeviceQuery{SortBy: datastore.SortSerial}, []string{"a111", "b222", "c333"}, nil},
+		{"sort-serial-desc", "abc", datastore.DeviceQuery{SortBy: datastore.SortSerial, SortDesc: true}, []string{"c333", "b222", "a111"}, nil},
+		{"sort-last-refresh", "abc", datastore.DeviceQuery{SortBy: datastore.SortLastRefresh}, []string{"b222", "c333", "a111"}, nil},
+		{"sort-device-id-desc", "abc", datastore.DeviceQuery{SortBy: datastore.SortDeviceID, SortDesc: true}, []string{"c333", "b222", "a111"}, nil},
+		{"invalid-sort", "abc", datastore.DeviceQuery{SortBy: "invalid"}, nil, datastore.ErrInvalid},
+		{"invalid-presence", "abc", datastore.DeviceQuery{Presence: "invalid"}, nil, datastore.ErrInvalid},
+		{"invalid-group", "abc", datastore.DeviceQuery{Group: "does-not-exist"}, nil, datastore.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.DeviceList(ctx, tt.orgID, tt.query)
+			if tt.wantErr != nil {
+				checkError(t, "DeviceList()", err, tt.wantErr)
+				return
+			}
+			check(t, "DeviceList()", err)
+			checkEqual(t, "DeviceList()", deviceIDs(got), tt.want)
+		})
+	}
+}
+
+func testDeviceListPage(t *testing.T, db datastore.DataStore) {
+	ctx := context.Background()
+	seed(t, db)
+
+	for _, sortBy := range []string{datastore.SortBrand, datastore.SortSerial, datastore.SortDeviceID} {
+		all, err := db.DeviceList(ctx, "abc", datastore.DeviceQuery{SortBy: sortBy})
+		check(t, "DeviceList()", err)
+
+		// Page through the devices one at a time
+		paged := []datastore.Device{}
+		query := datastore.DeviceQuery{SortBy: sortBy, Limit: 1}
+		for i := 0; i <= len(all); i++ {
+			page, err := db.DeviceList(ctx, "abc", query)
+			check(t, "DeviceList()", err)
+			if len(page) == 0 {
+				break
+			}
+			paged = append(paged, page...)
+			query.After = &page[len(page)-1]
+		}
+		checkEqual(t, "DeviceList() pages sorted by "+sortBy, deviceIDs(paged), deviceIDs(all))
+	}
+}
+
+func testDeviceLabels(t *testing.T, db datastore.DataStore) {
+	ctx := context.Background()
+	ids := seed(t, db)
+	id := ids["a111"]
+
+	check(t, "DeviceLabelSet()", db.DeviceLabelSet(ctx, id, "site", "berlin"))
+	check(t, "DeviceLabelSet()", db.DeviceLabelSet(ctx, id, "floor", "2"))
+	check(t, "DeviceLabelSet()", db.DeviceLabelSet(ctx, id, "site", "paris"))
+	check(t, "DeviceLabelSet()", db.DeviceLabelSet(ctx, ids["b222"], "site", "rome"))
+
+	labels, err := db.DeviceLabelList(ctx, id)
+	check(t, "DeviceLabelList()", err)
+	checkEqual(t, "DeviceLabelList()", labelValues(labels), []string{"floor=2", "site=paris"})
+	for _, l := range labels {
+		if l.DeviceID != id || l.ID <= 0 {
+			t.Errorf("DeviceLabelList() = %+v, want a label of device %d", l, id)
+		}
+	}
+
+	// The labels of an organization are listed by device
+	check(t, "DeviceLabelSet()", db.DeviceLabelSet(ctx, ids["x999"], "site", "oslo"))
+	labels, err = db.DeviceLabelListForOrg(ctx, "abc")
+	check(t, "DeviceLabelListForOrg()", err)
+	checkEqual(t, "DeviceLabelListForOrg()", labelValues(labels), []string{"floor=2", "site=paris", "site=rome"})
+	if labels[2].DeviceID != ids["b222"] {
+		t.Errorf("DeviceLabelListForOrg() = %+v, want a label of device %d", labels[2], ids["b222"])
+	}
+
+	check(t, "DeviceLabelDelete()", db.DeviceLabelDelete(ctx, id, "floor"))
+	check(t, "DeviceLabelDelete() missing", db.DeviceLabelDelete(ctx, id, "floor"))
+	labels, err = db.DeviceLabelList(ctx, id)
+	check(t, "DeviceLabelList()", err)
+	checkEqual(t, "DeviceLabelList()", labelValues(labels), []string{"site=paris"})
+
+	labels, err = db.DeviceLabelList(ctx, ids["c333"])
+	check(t, "DeviceLabelList()", err)
+	checkEqual(t, "DeviceLabelList() no labels", labelValues(labels), []string{})
+}
+
+func labelValues(labels []datastore.DeviceLabel) []string {
+	values := []string{}
+	for _, l := range labels {
+		values = append(values, l.Key+"="+l.Value)
+	}
+	return values
+}
+
+func testDeviceSnaps(t *testing.T, db datastore.DataStore) {
+	ctx := context.Background()
+	ids := seed(t, db)
+	id := ids["a111"]
+
+	check(t, "DeviceSnapUpsert()", db.DeviceSnapUpsert(ctx, datastore.DeviceSnap{DeviceID: id, Name: "helloworld", Revision: 29, Config: `{"title":"hello"}`}))
+	check(t, "DeviceSnapUpsert()", db.DeviceSnapUpsert(ctx, datastore.DeviceSnap{DeviceID: id, Name: "core", Revision: 6673}))
+	check(t, "DeviceSnapUpsert()", db.DeviceSnapUpsert(ctx, datastore.DeviceSnap{DeviceID: id, Name: "helloworld", Revision: 30, Config: `{"title":"bye"}`}))
+
+	snaps, err := db.DeviceSnapList(ctx, id)
+	check(t, "DeviceSnapList()", err)
+	checkEqual(t, "DeviceSnapList()", snapRevisions(snaps), []string{"core:6673", "helloworld:30"})
+	if snaps[1].Config != `{"title":"bye"}` || snaps[1].DeviceID != id {
+		t.Errorf("DeviceSnapUpsert() = %+v", snaps[1])
+	}
+
+	// Replacing drops the snaps that are not in the list, with the last duplicate winning.
+	// The snaps that are still installed keep their record
+	core := snaps[0]
+	check(t, "DeviceSnapReplace()", db.DeviceSnapReplace(ctx, id, []datastore.DeviceSnap{
+		{Name: "pc", Revision: 1}, {Name: "core", Revision: 7000}, {Name: "pc", Revision: 2},
+	}))
+	snaps, err = db.DeviceSnapList(ctx, id)
+	check(t, "DeviceSnapList()", err)
+	checkEqual(t, "DeviceSnapReplace()", snapRevisions(snaps), []string{"core:7000", "pc:2"})
+	if snaps[0].ID != core.ID || !snaps[0].Created.Equal(core.Created) {
+		t.Errorf("DeviceSnapReplace() = %v %v, want the existing record %v %v", snaps[0].ID, snaps[0].Created, core.ID, core.Created)
+	}
+
+	// Other devices are not changed
+	check(t, "DeviceSnapUpsert()", db.DeviceSnapUpsert(ctx, datastore.DeviceSnap{DeviceID: ids["b222"], Name: "core", Revision: 6673}))
+	check(t, "DeviceSnapUpsert()", db.DeviceSnapUpsert(ctx, datastore.DeviceSnap{DeviceID: ids["x999"], Name: "core", Revision: 6673}))
+	snaps, err = db.DeviceSnapListForOrg(ctx, "abc")
+	check(t, "DeviceSnapListForOrg()", err)
+	checkEqual(t, "DeviceSnapListForOrg()", snapRevisions(snaps), []string{"core:7000", "pc:2", "core:6673"})
+
+	check(t, "DeviceSnapDelete()", db.DeviceSnapDelete(ctx, id))
+	snaps, err = db.DeviceSnapList(ctx, id)
+	check(t, "DeviceSnapList()", err)
+	checkEqual(t, "DeviceSnapDelete()", snapRevisions(snaps), []string{})
+	snaps, err = db.DeviceSnapList(ctx, ids["b222"])
+	check(t, "DeviceSnapList()", err)
+	checkEqual(t, "DeviceSnapDelete() other device", snapRevisions(snaps), []string{"core:6673"})
+}
+
+func snapRevisions(snaps []datastore.DeviceSnap) []string {
+	values := []string{}
+	for _, s := range snaps {
+		values = append(values, s.Name+":"+strconv.Itoa(s.Revision))
+	}
+	return values
+}
+
+func testSnapInventory(t *testing.T, db datastore.DataStore) {
+	ctx := context.Background()
+	ids := seed(t, db)
+
+	for _, s := range []struct {
+		device string
+		snap   datastore.DeviceSnap
+	}{
+		{"a111", datastore.DeviceSnap{Name: "core", Version: "16-2.41", Revision: 7917, Channel: "stable", Status: "active"}},
+		{"b222", datastore.DeviceSnap{Name: "core", Version: "16-2.41", Revision: 7917, Channel: "stable", Status: "active"}},
+		{"c333", datastore.DeviceSnap{Name: "core", Version: "16-2.42", Revision: 8039, Channel: "beta", Status: "active"}},
+		{"a111", datastore.DeviceSnap{Name: "helloworld", Version: "6.4", Revision: 29, Channel: "stable", Status: "active"}},
+		{"x999", datastore.DeviceSnap{Name: "core", Version: "16-2.41", Revision: 7917, Channel: "stable", Status: "active"}},
+	} {
+		s.snap.DeviceID = ids[s.device]
+		check(t, "DeviceSnapUpsert()", db.DeviceSnapUpsert(ctx, s.snap))
+	}
+
+	got, err := db.SnapInventory(ctx, "abc")
+	check(t, "SnapInventory()", err)
+	checkEqual(t, "SnapInventory()", got, []datastore.SnapCount{
+		{Name: "core", Version: "16-2.41", Revision: 7917, Channel: "stable", Status: "active", Devices: 2},
+		{Name: "core", Version: "16-2.42", Revision: 8039, Channel: "beta", Status: "active", Devices: 1},
+		{Name: "helloworld", Version: "6.4", Revision: 29, Channel: "stable", Status: "active", Devices: 1},
+	})
+
+	got, err = db.SnapInventory(ctx, "unknown")
+	check(t, "SnapInventory()", err)
+	checkEqual(t, "SnapInventory() unknown org", got, []datastore.SnapCount{})
+}
+
+func testDeviceVersion(t *testing.T, db datastore.DataStore) {
+	ctx := context.Background()
+	ids := seed(t, db)
+	id := ids["a111"]
+
+	_, err := db.DeviceVersionGet(ctx, id)
+	checkError(t, "DeviceVersionGet() missing", err, datastore.ErrNotFound)
+
+	check(t, "DeviceVersionUpsert()", db.DeviceVersionUpsert(ctx, datastore.DeviceVersion{DeviceID: id, Version: "2.41", Series: "16", OSID: "ubuntu-core", OSVersionID: "16", KernelVersion: "4.4.0"}))
+	created, err := db.DeviceVersionGet(ctx, id)
+	check(t, "DeviceVersionGet()", err)
+
+	want := datastore.DeviceVersion{ID: created.ID, DeviceID: id, Version: "2.42", Series: "18", OSID: "ubuntu-core", OSVersionID: "18", OnClassic: true, KernelVersion: "4.15.0"}
+	check(t, "DeviceVersionUpsert()", db.DeviceVersionUpsert(ctx, datastore.DeviceVersion{DeviceID: id, Version: "2.42", Series: "18", OSID: "ubuntu-core", OSVersionID: "18", OnClassic: true, KernelVersion: "4.15.0"}))
+	got, err := db.DeviceVersionGet(ctx, id)
+	check(t, "DeviceVersionGet()", err)
+	checkEqual(t, "DeviceVersionUpsert() update", got, want)
+
+	check(t, "DeviceVersionUpsert()", db.DeviceVersionUpsert(ctx, datastore.DeviceVersion{DeviceID: ids["x999"], Version: "2.42"}))
+	versions, err := db.DeviceVersionListForOrg(ctx, "abc")
+	check(t, "DeviceVersionListForOrg()", err)
+	checkEqual(t, "DeviceVersionListForOrg()", versions, []datastore.DeviceVersion{want})
+
+	check(t, "DeviceVersionDelete()", db.DeviceVersionDelete(ctx, got.ID))
+	_, err = db.DeviceVersionGet(ctx, id)
+	checkError(t, "DeviceVersionGet() deleted", err, datastore.ErrNotFound)
+	checkError(t, "DeviceVersionDelete() missing", db.DeviceVersionDelete(ctx, got.ID), datastore.ErrNotFound)
+}
@@ -0,0 +1,251 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastoretest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/canonical/iot-devicetwin/datastore"
+)
+
+// createGroups creates the groups in order, setting the parents by name. Returns the IDs by name
+func createGroups(t *testing.T, db datastore.DataStore, groups []datastore.Group, parents map[string]string) map[string]int64 {
+	ids := map[string]int64{}
+	for _, g := range groups {
+		if parent, ok := parents[g.Name]; ok {
+			g.ParentID = ids[parent]
+		}
+		id, err := db.GroupCreate(context.Background(), g)
+		check(t, "GroupCreate()", err)
+		ids[g.Name] = id
+	}
+	return ids
+}
+
+// groupNames lists the group names in order
+func groupNames(groups []datastore.Group) []string {
+	names := []string{}
+	for _, g := range groups {
+		names = append(names, g.Name)
+	}
+	return names
+}
+
+func testGroups(t *testing.T, db datastore.DataStore) {
+	ctx := context.Background()
+	ids := createGroups(t, db, []datastore.Group{
+		{OrganisationID: "abc", Name: "workshop"},
+		{OrganisationID: "abc", Name: "drones", Rule: datastore.GroupRule{Brand: "example", Model: "drone-1000"}},
+		{OrganisationID: "abc", Name: "bench"},
+		{OrganisationID: "xyz", Name: "lab"},
+	}, map[string]string{"bench": "workshop"})
+	if ids["workshop"] <= 0 || ids["drones"] == ids["workshop"] {
+		t.Errorf("GroupCreate() IDs = %v, want unique positive IDs", ids)
+	}
+
+	_, err := db.GroupCreate(ctx, datastore.Group{OrganisationID: "abc", Name: "drones"})
+	checkError(t, "GroupCreate() duplicate", err, datastore.ErrConflict)
+
+	groups, err := db.GroupList(ctx, "abc")
+	check(t, "GroupList()", err)
+	checkEqual(t, "GroupList()", groupNames(groups), []string{"bench", "drones", "workshop"})
+	groups, err = db.GroupList(ctx, "unknown")
+	check(t, "GroupList()", err)
+	checkEqual(t, "GroupList() unknown org", groupNames(groups), []string{})
+
+	got, err := db.GroupGet(ctx, "abc", "drones")
+	check(t, "GroupGet()", err)
+	want := datastore.Group{ID: ids["drones"], OrganisationID: "abc", Name: "drones", Rule: datastore.GroupRule{Brand: "example", Model: "drone-1000"}}
+	got.Created, got.Modified = want.Created, want.Modified
+	checkEqual(t, "GroupGet()", got, want)
+
+	got, err = db.GroupGet(ctx, "abc", "bench")
+	check(t, "GroupGet()", err)
+	if got.ParentID != ids["workshop"] {
+		t.Errorf("GroupGet() parent = %d, want %d", got.ParentID, ids["workshop"])
+	}
+
+	_, err = db.GroupGet(ctx, "xyz", "drones")
+	checkError(t, "GroupGet() other org", err, datastore.ErrNotFound)
+
+	// Update the name, description, parent and rule
+	update := datastore.Group{ID: ids["drones"], OrganisationID: "abc", Name: "fleet", Description: "All the drones", ParentID: ids["workshop"], Rule: datastore.GroupRule{Brand: "example"}}
+	check(t, "GroupUpdate()", db.GroupUpdate(ctx, update))
+	got, err = db.GroupGet(ctx, "abc", "fleet")
+	check(t, "GroupGet()", err)
+	got.Created, got.Modified = update.Created, update.Modified
+	checkEqual(t, "GroupUpdate()", got, update)
+	_, err = db.GroupGet(ctx, "abc", "drones")
+	checkError(t, "GroupGet() renamed", err, datastore.ErrNotFound)
+
+	update.Name = "workshop"
+	checkError(t, "GroupUpdate() duplicate", db.GroupUpdate(ctx, update), datastore.ErrConflict)
+	checkError(t, "GroupUpdate() missing", db.GroupUpdate(ctx, datastore.Group{ID: 9999, OrganisationID: "abc", Name: "missing"}), datastore.ErrNotFound)
+}
+
+func testGroupDelete(t *testing.T, db datastore.DataStore) {
+	ctx := context.Background()
+	seed(t, db)
+	ids := createGroups(t, db, []datastore.Group{
+		{OrganisationID: "abc", Name: "site"},
+		{OrganisationID: "abc", Name: "building"},
+		{OrganisationID: "abc", Name: "floor"},
+		{OrganisationID: "xyz", Name: "building"},
+	}, map[string]string{"building": "site", "floor": "building"})
+	check(t, "GroupLinkDevice()", db.GroupLinkDevice(ctx, "abc", "building", "a111"))
+	check(t, "GroupLinkDevice()", db.GroupLinkDevice(ctx, "abc", "floor", "b222"))
+
+	check(t, "GroupDelete()", db.GroupDelete(ctx, "abc", "building"))
+	checkError(t, "GroupDelete() missing", db.GroupDelete(ctx, "abc", "building"), datastore.ErrNotFound)
+
+	groups, err := db.GroupList(ctx, "abc")
+	check(t, "GroupList()", err)
+	checkEqual(t, "GroupList()", groupNames(groups), []string{"floor", "site"})
+
+	// The child group is moved to the parent of the deleted group
+	floor, err := db.GroupGet(ctx, "abc", "floor")
+	check(t, "GroupGet()", err)
+	if floor.ParentID != ids["site"] {
+		t.Errorf("GroupDelete() child parent = %d, want %d", floor.ParentID, ids["site"])
+	}
+
+	// The links of the deleted group are removed
+	got, err := db.GroupGetDevices(ctx, "abc", "site", datastore.DeviceQuery{})
+	check(t, "GroupGetDevices()", err)
+	checkEqual(t, "GroupGetDevices()", deviceIDs(got), []string{"b222"})
+
+	// Groups of other organizations are not changed
+	_, err = db.GroupGet(ctx, "xyz", "building")
+	check(t, "GroupGet() other org", err)
+}
+
+func testGroupLinks(t *testing.T, db datastore.DataStore) {
+	ctx := context.Background()
+	ids := seed(t, db)
+	groups := createGroups(t, db, []datastore.Group{
+		{OrganisationID: "abc", Name: "workshop"},
+		{OrganisationID: "abc", Name: "bench"},
+		{OrganisationID: "xyz", Name: "lab"},
+	}, map[string]string{"bench": "workshop"})
+
+	check(t, "GroupLinkDevice()", db.GroupLinkDevice(ctx, "abc", "workshop", "a111"))
+	check(t, "GroupLinkDevice() again", db.GroupLinkDevice(ctx, "abc", "workshop", "a111"))
+	check(t, "GroupLinkDevice()", db.GroupLinkDevice(ctx, "abc", "workshop", "b222"))
+
+	checkError(t, "GroupLinkDevice() missing group", db.GroupLinkDevice(ctx, "abc", "missing", "a111"), datastore.ErrNotFound)
+	checkError(t, "GroupLinkDevice() missing device", db.GroupLinkDevice(ctx, "abc", "workshop", "missing"), datastore.ErrNotFound)
+	checkError(t, "GroupLinkDevice() other org", db.GroupLinkDevice(ctx, "xyz", "lab", "a111"), datastore.ErrNotFound)
+
+	check(t, "GroupLinkDevice()", db.GroupLinkDevice(ctx, "abc", "bench", "b222"))
+	check(t, "GroupLinkDevice()", db.GroupLinkDevice(ctx, "xyz", "lab", "x999"))
+
+	got, err := db.GroupGetDevices(ctx, "abc", "workshop", datastore.DeviceQuery{})
+	check(t, "GroupGetDevices()", err)
+	checkEqual(t, "GroupGetDevices()", deviceIDs(got), []string{"a111", "b222"})
+
+	// The links are listed once, without the members of the child groups
+	links, err := db.GroupLinkList(ctx, "abc")
+	check(t, "GroupLinkList()", err)
+	checkEqual(t, "GroupLinkList()", linkPairs(links), [][2]int64{
+		{groups["workshop"], ids["a111"]}, {groups["workshop"], ids["b222"]}, {groups["bench"], ids["b222"]},
+	})
+	for _, l := range links {
+		if l.OrganisationID != "abc" {
+			t.Errorf("GroupLinkList() = %+v, want a link of organization abc", l)
+		}
+	}
+
+	check(t, "GroupUnlinkDevice()", db.GroupUnlinkDevice(ctx, "abc", "workshop", "a111"))
+	check(t, "GroupUnlinkDevice() again", db.GroupUnlinkDevice(ctx, "abc", "workshop", "a111"))
+	checkError(t, "GroupUnlinkDevice() missing group", db.GroupUnlinkDevice(ctx, "abc", "missing", "a111"), datastore.ErrNotFound)
+	checkError(t, "GroupUnlinkDevice() missing device", db.GroupUnlinkDevice(ctx, "abc", "workshop", "missing"), datastore.ErrNotFound)
+	checkError(t, "GroupUnlinkDevice() other org", db.GroupUnlinkDevice(ctx, "xyz", "lab", "b222"), datastore.ErrNotFound)
+
+	got, err = db.GroupGetDevices(ctx, "abc", "workshop", datastore.DeviceQuery{})
+	check(t, "GroupGetDevices()", err)
+	checkEqual(t, "GroupGetDevices() unlinked", deviceIDs(got), []string{"b222"})
+
+	links, err = db.GroupLinkList(ctx, "abc")
+	check(t, "GroupLinkList()", err)
+	checkEqual(t, "GroupLinkList() unlinked", linkPairs(links), [][2]int64{
+		{groups["workshop"], ids["b222"]}, {groups["bench"], ids["b222"]},
+	})
+	links, err = db.GroupLinkList(ctx, "unknown")
+	check(t, "GroupLinkList()", err)
+	checkEqual(t, "GroupLinkList() unknown org", linkPairs(links), [][2]int64{})
+}
+
+// linkPairs lists the group and device IDs of the links, ordered by group ID and device ID
+func linkPairs(links []datastore.GroupDeviceLink) [][2]int64 {
+	pairs := [][2]int64{}
+	for _, l := range links {
+		pairs = append(pairs, [2]int64{l.GroupID, l.DeviceID})
+	}
+	return pairs
+}
+
+func testGroupDevices(t *testing.T, db datastore.DataStore) {
+	ctx := context.Background()
+	ids := seed(t, db)
+	check(t, "DeviceLabelSet()", db.DeviceLabelSet(ctx, ids["b222"], "site", "berlin"))
+	createGroups(t, db, []datastore.Group{
+		{OrganisationID: "abc", Name: "site"},
+		{OrganisationID: "abc", Name: "workshop"},
+		{OrganisationID: "abc", Name: "drones", Rule: datastore.GroupRule{Brand: "example", Model: "drone-1000"}},
+		{OrganisationID: "abc", Name: "berlin", Rule: datastore.GroupRule{LabelSelector: "site=berlin"}},
+		{OrganisationID: "abc", Name: "empty"},
+	}, map[string]string{"workshop": "site"})
+	check(t, "GroupLinkDevice()", db.GroupLinkDevice(ctx, "abc", "site", "c333"))
+	check(t, "GroupLinkDevice()", db.GroupLinkDevice(ctx, "abc", "workshop", "a111"))
+
+	tests := []struct {
+		name     string
+		group    string
+		query    datastore.DeviceQuery
+		want     []string
+		excluded []string
+	}{
+		{"static", "workshop", datastore.DeviceQuery{}, []string{"a111"}, []string{"c333", "b222"}},
+		{"child-groups", "site", datastore.DeviceQuery{}, []string{"c333", "a111"}, []string{"b222"}},
+		{"dynamic", "drones", datastore.DeviceQuery{}, []string{"a111", "b222"}, []string{"c333"}},
+		{"dynamic-labels", "berlin", datastore.DeviceQuery{}, []string{"b222"}, []string{"c333", "a111"}},
+		{"empty", "empty", datastore.DeviceQuery{}, []string{}, []string{"c333", "a111", "b222"}},
+		{"query", "site", datastore.DeviceQuery{Brand: "canonical"}, []string{"c333"}, []string{}},
+		{"sort", "drones", datastore.DeviceQuery{SortBy: datastore.SortSerial, SortDesc: true}, []string{"b222", "a111"}, []string{"c333"}},
+		{"limit", "site", datastore.DeviceQuery{Limit: 1}, []string{"c333"}, []string{"b222"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.GroupGetDevices(ctx, "abc", tt.group, tt.query)
+			check(t, "GroupGetDevices()", err)
+			checkEqual(t, "GroupGetDevices()", deviceIDs(got), tt.want)
+
+			got, err = db.GroupGetExcludedDevices(ctx, "abc", tt.group, tt.query)
+			check(t, "GroupGetExcludedDevices()", err)
+			checkEqual(t, "GroupGetExcludedDevices()", deviceIDs(got), tt.excluded)
+		})
+	}
+
+	_, err := db.GroupGetDevices(ctx, "xyz", "site", datastore.DeviceQuery{})
+	checkError(t, "GroupGetDevices() other org", err, datastore.ErrNotFound)
+	_, err = db.GroupGetExcludedDevices(ctx, "abc", "missing", datastore.DeviceQuery{})
+	checkError(t, "GroupGetExcludedDevices() missing", err, datastore.ErrNotFound)
+}
@@ -0,0 +1,85 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastoretest
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/canonical/iot-devicetwin/datastore"
+)
+
+// secretValues lists the secrets by kind and owner, in order
+func secretValues(secrets []datastore.Secret) []string {
+	values := []string{}
+	for _, s := range secrets {
+		values = append(values, s.Kind+" "+s.Owner+" "+s.Value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func testSecrets(t *testing.T, db datastore.DataStore) {
+	ctx := context.Background()
+	ids := seed(t, db)
+	check(t, "DeviceSnapUpsert()", db.DeviceSnapUpsert(ctx, datastore.DeviceSnap{DeviceID: ids["a111"], Name: "helloworld", Config: `{"title":"hello"}`}))
+	check(t, "DeviceSnapUpsert()", db.DeviceSnapUpsert(ctx, datastore.DeviceSnap{DeviceID: ids["a111"], Name: "core"}))
+	helloworld := datastore.SnapConfigOwner(ids["a111"], "helloworld")
+
+	// Only the fields with values are listed
+	secrets, err := db.SecretList(ctx)
+	check(t, "SecretList()", err)
+	checkEqual(t, "SecretList()", secretValues(secrets), []string{
+		"device-key a111 AAAAAAAAA",
+		"device-key b222 BBBBBBBBB",
+		"device-key c333 CCCCCCCCC",
+		"device-key x999 XXXXXXXXX",
+		"snap-config " + helloworld + ` {"title":"hello"}`,
+	})
+
+	// Update the values that are returned by the list
+	update := []datastore.Secret{}
+	for _, s := range secrets {
+		if s.Owner == "a111" || s.Owner == helloworld {
+			s.Value = "updated"
+			update = append(update, s)
+		}
+	}
+	check(t, "SecretUpdate()", db.SecretUpdate(ctx, update))
+
+	device, err := db.DeviceGet(ctx, "a111")
+	check(t, "DeviceGet()", err)
+	checkEqual(t, "SecretUpdate() device key", device.DeviceKey, "updated")
+	device, err = db.DeviceGet(ctx, "b222")
+	check(t, "DeviceGet()", err)
+	checkEqual(t, "SecretUpdate() other device key", device.DeviceKey, "BBBBBBBBB")
+
+	snaps, err := db.DeviceSnapList(ctx, ids["a111"])
+	check(t, "DeviceSnapList()", err)
+	for _, s := range snaps {
+		if s.Name == "helloworld" {
+			checkEqual(t, "SecretUpdate() snap config", s.Config, "updated")
+		}
+	}
+
+	invalid := []datastore.Secret{{Kind: "invalid", ID: ids["a111"], Owner: "a111", Value: "invalid"}}
+	checkError(t, "SecretUpdate() invalid kind", db.SecretUpdate(ctx, invalid), datastore.ErrInvalid)
+}
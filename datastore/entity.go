@@ -19,7 +19,10 @@
 
 package datastore
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // Action is the log of an action request
 type Action struct {
@@ -67,6 +70,17 @@ type DeviceSnap struct {
 	Config        string
 }
 
+// SnapCount is the number of devices of an organization that have a snap installed
+// with the same version, revision, channel and status
+type SnapCount struct {
+	Name     string
+	Version  string
+	Revision int
+	Channel  string
+	Status   string
+	Devices  int
+}
+
 // DeviceVersion holds the details of the OS details on the device
 type DeviceVersion struct {
 	ID            int64
@@ -86,6 +100,29 @@ type Group struct {
 	Modified       time.Time
 	OrganisationID string
 	Name           string
+	Description    string
+	ParentID       int64
+	Rule           GroupRule
+}
+
+// GroupRule defines the membership of a dynamic group. The devices that match
+// all the criteria are members of the group, evaluated each time the group is queried.
+type GroupRule struct {
+	Brand         string `json:"brand,omitempty"`
+	Model         string `json:"model,omitempty"`
+	Series        string `json:"series,omitempty"`
+	Snap          string `json:"snap,omitempty"`
+	LabelSelector string `json:"labelSelector,omitempty"`
+}
+
+// DeviceLabel is a key/value label on a device
+type DeviceLabel struct {
+	ID       int64
+	Created  time.Time
+	Modified time.Time
+	DeviceID int64
+	Key      string
+	Value    string
 }
 
 // GroupDeviceLink is the record for linking devices to groups
@@ -96,3 +133,24 @@ type GroupDeviceLink struct {
 	GroupID        int64
 	DeviceID       int64
 }
+
+// Kinds of sensitive fields that are encrypted at rest
+const (
+	SecretDeviceKey  = "device-key"
+	SecretSnapConfig = "snap-config"
+)
+
+// Secret is the stored value of a sensitive field, used to re-encrypt the field when the
+// key is rotated. The owner identifies the record the value belongs to: the device ID
+// for a device key, or the device and snap name for a snap config
+type Secret struct {
+	Kind  string
+	ID    int64
+	Owner string
+	Value string
+}
+
+// SnapConfigOwner identifies the snap of a device that a config secret belongs to
+func SnapConfigOwner(deviceID int64, name string) string {
+	return fmt.Sprintf("%d/%s", deviceID, name)
+}
@@ -0,0 +1,69 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The kinds of error returned by the data stores, to be checked with errors.Is
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrConflict  = errors.New("already exists")
+	ErrForbidden = errors.New("the organization ID does not match")
+	ErrInvalid   = errors.New("invalid request")
+)
+
+// Error is an error of a known kind, with a message that describes the record
+type Error struct {
+	Kind    error
+	Message string
+}
+
+// Error returns the message of the error
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the kind of the error
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// NotFound creates an error for a record that does not exist
+func NotFound(format string, a ...interface{}) error {
+	return &Error{Kind: ErrNotFound, Message: fmt.Sprintf(format, a...)}
+}
+
+// Conflict creates an error for a record that already exists
+func Conflict(format string, a ...interface{}) error {
+	return &Error{Kind: ErrConflict, Message: fmt.Sprintf(format, a...)}
+}
+
+// Forbidden creates an error for a record that belongs to another organization
+func Forbidden(format string, a ...interface{}) error {
+	return &Error{Kind: ErrForbidden, Message: fmt.Sprintf(format, a...)}
+}
+
+// Invalid creates an error for a request that is not valid
+func Invalid(format string, a ...interface{}) error {
+	return &Error{Kind: ErrInvalid, Message: fmt.Sprintf(format, a...)}
+}
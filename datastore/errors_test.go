@@ -0,0 +1,56 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		kind    error
+		message string
+	}{
+		{"not-found", NotFound("device with ID `%s` not found", "a111"), ErrNotFound, "device with ID `a111` not found"},
+		{"conflict", Conflict("group `%s` already exists", "workshop"), ErrConflict, "group `workshop` already exists"},
+		{"forbidden", Forbidden("the organization ID does not match the device"), ErrForbidden, "the organization ID does not match the device"},
+		{"invalid", Invalid("invalid sort order `%s`", "size"), ErrInvalid, "invalid sort order `size`"},
+		{"wrapped", fmt.Errorf("error finding group: %w", NotFound("group not found")), ErrNotFound, "error finding group: group not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !errors.Is(tt.err, tt.kind) {
+				t.Errorf("Error kind = %v, want %v", tt.err, tt.kind)
+			}
+			if tt.err.Error() != tt.message {
+				t.Errorf("Error() = %v, want %v", tt.err.Error(), tt.message)
+			}
+			for _, kind := range []error{ErrNotFound, ErrConflict, ErrForbidden, ErrInvalid} {
+				if kind != tt.kind && errors.Is(tt.err, kind) {
+					t.Errorf("Error kind = %v, unexpected %v", tt.err, kind)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+// Dynamic checks if the group membership is defined by the rule, rather than by device links
+func (r GroupRule) Dynamic() bool {
+	return r != GroupRule{}
+}
+
+// Query converts the rule to the device filters it represents
+func (r GroupRule) Query() (DeviceQuery, error) {
+	labels, err := ParseLabelSelector(r.LabelSelector)
+	if err != nil {
+		return DeviceQuery{}, err
+	}
+
+	return DeviceQuery{
+		Brand:  r.Brand,
+		Model:  r.Model,
+		Series: r.Series,
+		Snap:   r.Snap,
+		Labels: labels,
+	}, nil
+}
+
+// GroupSubtree returns the group with the given ID and all its descendants. The
+// members of a group are the members of any group in its subtree.
+func GroupSubtree(groups []Group, id int64) []Group {
+	subtree := []Group{}
+	visited := map[int64]bool{}
+	pending := []int64{id}
+
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+		if visited[current] {
+			continue
+		}
+		visited[current] = true
+
+		for _, g := range groups {
+			if g.ID == current {
+				subtree = append(subtree, g)
+			}
+			if g.ParentID == current && !visited[g.ID] {
+				pending = append(pending, g.ID)
+			}
+		}
+	}
+	return subtree
+}
@@ -0,0 +1,60 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package datastore
+
+import (
+	"testing"
+)
+
+func TestGroupSubtree(t *testing.T) {
+	groups := []Group{
+		{ID: 1, Name: "site"},
+		{ID: 2, Name: "building", ParentID: 1},
+		{ID: 3, Name: "floor", ParentID: 2},
+		{ID: 4, Name: "other"},
+	}
+	tests := []struct {
+		name string
+		id   int64
+		want []string
+	}{
+		{"root", 1, []string{"site", "building", "floor"}},
+		{"middle", 2, []string{"building", "floor"}},
+		{"leaf", 3, []string{"floor"}},
+		{"not-found", 5, []string{}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got := GroupSubtree(groups, tt.id)
+			names := []string{}
+			for _, g := range got {
+				names = append(names, g.Name)
+			}
+			if len(names) != len(tt.want) {
+				t.Fatalf("GroupSubtree() = %v, want %v", names, tt.want)
+			}
+			for i := range names {
+				if names[i] != tt.want[i] {
+					t.Errorf("GroupSubtree() = %v, want %v", names, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Operators for label selector requirements
+const (
+	LabelEquals    = "="
+	LabelNotEquals = "!="
+	LabelIn        = "in"
+	LabelExists    = "exists"
+)
+
+const maxLabelLength = 200
+
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+
+// LabelRequirement is a single condition of a label selector
+type LabelRequirement struct {
+	Key      string
+	Operator string
+	Values   []string
+}
+
+// Matches checks the requirement against the labels of a device
+func (req LabelRequirement) Matches(labels map[string]string) bool {
+	value, ok := labels[req.Key]
+	switch req.Operator {
+	case LabelEquals:
+		return ok && value == req.Values[0]
+	case LabelNotEquals:
+		return !ok || value != req.Values[0]
+	case LabelIn:
+		if !ok {
+			return false
+		}
+		for _, v := range req.Values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	case LabelExists:
+		return ok
+	default:
+		return false
+	}
+}
+
+// ValidateLabel checks that a label key and value are well-formed
+func ValidateLabel(key, value string) error {
+	if len(key) > maxLabelLength || !labelPattern.MatchString(key) {
+		return Invalid("invalid label key `%s`", key)
+	}
+	if len(value) > maxLabelLength || (len(value) > 0 && !labelPattern.MatchString(value)) {
+		return Invalid("invalid label value `%s`", value)
+	}
+	return nil
+}
+
+// ParseLabelSelector parses a comma-separated list of label requirements e.g.
+// `site=berlin,hw-rev!=2,tier in (edge,core),beta`
+func ParseLabelSelector(selector string) ([]LabelRequirement, error) {
+	reqs := []LabelRequirement{}
+	for _, term := range splitSelector(selector) {
+		term = strings.TrimSpace(term)
+		if len(term) == 0 {
+			continue
+		}
+
+		req, err := parseRequirement(term)
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+// splitSelector splits the selector on the commas that are not within parentheses
+func splitSelector(selector string) []string {
+	terms := []string{}
+	depth, start := 0, 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, selector[start:])
+}
+
+func parseRequirement(term string) (LabelRequirement, error) {
+	var req LabelRequirement
+
+	switch {
+	case strings.Contains(term, "!="):
+		parts := strings.SplitN(term, "!=", 2)
+		req = LabelRequirement{Key: strings.TrimSpace(parts[0]), Operator: LabelNotEquals, Values: []string{strings.TrimSpace(parts[1])}}
+	case strings.Contains(term, "="):
+		parts := strings.SplitN(term, "=", 2)
+		req = LabelRequirement{Key: strings.TrimSpace(parts[0]), Operator: LabelEquals, Values: []string{strings.TrimSpace(strings.TrimPrefix(parts[1], "="))}}
+	case strings.Contains(term, "("):
+		fields := strings.SplitN(term, "(", 2)
+		keyOp := strings.Fields(fields[0])
+		if len(keyOp) != 2 || keyOp[1] != LabelIn || !strings.HasSuffix(fields[1], ")") {
+			return req, Invalid("invalid label selector `%s`", term)
+		}
+		req = LabelRequirement{Key: keyOp[0], Operator: LabelIn}
+		for _, v := range strings.Split(strings.TrimSuffix(fields[1], ")"), ",") {
+			v = strings.TrimSpace(v)
+			if len(v) == 0 {
+				return req, Invalid("invalid label selector `%s`", term)
+			}
+			req.Values = append(req.Values, v)
+		}
+	default:
+		req = LabelRequirement{Key: term, Operator: LabelExists}
+	}
+
+	for _, v := range append([]string{""}, req.Values...) {
+		if err := ValidateLabel(req.Key, v); err != nil {
+			return req, Invalid("invalid label selector `%s`: %v", term, err)
+		}
+	}
+	return req, nil
+}
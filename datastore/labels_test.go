@@ -0,0 +1,89 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+		want     []LabelRequirement
+		wantErr  bool
+	}{
+		{"empty", "", []LabelRequirement{}, false},
+		{"equals", "site=berlin", []LabelRequirement{{"site", LabelEquals, []string{"berlin"}}}, false},
+		{"double-equals", "site==berlin", []LabelRequirement{{"site", LabelEquals, []string{"berlin"}}}, false},
+		{"not-equals", "hw-rev != 3", []LabelRequirement{{"hw-rev", LabelNotEquals, []string{"3"}}}, false},
+		{"in", "site in (berlin, london)", []LabelRequirement{{"site", LabelIn, []string{"berlin", "london"}}}, false},
+		{"exists", "beta", []LabelRequirement{{"beta", LabelExists, nil}}, false},
+		{"multiple", "site in (berlin,london),hw-rev=3,beta", []LabelRequirement{
+			{"site", LabelIn, []string{"berlin", "london"}},
+			{"hw-rev", LabelEquals, []string{"3"}},
+			{"beta", LabelExists, nil},
+		}, false},
+		{"invalid-key", "-site=berlin", nil, true},
+		{"invalid-value", "site=ber lin", nil, true},
+		{"invalid-operator", "site notin (berlin)", nil, true},
+		{"invalid-in-empty", "site in ()", nil, true},
+		{"invalid-in-unclosed", "site in (berlin", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLabelSelector(tt.selector)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseLabelSelector() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLabelSelector() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabelRequirement_Matches(t *testing.T) {
+	labels := map[string]string{"site": "berlin", "hw-rev": "3"}
+	tests := []struct {
+		name string
+		req  LabelRequirement
+		want bool
+	}{
+		{"equals", LabelRequirement{"site", LabelEquals, []string{"berlin"}}, true},
+		{"equals-no-match", LabelRequirement{"site", LabelEquals, []string{"london"}}, false},
+		{"not-equals", LabelRequirement{"site", LabelNotEquals, []string{"london"}}, true},
+		{"not-equals-missing", LabelRequirement{"tier", LabelNotEquals, []string{"edge"}}, true},
+		{"not-equals-no-match", LabelRequirement{"site", LabelNotEquals, []string{"berlin"}}, false},
+		{"in", LabelRequirement{"hw-rev", LabelIn, []string{"2", "3"}}, true},
+		{"in-no-match", LabelRequirement{"hw-rev", LabelIn, []string{"1", "2"}}, false},
+		{"exists", LabelRequirement{"site", LabelExists, nil}, true},
+		{"exists-missing", LabelRequirement{"tier", LabelExists, nil}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.req.Matches(labels); got != tt.want {
+				t.Errorf("LabelRequirement.Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memory
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/canonical/iot-devicetwin/datastore"
+	"github.com/canonical/iot-devicetwin/datastore/datastoretest"
+)
+
+func TestStore_Conformance(t *testing.T) {
+	datastoretest.Run(t, func(t *testing.T) (datastore.DataStore, func()) {
+		return NewEmptyStore(), func() {}
+	})
+}
+
+func TestStore_ConformancePersisted(t *testing.T) {
+	datastoretest.Run(t, func(t *testing.T) (datastore.DataStore, func()) {
+		dir, err := ioutil.TempDir("", "devicetwin")
+		if err != nil {
+			t.Fatalf("TempDir() error = %v", err)
+		}
+		mem, err := OpenStore(path.Join(dir, "devicetwin.json"))
+		if err != nil {
+			t.Fatalf("OpenStore() error = %v", err)
+		}
+		return mem, func() {
+			_ = mem.Close()
+			_ = os.RemoveAll(dir)
+		}
+	})
+}
@@ -20,8 +20,9 @@
 package memory
 
 import (
-	"fmt"
+	"context"
 	"github.com/canonical/iot-devicetwin/datastore"
+	"sort"
 	"sync"
 	"time"
 )
@@ -30,14 +31,32 @@ import (
 type Store struct {
 	Devices        []datastore.Device
 	Snaps          []datastore.DeviceSnap
+	Labels         []datastore.DeviceLabel
 	Actions        []datastore.Action
 	DeviceVersions []datastore.DeviceVersion
 	Groups         []datastore.Group
 	GroupLinks     []datastore.GroupDeviceLink
+	lastVersionID  int64 // the last ID given to a device version, so IDs are not reused
+	lastLinkID     int64 // the last ID given to a group link, so IDs are not reused
 	lock           sync.RWMutex
+	now            func() time.Time
+	journal        *journal
 }
 
-// NewStore creates a new memory store
+// NewEmptyStore creates a new memory store without any records
+func NewEmptyStore() *Store {
+	return &Store{
+		Devices:        []datastore.Device{},
+		Snaps:          []datastore.DeviceSnap{},
+		Labels:         []datastore.DeviceLabel{},
+		Actions:        []datastore.Action{},
+		DeviceVersions: []datastore.DeviceVersion{},
+		Groups:         []datastore.Group{},
+		GroupLinks:     []datastore.GroupDeviceLink{},
+	}
+}
+
+// NewStore creates a new memory store, seeded with demo records
 func NewStore() *Store {
 	d1 := datastore.Device{ID: 1, OrganisationID: "abc", DeviceID: "a111", Brand: "example", Model: "drone-1000", SerialNumber: "DR1000A111", DeviceKey: "AAAAAAAAA", StoreID: "example-store", Active: true}
 	d2 := datastore.Device{ID: 2, OrganisationID: "abc", DeviceID: "b222", Brand: "example", Model: "drone-1000", SerialNumber: "DR1000B222", DeviceKey: "BBBBBBBBB", StoreID: "example-store", Active: true}
@@ -48,6 +67,10 @@ func NewStore() *Store {
 		Snaps: []datastore.DeviceSnap{
 			{DeviceID: 1, Name: "example-snap", InstalledSize: 2000, Status: "active"},
 		},
+		Labels: []datastore.DeviceLabel{
+			{ID: 1, DeviceID: 1, Key: "site", Value: "berlin"},
+			{ID: 2, DeviceID: 2, Key: "site", Value: "london"},
+		},
 		Actions: []datastore.Action{
 			{ID: 1, OrganizationID: "abc", DeviceID: "c333", Action: "list", Status: ""},
 			{ID: 2, OrganizationID: "abc", DeviceID: "c333", Action: "list", Status: ""},
@@ -61,12 +84,12 @@ func NewStore() *Store {
 }
 
 // DeviceList fetches existing devices
-func (mem *Store) DeviceList(orgID string) ([]datastore.Device, error) {
+func (mem *Store) DeviceList(ctx context.Context, orgID string, query datastore.DeviceQuery) ([]datastore.Device, error) {
 	mem.lock.RLock()
 	defer mem.lock.RUnlock()
 
-	if orgID == "invalid" {
-		return nil, fmt.Errorf("MOCK list error")
+	if len(query.Group) > 0 && mem.group(orgID, query.Group) == nil {
+		return nil, datastore.NotFound("error cannot find group `%s`", query.Group)
 	}
 
 	devices := []datastore.Device{}
@@ -76,11 +99,11 @@ func (mem *Store) DeviceList(orgID string) ([]datastore.Device, error) {
 			devices = append(devices, d)
 		}
 	}
-	return devices, nil
+	return mem.queryDevices(devices, query)
 }
 
 // DeviceGet fetches an existing device
-func (mem *Store) DeviceGet(id string) (datastore.Device, error) {
+func (mem *Store) DeviceGet(ctx context.Context, id string) (datastore.Device, error) {
 	mem.lock.RLock()
 	defer mem.lock.RUnlock()
 
@@ -89,31 +112,21 @@ func (mem *Store) DeviceGet(id string) (datastore.Device, error) {
 			return d, nil
 		}
 	}
-	return datastore.Device{}, fmt.Errorf("device with ID `%s` not found", id)
-}
-
-// deviceGetByID fetches an existing device
-func (mem *Store) deviceGetByID(id int64) (datastore.Device, error) {
-	mem.lock.RLock()
-	defer mem.lock.RUnlock()
-
-	for _, d := range mem.Devices {
-		if d.ID == id {
-			return d, nil
-		}
-	}
-	return datastore.Device{}, fmt.Errorf("device with ID `%d` not found", id)
+	return datastore.Device{}, datastore.NotFound("device with ID `%s` not found", id)
 }
 
 // DevicePing updates a device to indicate its health
-func (mem *Store) DevicePing(id string, refresh time.Time) error {
-	device, err := mem.DeviceGet(id)
+func (mem *Store) DevicePing(ctx context.Context, id string, refresh time.Time) error {
+	device, err := mem.DeviceGet(ctx, id)
 	if err != nil {
 		return err
 	}
 
 	mem.lock.Lock()
 	defer mem.lock.Unlock()
+	if err := mem.record(mem.clock(), opDevicePing, id, refresh); err != nil {
+		return err
+	}
 	device.LastRefresh = refresh
 
 	for i := range mem.Devices {
@@ -124,52 +137,125 @@ func (mem *Store) DevicePing(id string, refresh time.Time) error {
 	return nil
 }
 
+// DevicePingBatch updates the health of several devices, skipping the unknown devices
+func (mem *Store) DevicePingBatch(ctx context.Context, refreshes map[string]time.Time) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+	if err := mem.record(mem.clock(), opDevicePingBatch, refreshes); err != nil {
+		return err
+	}
+
+	for i := range mem.Devices {
+		if refresh, ok := refreshes[mem.Devices[i].DeviceID]; ok {
+			mem.Devices[i].LastRefresh = refresh
+		}
+	}
+	return nil
+}
+
 // DeviceCreate creates a new device
-func (mem *Store) DeviceCreate(device datastore.Device) (int64, error) {
+func (mem *Store) DeviceCreate(ctx context.Context, device datastore.Device) (int64, error) {
 	// Check the device does not exist
-	if _, err := mem.DeviceGet(device.DeviceID); err == nil {
-		return 0, fmt.Errorf("device with ID `%s` already exists", device.DeviceID)
+	if _, err := mem.DeviceGet(ctx, device.DeviceID); err == nil {
+		return 0, datastore.Conflict("device with ID `%s` already exists", device.DeviceID)
 	}
 
 	mem.lock.Lock()
 	defer mem.lock.Unlock()
+	now := mem.clock()
 
-	device.Created = time.Now()
-	device.LastRefresh = time.Now()
+	if device.Created.IsZero() {
+		device.Created = now
+	}
+	if device.LastRefresh.IsZero() {
+		device.LastRefresh = device.Created
+	}
+	device.Active = true
 
 	device.ID = int64(len(mem.Devices) + 1)
+	if err := mem.record(now, opDeviceCreate, device); err != nil {
+		return 0, err
+	}
 	mem.Devices = append(mem.Devices, device)
 	return device.ID, nil
 }
 
 // DeviceSnapUpsert creates or updates a snap for a device
-func (mem *Store) DeviceSnapUpsert(ds datastore.DeviceSnap) error {
+func (mem *Store) DeviceSnapUpsert(ctx context.Context, ds datastore.DeviceSnap) error {
 	mem.lock.Lock()
 	defer mem.lock.Unlock()
+	now := mem.clock()
 	// Find the snap
 	found := -1
 	for i, s := range mem.Snaps {
-		if s.Name == ds.Name {
+		if s.DeviceID == ds.DeviceID && s.Name == ds.Name {
 			found = i
 		}
 	}
 
+	if err := mem.record(now, opDeviceSnapUpsert, ds); err != nil {
+		return err
+	}
+
 	if found < 0 {
 		// Not found, so create it
-		ds.Created = time.Now()
-		ds.Modified = time.Now()
+		ds.Created = now
+		ds.Modified = now
 		mem.Snaps = append(mem.Snaps, ds)
 		return nil
 	}
 
 	// Update the existing record
-	ds.Modified = time.Now()
+	ds.ID = mem.Snaps[found].ID
+	ds.Created = mem.Snaps[found].Created
+	ds.Modified = now
 	mem.Snaps[found] = ds
 	return nil
 }
 
+// DeviceSnapReplace replaces the snaps of a device
+func (mem *Store) DeviceSnapReplace(ctx context.Context, id int64, snaps []datastore.DeviceSnap) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+	now := mem.clock()
+
+	// The snaps that are already installed keep their created time
+	existing := []datastore.DeviceSnap{}
+	previous := map[string]datastore.DeviceSnap{}
+	for _, s := range mem.Snaps {
+		if s.DeviceID != id {
+			existing = append(existing, s)
+			continue
+		}
+		previous[s.Name] = s
+	}
+
+	names := map[string]int{}
+	for _, ds := range snaps {
+		ds.DeviceID = id
+		ds.Created = now
+		if s, ok := previous[ds.Name]; ok {
+			ds.ID = s.ID
+			ds.Created = s.Created
+		}
+		ds.Modified = now
+		if i, ok := names[ds.Name]; ok {
+			existing[i] = ds
+			continue
+		}
+		names[ds.Name] = len(existing)
+		existing = append(existing, ds)
+	}
+
+	if err := mem.record(now, opDeviceSnapReplace, id, snaps); err != nil {
+		return err
+	}
+	mem.Snaps = existing
+	return nil
+}
+
 // DeviceSnapList lists the snaps for a device
-func (mem *Store) DeviceSnapList(id int64) ([]datastore.DeviceSnap, error) {
+func (mem *Store) DeviceSnapList(ctx context.Context, id int64) ([]datastore.DeviceSnap, error) {
 	mem.lock.RLock()
 	defer mem.lock.RUnlock()
 	snaps := []datastore.DeviceSnap{}
@@ -179,11 +265,91 @@ func (mem *Store) DeviceSnapList(id int64) ([]datastore.DeviceSnap, error) {
 			snaps = append(snaps, s)
 		}
 	}
+	sort.Slice(snaps, func(i, j int) bool {
+		return snaps[i].Name < snaps[j].Name
+	})
+	return snaps, nil
+}
+
+// DeviceSnapListForOrg lists the snaps of the devices of an organization, by device
+func (mem *Store) DeviceSnapListForOrg(ctx context.Context, orgID string) ([]datastore.DeviceSnap, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	devices := mem.orgDevices(orgID)
+	snaps := []datastore.DeviceSnap{}
+	for _, s := range mem.Snaps {
+		if devices[s.DeviceID] {
+			snaps = append(snaps, s)
+		}
+	}
+	sort.Slice(snaps, func(i, j int) bool {
+		if snaps[i].DeviceID == snaps[j].DeviceID {
+			return snaps[i].Name < snaps[j].Name
+		}
+		return snaps[i].DeviceID < snaps[j].DeviceID
+	})
 	return snaps, nil
 }
 
+// orgDevices returns the IDs of the devices of an organization. The caller holds the lock
+func (mem *Store) orgDevices(orgID string) map[int64]bool {
+	devices := map[int64]bool{}
+	for _, d := range mem.Devices {
+		if d.OrganisationID == orgID {
+			devices[d.ID] = true
+		}
+	}
+	return devices
+}
+
+// SnapInventory counts the devices of an organization for each installed snap, version,
+// revision, channel and status
+func (mem *Store) SnapInventory(ctx context.Context, orgID string) ([]datastore.SnapCount, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	devices := mem.orgDevices(orgID)
+	counts := []datastore.SnapCount{}
+	for _, s := range mem.Snaps {
+		if !devices[s.DeviceID] {
+			continue
+		}
+
+		found := false
+		for i := range counts {
+			c := &counts[i]
+			if c.Name == s.Name && c.Version == s.Version && c.Revision == s.Revision && c.Channel == s.Channel && c.Status == s.Status {
+				c.Devices++
+				found = true
+			}
+		}
+		if !found {
+			counts = append(counts, datastore.SnapCount{Name: s.Name, Version: s.Version, Revision: s.Revision, Channel: s.Channel, Status: s.Status, Devices: 1})
+		}
+	}
+
+	sort.SliceStable(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		if a.Revision != b.Revision {
+			return a.Revision < b.Revision
+		}
+		if a.Channel != b.Channel {
+			return a.Channel < b.Channel
+		}
+		return a.Status < b.Status
+	})
+	return counts, nil
+}
+
 // DeviceSnapDelete deletes the snap records for a device
-func (mem *Store) DeviceSnapDelete(id int64) error {
+func (mem *Store) DeviceSnapDelete(ctx context.Context, id int64) error {
 	mem.lock.Lock()
 	defer mem.lock.Unlock()
 	snaps := []datastore.DeviceSnap{}
@@ -193,56 +359,360 @@ func (mem *Store) DeviceSnapDelete(id int64) error {
 			snaps = append(snaps, s)
 		}
 	}
+
+	if err := mem.record(mem.clock(), opDeviceSnapDelete, id); err != nil {
+		return err
+	}
 	mem.Snaps = snaps
+	return nil
+}
+
+// DeviceLabelList lists the labels for a device
+func (mem *Store) DeviceLabelList(ctx context.Context, id int64) ([]datastore.DeviceLabel, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	labels := []datastore.DeviceLabel{}
+	for _, l := range mem.Labels {
+		if l.DeviceID == id {
+			labels = append(labels, l)
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Key < labels[j].Key
+	})
+	return labels, nil
+}
+
+// DeviceLabelListForOrg lists the labels of the devices of an organization, by device
+func (mem *Store) DeviceLabelListForOrg(ctx context.Context, orgID string) ([]datastore.DeviceLabel, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
 
+	devices := mem.orgDevices(orgID)
+	labels := []datastore.DeviceLabel{}
+	for _, l := range mem.Labels {
+		if devices[l.DeviceID] {
+			labels = append(labels, l)
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].DeviceID == labels[j].DeviceID {
+			return labels[i].Key < labels[j].Key
+		}
+		return labels[i].DeviceID < labels[j].DeviceID
+	})
+	return labels, nil
+}
+
+// DeviceLabelSet creates or updates a label for a device
+func (mem *Store) DeviceLabelSet(ctx context.Context, id int64, key, value string) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+	now := mem.clock()
+	if err := mem.record(now, opDeviceLabelSet, id, key, value); err != nil {
+		return err
+	}
+
+	for i := range mem.Labels {
+		if mem.Labels[i].DeviceID == id && mem.Labels[i].Key == key {
+			mem.Labels[i].Value = value
+			mem.Labels[i].Modified = now
+			return nil
+		}
+	}
+
+	var labelID int64
+	for _, l := range mem.Labels {
+		if l.ID > labelID {
+			labelID = l.ID
+		}
+	}
+
+	mem.Labels = append(mem.Labels, datastore.DeviceLabel{
+		ID:       labelID + 1,
+		Created:  now,
+		Modified: now,
+		DeviceID: id,
+		Key:      key,
+		Value:    value,
+	})
+	return nil
+}
+
+// DeviceLabelDelete removes a label from a device
+func (mem *Store) DeviceLabelDelete(ctx context.Context, id int64, key string) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	labels := []datastore.DeviceLabel{}
+	for _, l := range mem.Labels {
+		if l.DeviceID != id || l.Key != key {
+			labels = append(labels, l)
+		}
+	}
+
+	if err := mem.record(mem.clock(), opDeviceLabelDelete, id, key); err != nil {
+		return err
+	}
+	mem.Labels = labels
 	return nil
 }
 
 // ActionCreate creates an action log
-func (mem *Store) ActionCreate(act datastore.Action) (int64, error) {
+func (mem *Store) ActionCreate(ctx context.Context, act datastore.Action) (int64, error) {
 	mem.lock.Lock()
 	defer mem.lock.Unlock()
 
-	act.ID = int64(len(mem.Actions) + 1)
+	now := mem.clock()
+	if act.Created.IsZero() {
+		act.Created = now
+	}
+	if act.Modified.IsZero() {
+		act.Modified = act.Created
+	}
+
+	// IDs are not reused after actions are purged
+	act.ID = 1
+	for _, a := range mem.Actions {
+		if a.ID >= act.ID {
+			act.ID = a.ID + 1
+		}
+	}
+	if err := mem.record(now, opActionCreate, act); err != nil {
+		return 0, err
+	}
 	mem.Actions = append(mem.Actions, act)
 	return act.ID, nil
 }
 
 // ActionUpdate updates an action log
-func (mem *Store) ActionUpdate(actionID, status, message string) error {
+func (mem *Store) ActionUpdate(ctx context.Context, actionID, status, message string) error {
 	mem.lock.Lock()
 	defer mem.lock.Unlock()
+	now := mem.clock()
 
 	actions := []datastore.Action{}
 	for _, a := range mem.Actions {
 		if a.ActionID == actionID {
 			a.Status = status
 			a.Message = message
+			a.Modified = now
 		}
-		a.Modified = time.Now()
 		actions = append(actions, a)
 	}
+
+	if err := mem.record(now, opActionUpdate, actionID, status, message); err != nil {
+		return err
+	}
 	mem.Actions = actions
 	return nil
 }
 
-// ActionListForDevice fetches the actions for a device
-func (mem *Store) ActionListForDevice(orgID, clientID string) ([]datastore.Action, error) {
+// ActionGet fetches an action by its action ID, the most recent if the ID is reused
+func (mem *Store) ActionGet(ctx context.Context, actionID string) (datastore.Action, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	found := false
+	action := datastore.Action{}
+	for _, a := range mem.Actions {
+		if a.ActionID == actionID && (!found || !a.Created.Before(action.Created)) {
+			action = a
+			found = true
+		}
+	}
+	if !found {
+		return action, datastore.NotFound("action with ID `%s` not found", actionID)
+	}
+	return action, nil
+}
+
+// ActionListForDevice fetches the actions for a device, most recent first
+func (mem *Store) ActionListForDevice(ctx context.Context, orgID, clientID string, query datastore.ActionQuery) ([]datastore.Action, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	actions := []datastore.Action{}
+	for _, a := range mem.Actions {
+		if a.OrganizationID != orgID || a.DeviceID != clientID {
+			continue
+		}
+		if (!query.From.IsZero() && a.Created.Before(query.From)) || (!query.To.IsZero() && !a.Created.Before(query.To)) {
+			continue
+		}
+		actions = append(actions, a)
+	}
+	sortActions(actions)
+
+	if query.Limit > 0 && len(actions) > query.Limit {
+		actions = actions[:query.Limit]
+	}
+	return actions, nil
+}
+
+// ActionListForOrg lists the action log of an organization, oldest first
+func (mem *Store) ActionListForOrg(ctx context.Context, orgID string) ([]datastore.Action, error) {
 	mem.lock.RLock()
 	defer mem.lock.RUnlock()
 
 	actions := []datastore.Action{}
 	for _, a := range mem.Actions {
-		if a.DeviceID == clientID {
+		if a.OrganizationID == orgID {
 			actions = append(actions, a)
 		}
 	}
+	sort.SliceStable(actions, func(i, j int) bool {
+		if actions[i].Created.Equal(actions[j].Created) {
+			return actions[i].ID < actions[j].ID
+		}
+		return actions[i].Created.Before(actions[j].Created)
+	})
+	return actions, nil
+}
 
+// ActionOrgList lists the organizations that have actions in the log
+func (mem *Store) ActionOrgList(ctx context.Context) ([]string, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	found := map[string]bool{}
+	orgs := []string{}
+	for _, a := range mem.Actions {
+		if !found[a.OrganizationID] {
+			found[a.OrganizationID] = true
+			orgs = append(orgs, a.OrganizationID)
+		}
+	}
+	sort.Strings(orgs)
+	return orgs, nil
+}
+
+// ActionListExpired lists the actions of an organization that are created before a time,
+// or beyond the most recent actions of each device. A zero time or count disables that limit.
+// The actions are in the order they were created, up to the limit or all of them for zero
+func (mem *Store) ActionListExpired(ctx context.Context, orgID string, before time.Time, keep, limit int) ([]datastore.Action, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	actions := []datastore.Action{}
+	if before.IsZero() && keep <= 0 {
+		return actions, nil
+	}
+
+	devices := map[string][]datastore.Action{}
+	for _, a := range mem.Actions {
+		if a.OrganizationID == orgID {
+			devices[a.DeviceID] = append(devices[a.DeviceID], a)
+		}
+	}
+	for _, list := range devices {
+		sortActions(list)
+		for i, a := range list {
+			if (!before.IsZero() && a.Created.Before(before)) || (keep > 0 && i >= keep) {
+				actions = append(actions, a)
+			}
+		}
+	}
+
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i].ID < actions[j].ID
+	})
+	if limit > 0 && len(actions) > limit {
+		actions = actions[:limit]
+	}
 	return actions, nil
 }
 
+// ActionDelete removes actions from the log
+func (mem *Store) ActionDelete(ctx context.Context, ids []int64) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	remove := map[int64]bool{}
+	for _, id := range ids {
+		remove[id] = true
+	}
+
+	actions := []datastore.Action{}
+	for _, a := range mem.Actions {
+		if !remove[a.ID] {
+			actions = append(actions, a)
+		}
+	}
+
+	if err := mem.record(mem.clock(), opActionDelete, ids); err != nil {
+		return err
+	}
+	mem.Actions = actions
+	return nil
+}
+
+// sortActions orders the actions with the most recent first
+func sortActions(actions []datastore.Action) {
+	sort.SliceStable(actions, func(i, j int) bool {
+		if actions[i].Created.Equal(actions[j].Created) {
+			return actions[i].ID > actions[j].ID
+		}
+		return actions[i].Created.After(actions[j].Created)
+	})
+}
+
+// SecretList lists the stored values of the sensitive fields
+func (mem *Store) SecretList(ctx context.Context) ([]datastore.Secret, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	secrets := []datastore.Secret{}
+	for _, d := range mem.Devices {
+		if len(d.DeviceKey) > 0 {
+			secrets = append(secrets, datastore.Secret{Kind: datastore.SecretDeviceKey, ID: d.ID, Owner: d.DeviceID, Value: d.DeviceKey})
+		}
+	}
+	for _, ds := range mem.Snaps {
+		if len(ds.Config) > 0 {
+			secrets = append(secrets, datastore.Secret{Kind: datastore.SecretSnapConfig, ID: int64(ds.ID), Owner: datastore.SnapConfigOwner(ds.DeviceID, ds.Name), Value: ds.Config})
+		}
+	}
+	return secrets, nil
+}
+
+// SecretUpdate stores new values of sensitive fields
+func (mem *Store) SecretUpdate(ctx context.Context, secrets []datastore.Secret) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	for _, s := range secrets {
+		if s.Kind != datastore.SecretDeviceKey && s.Kind != datastore.SecretSnapConfig {
+			return datastore.Invalid("invalid secret kind `%s`", s.Kind)
+		}
+	}
+	if err := mem.record(mem.clock(), opSecretUpdate, secrets); err != nil {
+		return err
+	}
+
+	for _, s := range secrets {
+		switch s.Kind {
+		case datastore.SecretDeviceKey:
+			for i := range mem.Devices {
+				if mem.Devices[i].ID == s.ID {
+					mem.Devices[i].DeviceKey = s.Value
+				}
+			}
+		case datastore.SecretSnapConfig:
+			// The snaps are not numbered in memory, so they are matched by device and name
+			for i := range mem.Snaps {
+				if datastore.SnapConfigOwner(mem.Snaps[i].DeviceID, mem.Snaps[i].Name) == s.Owner {
+					mem.Snaps[i].Config = s.Value
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // DeviceVersionGet gets the OS details for a device
-func (mem *Store) DeviceVersionGet(deviceID int64) (datastore.DeviceVersion, error) {
+func (mem *Store) DeviceVersionGet(ctx context.Context, deviceID int64) (datastore.DeviceVersion, error) {
 	mem.lock.RLock()
 	defer mem.lock.RUnlock()
 
@@ -251,14 +721,32 @@ func (mem *Store) DeviceVersionGet(deviceID int64) (datastore.DeviceVersion, err
 			return d, nil
 		}
 	}
-	return datastore.DeviceVersion{}, fmt.Errorf("device version with device ID `%d` not found", deviceID)
+	return datastore.DeviceVersion{}, datastore.NotFound("device version with device ID `%d` not found", deviceID)
 }
 
-// DeviceVersionUpsert creates or updates the device OS details
-func (mem *Store) DeviceVersionUpsert(dv datastore.DeviceVersion) error {
+// DeviceVersionListForOrg lists the device OS details of the devices of an organization
+func (mem *Store) DeviceVersionListForOrg(ctx context.Context, orgID string) ([]datastore.DeviceVersion, error) {
 	mem.lock.RLock()
 	defer mem.lock.RUnlock()
 
+	devices := mem.orgDevices(orgID)
+	versions := []datastore.DeviceVersion{}
+	for _, v := range mem.DeviceVersions {
+		if devices[v.DeviceID] {
+			versions = append(versions, v)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].DeviceID < versions[j].DeviceID
+	})
+	return versions, nil
+}
+
+// DeviceVersionUpsert creates or updates the device OS details
+func (mem *Store) DeviceVersionUpsert(ctx context.Context, dv datastore.DeviceVersion) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
 	// Find the record
 	found := -1
 	for i, v := range mem.DeviceVersions {
@@ -267,20 +755,26 @@ func (mem *Store) DeviceVersionUpsert(dv datastore.DeviceVersion) error {
 		}
 	}
 
+	if err := mem.record(mem.clock(), opDeviceVersionUpsert, dv); err != nil {
+		return err
+	}
+
 	if found < 0 {
 		// Not found, so create it
-		dv.ID = int64(len(mem.DeviceVersions) + 1)
+		dv.ID = mem.versionID() + 1
+		mem.lastVersionID = dv.ID
 		mem.DeviceVersions = append(mem.DeviceVersions, dv)
 		return nil
 	}
 
-	// Update the existing record
+	// Update the existing record, keeping its ID
+	dv.ID = mem.DeviceVersions[found].ID
 	mem.DeviceVersions[found] = dv
 	return nil
 }
 
 // DeviceVersionDelete removes a OS record
-func (mem *Store) DeviceVersionDelete(id int64) error {
+func (mem *Store) DeviceVersionDelete(ctx context.Context, id int64) error {
 	mem.lock.Lock()
 	defer mem.lock.Unlock()
 	versions := []datastore.DeviceVersion{}
@@ -293,61 +787,73 @@ func (mem *Store) DeviceVersionDelete(id int64) error {
 			found = true
 		}
 	}
-	mem.DeviceVersions = versions
-
 	if !found {
-		return fmt.Errorf("cannot find record with ID %d", id)
+		return datastore.NotFound("cannot find record with ID %d", id)
+	}
+
+	if err := mem.record(mem.clock(), opDeviceVersionDelete, id); err != nil {
+		return err
 	}
+	mem.DeviceVersions = versions
 	return nil
 }
 
 // GroupCreate creates a group record
-func (mem *Store) GroupCreate(orgID, name string) (int64, error) {
+func (mem *Store) GroupCreate(ctx context.Context, grp datastore.Group) (int64, error) {
 	mem.lock.Lock()
 	defer mem.lock.Unlock()
+	now := mem.clock()
 
-	if orgID == "invalid" {
-		return 0, fmt.Errorf("error cannot find organization `%s`", orgID)
+	for _, g := range mem.Groups {
+		if g.OrganisationID == grp.OrganisationID && g.Name == grp.Name {
+			return 0, datastore.Conflict("group `%s` already exists for organization `%s`", grp.Name, grp.OrganisationID)
+		}
 	}
 
+	var id int64
 	for _, g := range mem.Groups {
-		if g.OrganisationID == orgID && g.Name == name {
-			return 0, fmt.Errorf("group `%s1` already exists for organization `%s`", name, orgID)
+		if g.ID > id {
+			id = g.ID
 		}
 	}
 
 	g := datastore.Group{
-		ID:             int64(len(mem.Groups) + 1),
-		OrganisationID: orgID,
-		Name:           name,
-		Created:        time.Now(),
-		Modified:       time.Now(),
+		ID:             id + 1,
+		OrganisationID: grp.OrganisationID,
+		Name:           grp.Name,
+		Description:    grp.Description,
+		ParentID:       grp.ParentID,
+		Rule:           grp.Rule,
+		Created:        now,
+		Modified:       now,
+	}
+	if err := mem.record(now, opGroupCreate, grp); err != nil {
+		return 0, err
 	}
 	mem.Groups = append(mem.Groups, g)
 	return g.ID, nil
 }
 
 // GroupList lists groups for an organization
-func (mem *Store) GroupList(orgID string) ([]datastore.Group, error) {
+func (mem *Store) GroupList(ctx context.Context, orgID string) ([]datastore.Group, error) {
 	mem.lock.RLock()
 	defer mem.lock.RUnlock()
 
-	if orgID == "invalid" {
-		return nil, fmt.Errorf("error cannot find organization `%s`", orgID)
-	}
-
 	groups := []datastore.Group{}
 	for _, g := range mem.Groups {
 		if g.OrganisationID == orgID {
 			groups = append(groups, g)
 		}
 	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
 
 	return groups, nil
 }
 
 // GroupGet fetches a group record
-func (mem *Store) GroupGet(orgID, name string) (datastore.Group, error) {
+func (mem *Store) GroupGet(ctx context.Context, orgID, name string) (datastore.Group, error) {
 	mem.lock.RLock()
 	defer mem.lock.RUnlock()
 
@@ -357,17 +863,86 @@ func (mem *Store) GroupGet(orgID, name string) (datastore.Group, error) {
 		}
 	}
 
-	return datastore.Group{}, fmt.Errorf("error cannot find group `%s`", name)
+	return datastore.Group{}, datastore.NotFound("error cannot find group `%s`", name)
+}
+
+// GroupUpdate updates the name, description, parent and rule of a group
+func (mem *Store) GroupUpdate(ctx context.Context, grp datastore.Group) error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+	now := mem.clock()
+
+	found := -1
+	for i, g := range mem.Groups {
+		if g.OrganisationID == grp.OrganisationID && g.Name == grp.Name && g.ID != grp.ID {
+			return datastore.Conflict("group `%s` already exists for organization `%s`", grp.Name, grp.OrganisationID)
+		}
+		if g.ID == grp.ID {
+			found = i
+		}
+	}
+	if found < 0 {
+		return datastore.NotFound("error cannot find group with ID %d", grp.ID)
+	}
+	if err := mem.record(now, opGroupUpdate, grp); err != nil {
+		return err
+	}
+
+	g := &mem.Groups[found]
+	g.Name = grp.Name
+	g.Description = grp.Description
+	g.ParentID = grp.ParentID
+	g.Rule = grp.Rule
+	g.Modified = now
+	return nil
+}
+
+// GroupDelete deletes a group and its device links. The child groups are moved to the parent of the group
+func (mem *Store) GroupDelete(ctx context.Context, orgID, name string) error {
+	group, err := mem.GroupGet(ctx, orgID, name)
+	if err != nil {
+		return err
+	}
+
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+	if err := mem.record(mem.clock(), opGroupDelete, orgID, name); err != nil {
+		return err
+	}
+
+	links := []datastore.GroupDeviceLink{}
+	for _, l := range mem.GroupLinks {
+		if l.GroupID != group.ID {
+			links = append(links, l)
+		}
+	}
+	mem.GroupLinks = links
+
+	groups := []datastore.Group{}
+	for _, g := range mem.Groups {
+		if g.ID == group.ID {
+			continue
+		}
+		if g.ParentID == group.ID {
+			g.ParentID = group.ParentID
+		}
+		groups = append(groups, g)
+	}
+	mem.Groups = groups
+	return nil
 }
 
 // GroupLinkDevice links a device with a group
-func (mem *Store) GroupLinkDevice(orgID, name, clientID string) error {
-	device, err := mem.DeviceGet(clientID)
+func (mem *Store) GroupLinkDevice(ctx context.Context, orgID, name, clientID string) error {
+	device, err := mem.DeviceGet(ctx, clientID)
 	if err != nil {
 		return err
 	}
+	if device.OrganisationID != orgID {
+		return datastore.NotFound("error cannot find device `%s`", clientID)
+	}
 
-	group, err := mem.GroupGet(orgID, name)
+	group, err := mem.GroupGet(ctx, orgID, name)
 	if err != nil {
 		return err
 	}
@@ -376,30 +951,38 @@ func (mem *Store) GroupLinkDevice(orgID, name, clientID string) error {
 	defer mem.lock.Unlock()
 
 	for _, l := range mem.GroupLinks {
-		if l.GroupID == group.ID && l.ID == device.ID {
+		if l.GroupID == group.ID && l.DeviceID == device.ID {
 			// Link already exists, so no more work needed
 			return nil
 		}
 	}
 
 	link := datastore.GroupDeviceLink{
-		ID:             int64(len(mem.GroupLinks) + 1),
+		ID:             mem.linkID() + 1,
+		Created:        mem.clock(),
 		OrganisationID: orgID,
 		GroupID:        group.ID,
 		DeviceID:       device.ID,
 	}
+	if err := mem.record(mem.clock(), opGroupLinkDevice, orgID, name, clientID); err != nil {
+		return err
+	}
+	mem.lastLinkID = link.ID
 	mem.GroupLinks = append(mem.GroupLinks, link)
 	return nil
 }
 
 // GroupUnlinkDevice unlinks a device from a group
-func (mem *Store) GroupUnlinkDevice(orgID, name, clientID string) error {
-	device, err := mem.DeviceGet(clientID)
+func (mem *Store) GroupUnlinkDevice(ctx context.Context, orgID, name, clientID string) error {
+	device, err := mem.DeviceGet(ctx, clientID)
 	if err != nil {
 		return err
 	}
+	if device.OrganisationID != orgID {
+		return datastore.NotFound("error cannot find device `%s`", clientID)
+	}
 
-	group, err := mem.GroupGet(orgID, name)
+	group, err := mem.GroupGet(ctx, orgID, name)
 	if err != nil {
 		return err
 	}
@@ -414,13 +997,36 @@ func (mem *Store) GroupUnlinkDevice(orgID, name, clientID string) error {
 		}
 	}
 
+	if err := mem.record(mem.clock(), opGroupUnlinkDevice, orgID, name, clientID); err != nil {
+		return err
+	}
 	mem.GroupLinks = links
 	return nil
 }
 
+// GroupLinkList lists the devices that are linked to the static groups of an organization
+func (mem *Store) GroupLinkList(ctx context.Context, orgID string) ([]datastore.GroupDeviceLink, error) {
+	mem.lock.RLock()
+	defer mem.lock.RUnlock()
+
+	links := []datastore.GroupDeviceLink{}
+	for _, l := range mem.GroupLinks {
+		if l.OrganisationID == orgID {
+			links = append(links, l)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].GroupID == links[j].GroupID {
+			return links[i].DeviceID < links[j].DeviceID
+		}
+		return links[i].GroupID < links[j].GroupID
+	})
+	return links, nil
+}
+
 // GroupGetDevices fetches the devices for a group
-func (mem *Store) GroupGetDevices(orgID, name string) ([]datastore.Device, error) {
-	group, err := mem.GroupGet(orgID, name)
+func (mem *Store) GroupGetDevices(ctx context.Context, orgID, name string, query datastore.DeviceQuery) ([]datastore.Device, error) {
+	group, err := mem.GroupGet(ctx, orgID, name)
 	if err != nil {
 		return nil, err
 	}
@@ -429,43 +1035,102 @@ func (mem *Store) GroupGetDevices(orgID, name string) ([]datastore.Device, error
 	defer mem.lock.RUnlock()
 
 	devices := []datastore.Device{}
-
-	for _, l := range mem.GroupLinks {
-		if l.GroupID != group.ID {
-			continue
-		}
-
-		d, err := mem.deviceGetByID(l.DeviceID)
-		if err != nil {
-			return nil, err
+	for _, d := range mem.Devices {
+		if d.OrganisationID == orgID && mem.member(group, &d) {
+			devices = append(devices, d)
 		}
-		devices = append(devices, d)
 	}
-	return devices, nil
+	return mem.queryDevices(devices, query)
 }
 
 // GroupGetExcludedDevices fetches the devices not in a group
-func (mem *Store) GroupGetExcludedDevices(orgID, name string) ([]datastore.Device, error) {
-	devicesInGroup, err := mem.GroupGetDevices(orgID, name)
+func (mem *Store) GroupGetExcludedDevices(ctx context.Context, orgID, name string, query datastore.DeviceQuery) ([]datastore.Device, error) {
+	group, err := mem.GroupGet(ctx, orgID, name)
 	if err != nil {
 		return nil, err
 	}
-	devices := []datastore.Device{}
 
 	mem.lock.RLock()
 	defer mem.lock.RUnlock()
 
-	for _, l := range mem.Devices {
-		found := false
-		for _, d := range devicesInGroup {
-			if l.ID == d.ID {
-				found = true
-				break
+	devices := []datastore.Device{}
+	for _, d := range mem.Devices {
+		if d.OrganisationID == orgID && !mem.member(group, &d) {
+			devices = append(devices, d)
+		}
+	}
+	return mem.queryDevices(devices, query)
+}
+
+// member checks if a device is a member of a group or of any of its child groups.
+// The caller must hold the lock.
+func (mem *Store) member(group datastore.Group, d *datastore.Device) bool {
+	for _, g := range datastore.GroupSubtree(mem.Groups, group.ID) {
+		if mem.memberOf(g, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// memberOf checks if a device is a member of a single group, either matching the rule
+// of a dynamic group or linked to a static group. The caller must hold the lock.
+func (mem *Store) memberOf(group datastore.Group, d *datastore.Device) bool {
+	if group.Rule.Dynamic() {
+		rule, err := group.Rule.Query()
+		if err != nil {
+			return false
+		}
+		return mem.matchDevice(d, rule)
+	}
+
+	for _, l := range mem.GroupLinks {
+		if l.GroupID == group.ID && l.DeviceID == d.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// versionID is the last ID given to a device version, which starts from the highest ID of the
+// seeded records
+func (mem *Store) versionID() int64 {
+	if mem.lastVersionID == 0 {
+		for _, v := range mem.DeviceVersions {
+			if v.ID > mem.lastVersionID {
+				mem.lastVersionID = v.ID
 			}
 		}
-		if !found {
-			devices = append(devices, l)
+	}
+	return mem.lastVersionID
+}
+
+// linkID is the last ID given to a group link, which starts from the highest ID of the seeded links
+func (mem *Store) linkID() int64 {
+	if mem.lastLinkID == 0 {
+		for _, l := range mem.GroupLinks {
+			if l.ID > mem.lastLinkID {
+				mem.lastLinkID = l.ID
+			}
 		}
 	}
-	return devices, nil
+	return mem.lastLinkID
+}
+
+// clock returns the current time, or the time of the journal entry that is being replayed
+func (mem *Store) clock() time.Time {
+	if mem.now != nil {
+		return mem.now()
+	}
+	return time.Now()
+}
+
+// group finds a group record. The caller must hold the lock.
+func (mem *Store) group(orgID, name string) *datastore.Group {
+	for i := range mem.Groups {
+		if mem.Groups[i].OrganisationID == orgID && mem.Groups[i].Name == name {
+			return &mem.Groups[i]
+		}
+	}
+	return nil
 }
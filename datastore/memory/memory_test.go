@@ -20,6 +20,8 @@
 package memory
 
 import (
+	"context"
+	"reflect"
 	"testing"
 	"time"
 
@@ -42,7 +44,7 @@ func TestStore_DeviceGet(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mem := NewStore()
-			got, err := mem.DeviceGet(tt.args.id)
+			got, err := mem.DeviceGet(context.Background(), tt.args.id)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Store.DeviceGet() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -73,7 +75,7 @@ func TestStore_DevicePing(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mem := NewStore()
-			if err := mem.DevicePing(tt.args.id, tt.args.refresh); (err != nil) != tt.wantErr {
+			if err := mem.DevicePing(context.Background(), tt.args.id, tt.args.refresh); (err != nil) != tt.wantErr {
 				t.Errorf("Store.DevicePing() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if tt.wantErr {
@@ -81,7 +83,7 @@ func TestStore_DevicePing(t *testing.T) {
 			}
 
 			// Check the device is updated
-			got, err := mem.DeviceGet(tt.args.id)
+			got, err := mem.DeviceGet(context.Background(), tt.args.id)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Store.DeviceGet() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -123,7 +125,7 @@ func TestStore_DeviceCreate(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mem := NewStore()
-			got, err := mem.DeviceCreate(tt.args.device)
+			got, err := mem.DeviceCreate(context.Background(), tt.args.device)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Store.DeviceCreate() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -137,6 +139,33 @@ func TestStore_DeviceCreate(t *testing.T) {
 	}
 }
 
+func TestStore_DeviceLabelWorkflow(t *testing.T) {
+	mem := NewStore()
+
+	if err := mem.DeviceLabelSet(context.Background(), 3, "site", "paris"); err != nil {
+		t.Errorf("Store.DeviceLabelSet() error = %v", err)
+	}
+	if err := mem.DeviceLabelSet(context.Background(), 3, "site", "madrid"); err != nil {
+		t.Errorf("Store.DeviceLabelSet() error update = %v", err)
+	}
+
+	labels, err := mem.DeviceLabelList(context.Background(), 3)
+	if err != nil {
+		t.Errorf("Store.DeviceLabelList() error = %v", err)
+	}
+	if len(labels) != 1 || labels[0].Value != "madrid" {
+		t.Errorf("Store.DeviceLabelList() = %v, want site=madrid", labels)
+	}
+
+	if err := mem.DeviceLabelDelete(context.Background(), 3, "site"); err != nil {
+		t.Errorf("Store.DeviceLabelDelete() error = %v", err)
+	}
+	labels, _ = mem.DeviceLabelList(context.Background(), 3)
+	if len(labels) != 0 {
+		t.Errorf("Store.DeviceLabelList() after delete = %v, want none", labels)
+	}
+}
+
 func TestStore_ActionWorkflow(t *testing.T) {
 	type args struct {
 		act datastore.Action
@@ -147,12 +176,12 @@ func TestStore_ActionWorkflow(t *testing.T) {
 		want    int64
 		wantErr bool
 	}{
-		{"valid", args{datastore.Action{ActionID: "a1", Action: "device", DeviceID: "a111"}}, 3, false},
+		{"valid", args{datastore.Action{OrganizationID: "abc", ActionID: "a1", Action: "device", DeviceID: "a111"}}, 3, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mem := NewStore()
-			got, err := mem.ActionCreate(tt.args.act)
+			got, err := mem.ActionCreate(context.Background(), tt.args.act)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Store.ActionCreate() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -161,13 +190,13 @@ func TestStore_ActionWorkflow(t *testing.T) {
 				t.Errorf("Store.ActionCreate() = %v, want %v", got, tt.want)
 			}
 
-			err = mem.ActionUpdate(tt.args.act.ActionID, "complete", "Done")
+			err = mem.ActionUpdate(context.Background(), tt.args.act.ActionID, "complete", "Done")
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Store.ActionUpdate() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 
-			actions, err := mem.ActionListForDevice("abc", tt.args.act.DeviceID)
+			actions, err := mem.ActionListForDevice(context.Background(), "abc", tt.args.act.DeviceID, datastore.ActionQuery{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Store.ActionListForDevice() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -184,6 +213,89 @@ func TestStore_ActionWorkflow(t *testing.T) {
 	}
 }
 
+func TestStore_ActionRetention(t *testing.T) {
+	now := time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC)
+	mem := NewEmptyStore()
+	for i, a := range []datastore.Action{
+		{OrganizationID: "abc", DeviceID: "a111", ActionID: "a1", Created: now.AddDate(0, 0, -9)},
+		{OrganizationID: "abc", DeviceID: "a111", ActionID: "a2", Created: now.AddDate(0, 0, -5)},
+		{OrganizationID: "abc", DeviceID: "a111", ActionID: "a3", Created: now.AddDate(0, 0, -1)},
+		{OrganizationID: "abc", DeviceID: "b222", ActionID: "b1", Created: now.AddDate(0, 0, -8)},
+		{OrganizationID: "xyz", DeviceID: "c333", ActionID: "c1", Created: now.AddDate(0, 0, -9)},
+	} {
+		if _, err := mem.ActionCreate(context.Background(), a); err != nil {
+			t.Fatalf("Store.ActionCreate() %d error = %v", i, err)
+		}
+	}
+
+	list := []struct {
+		name  string
+		query datastore.ActionQuery
+		want  []string
+	}{
+		{"all", datastore.ActionQuery{}, []string{"a3", "a2", "a1"}},
+		{"from", datastore.ActionQuery{From: now.AddDate(0, 0, -5)}, []string{"a3", "a2"}},
+		{"to", datastore.ActionQuery{To: now.AddDate(0, 0, -5)}, []string{"a1"}},
+		{"limit", datastore.ActionQuery{Limit: 1}, []string{"a3"}},
+	}
+	for _, tt := range list {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mem.ActionListForDevice(context.Background(), "abc", "a111", tt.query)
+			if err != nil {
+				t.Fatalf("Store.ActionListForDevice() error = %v", err)
+			}
+			if ids := actionIDs(got); !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("Store.ActionListForDevice() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	expired := []struct {
+		name   string
+		before time.Time
+		keep   int
+		want   []string
+	}{
+		{"none", time.Time{}, 0, []string{}},
+		{"days", now.AddDate(0, 0, -7), 0, []string{"a1", "b1"}},
+		{"count", time.Time{}, 2, []string{"a1"}},
+		{"days-and-count", now.AddDate(0, 0, -3), 1, []string{"a1", "a2", "b1"}},
+	}
+	for _, tt := range expired {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mem.ActionListExpired(context.Background(), "abc", tt.before, tt.keep, 0)
+			if err != nil {
+				t.Fatalf("Store.ActionListExpired() error = %v", err)
+			}
+			if ids := actionIDs(got); !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("Store.ActionListExpired() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	if err := mem.ActionDelete(context.Background(), []int64{1, 4}); err != nil {
+		t.Fatalf("Store.ActionDelete() error = %v", err)
+	}
+	if id, _ := mem.ActionCreate(context.Background(), datastore.Action{OrganizationID: "abc", DeviceID: "a111", ActionID: "a4"}); id != 6 {
+		t.Errorf("Store.ActionCreate() = %v, want %v", id, 6)
+	}
+	orgs, _ := mem.ActionOrgList(context.Background())
+	if !reflect.DeepEqual(orgs, []string{"abc", "xyz"}) {
+		t.Errorf("Store.ActionOrgList() = %v, want %v", orgs, []string{"abc", "xyz"})
+	}
+	if len(mem.Actions) != 4 {
+		t.Errorf("Store.ActionDelete() left %d actions, want %d", len(mem.Actions), 4)
+	}
+}
+
+func actionIDs(actions []datastore.Action) []string {
+	ids := []string{}
+	for _, a := range actions {
+		ids = append(ids, a.ActionID)
+	}
+	return ids
+}
+
 func TestStore_DeviceVersionWorkflow(t *testing.T) {
 	type args struct {
 		dv datastore.DeviceVersion
@@ -198,7 +310,7 @@ func TestStore_DeviceVersionWorkflow(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mem := NewStore()
-			if err := mem.DeviceVersionUpsert(tt.args.dv); (err != nil) != tt.wantErr {
+			if err := mem.DeviceVersionUpsert(context.Background(), tt.args.dv); (err != nil) != tt.wantErr {
 				t.Errorf("Store.DeviceVersionUpsert() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
@@ -207,7 +319,7 @@ func TestStore_DeviceVersionWorkflow(t *testing.T) {
 			}
 
 			// Get the created record
-			dv, err := mem.DeviceVersionGet(tt.args.dv.DeviceID)
+			dv, err := mem.DeviceVersionGet(context.Background(), tt.args.dv.DeviceID)
 			if err != nil {
 				t.Errorf("Store.DeviceVersionGet() error = %v", err)
 			}
@@ -217,12 +329,12 @@ func TestStore_DeviceVersionWorkflow(t *testing.T) {
 
 			// Update the record
 			tt.args.dv.OSVersionID = "changed"
-			if err := mem.DeviceVersionUpsert(tt.args.dv); err != nil {
+			if err := mem.DeviceVersionUpsert(context.Background(), tt.args.dv); err != nil {
 				t.Errorf("Store.DeviceVersionUpsert() error update = %v", err)
 			}
 
 			// Get the updated record
-			dv2, err := mem.DeviceVersionGet(tt.args.dv.DeviceID)
+			dv2, err := mem.DeviceVersionGet(context.Background(), tt.args.dv.DeviceID)
 			if err != nil {
 				t.Errorf("Store.DeviceVersionGet() error = %v", err)
 			}
@@ -231,12 +343,12 @@ func TestStore_DeviceVersionWorkflow(t *testing.T) {
 			}
 
 			// Delete the record
-			if err := mem.DeviceVersionDelete(dv2.ID); err != nil {
+			if err := mem.DeviceVersionDelete(context.Background(), dv2.ID); err != nil {
 				t.Errorf("Store.DeviceVersionDelete() error update = %v", err)
 			}
 
 			// Check the record is deleted
-			if _, err := mem.DeviceVersionGet(tt.args.dv.DeviceID); err == nil {
+			if _, err := mem.DeviceVersionGet(context.Background(), tt.args.dv.DeviceID); err == nil {
 				t.Error("Store.DeviceVersionDelete() error delete check failed")
 			}
 		})
@@ -261,11 +373,11 @@ func TestStore_DeviceVersionDelete(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mem := NewStore()
 
-			if err := mem.DeviceVersionUpsert(tt.args.dv); err != nil {
+			if err := mem.DeviceVersionUpsert(context.Background(), tt.args.dv); err != nil {
 				t.Errorf("Store.DeviceVersionUpsert() error = %v", err)
 			}
 
-			if err := mem.DeviceVersionDelete(tt.args.id); (err != nil) != tt.wantErr {
+			if err := mem.DeviceVersionDelete(context.Background(), tt.args.id); (err != nil) != tt.wantErr {
 				t.Errorf("Store.DeviceVersionDelete() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -273,26 +385,48 @@ func TestStore_DeviceVersionDelete(t *testing.T) {
 }
 
 func TestStore_DeviceList(t *testing.T) {
+	after := &datastore.Device{ID: 1, Brand: "example", Model: "drone-1000", SerialNumber: "DR1000A111"}
 	tests := []struct {
 		name    string
 		orgID   string
-		want    int
+		query   datastore.DeviceQuery
+		want    []string
 		wantErr bool
 	}{
-		{"valid", "abc", 3, false},
-		{"valid-no-devices", "none", 0, false},
-		{"valid", "invalid", 0, true},
+		{"valid", "abc", datastore.DeviceQuery{}, []string{"c333", "a111", "b222"}, false},
+		{"valid-no-devices", "none", datastore.DeviceQuery{}, []string{}, false},
+		{"filter-model", "abc", datastore.DeviceQuery{Model: "drone-1000"}, []string{"a111", "b222"}, false},
+		{"filter-brand", "abc", datastore.DeviceQuery{Brand: "canonical"}, []string{"c333"}, false},
+		{"filter-series", "abc", datastore.DeviceQuery{Series: "16"}, []string{"c333"}, false},
+		{"filter-snap", "abc", datastore.DeviceQuery{Snap: "example-snap"}, []string{"a111"}, false},
+		{"filter-group", "abc", datastore.DeviceQuery{Group: "workshop"}, []string{"a111"}, false},
+		{"filter-online", "abc", datastore.DeviceQuery{Presence: datastore.PresenceOnline, PresenceCutoff: time.Now()}, []string{}, false},
+		{"filter-offline", "abc", datastore.DeviceQuery{Presence: datastore.PresenceOffline, PresenceCutoff: time.Now()}, []string{"c333", "a111", "b222"}, false},
+		{"sort-desc", "abc", datastore.DeviceQuery{SortBy: datastore.SortDeviceID, SortDesc: true}, []string{"c333", "b222", "a111"}, false},
+		{"limit", "abc", datastore.DeviceQuery{Limit: 2}, []string{"c333", "a111"}, false},
+		{"after", "abc", datastore.DeviceQuery{After: after}, []string{"b222"}, false},
+		{"filter-label", "abc", datastore.DeviceQuery{Labels: []datastore.LabelRequirement{{Key: "site", Operator: datastore.LabelEquals, Values: []string{"berlin"}}}}, []string{"a111"}, false},
+		{"filter-label-not-equals", "abc", datastore.DeviceQuery{Labels: []datastore.LabelRequirement{{Key: "site", Operator: datastore.LabelNotEquals, Values: []string{"berlin"}}}}, []string{"c333", "b222"}, false},
+		{"filter-label-exists", "abc", datastore.DeviceQuery{Labels: []datastore.LabelRequirement{{Key: "site", Operator: datastore.LabelExists}}}, []string{"a111", "b222"}, false},
+		{"invalid-sort", "abc", datastore.DeviceQuery{SortBy: "invalid"}, nil, true},
+		{"invalid-presence", "abc", datastore.DeviceQuery{Presence: "invalid"}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mem := NewStore()
-			got, err := mem.DeviceList(tt.orgID)
+			got, err := mem.DeviceList(context.Background(), tt.orgID, tt.query)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Store.DeviceList() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if len(got) != tt.want {
-				t.Errorf("Store.DeviceList() = %v, want %v", len(got), tt.want)
+			if len(got) != len(tt.want) {
+				t.Errorf("Store.DeviceList() = %v, want %v", len(got), len(tt.want))
+				return
+			}
+			for i := range got {
+				if got[i].DeviceID != tt.want[i] {
+					t.Errorf("Store.DeviceList() device %d = %v, want %v", i, got[i].DeviceID, tt.want[i])
+				}
 			}
 		})
 	}
@@ -311,12 +445,11 @@ func TestStore_GroupCreate(t *testing.T) {
 	}{
 		{"valid", args{"abc", "test-group"}, 2, false},
 		{"valid-exists", args{"abc", "workshop"}, 0, true},
-		{"invalid", args{"invalid", "test-group"}, 0, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mem := NewStore()
-			got, err := mem.GroupCreate(tt.args.orgID, tt.args.name)
+			got, err := mem.GroupCreate(context.Background(), datastore.Group{OrganisationID: tt.args.orgID, Name: tt.args.name})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Store.GroupCreate() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -328,6 +461,50 @@ func TestStore_GroupCreate(t *testing.T) {
 	}
 }
 
+func TestStore_GroupDynamic(t *testing.T) {
+	tests := []struct {
+		name         string
+		rule         datastore.GroupRule
+		want         int
+		wantExcluded int
+		wantLabelled int
+	}{
+		{"model", datastore.GroupRule{Model: "drone-1000"}, 2, 1, 2},
+		{"series", datastore.GroupRule{Series: "16"}, 1, 2, 1},
+		{"snap", datastore.GroupRule{Snap: "example-snap"}, 1, 2, 1},
+		{"labels", datastore.GroupRule{LabelSelector: "site=berlin"}, 1, 2, 2},
+		{"combined", datastore.GroupRule{Brand: "example", LabelSelector: "site in (berlin,paris)"}, 1, 2, 2},
+		{"none", datastore.GroupRule{Model: "does-not-exist"}, 0, 3, 0},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewStore()
+			if _, err := mem.GroupCreate(context.Background(), datastore.Group{OrganisationID: "abc", Name: "dynamic", Rule: tt.rule}); err != nil {
+				t.Fatalf("Store.GroupCreate() error = %v", err)
+			}
+
+			got, err := mem.GroupGetDevices(context.Background(), "abc", "dynamic", datastore.DeviceQuery{})
+			if err != nil || len(got) != tt.want {
+				t.Errorf("Store.GroupGetDevices() = %v, %v, want %v", len(got), err, tt.want)
+			}
+			excluded, err := mem.GroupGetExcludedDevices(context.Background(), "abc", "dynamic", datastore.DeviceQuery{})
+			if err != nil || len(excluded) != tt.wantExcluded {
+				t.Errorf("Store.GroupGetExcludedDevices() = %v, %v, want %v", len(excluded), err, tt.wantExcluded)
+			}
+
+			// Membership follows the device data
+			if err := mem.DeviceLabelSet(context.Background(), 2, "site", "berlin"); err != nil {
+				t.Fatalf("Store.DeviceLabelSet() error = %v", err)
+			}
+			got, err = mem.DeviceList(context.Background(), "abc", datastore.DeviceQuery{Group: "dynamic"})
+			if err != nil || len(got) != tt.wantLabelled {
+				t.Errorf("Store.DeviceList() = %v, %v, want %v", len(got), err, tt.wantLabelled)
+			}
+		})
+	}
+}
+
 func TestStore_GroupDeviceWorkflow(t *testing.T) {
 	type args struct {
 		orgID  string
@@ -350,14 +527,14 @@ func TestStore_GroupDeviceWorkflow(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mem := NewStore()
 			// Get the group
-			_, err := mem.GroupGet(tt.args.orgID, tt.args.name)
+			_, err := mem.GroupGet(context.Background(), tt.args.orgID, tt.args.name)
 			if (err != nil) != tt.wantErr && tt.args.device != "invalid" {
 				t.Errorf("Store.GroupGet() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 
 			// Link device to the group
-			err = mem.GroupLinkDevice(tt.args.orgID, tt.args.name, tt.args.device)
+			err = mem.GroupLinkDevice(context.Background(), tt.args.orgID, tt.args.name, tt.args.device)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Store.GroupLinkDevice() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -365,7 +542,7 @@ func TestStore_GroupDeviceWorkflow(t *testing.T) {
 
 			// Get devices for the group
 			if tt.args.device != "invalid" {
-				devices, err := mem.GroupGetDevices(tt.args.orgID, tt.args.name)
+				devices, err := mem.GroupGetDevices(context.Background(), tt.args.orgID, tt.args.name, datastore.DeviceQuery{})
 				if (err != nil) != tt.wantErr {
 					t.Errorf("Store.GroupGetDevices() error = %v, wantErr %v", err, tt.wantErr)
 					return
@@ -377,7 +554,7 @@ func TestStore_GroupDeviceWorkflow(t *testing.T) {
 			}
 
 			// Unlink device from the group
-			err = mem.GroupUnlinkDevice(tt.args.orgID, tt.args.name, tt.args.device)
+			err = mem.GroupUnlinkDevice(context.Background(), tt.args.orgID, tt.args.name, tt.args.device)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Store.GroupUnlinkDevice() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -385,7 +562,7 @@ func TestStore_GroupDeviceWorkflow(t *testing.T) {
 
 			// Get devices for the group
 			if tt.args.device != "invalid" {
-				devices2, err := mem.GroupGetDevices(tt.args.orgID, tt.args.name)
+				devices2, err := mem.GroupGetDevices(context.Background(), tt.args.orgID, tt.args.name, datastore.DeviceQuery{})
 				if (err != nil) != tt.wantErr {
 					t.Errorf("Store.GroupGetDevices() error = %v, wantErr %v", err, tt.wantErr)
 					return
@@ -419,7 +596,7 @@ func TestStore_GroupGetExcludedDevices(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mem := NewStore()
-			got, err := mem.GroupGetExcludedDevices(tt.args.orgID, tt.args.name)
+			got, err := mem.GroupGetExcludedDevices(context.Background(), tt.args.orgID, tt.args.name, datastore.DeviceQuery{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Store.GroupGetExcludedDevices() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -430,3 +607,80 @@ func TestStore_GroupGetExcludedDevices(t *testing.T) {
 		})
 	}
 }
+
+func TestStore_SnapInventory(t *testing.T) {
+	tests := []struct {
+		name    string
+		orgID   string
+		want    []datastore.SnapCount
+		wantErr bool
+	}{
+		{"valid", "abc", []datastore.SnapCount{
+			{Name: "core", Version: "16-2.41", Revision: 12, Channel: "stable", Status: "active", Devices: 2},
+			{Name: "core", Version: "16-2.42", Revision: 13, Channel: "stable", Status: "active", Devices: 1},
+			{Name: "example-snap", Status: "active", Devices: 1},
+		}, false},
+		{"valid-other-org", "def", []datastore.SnapCount{}, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewStore()
+			_ = mem.DeviceSnapUpsert(context.Background(), datastore.DeviceSnap{DeviceID: 3, Name: "core", Version: "16-2.42", Revision: 13, Channel: "stable", Status: "active"})
+			_ = mem.DeviceSnapUpsert(context.Background(), datastore.DeviceSnap{DeviceID: 1, Name: "core", Version: "16-2.41", Revision: 12, Channel: "stable", Status: "active"})
+			_ = mem.DeviceSnapUpsert(context.Background(), datastore.DeviceSnap{DeviceID: 2, Name: "core", Version: "16-2.41", Revision: 12, Channel: "stable", Status: "active"})
+
+			got, err := mem.SnapInventory(context.Background(), tt.orgID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Store.SnapInventory() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Store.SnapInventory() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStore_DeviceSnapReplace(t *testing.T) {
+	tests := []struct {
+		name  string
+		id    int64
+		snaps []datastore.DeviceSnap
+		want  []string
+	}{
+		{"valid", 1, []datastore.DeviceSnap{{Name: "core"}, {Name: "helloworld"}}, []string{"core", "helloworld"}},
+		{"valid-duplicate", 1, []datastore.DeviceSnap{{Name: "core", Revision: 12}, {Name: "core", Revision: 13}}, []string{"core"}},
+		{"valid-empty", 1, []datastore.DeviceSnap{}, []string{}},
+		{"valid-new-device", 2, []datastore.DeviceSnap{{Name: "core"}}, []string{"core"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewStore()
+			if err := mem.DeviceSnapReplace(context.Background(), tt.id, tt.snaps); err != nil {
+				t.Fatalf("Store.DeviceSnapReplace() error = %v", err)
+			}
+
+			snaps, _ := mem.DeviceSnapList(context.Background(), tt.id)
+			got := []string{}
+			for _, s := range snaps {
+				got = append(got, s.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Store.DeviceSnapReplace() = %v, want %v", got, tt.want)
+			}
+			if tt.name == "valid-duplicate" && snaps[0].Revision != 13 {
+				t.Errorf("Store.DeviceSnapReplace() revision = %v, want 13", snaps[0].Revision)
+			}
+
+			// The snaps of the other devices are untouched
+			if tt.id != 1 {
+				other, _ := mem.DeviceSnapList(context.Background(), 1)
+				if len(other) != 1 {
+					t.Errorf("Store.DeviceSnapReplace() other device snaps = %v, want 1", len(other))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,441 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memory
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/canonical/iot-devicetwin/datastore"
+)
+
+// The operations that change the store, recorded in the journal
+const (
+	opDevicePing          = "device-ping"
+	opDevicePingBatch     = "device-ping-batch"
+	opDeviceCreate        = "device-create"
+	opDeviceSnapUpsert    = "device-snap-upsert"
+	opDeviceSnapReplace   = "device-snap-replace"
+	opDeviceSnapDelete    = "device-snap-delete"
+	opDeviceLabelSet      = "device-label-set"
+	opDeviceLabelDelete   = "device-label-delete"
+	opActionCreate        = "action-create"
+	opActionUpdate        = "action-update"
+	opActionDelete        = "action-delete"
+	opDeviceVersionUpsert = "device-version-upsert"
+	opDeviceVersionDelete = "device-version-delete"
+	opGroupCreate         = "group-create"
+	opGroupUpdate         = "group-update"
+	opGroupDelete         = "group-delete"
+	opGroupLinkDevice     = "group-link-device"
+	opGroupUnlinkDevice   = "group-unlink-device"
+	opSecretUpdate        = "secret-update"
+)
+
+// snapshotEntries is the number of journal entries that triggers a new snapshot
+const snapshotEntries = 1000
+
+// journalSuffix is appended to the path of the snapshot file to name the journal file
+const journalSuffix = ".journal"
+
+// snapshot is the file format of the full copy of the store
+type snapshot struct {
+	Sequence       int64                       `json:"sequence"`
+	Devices        []datastore.Device          `json:"devices"`
+	Snaps          []datastore.DeviceSnap      `json:"snaps"`
+	Labels         []datastore.DeviceLabel     `json:"labels"`
+	Actions        []datastore.Action          `json:"actions"`
+	DeviceVersions []datastore.DeviceVersion   `json:"deviceVersions"`
+	Groups         []datastore.Group           `json:"groups"`
+	GroupLinks     []datastore.GroupDeviceLink `json:"groupLinks"`
+	LastVersionID  int64                       `json:"lastVersionId,omitempty"`
+	LastLinkID     int64                       `json:"lastLinkId,omitempty"`
+}
+
+// entry is a single change in the journal, with the arguments of the operation
+type entry struct {
+	Sequence int64             `json:"seq"`
+	Time     time.Time         `json:"time"`
+	Op       string            `json:"op"`
+	Args     []json.RawMessage `json:"args"`
+}
+
+// journal holds the files that persist the store
+type journal struct {
+	path     string
+	file     *os.File
+	size     int64
+	sequence int64
+	entries  int
+}
+
+// OpenStore creates a memory store that is persisted to a file. The store starts empty
+// on the first run and is restored from the last snapshot and the journal of the changes
+// made since then.
+func OpenStore(path string) (*Store, error) {
+	mem := NewEmptyStore()
+
+	sequence, err := mem.restoreSnapshot(path)
+	if err != nil {
+		return nil, fmt.Errorf("error restoring snapshot: %v", err)
+	}
+
+	sequence, entries, err := mem.replayJournal(path+journalSuffix, sequence)
+	if err != nil {
+		return nil, fmt.Errorf("error replaying journal: %v", err)
+	}
+
+	f, err := os.OpenFile(path+journalSuffix, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	mem.journal = &journal{path: path, file: f, size: info.Size(), sequence: sequence, entries: entries}
+	return mem, nil
+}
+
+// Snapshot writes a full copy of the store to disk and clears the journal
+func (mem *Store) Snapshot() error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+	return mem.snapshot()
+}
+
+// Close writes a snapshot of the store and closes the journal
+func (mem *Store) Close() error {
+	mem.lock.Lock()
+	defer mem.lock.Unlock()
+
+	if mem.journal == nil {
+		return nil
+	}
+	if err := mem.snapshot(); err != nil {
+		return err
+	}
+	err := mem.journal.file.Close()
+	mem.journal = nil
+	return err
+}
+
+// record appends a change to the journal and syncs it to disk, before the change is made to the
+// store, so the store is never ahead of the journal. A failed write is removed from the journal,
+// and the change must not be made. A snapshot is taken first when the journal gets too long.
+// The time of the change is replayed for the timestamps of the records. The caller must hold
+// the write lock, so the entries are in the same order as the changes.
+func (mem *Store) record(at time.Time, op string, args ...interface{}) error {
+	if mem.journal == nil {
+		return nil
+	}
+	if mem.journal.entries >= snapshotEntries {
+		if err := mem.snapshot(); err != nil {
+			return err
+		}
+	}
+
+	e := entry{Sequence: mem.journal.sequence + 1, Time: at, Op: op}
+	for _, a := range args {
+		b, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		e.Args = append(e.Args, b)
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	n, err := mem.journal.file.Write(append(b, '\n'))
+	if err == nil {
+		err = mem.journal.file.Sync()
+	}
+	if err != nil {
+		log.Printf("Error writing to journal: %v\n", err)
+		if n > 0 {
+			_ = mem.journal.file.Truncate(mem.journal.size)
+		}
+		return fmt.Errorf("error writing to journal: %v", err)
+	}
+	mem.journal.size += int64(n)
+	mem.journal.sequence = e.Sequence
+	mem.journal.entries++
+	return nil
+}
+
+// snapshot writes the store to a temporary file that replaces the previous snapshot,
+// and then truncates the journal. The sequence number of the snapshot allows the replay
+// to skip entries that are already in the snapshot, if the journal was not truncated.
+// The caller must hold the write lock.
+func (mem *Store) snapshot() error {
+	if mem.journal == nil {
+		return nil
+	}
+
+	snap := snapshot{
+		Sequence:       mem.journal.sequence,
+		Devices:        mem.Devices,
+		Snaps:          mem.Snaps,
+		Labels:         mem.Labels,
+		Actions:        mem.Actions,
+		DeviceVersions: mem.DeviceVersions,
+		Groups:         mem.Groups,
+		GroupLinks:     mem.GroupLinks,
+		LastVersionID:  mem.versionID(),
+		LastLinkID:     mem.linkID(),
+	}
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	tmp := mem.journal.path + ".tmp"
+	if err := writeFileSync(tmp, b); err != nil {
+		log.Printf("Error writing snapshot: %v\n", err)
+		return fmt.Errorf("error writing snapshot: %v", err)
+	}
+	if err := os.Rename(tmp, mem.journal.path); err != nil {
+		return fmt.Errorf("error writing snapshot: %v", err)
+	}
+
+	if err := mem.journal.file.Truncate(0); err != nil {
+		return fmt.Errorf("error truncating journal: %v", err)
+	}
+	mem.journal.size = 0
+	mem.journal.entries = 0
+	return nil
+}
+
+// restoreSnapshot loads the records from the snapshot file, if it exists
+func (mem *Store) restoreSnapshot(path string) (int64, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	snap := snapshot{}
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return 0, err
+	}
+
+	mem.Devices = append(mem.Devices, snap.Devices...)
+	mem.Snaps = append(mem.Snaps, snap.Snaps...)
+	mem.Labels = append(mem.Labels, snap.Labels...)
+	mem.Actions = append(mem.Actions, snap.Actions...)
+	mem.DeviceVersions = append(mem.DeviceVersions, snap.DeviceVersions...)
+	mem.Groups = append(mem.Groups, snap.Groups...)
+	mem.GroupLinks = append(mem.GroupLinks, snap.GroupLinks...)
+	mem.lastVersionID = snap.LastVersionID
+	mem.lastLinkID = snap.LastLinkID
+	return snap.Sequence, nil
+}
+
+// replayJournal applies the journal entries that are newer than the snapshot. An incomplete
+// last entry, from a crash in the middle of a write, is discarded.
+func (mem *Store) replayJournal(path string, sequence int64) (int64, int, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return sequence, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var (
+		entries int
+		valid   int
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(make([]byte, 64*1024), len(b)+1)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		e := entry{}
+		if err := json.Unmarshal(line, &e); err != nil {
+			log.Printf("Discarding incomplete journal entry: %v\n", err)
+			break
+		}
+		valid += len(line) + 1
+		entries++
+
+		if e.Sequence <= sequence {
+			continue
+		}
+		if err := mem.apply(e); err != nil {
+			return 0, 0, fmt.Errorf("entry %d: %v", e.Sequence, err)
+		}
+		sequence = e.Sequence
+	}
+
+	if valid < len(b) {
+		if err := os.Truncate(path, int64(valid)); err != nil {
+			return 0, 0, err
+		}
+	}
+	return sequence, entries, nil
+}
+
+// apply replays a journal entry, using the time of the entry for the timestamps of the records
+func (mem *Store) apply(e entry) error {
+	mem.now = func() time.Time { return e.Time }
+	defer func() { mem.now = nil }()
+	ctx := context.Background()
+
+	var (
+		id                 int64
+		orgID, name, key   string
+		value, status, msg string
+		refresh            time.Time
+		refreshes          map[string]time.Time
+		device             datastore.Device
+		snap               datastore.DeviceSnap
+		snaps              []datastore.DeviceSnap
+		ids                []int64
+		secrets            []datastore.Secret
+		act                datastore.Action
+		version            datastore.DeviceVersion
+		grp                datastore.Group
+	)
+
+	var err error
+	switch e.Op {
+	case opDevicePing:
+		if err = decodeArgs(e.Args, &key, &refresh); err == nil {
+			err = mem.DevicePing(ctx, key, refresh)
+		}
+	case opDevicePingBatch:
+		if err = decodeArgs(e.Args, &refreshes); err == nil {
+			err = mem.DevicePingBatch(ctx, refreshes)
+		}
+	case opDeviceCreate:
+		if err = decodeArgs(e.Args, &device); err == nil {
+			_, err = mem.DeviceCreate(ctx, device)
+		}
+	case opDeviceSnapUpsert:
+		if err = decodeArgs(e.Args, &snap); err == nil {
+			err = mem.DeviceSnapUpsert(ctx, snap)
+		}
+	case opDeviceSnapReplace:
+		if err = decodeArgs(e.Args, &id, &snaps); err == nil {
+			err = mem.DeviceSnapReplace(ctx, id, snaps)
+		}
+	case opDeviceSnapDelete:
+		if err = decodeArgs(e.Args, &id); err == nil {
+			err = mem.DeviceSnapDelete(ctx, id)
+		}
+	case opDeviceLabelSet:
+		if err = decodeArgs(e.Args, &id, &key, &value); err == nil {
+			err = mem.DeviceLabelSet(ctx, id, key, value)
+		}
+	case opDeviceLabelDelete:
+		if err = decodeArgs(e.Args, &id, &key); err == nil {
+			err = mem.DeviceLabelDelete(ctx, id, key)
+		}
+	case opActionCreate:
+		if err = decodeArgs(e.Args, &act); err == nil {
+			_, err = mem.ActionCreate(ctx, act)
+		}
+	case opActionUpdate:
+		if err = decodeArgs(e.Args, &key, &status, &msg); err == nil {
+			err = mem.ActionUpdate(ctx, key, status, msg)
+		}
+	case opActionDelete:
+		if err = decodeArgs(e.Args, &ids); err == nil {
+			err = mem.ActionDelete(ctx, ids)
+		}
+	case opDeviceVersionUpsert:
+		if err = decodeArgs(e.Args, &version); err == nil {
+			err = mem.DeviceVersionUpsert(ctx, version)
+		}
+	case opDeviceVersionDelete:
+		if err = decodeArgs(e.Args, &id); err == nil {
+			err = mem.DeviceVersionDelete(ctx, id)
+		}
+	case opGroupCreate:
+		if err = decodeArgs(e.Args, &grp); err == nil {
+			_, err = mem.GroupCreate(ctx, grp)
+		}
+	case opGroupUpdate:
+		if err = decodeArgs(e.Args, &grp); err == nil {
+			err = mem.GroupUpdate(ctx, grp)
+		}
+	case opGroupDelete:
+		if err = decodeArgs(e.Args, &orgID, &name); err == nil {
+			err = mem.GroupDelete(ctx, orgID, name)
+		}
+	case opGroupLinkDevice:
+		if err = decodeArgs(e.Args, &orgID, &name, &key); err == nil {
+			err = mem.GroupLinkDevice(ctx, orgID, name, key)
+		}
+	case opGroupUnlinkDevice:
+		if err = decodeArgs(e.Args, &orgID, &name, &key); err == nil {
+			err = mem.GroupUnlinkDevice(ctx, orgID, name, key)
+		}
+	case opSecretUpdate:
+		if err = decodeArgs(e.Args, &secrets); err == nil {
+			err = mem.SecretUpdate(ctx, secrets)
+		}
+	default:
+		err = fmt.Errorf("unknown operation `%s`", e.Op)
+	}
+	return err
+}
+
+// decodeArgs parses the arguments of a journal entry
+func decodeArgs(args []json.RawMessage, values ...interface{}) error {
+	if len(args) != len(values) {
+		return fmt.Errorf("expected %d arguments, got %d", len(values), len(args))
+	}
+	for i := range values {
+		if err := json.Unmarshal(args[i], values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileSync writes a file and flushes it to disk
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,317 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memory
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-devicetwin/datastore"
+)
+
+// populate makes a change of each kind to the store
+func populate(t *testing.T, mem *Store) {
+	id, err := mem.DeviceCreate(context.Background(), datastore.Device{OrganisationID: "abc", DeviceID: "a111", Brand: "example", Model: "drone-1000", SerialNumber: "DR1000A111"})
+	if err != nil {
+		t.Fatalf("Store.DeviceCreate() error = %v", err)
+	}
+	if _, err := mem.DeviceCreate(context.Background(), datastore.Device{OrganisationID: "abc", DeviceID: "b222", Brand: "example", Model: "drone-1000"}); err != nil {
+		t.Fatalf("Store.DeviceCreate() error = %v", err)
+	}
+
+	steps := []error{
+		mem.DevicePing(context.Background(), "a111", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)),
+		mem.DevicePingBatch(context.Background(), map[string]time.Time{"b222": time.Date(2020, 1, 3, 3, 4, 5, 0, time.UTC)}),
+		mem.DeviceSnapReplace(context.Background(), id, []datastore.DeviceSnap{{Name: "core", Revision: 12}, {Name: "helloworld"}}),
+		mem.DeviceSnapUpsert(context.Background(), datastore.DeviceSnap{DeviceID: id, Name: "core", Revision: 13}),
+		mem.DeviceLabelSet(context.Background(), id, "site", "berlin"),
+		mem.DeviceLabelSet(context.Background(), id, "floor", "2"),
+		mem.DeviceLabelDelete(context.Background(), id, "floor"),
+		mem.DeviceVersionUpsert(context.Background(), datastore.DeviceVersion{DeviceID: id, Series: "18"}),
+		mem.ActionUpdate(context.Background(), "a1", "complete", "done"),
+		mem.GroupUpdate(context.Background(), datastore.Group{ID: 1, OrganisationID: "abc", Name: "workshop", Description: "the workshop"}),
+		mem.GroupLinkDevice(context.Background(), "abc", "workshop", "a111"),
+		mem.GroupLinkDevice(context.Background(), "abc", "workshop", "b222"),
+		mem.GroupUnlinkDevice(context.Background(), "abc", "workshop", "b222"),
+		mem.GroupDelete(context.Background(), "abc", "lab"),
+	}
+	for i, err := range steps {
+		if err != nil {
+			t.Fatalf("step %d error = %v", i, err)
+		}
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devicetwin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "devicetwin.json")
+
+	mem, err := OpenStore(p)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	if len(mem.Devices) != 0 || len(mem.Groups) != 0 {
+		t.Fatalf("OpenStore() expected an empty store, got %d devices", len(mem.Devices))
+	}
+
+	// Changes made before and after a snapshot
+	if _, err := mem.ActionCreate(context.Background(), datastore.Action{OrganizationID: "abc", DeviceID: "a111", ActionID: "a1", Action: "list"}); err != nil {
+		t.Fatalf("Store.ActionCreate() error = %v", err)
+	}
+	if _, err := mem.GroupCreate(context.Background(), datastore.Group{OrganisationID: "abc", Name: "workshop"}); err != nil {
+		t.Fatalf("Store.GroupCreate() error = %v", err)
+	}
+	if _, err := mem.GroupCreate(context.Background(), datastore.Group{OrganisationID: "abc", Name: "lab"}); err != nil {
+		t.Fatalf("Store.GroupCreate() error = %v", err)
+	}
+	if err := mem.Snapshot(); err != nil {
+		t.Fatalf("Store.Snapshot() error = %v", err)
+	}
+	populate(t, mem)
+	want := &Store{Devices: mem.Devices, Snaps: mem.Snaps, Labels: mem.Labels, Actions: mem.Actions,
+		DeviceVersions: mem.DeviceVersions, Groups: mem.Groups, GroupLinks: mem.GroupLinks}
+
+	tests := []struct {
+		name  string
+		close bool
+	}{
+		{"restore-journal", false},
+		{"restore-snapshot", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.close {
+				if err := mem.Close(); err != nil {
+					t.Fatalf("Store.Close() error = %v", err)
+				}
+			}
+
+			got, err := OpenStore(p)
+			if err != nil {
+				t.Fatalf("OpenStore() error = %v", err)
+			}
+			defer got.journal.file.Close()
+
+			assertStoreEqual(t, got, want)
+		})
+	}
+}
+
+func TestOpenStore_IncompleteJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devicetwin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "devicetwin.json")
+
+	mem, err := OpenStore(p)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	if _, err := mem.DeviceCreate(context.Background(), datastore.Device{OrganisationID: "abc", DeviceID: "a111"}); err != nil {
+		t.Fatalf("Store.DeviceCreate() error = %v", err)
+	}
+	_ = mem.journal.file.Close()
+
+	// Simulate a crash in the middle of writing an entry
+	f, _ := os.OpenFile(p+journalSuffix, os.O_WRONLY|os.O_APPEND, 0600)
+	_, _ = f.WriteString(`{"seq":2,"op":"device-cre`)
+	_ = f.Close()
+
+	got, err := OpenStore(p)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	if len(got.Devices) != 1 {
+		t.Errorf("OpenStore() got %d devices, want 1", len(got.Devices))
+	}
+
+	// New entries are appended after the last complete entry
+	if _, err := got.DeviceCreate(context.Background(), datastore.Device{OrganisationID: "abc", DeviceID: "b222"}); err != nil {
+		t.Fatalf("Store.DeviceCreate() error = %v", err)
+	}
+	_ = got.journal.file.Close()
+
+	got, err = OpenStore(p)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	defer got.journal.file.Close()
+	if len(got.Devices) != 2 {
+		t.Errorf("OpenStore() got %d devices, want 2", len(got.Devices))
+	}
+}
+
+func TestStore_JournalWriteError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devicetwin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "devicetwin.json")
+
+	mem, err := OpenStore(p)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	if _, err := mem.DeviceCreate(context.Background(), datastore.Device{OrganisationID: "abc", DeviceID: "a111"}); err != nil {
+		t.Fatalf("Store.DeviceCreate() error = %v", err)
+	}
+
+	// A change that cannot be journaled is not made to the store
+	_ = mem.journal.file.Close()
+	if _, err := mem.DeviceCreate(context.Background(), datastore.Device{OrganisationID: "abc", DeviceID: "b222"}); err == nil {
+		t.Error("Store.DeviceCreate() expected an error writing the journal")
+	}
+	if err := mem.DeviceLabelSet(context.Background(), 1, "site", "berlin"); err == nil {
+		t.Error("Store.DeviceLabelSet() expected an error writing the journal")
+	}
+	if len(mem.Devices) != 1 || len(mem.Labels) != 0 {
+		t.Errorf("Store = %d devices and %d labels, want the changes before the error", len(mem.Devices), len(mem.Labels))
+	}
+}
+
+func TestOpenStore_Invalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devicetwin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		snapshot string
+		journal  string
+	}{
+		{"invalid-snapshot", `{"devices":`, ""},
+		{"invalid-operation", "", `{"seq":1,"op":"invalid","args":[]}` + "\n"},
+		{"invalid-arguments", "", `{"seq":1,"op":"device-ping","args":["a111"]}` + "\n"},
+		{"invalid-replay", "", `{"seq":1,"op":"device-ping","args":["a111","2020-01-02T03:04:05Z"]}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := path.Join(dir, tt.name)
+			if len(tt.snapshot) > 0 {
+				_ = ioutil.WriteFile(p, []byte(tt.snapshot), 0600)
+			}
+			if len(tt.journal) > 0 {
+				_ = ioutil.WriteFile(p+journalSuffix, []byte(tt.journal), 0600)
+			}
+
+			if _, err := OpenStore(p); err == nil {
+				t.Error("OpenStore() expected error")
+			}
+		})
+	}
+}
+
+func assertStoreEqual(t *testing.T, got, want *Store) {
+	if len(got.Devices) != len(want.Devices) || len(got.Snaps) != len(want.Snaps) || len(got.Labels) != len(want.Labels) ||
+		len(got.Actions) != len(want.Actions) || len(got.DeviceVersions) != len(want.DeviceVersions) ||
+		len(got.Groups) != len(want.Groups) || len(got.GroupLinks) != len(want.GroupLinks) {
+		t.Fatalf("OpenStore() = %+v, want %+v", got, want)
+	}
+	for i := range want.Devices {
+		if !got.Devices[i].LastRefresh.Equal(want.Devices[i].LastRefresh) || got.Devices[i].DeviceID != want.Devices[i].DeviceID {
+			t.Errorf("OpenStore() device = %+v, want %+v", got.Devices[i], want.Devices[i])
+		}
+	}
+	for i := range want.Snaps {
+		if got.Snaps[i].Name != want.Snaps[i].Name || got.Snaps[i].Revision != want.Snaps[i].Revision {
+			t.Errorf("OpenStore() snap = %+v, want %+v", got.Snaps[i], want.Snaps[i])
+		}
+	}
+	if got.Labels[0].Key != "site" || got.Actions[0].Status != "complete" || got.DeviceVersions[0].Series != "18" {
+		t.Errorf("OpenStore() = %+v, want %+v", got, want)
+	}
+	if got.Groups[0].Description != "the workshop" || got.GroupLinks[0].DeviceID != want.GroupLinks[0].DeviceID {
+		t.Errorf("OpenStore() groups = %+v, want %+v", got.Groups, want.Groups)
+	}
+}
+
+func TestStore_IDsNotReused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devicetwin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "devicetwin.json")
+	ctx := context.Background()
+
+	mem, err := OpenStore(p)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	for _, id := range []string{"a111", "b222", "c333"} {
+		if _, err := mem.DeviceCreate(ctx, datastore.Device{OrganisationID: "abc", DeviceID: id}); err != nil {
+			t.Fatalf("Store.DeviceCreate() error = %v", err)
+		}
+	}
+	if _, err := mem.GroupCreate(ctx, datastore.Group{OrganisationID: "abc", Name: "workshop"}); err != nil {
+		t.Fatalf("Store.GroupCreate() error = %v", err)
+	}
+
+	// The last link and version are removed before the next ones are created
+	steps := []error{
+		mem.GroupLinkDevice(ctx, "abc", "workshop", "a111"),
+		mem.GroupLinkDevice(ctx, "abc", "workshop", "b222"),
+		mem.GroupUnlinkDevice(ctx, "abc", "workshop", "b222"),
+		mem.DeviceVersionUpsert(ctx, datastore.DeviceVersion{DeviceID: 1, Series: "18"}),
+		mem.DeviceVersionUpsert(ctx, datastore.DeviceVersion{DeviceID: 2, Series: "18"}),
+		mem.DeviceVersionDelete(ctx, 2),
+	}
+	for i, err := range steps {
+		if err != nil {
+			t.Fatalf("step %d error = %v", i, err)
+		}
+	}
+
+	// The IDs continue after the store is restored from the snapshot
+	if err := mem.Close(); err != nil {
+		t.Fatalf("Store.Close() error = %v", err)
+	}
+	mem, err = OpenStore(p)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	defer mem.journal.file.Close()
+	if err := mem.GroupLinkDevice(ctx, "abc", "workshop", "c333"); err != nil {
+		t.Fatalf("Store.GroupLinkDevice() error = %v", err)
+	}
+	if err := mem.DeviceVersionUpsert(ctx, datastore.DeviceVersion{DeviceID: 3, Series: "20"}); err != nil {
+		t.Fatalf("Store.DeviceVersionUpsert() error = %v", err)
+	}
+
+	if len(mem.GroupLinks) != 2 || mem.GroupLinks[0].ID != 1 || mem.GroupLinks[1].ID != 3 {
+		t.Errorf("Store.GroupLinkDevice() links = %+v, want IDs 1 and 3", mem.GroupLinks)
+	}
+	if len(mem.DeviceVersions) != 2 || mem.DeviceVersions[0].ID != 1 || mem.DeviceVersions[1].ID != 3 {
+		t.Errorf("Store.DeviceVersionUpsert() versions = %+v, want IDs 1 and 3", mem.DeviceVersions)
+	}
+}
@@ -0,0 +1,176 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package memory
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/canonical/iot-devicetwin/datastore"
+)
+
+// compareDevices compares two devices on the sort order, using the record ID to break ties
+func compareDevices(sortBy string, a, b *datastore.Device) int {
+	c := 0
+	switch sortBy {
+	case datastore.SortModel:
+		c = compareStrings(a.Model, b.Model, a.SerialNumber, b.SerialNumber)
+	case datastore.SortSerial:
+		c = strings.Compare(a.SerialNumber, b.SerialNumber)
+	case datastore.SortDeviceID:
+		c = strings.Compare(a.DeviceID, b.DeviceID)
+	case datastore.SortCreated:
+		c = compareInts(a.Created.UnixNano(), b.Created.UnixNano())
+	case datastore.SortLastRefresh:
+		c = compareInts(a.LastRefresh.UnixNano(), b.LastRefresh.UnixNano())
+	default:
+		c = compareStrings(a.Brand, b.Brand, a.Model, b.Model, a.SerialNumber, b.SerialNumber)
+	}
+	if c != 0 {
+		return c
+	}
+	return compareInts(a.ID, b.ID)
+}
+
+// compareStrings compares pairs of strings in order
+func compareStrings(pairs ...string) int {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if c := strings.Compare(pairs[i], pairs[i+1]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// queryDevices filters, sorts and pages the devices. The caller must hold the lock.
+func (mem *Store) queryDevices(devices []datastore.Device, query datastore.DeviceQuery) ([]datastore.Device, error) {
+	if !datastore.ValidSort(query.SortBy) {
+		return nil, datastore.Invalid("invalid sort order `%s`", query.SortBy)
+	}
+	switch query.Presence {
+	case "", datastore.PresenceOnline, datastore.PresenceOffline:
+	default:
+		return nil, datastore.Invalid("invalid presence `%s`", query.Presence)
+	}
+
+	direction := 1
+	if query.SortDesc {
+		direction = -1
+	}
+
+	result := []datastore.Device{}
+	for i := range devices {
+		d := devices[i]
+		if !mem.matchDevice(&d, query) {
+			continue
+		}
+		if query.After != nil && compareDevices(query.SortBy, &d, query.After)*direction <= 0 {
+			continue
+		}
+		result = append(result, d)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return compareDevices(query.SortBy, &result[i], &result[j])*direction < 0
+	})
+
+	if query.Limit > 0 && len(result) > query.Limit {
+		result = result[:query.Limit]
+	}
+	return result, nil
+}
+
+// matchDevice checks the device against the query filters. The caller must hold the lock.
+func (mem *Store) matchDevice(d *datastore.Device, query datastore.DeviceQuery) bool {
+	if len(query.Brand) > 0 && d.Brand != query.Brand {
+		return false
+	}
+	if len(query.Model) > 0 && d.Model != query.Model {
+		return false
+	}
+	if query.Presence == datastore.PresenceOnline && d.LastRefresh.Before(query.PresenceCutoff) {
+		return false
+	}
+	if query.Presence == datastore.PresenceOffline && !d.LastRefresh.Before(query.PresenceCutoff) {
+		return false
+	}
+	if len(query.Series) > 0 && !mem.hasSeries(d.ID, query.Series) {
+		return false
+	}
+	if len(query.Snap) > 0 && !mem.hasSnap(d.ID, query.Snap, query.SnapRevision) {
+		return false
+	}
+	if len(query.Group) > 0 && !mem.inGroup(d, query.Group) {
+		return false
+	}
+	if len(query.Labels) > 0 {
+		labels := mem.deviceLabels(d.ID)
+		for _, req := range query.Labels {
+			if !req.Matches(labels) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (mem *Store) deviceLabels(deviceID int64) map[string]string {
+	labels := map[string]string{}
+	for _, l := range mem.Labels {
+		if l.DeviceID == deviceID {
+			labels[l.Key] = l.Value
+		}
+	}
+	return labels
+}
+
+func (mem *Store) hasSeries(deviceID int64, series string) bool {
+	for _, v := range mem.DeviceVersions {
+		if v.DeviceID == deviceID && v.Series == series {
+			return true
+		}
+	}
+	return false
+}
+
+func (mem *Store) hasSnap(deviceID int64, name string, revision int) bool {
+	for _, s := range mem.Snaps {
+		if s.DeviceID == deviceID && s.Name == name && (revision == 0 || s.Revision == revision) {
+			return true
+		}
+	}
+	return false
+}
+
+func (mem *Store) inGroup(d *datastore.Device, name string) bool {
+	group := mem.group(d.OrganisationID, name)
+	return group != nil && mem.member(*group, d)
+}
@@ -0,0 +1,54 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+import (
+	"os"
+	"testing"
+
+	"github.com/canonical/iot-devicetwin/datastore"
+	"github.com/canonical/iot-devicetwin/datastore/datastoretest"
+)
+
+// postgresDataSourceEnv names the environment variable with the data source of a postgreSQL
+// database for the conformance tests. The database is emptied before each test
+const postgresDataSourceEnv = "DEVICETWIN_TEST_POSTGRES"
+
+func TestPostgres_Conformance(t *testing.T) {
+	dataSource := os.Getenv(postgresDataSourceEnv)
+	if len(dataSource) == 0 {
+		t.Skipf("set %s to the data source of a test database to run the postgreSQL tests", postgresDataSourceEnv)
+	}
+
+	datastoretest.Run(t, func(t *testing.T) (datastore.DataStore, func()) {
+		db, err := Open(dataSource)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		// Revert all the migrations to start from an empty database
+		if err := db.MigrateTo(0); err != nil {
+			t.Fatalf("DataStore.MigrateTo() error = %v", err)
+		}
+		if err := db.Migrate(); err != nil {
+			t.Fatalf("DataStore.Migrate() error = %v", err)
+		}
+		return db, func() { _ = db.Close() }
+	})
+}
@@ -0,0 +1,198 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+import "github.com/canonical/iot-devicetwin/datastore/sqlstore"
+
+// migrations are the ordered changes to the database schema. A released migration must never
+// be changed: add a new migration instead. The first migrations use `IF NOT EXISTS` so that
+// databases created before the schema was versioned are adopted.
+var migrations = []sqlstore.Migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		Up: []string{`
+CREATE TABLE IF NOT EXISTS device (
+   id             serial primary key,
+   created        timestamp default current_timestamp,
+   lastrefresh    timestamp default current_timestamp,
+   org_id         varchar(200) not null,
+   device_id      varchar(200) unique not null,
+   brand          varchar(200) not null,
+   model          varchar(200) not null,
+   serial         varchar(200) not null,
+   store_id       varchar(200) not null,
+   device_key     text,
+   active         bool default true
+)`, `
+CREATE TABLE IF NOT EXISTS action (
+   id             serial primary key,
+   created        timestamp default current_timestamp,
+   modified       timestamp default current_timestamp,
+   org_id         varchar(200) not null,
+   device_id      varchar(200) not null,
+   action_id      varchar(200) not null,
+   action         varchar(200) not null,
+   status         varchar(200) default '',
+   message        text default ''
+)`, `
+CREATE TABLE IF NOT EXISTS device_snap (
+   id             serial primary key,
+   created        timestamp default current_timestamp,
+   modified       timestamp default current_timestamp,
+   device_id      int references device not null,
+   name           varchar(200) not null,
+   installed_size int default 0,
+   installed_date timestamp default current_timestamp,
+   status         varchar(200) default '',
+   channel        varchar(200) default '',
+   confinement    varchar(200) default '',
+   version        varchar(200) default '',
+   revision       int default 0,
+   devmode        bool default false,
+   config         text default ''
+)`,
+			"CREATE UNIQUE INDEX IF NOT EXISTS device_snap_idx ON device_snap (device_id, name)", `
+CREATE TABLE IF NOT EXISTS device_version (
+   id             serial primary key,
+   device_id      int references device not null unique,
+   version        varchar(200) not null,
+   series         varchar(200) default '',
+   os_id          varchar(200) default '',
+   os_version_id  varchar(200) default '',
+   on_classic     bool default false,
+   kernel_version varchar(200) default ''
+)`, `
+CREATE TABLE IF NOT EXISTS org_group (
+   id             serial primary key,
+   created        timestamp default current_timestamp,
+   modified       timestamp default current_timestamp,
+   org_id         varchar(200) not null,
+   name           varchar(200) not null
+)`, `
+CREATE TABLE IF NOT EXISTS group_device_link (
+   id             serial primary key,
+   created        timestamp default current_timestamp,
+   org_id         varchar(200) not null,
+   group_id       int references org_group not null,
+   device_id      int references device not null
+)`,
+			"CREATE INDEX IF NOT EXISTS org_group_idx ON org_group (org_id, name)",
+		},
+		Down: []string{
+			"DROP TABLE group_device_link",
+			"DROP TABLE org_group",
+			"DROP TABLE device_version",
+			"DROP TABLE device_snap",
+			"DROP TABLE action",
+			"DROP TABLE device",
+		},
+	},
+	{
+		Version:     2,
+		Description: "device listing index",
+		Up: []string{
+			"CREATE INDEX IF NOT EXISTS device_org_idx ON device (org_id, brand, model, serial, id)",
+		},
+		Down: []string{
+			"DROP INDEX device_org_idx",
+		},
+	},
+	{
+		Version:     3,
+		Description: "device labels",
+		Up: []string{`
+CREATE TABLE IF NOT EXISTS device_label (
+   id             serial primary key,
+   created        timestamp default current_timestamp,
+   modified       timestamp default current_timestamp,
+   device_id      int references device not null,
+   key            varchar(200) not null,
+   value          varchar(200) not null default ''
+)`,
+			"CREATE UNIQUE INDEX IF NOT EXISTS device_label_idx ON device_label (device_id, key)",
+			"CREATE INDEX IF NOT EXISTS device_label_key_idx ON device_label (key, value)",
+		},
+		Down: []string{
+			"DROP TABLE device_label",
+		},
+	},
+	{
+		Version:     4,
+		Description: "dynamic groups and group hierarchy",
+		Up: []string{
+			"ALTER TABLE org_group ADD COLUMN IF NOT EXISTS rule text default ''",
+			"ALTER TABLE org_group ADD COLUMN IF NOT EXISTS description text default ''",
+			"ALTER TABLE org_group ADD COLUMN IF NOT EXISTS parent_id int references org_group",
+			mergeDuplicateGroupLinksSQL,
+			deleteDuplicateGroupsSQL,
+			deleteDuplicateGroupLinksSQL,
+			"CREATE UNIQUE INDEX IF NOT EXISTS org_group_name_idx ON org_group (org_id, name)",
+			"CREATE UNIQUE INDEX IF NOT EXISTS group_device_link_idx ON group_device_link (group_id, device_id)",
+			"DROP INDEX IF EXISTS org_group_idx",
+		},
+		Down: []string{
+			"CREATE INDEX org_group_idx ON org_group (org_id, name)",
+			"DROP INDEX group_device_link_idx",
+			"DROP INDEX org_group_name_idx",
+			"ALTER TABLE org_group DROP COLUMN parent_id",
+			"ALTER TABLE org_group DROP COLUMN description",
+			"ALTER TABLE org_group DROP COLUMN rule",
+		},
+	},
+	{
+		Version:     5,
+		Description: "action log index",
+		Up: []string{
+			"CREATE INDEX IF NOT EXISTS action_device_idx ON action (org_id, device_id, created)",
+		},
+		Down: []string{
+			"DROP INDEX action_device_idx",
+		},
+	},
+	{
+		Version:     6,
+		Description: "action ID index",
+		Up: []string{
+			"CREATE INDEX IF NOT EXISTS action_id_idx ON action (action_id)",
+		},
+		Down: []string{
+			"DROP INDEX action_id_idx",
+		},
+	},
+}
+
+// The group names were not unique before migration 4. The duplicate groups of an organization
+// are merged onto the first one: their device links are moved to it, and then they are deleted
+// along with the links that are now repeated
+const mergeDuplicateGroupLinksSQL = `
+update group_device_link
+set group_id=(
+   select min(dup.id) from org_group grp
+   inner join org_group dup on dup.org_id=grp.org_id and dup.name=grp.name
+   where grp.id=group_device_link.group_id)
+where group_id not in (select min(id) from org_group group by org_id, name)`
+
+const deleteDuplicateGroupsSQL = "delete from org_group where id not in (select min(id) from org_group group by org_id, name)"
+
+const deleteDuplicateGroupLinksSQL = "delete from group_device_link where id not in (select min(id) from group_device_link group by group_id, device_id)"
+
+// lockSchemaVersionSQL stops other instances of the service migrating the schema at the same time
+const lockSchemaVersionSQL = "LOCK TABLE schema_version IN ACCESS EXCLUSIVE MODE"
@@ -0,0 +1,90 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // sqlite driver, to check the portable statements
+)
+
+func TestMigrations(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d, migrations must be numbered in order", i+1, m.Version)
+		}
+		if len(m.Description) == 0 {
+			t.Errorf("migration %d has no description", m.Version)
+		}
+		if len(m.Up) == 0 || len(m.Down) == 0 {
+			t.Errorf("migration %d must have up and down statements", m.Version)
+		}
+	}
+}
+
+func TestMigrations_DuplicateGroups(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer db.Close()
+
+	// The groups and links before migration 4, with repeated group names and links
+	setup := []string{
+		"CREATE TABLE org_group (id integer primary key, org_id varchar(200) not null, name varchar(200) not null)",
+		"CREATE TABLE group_device_link (id integer primary key, org_id varchar(200) not null, group_id int not null, device_id int not null)",
+		"insert into org_group (id, org_id, name) values (1,'abc','workshop'), (2,'abc','workshop'), (3,'xyz','workshop'), (4,'abc','lab'), (5,'abc','workshop')",
+		"insert into group_device_link (id, org_id, group_id, device_id) values (1,'abc',1,10), (2,'abc',2,10), (3,'abc',2,11), (4,'xyz',3,12), (5,'abc',4,10), (6,'abc',5,13), (7,'abc',1,13)",
+	}
+	for _, stmt := range append(setup, mergeDuplicateGroupLinksSQL, deleteDuplicateGroupsSQL, deleteDuplicateGroupLinksSQL) {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Exec(%s) error = %v", stmt, err)
+		}
+	}
+
+	query := func(stmt string) [][2]int64 {
+		t.Helper()
+		rows, err := db.Query(stmt)
+		if err != nil {
+			t.Fatalf("Query() error = %v", err)
+		}
+		defer rows.Close()
+		got := [][2]int64{}
+		for rows.Next() {
+			var row [2]int64
+			if err := rows.Scan(&row[0], &row[1]); err != nil {
+				t.Fatalf("Scan() error = %v", err)
+			}
+			got = append(got, row)
+		}
+		return got
+	}
+
+	groups := query("select id, 0 from org_group order by id")
+	if want := [][2]int64{{1, 0}, {3, 0}, {4, 0}}; !reflect.DeepEqual(groups, want) {
+		t.Errorf("groups = %v, want %v", groups, want)
+	}
+	links := query("select group_id, device_id from group_device_link order by group_id, device_id")
+	if want := [][2]int64{{1, 10}, {1, 11}, {1, 13}, {3, 12}, {4, 10}}; !reflect.DeepEqual(links, want) {
+		t.Errorf("links = %v, want %v", links, want)
+	}
+}
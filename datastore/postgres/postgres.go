@@ -20,55 +20,34 @@
 package postgres
 
 import (
-	"database/sql"
-	_ "github.com/lib/pq" // postgresql driver
-	"log"
+	"errors"
+	"github.com/canonical/iot-devicetwin/datastore/sqlstore"
+	"github.com/lib/pq"
 )
 
-// DataStore is the postgreSQL implementation of a data store
-type DataStore struct {
-	driver string
-	*sql.DB
-}
-
-var pgStore *DataStore
-
-// OpenDataStore returns an open database connection
-func OpenDataStore(driver, dataSource string) *DataStore {
-	if pgStore != nil {
-		return pgStore
-	}
-
-	// Open the database
-	pgStore = openDatabase(driver, dataSource)
+// uniqueViolation is the postgreSQL error code for a duplicate key
+const uniqueViolation = "23505"
 
-	// Create the tables, if needed
-	pgStore.createTables()
-
-	return pgStore
+// Dialect is the postgreSQL dialect of the SQL data store
+var Dialect = sqlstore.Dialect{
+	Driver:        "postgres",
+	Migrations:    migrations,
+	TimestampCast: "::timestamp",
+	IsDuplicate:   isDuplicate,
+	LockSchemaSQL: lockSchemaVersionSQL,
 }
 
-// openDatabase return an open database connection for a postgreSQL database
-func openDatabase(driver, dataSource string) *DataStore {
-	// Open the database connection
-	db, err := sql.Open(driver, dataSource)
-	if err != nil {
-		log.Fatalf("Error opening the database: %v\n", err)
-	}
-
-	// Check that we have a valid database connection
-	err = db.Ping()
-	if err != nil {
-		log.Fatalf("Error accessing the database: %v\n", err)
-	}
+// OpenDataStore returns an open database connection, with the latest schema
+func OpenDataStore(dataSource string) *sqlstore.DataStore {
+	return sqlstore.OpenDataStore(Dialect, dataSource)
+}
 
-	return &DataStore{driver, db}
+// Open returns an open database connection for a postgreSQL database, without migrating the schema
+func Open(dataSource string) (*sqlstore.DataStore, error) {
+	return sqlstore.Open(Dialect, dataSource)
 }
 
-func (db *DataStore) createTables() {
-	_ = db.createDeviceTable()
-	_ = db.createActionTable()
-	_ = db.createDeviceSnapTable()
-	_ = db.createDeviceVersionTable()
-	_ = db.createOrgGroupTable()
+func isDuplicate(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
 }
@@ -0,0 +1,126 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package datastore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// Sort orders for device listings
+const (
+	SortBrand       = "brand"
+	SortModel       = "model"
+	SortSerial      = "serial"
+	SortDeviceID    = "deviceId"
+	SortCreated     = "created"
+	SortLastRefresh = "lastRefresh"
+)
+
+// Presence filters for device listings
+const (
+	PresenceOnline  = "online"
+	PresenceOffline = "offline"
+)
+
+// DeviceQuery holds the filters, sort order and page for a device listing
+type DeviceQuery struct {
+	Brand          string
+	Model          string
+	Series         string
+	Snap           string
+	SnapRevision   int
+	Group          string
+	Presence       string
+	PresenceCutoff time.Time
+	Labels         []LabelRequirement
+	SortBy         string
+	SortDesc       bool
+	After          *Device
+	Limit          int
+}
+
+// ActionQuery holds the time range and limit for an action log listing. The range includes
+// From and excludes To, and a zero time leaves that end of the range open
+type ActionQuery struct {
+	From  time.Time
+	To    time.Time
+	Limit int
+}
+
+// ValidSort checks that the sort order is supported
+func ValidSort(sortBy string) bool {
+	switch sortBy {
+	case "", SortBrand, SortModel, SortSerial, SortDeviceID, SortCreated, SortLastRefresh:
+		return true
+	default:
+		return false
+	}
+}
+
+// cursor is the serialized position of a device in a listing
+type cursor struct {
+	ID          int64     `json:"i"`
+	Brand       string    `json:"b,omitempty"`
+	Model       string    `json:"m,omitempty"`
+	Serial      string    `json:"s,omitempty"`
+	DeviceID    string    `json:"d,omitempty"`
+	Created     time.Time `json:"c"`
+	LastRefresh time.Time `json:"r"`
+}
+
+// EncodeCursor generates an opaque cursor that points after the device
+func EncodeCursor(d Device) string {
+	c := cursor{
+		ID:          d.ID,
+		Brand:       d.Brand,
+		Model:       d.Model,
+		Serial:      d.SerialNumber,
+		DeviceID:    d.DeviceID,
+		Created:     d.Created,
+		LastRefresh: d.LastRefresh,
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses an opaque cursor, returning the sort fields of the device it points after
+func DecodeCursor(s string) (*Device, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, Invalid("invalid cursor: %v", err)
+	}
+
+	c := cursor{}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, Invalid("invalid cursor: %v", err)
+	}
+
+	return &Device{
+		ID:           c.ID,
+		Brand:        c.Brand,
+		Model:        c.Model,
+		SerialNumber: c.Serial,
+		DeviceID:     c.DeviceID,
+		Created:      c.Created,
+		LastRefresh:  c.LastRefresh,
+	}, nil
+}
@@ -0,0 +1,143 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+import "github.com/canonical/iot-devicetwin/datastore/sqlstore"
+
+// migrations are the ordered changes to the schema of a SQLite database, matching
+// the postgreSQL schema. A released migration must never be changed: add a new migration instead.
+var migrations = []sqlstore.Migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		Up: []string{`
+CREATE TABLE device (
+   id             integer primary key autoincrement,
+   created        timestamp default current_timestamp,
+   lastrefresh    timestamp default current_timestamp,
+   org_id         varchar(200) not null,
+   device_id      varchar(200) unique not null,
+   brand          varchar(200) not null,
+   model          varchar(200) not null,
+   serial         varchar(200) not null,
+   store_id       varchar(200) not null,
+   device_key     text,
+   active         bool default true
+)`,
+			"CREATE INDEX device_org_idx ON device (org_id, brand, model, serial, id)", `
+CREATE TABLE action (
+   id             integer primary key autoincrement,
+   created        timestamp default current_timestamp,
+   modified       timestamp default current_timestamp,
+   org_id         varchar(200) not null,
+   device_id      varchar(200) not null,
+   action_id      varchar(200) not null,
+   action         varchar(200) not null,
+   status         varchar(200) default '',
+   message        text default ''
+)`, `
+CREATE TABLE device_snap (
+   id             integer primary key autoincrement,
+   created        timestamp default current_timestamp,
+   modified       timestamp default current_timestamp,
+   device_id      int references device not null,
+   name           varchar(200) not null,
+   installed_size int default 0,
+   installed_date timestamp default current_timestamp,
+   status         varchar(200) default '',
+   channel        varchar(200) default '',
+   confinement    varchar(200) default '',
+   version        varchar(200) default '',
+   revision       int default 0,
+   devmode        bool default false,
+   config         text default ''
+)`,
+			"CREATE UNIQUE INDEX device_snap_idx ON device_snap (device_id, name)", `
+CREATE TABLE device_version (
+   id             integer primary key autoincrement,
+   device_id      int references device not null unique,
+   version        varchar(200) not null,
+   series         varchar(200) default '',
+   os_id          varchar(200) default '',
+   os_version_id  varchar(200) default '',
+   on_classic     bool default false,
+   kernel_version varchar(200) default ''
+)`, `
+CREATE TABLE device_label (
+   id             integer primary key autoincrement,
+   created        timestamp default current_timestamp,
+   modified       timestamp default current_timestamp,
+   device_id      int references device not null,
+   key            varchar(200) not null,
+   value          varchar(200) not null default ''
+)`,
+			"CREATE UNIQUE INDEX device_label_idx ON device_label (device_id, key)",
+			"CREATE INDEX device_label_key_idx ON device_label (key, value)", `
+CREATE TABLE org_group (
+   id             integer primary key autoincrement,
+   created        timestamp default current_timestamp,
+   modified       timestamp default current_timestamp,
+   org_id         varchar(200) not null,
+   name           varchar(200) not null,
+   rule           text default '',
+   description    text default '',
+   parent_id      int references org_group
+)`,
+			"CREATE UNIQUE INDEX org_group_name_idx ON org_group (org_id, name)", `
+CREATE TABLE group_device_link (
+   id             integer primary key autoincrement,
+   created        timestamp default current_timestamp,
+   org_id         varchar(200) not null,
+   group_id       int references org_group not null,
+   device_id      int references device not null
+)`,
+			"CREATE UNIQUE INDEX group_device_link_idx ON group_device_link (group_id, device_id)",
+		},
+		Down: []string{
+			"DROP TABLE group_device_link",
+			"DROP TABLE org_group",
+			"DROP TABLE device_label",
+			"DROP TABLE device_version",
+			"DROP TABLE device_snap",
+			"DROP TABLE action",
+			"DROP TABLE device",
+		},
+	},
+	{
+		Version:     2,
+		Description: "action log index",
+		Up: []string{
+			"CREATE INDEX action_device_idx ON action (org_id, device_id, created)",
+		},
+		Down: []string{
+			"DROP INDEX action_device_idx",
+		},
+	},
+	{
+		Version:     3,
+		Description: "action ID index",
+		Up: []string{
+			"CREATE INDEX action_id_idx ON action (action_id)",
+		},
+		Down: []string{
+			"DROP INDEX action_id_idx",
+		},
+	},
+}
@@ -0,0 +1,70 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package sqlite
+
+import (
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d, migrations must be numbered in order", i+1, m.Version)
+		}
+		if len(m.Description) == 0 {
+			t.Errorf("migration %d has no description", m.Version)
+		}
+		if len(m.Up) == 0 || len(m.Down) == 0 {
+			t.Errorf("migration %d must have up and down statements", m.Version)
+		}
+	}
+}
+
+func TestDataStore_MigrateTo(t *testing.T) {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	steps := []struct {
+		name    string
+		version int
+		wantErr bool
+	}{
+		{"upgrade", db.LatestSchemaVersion(), false},
+		{"upgrade-again", db.LatestSchemaVersion(), false},
+		{"downgrade", 0, false},
+		{"upgrade-after-downgrade", db.LatestSchemaVersion(), false},
+		{"invalid-version", db.LatestSchemaVersion() + 1, true},
+		{"invalid-negative", -1, true},
+	}
+	for _, tt := range steps {
+		if err := db.MigrateTo(tt.version); (err != nil) != tt.wantErr {
+			t.Fatalf("%s: DataStore.MigrateTo() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr {
+			continue
+		}
+		got, err := db.SchemaVersion()
+		if err != nil || got != tt.version {
+			t.Errorf("%s: DataStore.SchemaVersion() = %v, %v, want %v", tt.name, got, err, tt.version)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/canonical/iot-devicetwin/datastore/sqlstore"
+	"github.com/mattn/go-sqlite3"
+	"regexp"
+)
+
+// Dialect is the SQLite dialect of the SQL data store. SQLite uses the same queries
+// as postgreSQL with its own schema, and stores timestamps as text, so they are stored
+// in UTC to keep them in order. A SQLite transaction already has exclusive access when
+// it writes, so the schema table is not locked for the migrations.
+var Dialect = sqlstore.Dialect{
+	Driver:      "sqlite3",
+	Migrations:  migrations,
+	Configure:   configure,
+	Rebind:      rebind,
+	UTC:         true,
+	IsDuplicate: isDuplicate,
+}
+
+// OpenDataStore returns an open database connection, with the latest schema
+func OpenDataStore(dataSource string) *sqlstore.DataStore {
+	return sqlstore.OpenDataStore(Dialect, dataSource)
+}
+
+// Open returns an open database connection for a SQLite database file, without migrating the schema
+func Open(dataSource string) (*sqlstore.DataStore, error) {
+	return sqlstore.Open(Dialect, dataSource)
+}
+
+// configure sets up a SQLite database file. SQLite allows a single writer, so a
+// single connection is used to serialize the writes, and foreign keys must be enabled
+func configure(db *sql.DB) error {
+	db.SetMaxOpenConns(1)
+
+	for _, pragma := range []string{"PRAGMA foreign_keys = ON", "PRAGMA journal_mode = WAL", "PRAGMA busy_timeout = 5000"} {
+		if _, err := db.Exec(pragma); err != nil {
+			return fmt.Errorf("error configuring the database: %v", err)
+		}
+	}
+	return nil
+}
+
+// placeholder matches the numbered parameters of a statement
+var placeholder = regexp.MustCompile(`\$(\d+)`)
+
+// rebind converts the parameters of a statement. SQLite numbers `$N` parameters in the
+// order they appear, so they are converted to `?N` which binds the Nth argument.
+func rebind(query string) string {
+	return placeholder.ReplaceAllString(query, "?$1")
+}
+
+func isDuplicate(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
@@ -0,0 +1,454 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-devicetwin/datastore"
+	"github.com/canonical/iot-devicetwin/datastore/sqlstore"
+)
+
+// openTestStore opens a SQLite database in memory with the latest schema and three devices
+func openTestStore(t *testing.T) *sqlstore.DataStore {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("DataStore.Migrate() error = %v", err)
+	}
+
+	devices := []datastore.Device{
+		{OrganisationID: "abc", DeviceID: "a111", Brand: "example", Model: "drone-1000", SerialNumber: "DR1000A111", StoreID: "example-store", DeviceKey: "AAAAAAAAA"},
+		{OrganisationID: "abc", DeviceID: "b222", Brand: "example", Model: "drone-1000", SerialNumber: "DR1000B222", StoreID: "example-store", DeviceKey: "BBBBBBBBB"},
+		{OrganisationID: "abc", DeviceID: "c333", Brand: "canonical", Model: "ubuntu-core-18-amd64", SerialNumber: "d75f7300", StoreID: "", DeviceKey: "CCCCCCCCC"},
+	}
+	for _, d := range devices {
+		if _, err := db.DeviceCreate(context.Background(), d); err != nil {
+			t.Fatalf("DataStore.DeviceCreate() error = %v", err)
+		}
+	}
+	return db
+}
+
+func deviceIDs(devices []datastore.Device) []string {
+	ids := []string{}
+	for _, d := range devices {
+		ids = append(ids, d.DeviceID)
+	}
+	return ids
+}
+
+func TestOpenDataStore(t *testing.T) {
+	dir := t.TempDir()
+
+	// Each data source has its own store
+	first := OpenDataStore(filepath.Join(dir, "first.db"))
+	defer first.Close()
+	second := OpenDataStore(filepath.Join(dir, "second.db"))
+	defer second.Close()
+	if first == second {
+		t.Fatal("OpenDataStore() returned the same store for two data sources")
+	}
+
+	if _, err := first.DeviceCreate(context.Background(), datastore.Device{OrganisationID: "abc", DeviceID: "a111"}); err != nil {
+		t.Fatalf("DataStore.DeviceCreate() error = %v", err)
+	}
+	if _, err := second.DeviceGet(context.Background(), "a111"); !errors.Is(err, datastore.ErrNotFound) {
+		t.Errorf("DataStore.DeviceGet() error = %v, want the device only in the first store", err)
+	}
+}
+
+func TestSQLite_DeviceList(t *testing.T) {
+	db := openTestStore(t)
+	defer db.Close()
+
+	a111, _ := db.DeviceGet(context.Background(), "a111")
+	_ = db.DeviceLabelSet(context.Background(), a111.ID, "site", "berlin")
+	_ = db.DeviceSnapUpsert(context.Background(), datastore.DeviceSnap{DeviceID: a111.ID, Name: "core", Revision: 12, InstalledDate: time.Now()})
+	_ = db.DevicePing(context.Background(), "b222", time.Now().Add(-time.Hour))
+
+	labels, _ := datastore.ParseLabelSelector("site=berlin")
+	after, _ := db.DeviceGet(context.Background(), "a111")
+
+	tests := []struct {
+		name    string
+		query   datastore.DeviceQuery
+		want    []string
+		wantErr bool
+	}{
+		{"all", datastore.DeviceQuery{}, []string{"c333", "a111", "b222"}, false},
+		{"brand", datastore.DeviceQuery{Brand: "example"}, []string{"a111", "b222"}, false},
+		{"labels", datastore.DeviceQuery{Labels: labels}, []string{"a111"}, false},
+		{"snap", datastore.DeviceQuery{Snap: "core", SnapRevision: 12}, []string{"a111"}, false},
+		{"snap-other-revision", datastore.DeviceQuery{Snap: "core", SnapRevision: 13}, []string{}, false},
+		{"offline", datastore.DeviceQuery{Presence: datastore.PresenceOffline, PresenceCutoff: time.Now().Add(-time.Minute)}, []string{"b222"}, false},
+		{"sort-desc", datastore.DeviceQuery{SortBy: datastore.SortDeviceID, SortDesc: true}, []string{"c333", "b222", "a111"}, false},
+		{"page", datastore.DeviceQuery{After: &after, Limit: 1}, []string{"b222"}, false},
+		{"invalid-sort", datastore.DeviceQuery{SortBy: "invalid"}, nil, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.DeviceList(context.Background(), "abc", tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DataStore.DeviceList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			ids := deviceIDs(got)
+			if len(ids) != len(tt.want) {
+				t.Fatalf("DataStore.DeviceList() = %v, want %v", ids, tt.want)
+			}
+			for i := range ids {
+				if ids[i] != tt.want[i] {
+					t.Errorf("DataStore.DeviceList() = %v, want %v", ids, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSQLite_SnapInventory(t *testing.T) {
+	db := openTestStore(t)
+	defer db.Close()
+
+	for _, id := range []string{"a111", "b222", "c333"} {
+		d, _ := db.DeviceGet(context.Background(), id)
+		if err := db.DeviceSnapUpsert(context.Background(), datastore.DeviceSnap{DeviceID: d.ID, Name: "core", Version: "16", Revision: 12, Channel: "stable", Status: "active", InstalledDate: time.Now()}); err != nil {
+			t.Fatalf("DataStore.DeviceSnapUpsert() error = %v", err)
+		}
+	}
+	d, _ := db.DeviceGet(context.Background(), "c333")
+	_ = db.DeviceSnapUpsert(context.Background(), datastore.DeviceSnap{DeviceID: d.ID, Name: "core", Version: "17", Revision: 13, Channel: "stable", Status: "active", InstalledDate: time.Now()})
+
+	got, err := db.SnapInventory(context.Background(), "abc")
+	if err != nil {
+		t.Fatalf("DataStore.SnapInventory() error = %v", err)
+	}
+	want := []datastore.SnapCount{
+		{Name: "core", Version: "16", Revision: 12, Channel: "stable", Status: "active", Devices: 2},
+		{Name: "core", Version: "17", Revision: 13, Channel: "stable", Status: "active", Devices: 1},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("DataStore.SnapInventory() = %v, want %v", got, want)
+	}
+}
+
+func TestSQLite_DeviceSnapReplace(t *testing.T) {
+	db := openTestStore(t)
+	defer db.Close()
+
+	a, _ := db.DeviceGet(context.Background(), "a111")
+	b, _ := db.DeviceGet(context.Background(), "b222")
+	_ = db.DeviceSnapUpsert(context.Background(), datastore.DeviceSnap{DeviceID: a.ID, Name: "helloworld", InstalledDate: time.Now()})
+	_ = db.DeviceSnapUpsert(context.Background(), datastore.DeviceSnap{DeviceID: b.ID, Name: "core", InstalledDate: time.Now()})
+
+	snaps := []datastore.DeviceSnap{
+		{Name: "core", Revision: 12, InstalledDate: time.Now()},
+		{Name: "core18", Revision: 4, InstalledDate: time.Now()},
+	}
+	if err := db.DeviceSnapReplace(context.Background(), a.ID, snaps); err != nil {
+		t.Fatalf("DataStore.DeviceSnapReplace() error = %v", err)
+	}
+	got, _ := db.DeviceSnapList(context.Background(), a.ID)
+	if len(got) != 2 || got[0].Name != "core" || got[0].Revision != 12 || got[1].Name != "core18" {
+		t.Errorf("DataStore.DeviceSnapReplace() = %v, want core and core18", got)
+	}
+	other, _ := db.DeviceSnapList(context.Background(), b.ID)
+	if len(other) != 1 {
+		t.Errorf("DataStore.DeviceSnapReplace() other device snaps = %d, want 1", len(other))
+	}
+
+	// A failed replacement leaves the existing snaps in place
+	if err := db.DeviceSnapReplace(context.Background(), 999, snaps); err == nil {
+		t.Error("DataStore.DeviceSnapReplace() expected error for unknown device")
+	}
+
+	if err := db.DeviceSnapReplace(context.Background(), a.ID, nil); err != nil {
+		t.Fatalf("DataStore.DeviceSnapReplace() error = %v", err)
+	}
+	got, _ = db.DeviceSnapList(context.Background(), a.ID)
+	if len(got) != 0 {
+		t.Errorf("DataStore.DeviceSnapReplace() = %v, want empty", got)
+	}
+}
+
+func TestSQLite_GroupWorkflow(t *testing.T) {
+	db := openTestStore(t)
+	defer db.Close()
+
+	siteID, err := db.GroupCreate(context.Background(), datastore.Group{OrganisationID: "abc", Name: "site", Description: "Berlin"})
+	if err != nil {
+		t.Fatalf("DataStore.GroupCreate() error = %v", err)
+	}
+	if _, err := db.GroupCreate(context.Background(), datastore.Group{OrganisationID: "abc", Name: "site"}); err == nil {
+		t.Error("DataStore.GroupCreate() expected error for duplicate name")
+	}
+	if _, err := db.GroupCreate(context.Background(), datastore.Group{OrganisationID: "abc", Name: "workshop", ParentID: siteID}); err != nil {
+		t.Fatalf("DataStore.GroupCreate() error = %v", err)
+	}
+	if _, err := db.GroupCreate(context.Background(), datastore.Group{OrganisationID: "abc", Name: "servers", ParentID: siteID, Rule: datastore.GroupRule{Brand: "canonical"}}); err != nil {
+		t.Fatalf("DataStore.GroupCreate() error = %v", err)
+	}
+	if err := db.GroupLinkDevice(context.Background(), "abc", "workshop", "a111"); err != nil {
+		t.Fatalf("DataStore.GroupLinkDevice() error = %v", err)
+	}
+
+	check := func(name string, want []string, excluded []string) {
+		t.Helper()
+		got, err := db.GroupGetDevices(context.Background(), "abc", name, datastore.DeviceQuery{SortBy: datastore.SortDeviceID})
+		if err != nil || len(got) != len(want) {
+			t.Errorf("DataStore.GroupGetDevices(%s) = %v, %v, want %v", name, deviceIDs(got), err, want)
+		}
+		got, err = db.GroupGetExcludedDevices(context.Background(), "abc", name, datastore.DeviceQuery{SortBy: datastore.SortDeviceID})
+		if err != nil || len(got) != len(excluded) {
+			t.Errorf("DataStore.GroupGetExcludedDevices(%s) = %v, %v, want %v", name, deviceIDs(got), err, excluded)
+		}
+	}
+	check("workshop", []string{"a111"}, []string{"b222", "c333"})
+	check("servers", []string{"c333"}, []string{"a111", "b222"})
+	check("site", []string{"a111", "c333"}, []string{"b222"})
+
+	grp, err := db.GroupGet(context.Background(), "abc", "servers")
+	if err != nil || grp.ParentID != siteID || grp.Rule.Brand != "canonical" {
+		t.Errorf("DataStore.GroupGet() = %v, %v", grp, err)
+	}
+	grp.Name = "edge"
+	grp.Rule = datastore.GroupRule{Model: "drone-1000"}
+	if err := db.GroupUpdate(context.Background(), grp); err != nil {
+		t.Fatalf("DataStore.GroupUpdate() error = %v", err)
+	}
+	check("edge", []string{"a111", "b222"}, []string{"c333"})
+
+	// A stored rule without criteria, from before the rules were validated, matches every device
+	if _, err := db.GroupCreate(context.Background(), datastore.Group{OrganisationID: "abc", Name: "blank", Rule: datastore.GroupRule{LabelSelector: " , "}}); err != nil {
+		t.Fatalf("DataStore.GroupCreate() error = %v", err)
+	}
+	check("blank", []string{"a111", "b222", "c333"}, []string{})
+
+	if err := db.GroupDelete(context.Background(), "abc", "site"); err != nil {
+		t.Fatalf("DataStore.GroupDelete() error = %v", err)
+	}
+	groups, err := db.GroupList(context.Background(), "abc")
+	if err != nil || len(groups) != 3 {
+		t.Fatalf("DataStore.GroupList() = %v, %v", groups, err)
+	}
+	for _, g := range groups {
+		if g.ParentID != 0 {
+			t.Errorf("DataStore.GroupDelete() left group %s with parent %d", g.Name, g.ParentID)
+		}
+	}
+}
+
+func TestSQLite_ContextCancelled(t *testing.T) {
+	db := openTestStore(t)
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := db.DeviceList(ctx, "abc", datastore.DeviceQuery{}); err == nil {
+		t.Error("DataStore.DeviceList() expected error for a cancelled context")
+	}
+	if err := db.DevicePing(ctx, "a111", time.Now()); err == nil {
+		t.Error("DataStore.DevicePing() expected error for a cancelled context")
+	}
+	if err := db.DeviceSnapReplace(ctx, 1, []datastore.DeviceSnap{{Name: "core"}}); err == nil {
+		t.Error("DataStore.DeviceSnapReplace() expected error for a cancelled context")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	time.Sleep(time.Millisecond)
+	if _, err := db.DeviceGet(ctx, "a111"); err == nil {
+		t.Error("DataStore.DeviceGet() expected error after the deadline")
+	}
+}
+
+func TestSQLite_Errors(t *testing.T) {
+	db := openTestStore(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	if _, err := db.GroupCreate(ctx, datastore.Group{OrganisationID: "abc", Name: "workshop"}); err != nil {
+		t.Fatalf("DataStore.GroupCreate() error = %v", err)
+	}
+	_, errDevice := db.DeviceCreate(ctx, datastore.Device{OrganisationID: "abc", DeviceID: "a111"})
+	_, errGroup := db.GroupCreate(ctx, datastore.Group{OrganisationID: "abc", Name: "workshop"})
+	_, errGet := db.DeviceGet(ctx, "invalid")
+	_, errVersion := db.DeviceVersionGet(ctx, 999)
+	_, errList := db.DeviceList(ctx, "abc", datastore.DeviceQuery{SortBy: "invalid"})
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"device-conflict", errDevice, datastore.ErrConflict},
+		{"group-conflict", errGroup, datastore.ErrConflict},
+		{"device-not-found", errGet, datastore.ErrNotFound},
+		{"version-not-found", errVersion, datastore.ErrNotFound},
+		{"ping-not-found", db.DevicePing(ctx, "invalid", time.Now()), datastore.ErrNotFound},
+		{"group-update-not-found", db.GroupUpdate(ctx, datastore.Group{ID: 999, OrganisationID: "abc", Name: "lab"}), datastore.ErrNotFound},
+		{"link-not-found", db.GroupLinkDevice(ctx, "abc", "invalid", "a111"), datastore.ErrNotFound},
+		{"invalid-sort", errList, datastore.ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !errors.Is(tt.err, tt.want) {
+				t.Errorf("DataStore error = %v, want %v", tt.err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLite_ActionRetention(t *testing.T) {
+	db := openTestStore(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	now := time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC)
+	for _, a := range []datastore.Action{
+		{OrganizationID: "abc", DeviceID: "a111", ActionID: "a1", Created: now.AddDate(0, 0, -9)},
+		{OrganizationID: "abc", DeviceID: "a111", ActionID: "a2", Created: now.AddDate(0, 0, -5)},
+		{OrganizationID: "abc", DeviceID: "a111", ActionID: "a3", Created: now.AddDate(0, 0, -1)},
+		{OrganizationID: "abc", DeviceID: "b222", ActionID: "b1", Created: now.AddDate(0, 0, -8)},
+		{OrganizationID: "xyz", DeviceID: "c333", ActionID: "c1", Created: now.AddDate(0, 0, -9)},
+	} {
+		_, err := db.ExecContext(ctx, "insert into action (org_id, device_id, action_id, action, created) values ($1,$2,$3,'list',$4)",
+			a.OrganizationID, a.DeviceID, a.ActionID, a.Created.UTC())
+		if err != nil {
+			t.Fatalf("Error creating action: %v", err)
+		}
+	}
+
+	list := []struct {
+		name  string
+		query datastore.ActionQuery
+		want  []string
+	}{
+		{"all", datastore.ActionQuery{}, []string{"a3", "a2", "a1"}},
+		{"from", datastore.ActionQuery{From: now.AddDate(0, 0, -5)}, []string{"a3", "a2"}},
+		{"to", datastore.ActionQuery{To: now.AddDate(0, 0, -5)}, []string{"a1"}},
+		{"limit", datastore.ActionQuery{Limit: 1}, []string{"a3"}},
+	}
+	for _, tt := range list {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.ActionListForDevice(ctx, "abc", "a111", tt.query)
+			if err != nil {
+				t.Fatalf("DataStore.ActionListForDevice() error = %v", err)
+			}
+			if ids := actionIDs(got); !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("DataStore.ActionListForDevice() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	expired := []struct {
+		name   string
+		before time.Time
+		keep   int
+		want   []string
+	}{
+		{"none", time.Time{}, 0, []string{}},
+		{"days", now.AddDate(0, 0, -7), 0, []string{"a1", "b1"}},
+		{"count", time.Time{}, 2, []string{"a1"}},
+		{"days-and-count", now.AddDate(0, 0, -3), 1, []string{"a1", "a2", "b1"}},
+	}
+	for _, tt := range expired {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.ActionListExpired(ctx, "abc", tt.before, tt.keep, 0)
+			if err != nil {
+				t.Fatalf("DataStore.ActionListExpired() error = %v", err)
+			}
+			if ids := actionIDs(got); !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("DataStore.ActionListExpired() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	if err := db.ActionDelete(ctx, []int64{1, 4}); err != nil {
+		t.Fatalf("DataStore.ActionDelete() error = %v", err)
+	}
+	orgs, err := db.ActionOrgList(ctx)
+	if err != nil || !reflect.DeepEqual(orgs, []string{"abc", "xyz"}) {
+		t.Errorf("DataStore.ActionOrgList() = %v, %v, want %v", orgs, err, []string{"abc", "xyz"})
+	}
+	got, _ := db.ActionListForDevice(ctx, "abc", "a111", datastore.ActionQuery{})
+	if ids := actionIDs(got); !reflect.DeepEqual(ids, []string{"a3", "a2"}) {
+		t.Errorf("DataStore.ActionDelete() left %v, want %v", ids, []string{"a3", "a2"})
+	}
+}
+
+func actionIDs(actions []datastore.Action) []string {
+	ids := []string{}
+	for _, a := range actions {
+		ids = append(ids, a.ActionID)
+	}
+	return ids
+}
+
+func TestSQLite_Secrets(t *testing.T) {
+	db := openTestStore(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	if err := db.DeviceSnapReplace(ctx, 1, []datastore.DeviceSnap{{Name: "helloworld", Config: `{"title":"hello"}`}, {Name: "core"}}); err != nil {
+		t.Fatalf("DataStore.DeviceSnapReplace() error = %v", err)
+	}
+
+	secrets, err := db.SecretList(ctx)
+	if err != nil {
+		t.Fatalf("DataStore.SecretList() error = %v", err)
+	}
+	if len(secrets) != 4 {
+		t.Fatalf("DataStore.SecretList() = %v, want 4 secrets", secrets)
+	}
+	snap := secrets[3]
+	if snap.Kind != datastore.SecretSnapConfig || snap.Owner != "1/helloworld" || snap.Value != `{"title":"hello"}` {
+		t.Errorf("DataStore.SecretList() snap = %+v", snap)
+	}
+
+	secrets[0].Value = "sealed-key"
+	snap.Value = "sealed-config"
+	if err := db.SecretUpdate(ctx, []datastore.Secret{secrets[0], snap}); err != nil {
+		t.Fatalf("DataStore.SecretUpdate() error = %v", err)
+	}
+	device, _ := db.DeviceGet(ctx, secrets[0].Owner)
+	snaps, _ := db.DeviceSnapList(ctx, 1)
+	configs := map[string]string{}
+	for _, ds := range snaps {
+		configs[ds.Name] = ds.Config
+	}
+	if device.DeviceKey != "sealed-key" || configs["helloworld"] != "sealed-config" || configs["core"] != "" {
+		t.Errorf("DataStore.SecretUpdate() = %v, %v", device.DeviceKey, configs)
+	}
+
+	if err := db.SecretUpdate(ctx, []datastore.Secret{{Kind: "invalid", ID: 1}}); !errors.Is(err, datastore.ErrInvalid) {
+		t.Errorf("DataStore.SecretUpdate() error = %v, want %v", err, datastore.ErrInvalid)
+	}
+}
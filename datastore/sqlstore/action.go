@@ -0,0 +1,198 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"github.com/canonical/iot-devicetwin/datastore"
+	"log"
+	"strings"
+	"time"
+)
+
+// ActionCreate log an new action
+func (db *DataStore) ActionCreate(ctx context.Context, act datastore.Action) (int64, error) {
+	created, modified := actionTimes(act)
+
+	var id int64
+	err := db.QueryRowContext(ctx, createActionSQL, act.OrganizationID, act.DeviceID, act.ActionID, act.Action, act.Status, act.Message, db.timeArg(created), db.timeArg(modified)).Scan(&id)
+	if err != nil {
+		log.Printf("Error creating action %s/%s: %v\n", act.DeviceID, act.ActionID, err)
+	}
+
+	return id, err
+}
+
+// actionTimes returns the created and modified times of a new action, defaulting to now
+func actionTimes(act datastore.Action) (time.Time, time.Time) {
+	now := time.Now()
+	if act.Created.IsZero() {
+		act.Created = now
+	}
+	if act.Modified.IsZero() {
+		act.Modified = act.Created
+	}
+	return act.Created, act.Modified
+}
+
+// ActionUpdate updates an action record
+func (db *DataStore) ActionUpdate(ctx context.Context, actionID, status, message string) error {
+	_, err := db.ExecContext(ctx, updateActionSQL, actionID, status, message)
+	if err != nil {
+		log.Printf("Error updating the device: %v\n", err)
+	}
+
+	return err
+}
+
+// ActionGet fetches an action by its action ID, which is sent to the device with the action
+func (db *DataStore) ActionGet(ctx context.Context, actionID string) (datastore.Action, error) {
+	actions, err := db.listActions(ctx, getActionSQL, actionID)
+	if err != nil {
+		log.Printf("Error retrieving action %s: %v\n", actionID, err)
+		return datastore.Action{}, err
+	}
+	if len(actions) == 0 {
+		return datastore.Action{}, datastore.NotFound("action with ID `%s` not found", actionID)
+	}
+	return actions[0], nil
+}
+
+// ActionListForDevice lists the actions for a device, most recent first
+func (db *DataStore) ActionListForDevice(ctx context.Context, orgID, deviceID string, query datastore.ActionQuery) ([]datastore.Action, error) {
+	q := &deviceQuery{utc: db.dialect.UTC}
+	q.where = append(q.where, "org_id="+q.arg(orgID), "device_id="+q.arg(deviceID))
+	if !query.From.IsZero() {
+		q.where = append(q.where, "created>="+q.arg(query.From))
+	}
+	if !query.To.IsZero() {
+		q.where = append(q.where, "created<"+q.arg(query.To))
+	}
+
+	stmt := fmt.Sprintf(listActionSQL, strings.Join(q.where, " and "))
+	if query.Limit > 0 {
+		stmt += " limit " + q.arg(query.Limit)
+	}
+
+	actions, err := db.listActions(ctx, stmt, q.args...)
+	if err != nil {
+		log.Printf("Error retrieving actions: %v\n", err)
+	}
+	return actions, err
+}
+
+// ActionListForOrg lists the action log of an organization, oldest first
+func (db *DataStore) ActionListForOrg(ctx context.Context, orgID string) ([]datastore.Action, error) {
+	actions, err := db.listActions(ctx, listOrgActionSQL, orgID)
+	if err != nil {
+		log.Printf("Error retrieving actions: %v\n", err)
+	}
+	return actions, err
+}
+
+// ActionOrgList lists the organizations that have actions in the log
+func (db *DataStore) ActionOrgList(ctx context.Context) ([]string, error) {
+	rows, err := db.QueryContext(ctx, listActionOrgSQL)
+	if err != nil {
+		log.Printf("Error retrieving action organizations: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []string{}
+	for rows.Next() {
+		var orgID string
+		if err := rows.Scan(&orgID); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, orgID)
+	}
+
+	return orgs, rows.Err()
+}
+
+// ActionListExpired lists the actions of an organization that are outside the retention
+// period: those created before a time, or beyond the most recent actions of each device.
+// A zero time or count disables that limit. The actions are in the order they were created,
+// up to the limit or all of them for zero
+func (db *DataStore) ActionListExpired(ctx context.Context, orgID string, before time.Time, keep, limit int) ([]datastore.Action, error) {
+	q := &deviceQuery{utc: db.dialect.UTC}
+	org := q.arg(orgID)
+	if !before.IsZero() {
+		q.where = append(q.where, "created<"+q.arg(before))
+	}
+	if keep > 0 {
+		q.where = append(q.where, "position>"+q.arg(keep))
+	}
+	if len(q.where) == 0 {
+		return []datastore.Action{}, nil
+	}
+
+	stmt := fmt.Sprintf(listExpiredActionSQL, org, strings.Join(q.where, " or "))
+	if limit > 0 {
+		stmt += " limit " + q.arg(limit)
+	}
+	actions, err := db.listActions(ctx, stmt, q.args...)
+	if err != nil {
+		log.Printf("Error retrieving expired actions: %v\n", err)
+	}
+	return actions, err
+}
+
+// ActionDelete removes actions from the log in a single statement
+func (db *DataStore) ActionDelete(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	q := &deviceQuery{}
+	params := make([]string, 0, len(ids))
+	for _, id := range ids {
+		params = append(params, q.arg(id))
+	}
+
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(deleteActionSQL, strings.Join(params, ",")), q.args...); err != nil {
+		log.Printf("Error deleting actions: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// listActions runs an action listing statement
+func (db *DataStore) listActions(ctx context.Context, stmt string, args ...interface{}) ([]datastore.Action, error) {
+	rows, err := db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []datastore.Action{}
+	for rows.Next() {
+		item := datastore.Action{}
+		err := rows.Scan(&item.ID, &item.Created, &item.Modified, &item.OrganizationID, &item.DeviceID, &item.ActionID, &item.Action, &item.Status, &item.Message)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, item)
+	}
+
+	return actions, rows.Err()
+}
@@ -17,33 +17,48 @@
  * along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
 
-package postgres
-
-const createActionTableSQL = `
-CREATE TABLE IF NOT EXISTS action (
-   id             serial primary key,
-   created        timestamp default current_timestamp,
-   modified       timestamp default current_timestamp,
-   org_id         varchar(200) not null,
-   device_id      varchar(200) not null,
-   action_id      varchar(200) not null,
-   action         varchar(200) not null,
-   status         varchar(200) default '',
-   message        text default ''
-)
-`
+package sqlstore
 
 const createActionSQL = `
-insert into action (org_id, device_id, action_id, action, status, message)
-values ($1,$2,$3,$4,$5,$6) RETURNING id`
+insert into action (org_id, device_id, action_id, action, status, message, created, modified)
+values ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING id`
 
 const updateActionSQL = `
 update action
 set status=$2, message=$3, modified=current_timestamp
 where action_id=$1`
 
+const getActionSQL = `
+select id, created, modified, org_id, device_id, action_id, action, status, message
+from action
+where action_id=$1
+order by created desc, id desc
+limit 1`
+
 const listActionSQL = `
 select id, created, modified, org_id, device_id, action_id, action, status, message
 from action
-where org_id=$1 and device_id=$2
-order by created desc`
+where %s
+order by created desc, id desc`
+
+const listOrgActionSQL = `
+select id, created, modified, org_id, device_id, action_id, action, status, message
+from action
+where org_id=$1
+order by created, id`
+
+const listActionOrgSQL = "select distinct org_id from action order by org_id"
+
+// listExpiredActionSQL numbers the actions of each device, most recent first, so the actions
+// beyond the number to keep can be selected
+const listExpiredActionSQL = `
+select id, created, modified, org_id, device_id, action_id, action, status, message
+from (
+   select a.*, row_number() over (partition by device_id order by created desc, id desc) as position
+   from action a
+   where org_id=%s
+) ranked
+where %s
+order by id`
+
+const deleteActionSQL = "delete from action where id in (%s)"
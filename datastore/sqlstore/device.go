@@ -0,0 +1,135 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"github.com/canonical/iot-devicetwin/datastore"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// pingBatchSize is the number of devices updated by each statement of a ping batch
+const pingBatchSize = 500
+
+// DeviceCreate adds a new record to device database table, returning the record ID
+func (db *DataStore) DeviceCreate(ctx context.Context, device datastore.Device) (int64, error) {
+	if device.Created.IsZero() {
+		device.Created = time.Now()
+	}
+	if device.LastRefresh.IsZero() {
+		device.LastRefresh = device.Created
+	}
+
+	var id int64
+	err := db.QueryRowContext(ctx, createDeviceSQL, device.OrganisationID, device.DeviceID, device.Brand, device.Model, device.SerialNumber, device.StoreID, device.DeviceKey,
+		db.timeArg(device.Created), db.timeArg(device.LastRefresh)).Scan(&id)
+	if err != nil {
+		log.Printf("Error creating device %s/%s: %v\n", device.Brand, device.Model, err)
+		return id, db.conflict(err, "device with ID `%s` already exists", device.DeviceID)
+	}
+
+	return id, nil
+}
+
+// DeviceGet fetches a device from the database
+func (db *DataStore) DeviceGet(ctx context.Context, deviceID string) (datastore.Device, error) {
+	item := datastore.Device{}
+	row := db.QueryRowContext(ctx, getDeviceSQL, deviceID)
+	err := row.Scan(&item.ID, &item.Created, &item.LastRefresh, &item.OrganisationID, &item.DeviceID, &item.Brand, &item.Model, &item.SerialNumber, &item.StoreID, &item.DeviceKey, &item.Active)
+	if err != nil {
+		log.Printf("Error retrieving device %s: %v\n", deviceID, err)
+		return item, notFound(err, "device with ID `%s` not found", deviceID)
+	}
+	return item, nil
+}
+
+// DevicePing updates the last ping time from a device
+func (db *DataStore) DevicePing(ctx context.Context, deviceID string, refresh time.Time) error {
+	result, err := db.ExecContext(ctx, pingDeviceSQL, deviceID, db.timeArg(refresh))
+	if err != nil {
+		log.Printf("Error updating the device: %v\n", err)
+		return err
+	}
+
+	return affected(result, "device with ID `%s` not found", deviceID)
+}
+
+// DevicePingBatch updates the last ping time of several devices, in batches of rows.
+// Devices that are not in the database are skipped
+func (db *DataStore) DevicePingBatch(ctx context.Context, refreshes map[string]time.Time) error {
+	// The refresh parameter needs a type in postgres, as the values are not typed
+	cast := db.dialect.TimestampCast
+
+	ids := make([]string, 0, len(refreshes))
+	for id := range refreshes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for start := 0; start < len(ids); start += pingBatchSize {
+		end := start + pingBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		rows := []string{}
+		args := []interface{}{}
+		for _, id := range ids[start:end] {
+			rows = append(rows, fmt.Sprintf("($%d,$%d%s)", len(args)+1, len(args)+2, cast))
+			args = append(args, id, db.timeArg(refreshes[id]))
+		}
+		if _, err := db.ExecContext(ctx, fmt.Sprintf(pingDeviceBatchSQL, strings.Join(rows, ",")), args...); err != nil {
+			log.Printf("Error updating the devices: %v\n", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// DeviceList fetches the devices for an organization from the database
+func (db *DataStore) DeviceList(ctx context.Context, orgID string, query datastore.DeviceQuery) ([]datastore.Device, error) {
+	conditions := []func(q *deviceQuery) string{}
+	if len(query.Group) > 0 {
+		grp, err := db.GroupGet(ctx, orgID, query.Group)
+		if err != nil {
+			return nil, fmt.Errorf("error finding group: %w", err)
+		}
+		member, err := db.membership(ctx, grp)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, member)
+	}
+
+	stmt, args, err := db.buildDeviceQuery(orgID, query, conditions...)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := db.listDevices(ctx, stmt, args...)
+	if err != nil {
+		log.Printf("Error retrieving devices: %v\n", err)
+	}
+	return devices, err
+}
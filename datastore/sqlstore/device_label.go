@@ -0,0 +1,107 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"github.com/canonical/iot-devicetwin/datastore"
+	"log"
+	"strings"
+)
+
+// DeviceLabelList lists the labels for a device
+func (db *DataStore) DeviceLabelList(ctx context.Context, deviceID int64) ([]datastore.DeviceLabel, error) {
+	labels, err := db.listDeviceLabels(ctx, listDeviceLabelSQL, deviceID)
+	if err != nil {
+		log.Printf("Error retrieving device labels: %v\n", err)
+	}
+	return labels, err
+}
+
+// DeviceLabelListForOrg lists the labels of the devices of an organization, by device
+func (db *DataStore) DeviceLabelListForOrg(ctx context.Context, orgID string) ([]datastore.DeviceLabel, error) {
+	labels, err := db.listDeviceLabels(ctx, listOrgDeviceLabelSQL, orgID)
+	if err != nil {
+		log.Printf("Error retrieving device labels: %v\n", err)
+	}
+	return labels, err
+}
+
+// listDeviceLabels runs a device label listing statement
+func (db *DataStore) listDeviceLabels(ctx context.Context, stmt string, args ...interface{}) ([]datastore.DeviceLabel, error) {
+	rows, err := db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	labels := []datastore.DeviceLabel{}
+	for rows.Next() {
+		item := datastore.DeviceLabel{}
+		err := rows.Scan(&item.ID, &item.Created, &item.Modified, &item.DeviceID, &item.Key, &item.Value)
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, item)
+	}
+
+	return labels, rows.Err()
+}
+
+// DeviceLabelSet creates or updates a label for a device
+func (db *DataStore) DeviceLabelSet(ctx context.Context, deviceID int64, key, value string) error {
+	_, err := db.ExecContext(ctx, upsertDeviceLabelSQL, deviceID, key, value)
+	if err != nil {
+		log.Printf("Error setting device label %s: %v\n", key, err)
+	}
+
+	return err
+}
+
+// DeviceLabelDelete removes a label from a device
+func (db *DataStore) DeviceLabelDelete(ctx context.Context, deviceID int64, key string) error {
+	_, err := db.ExecContext(ctx, deleteDeviceLabelSQL, deviceID, key)
+	if err != nil {
+		log.Printf("Error deleting device label %s: %v\n", key, err)
+	}
+
+	return err
+}
+
+// labelCondition generates the SQL condition for a label selector requirement
+func (q *deviceQuery) labelCondition(req datastore.LabelRequirement) string {
+	key := q.arg(req.Key)
+
+	switch req.Operator {
+	case datastore.LabelEquals:
+		return fmt.Sprintf(labelConditionSQL, key, " and lbl.value="+q.arg(req.Values[0]))
+	case datastore.LabelNotEquals:
+		return "not " + fmt.Sprintf(labelConditionSQL, key, " and lbl.value="+q.arg(req.Values[0]))
+	case datastore.LabelIn:
+		placeholders := []string{}
+		for _, v := range req.Values {
+			placeholders = append(placeholders, q.arg(v))
+		}
+		return fmt.Sprintf(labelConditionSQL, key, " and lbl.value in ("+strings.Join(placeholders, ", ")+")")
+	default:
+		return fmt.Sprintf(labelConditionSQL, key, "")
+	}
+}
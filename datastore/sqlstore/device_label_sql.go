@@ -0,0 +1,47 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlstore
+
+const upsertDeviceLabelSQL = `
+INSERT INTO device_label (device_id, key, value)
+VALUES ($1,$2,$3)
+ON CONFLICT (device_id, key)
+DO
+  UPDATE
+  SET value = EXCLUDED.value,
+      modified = current_timestamp
+`
+
+const listDeviceLabelSQL = `
+select id, created, modified, device_id, key, value
+from device_label
+where device_id=$1
+order by key`
+
+const listOrgDeviceLabelSQL = `
+select lbl.id, lbl.created, lbl.modified, lbl.device_id, lbl.key, lbl.value
+from device_label lbl
+inner join device d on d.id=lbl.device_id
+where d.org_id=$1
+order by lbl.device_id, lbl.key`
+
+const deleteDeviceLabelSQL = `delete from device_label where device_id=$1 and key=$2`
+
+const labelConditionSQL = "exists (select 1 from device_label lbl where lbl.device_id=d.id and lbl.key=%s%s)"
@@ -0,0 +1,220 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/canonical/iot-devicetwin/datastore"
+)
+
+// deviceSortColumns maps the sort order to the columns used for ordering and paging
+var deviceSortColumns = map[string][]string{
+	"":                        {"d.brand", "d.model", "d.serial"},
+	datastore.SortBrand:       {"d.brand", "d.model", "d.serial"},
+	datastore.SortModel:       {"d.model", "d.serial"},
+	datastore.SortSerial:      {"d.serial"},
+	datastore.SortDeviceID:    {"d.device_id"},
+	datastore.SortCreated:     {"d.created"},
+	datastore.SortLastRefresh: {"d.lastrefresh"},
+}
+
+// deviceSortValues returns the values of the sort columns for a device
+func deviceSortValues(sortBy string, d *datastore.Device) []interface{} {
+	switch sortBy {
+	case datastore.SortModel:
+		return []interface{}{d.Model, d.SerialNumber}
+	case datastore.SortSerial:
+		return []interface{}{d.SerialNumber}
+	case datastore.SortDeviceID:
+		return []interface{}{d.DeviceID}
+	case datastore.SortCreated:
+		return []interface{}{d.Created}
+	case datastore.SortLastRefresh:
+		return []interface{}{d.LastRefresh}
+	default:
+		return []interface{}{d.Brand, d.Model, d.SerialNumber}
+	}
+}
+
+// deviceQuery builds a device listing statement from the query filters
+type deviceQuery struct {
+	where []string
+	args  []interface{}
+	utc   bool
+}
+
+// arg adds a parameter to the statement, returning its placeholder
+func (q *deviceQuery) arg(v interface{}) string {
+	if t, ok := v.(time.Time); ok && q.utc {
+		v = t.UTC()
+	}
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// buildDeviceQuery generates the SQL and parameters to list the devices for an organization
+func (db *DataStore) buildDeviceQuery(orgID string, query datastore.DeviceQuery, conditions ...func(q *deviceQuery) string) (string, []interface{}, error) {
+	columns, ok := deviceSortColumns[query.SortBy]
+	if !ok {
+		return "", nil, datastore.Invalid("invalid sort order `%s`", query.SortBy)
+	}
+
+	q := &deviceQuery{utc: db.dialect.UTC}
+	q.where = append(q.where, "d.org_id="+q.arg(orgID))
+
+	for _, c := range conditions {
+		q.where = append(q.where, c(q))
+	}
+
+	q.where = append(q.where, q.filterConditions(query)...)
+
+	switch query.Presence {
+	case "":
+	case datastore.PresenceOnline:
+		q.where = append(q.where, "d.lastrefresh>="+q.arg(query.PresenceCutoff))
+	case datastore.PresenceOffline:
+		q.where = append(q.where, "d.lastrefresh<"+q.arg(query.PresenceCutoff))
+	default:
+		return "", nil, datastore.Invalid("invalid presence `%s`", query.Presence)
+	}
+
+	direction, comparison := "asc", ">"
+	if query.SortDesc {
+		direction, comparison = "desc", "<"
+	}
+
+	// Keyset pagination on the sort columns, using the record ID to break ties
+	if query.After != nil {
+		placeholders := []string{}
+		for _, v := range deviceSortValues(query.SortBy, query.After) {
+			placeholders = append(placeholders, q.arg(v))
+		}
+		placeholders = append(placeholders, q.arg(query.After.ID))
+		q.where = append(q.where, fmt.Sprintf("(%s, d.id) %s (%s)", strings.Join(columns, ", "), comparison, strings.Join(placeholders, ", ")))
+	}
+
+	order := []string{}
+	for _, c := range append(columns, "d.id") {
+		order = append(order, c+" "+direction)
+	}
+
+	stmt := fmt.Sprintf("%s\nwhere %s\norder by %s", listDeviceBaseSQL, strings.Join(q.where, " and "), strings.Join(order, ", "))
+	if query.Limit > 0 {
+		stmt += "\nlimit " + q.arg(query.Limit)
+	}
+
+	return stmt, q.args, nil
+}
+
+// filterConditions generates the conditions for the device attribute filters of a query
+func (q *deviceQuery) filterConditions(query datastore.DeviceQuery) []string {
+	where := []string{}
+	if len(query.Brand) > 0 {
+		where = append(where, "d.brand="+q.arg(query.Brand))
+	}
+	if len(query.Model) > 0 {
+		where = append(where, "d.model="+q.arg(query.Model))
+	}
+	if len(query.Series) > 0 {
+		where = append(where, fmt.Sprintf("exists (select 1 from device_version v where v.device_id=d.id and v.series=%s)", q.arg(query.Series)))
+	}
+	if len(query.Snap) > 0 && query.SnapRevision > 0 {
+		where = append(where, fmt.Sprintf("exists (select 1 from device_snap s where s.device_id=d.id and s.name=%s and s.revision=%s)", q.arg(query.Snap), q.arg(query.SnapRevision)))
+	} else if len(query.Snap) > 0 {
+		where = append(where, fmt.Sprintf("exists (select 1 from device_snap s where s.device_id=d.id and s.name=%s)", q.arg(query.Snap)))
+	}
+	for _, req := range query.Labels {
+		where = append(where, q.labelCondition(req))
+	}
+	return where
+}
+
+// memberCondition generates the condition for a device to be a member of any of the groups.
+// Dynamic groups match on the group rule, static groups on the device links.
+func memberCondition(groups []datastore.Group) (func(q *deviceQuery) string, error) {
+	linked := []int64{}
+	rules := []datastore.DeviceQuery{}
+	for _, grp := range groups {
+		if !grp.Rule.Dynamic() {
+			linked = append(linked, grp.ID)
+			continue
+		}
+
+		rule, err := grp.Rule.Query()
+		if err != nil {
+			return nil, datastore.Invalid("invalid rule for group `%s`: %v", grp.Name, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return func(q *deviceQuery) string {
+		or := []string{}
+		if len(linked) > 0 {
+			placeholders := []string{}
+			for _, id := range linked {
+				placeholders = append(placeholders, q.arg(id))
+			}
+			or = append(or, fmt.Sprintf(groupDeviceLinkConditionSQL, strings.Join(placeholders, ",")))
+		}
+		for _, rule := range rules {
+			conditions := q.filterConditions(rule)
+			if len(conditions) == 0 {
+				or = append(or, "true")
+				continue
+			}
+			or = append(or, "("+strings.Join(conditions, " and ")+")")
+		}
+		if len(or) == 0 {
+			return "false"
+		}
+		return "(" + strings.Join(or, " or ") + ")"
+	}, nil
+}
+
+// listDevices runs a device listing statement
+func (db *DataStore) listDevices(ctx context.Context, stmt string, args ...interface{}) ([]datastore.Device, error) {
+	rows, err := db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanDevices(rows)
+}
+
+// scanDevices reads the device records from a result set
+func scanDevices(rows *sql.Rows) ([]datastore.Device, error) {
+	devices := []datastore.Device{}
+	for rows.Next() {
+		item := datastore.Device{}
+		err := rows.Scan(&item.ID, &item.Created, &item.LastRefresh, &item.OrganisationID, &item.DeviceID, &item.Brand, &item.Model, &item.SerialNumber, &item.StoreID, &item.DeviceKey, &item.Active)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, item)
+	}
+
+	return devices, rows.Err()
+}
@@ -0,0 +1,171 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"github.com/canonical/iot-devicetwin/datastore"
+	"log"
+)
+
+// DeviceSnapUpsert creates or updates a device snap record
+func (db *DataStore) DeviceSnapUpsert(ctx context.Context, ds datastore.DeviceSnap) error {
+	var id int64
+	err := db.QueryRowContext(ctx, upsertDeviceSnapSQL, ds.DeviceID, ds.Name, ds.InstalledSize, db.timeArg(ds.InstalledDate), ds.Status, ds.Channel, ds.Confinement, ds.Version, ds.Revision, ds.Devmode, ds.Config).Scan(&id)
+	if err != nil {
+		log.Printf("Error creating device snap %s: %v\n", ds.Name, err)
+	}
+
+	return err
+}
+
+// DeviceSnapReplace replaces the snaps of a device in a single transaction, so readers
+// never see an empty or partial snap list. The snaps that are already installed are
+// updated, so they keep their created time
+func (db *DataStore) DeviceSnapReplace(ctx context.Context, deviceID int64, snaps []datastore.DeviceSnap) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	names, err := db.deviceSnapNames(ctx, tx, deviceID)
+	if err != nil {
+		_ = tx.Rollback()
+		log.Printf("Error retrieving device snaps: %v\n", err)
+		return err
+	}
+
+	installed := map[string]bool{}
+	for _, ds := range snaps {
+		installed[ds.Name] = true
+		_, err := tx.ExecContext(ctx, db.rebind(upsertDeviceSnapSQL), deviceID, ds.Name, ds.InstalledSize, db.timeArg(ds.InstalledDate), ds.Status, ds.Channel, ds.Confinement, ds.Version, ds.Revision, ds.Devmode, ds.Config)
+		if err != nil {
+			_ = tx.Rollback()
+			log.Printf("Error creating device snap %s: %v\n", ds.Name, err)
+			return err
+		}
+	}
+
+	// Remove the snaps that are no longer installed
+	for _, name := range names {
+		if installed[name] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, db.rebind(deleteDeviceSnapNameSQL), deviceID, name); err != nil {
+			_ = tx.Rollback()
+			log.Printf("Error deleting device snap %s: %v\n", name, err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// deviceSnapNames lists the names of the snaps of a device within a transaction
+func (db *DataStore) deviceSnapNames(ctx context.Context, tx *sql.Tx, deviceID int64) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, db.rebind(listDeviceSnapNameSQL), deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// DeviceSnapList lists the snaps for a device
+func (db *DataStore) DeviceSnapList(ctx context.Context, deviceID int64) ([]datastore.DeviceSnap, error) {
+	snaps, err := db.listDeviceSnaps(ctx, listDeviceSnapSQL, deviceID)
+	if err != nil {
+		log.Printf("Error retrieving device snaps: %v\n", err)
+	}
+	return snaps, err
+}
+
+// DeviceSnapListForOrg lists the snaps of the devices of an organization, by device
+func (db *DataStore) DeviceSnapListForOrg(ctx context.Context, orgID string) ([]datastore.DeviceSnap, error) {
+	snaps, err := db.listDeviceSnaps(ctx, listOrgDeviceSnapSQL, orgID)
+	if err != nil {
+		log.Printf("Error retrieving device snaps: %v\n", err)
+	}
+	return snaps, err
+}
+
+// listDeviceSnaps runs a device snap listing statement
+func (db *DataStore) listDeviceSnaps(ctx context.Context, stmt string, args ...interface{}) ([]datastore.DeviceSnap, error) {
+	rows, err := db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snaps := []datastore.DeviceSnap{}
+	for rows.Next() {
+		item := datastore.DeviceSnap{}
+		err := rows.Scan(&item.ID, &item.Created, &item.Modified, &item.DeviceID, &item.Name, &item.InstalledSize, &item.InstalledDate, &item.Status, &item.Channel, &item.Confinement, &item.Version, &item.Revision, &item.Devmode, &item.Config)
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, item)
+	}
+
+	return snaps, rows.Err()
+}
+
+// SnapInventory counts the devices of an organization for each installed snap, version,
+// revision, channel and status
+func (db *DataStore) SnapInventory(ctx context.Context, orgID string) ([]datastore.SnapCount, error) {
+	rows, err := db.QueryContext(ctx, inventoryDeviceSnapSQL, orgID)
+	if err != nil {
+		log.Printf("Error retrieving snap inventory: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []datastore.SnapCount{}
+	for rows.Next() {
+		item := datastore.SnapCount{}
+		err := rows.Scan(&item.Name, &item.Version, &item.Revision, &item.Channel, &item.Status, &item.Devices)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, item)
+	}
+
+	return counts, rows.Err()
+}
+
+// DeviceSnapDelete removes a snap for a device
+func (db *DataStore) DeviceSnapDelete(ctx context.Context, id int64) error {
+	_, err := db.ExecContext(ctx, deleteDeviceSnapSQL, id)
+	if err != nil {
+		log.Printf("Error updating the device snap: %v\n", err)
+	}
+
+	return err
+}
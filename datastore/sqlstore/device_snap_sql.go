@@ -17,28 +17,7 @@
  * along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
 
-package postgres
-
-const createDeviceSnapTableSQL = `
-CREATE TABLE IF NOT EXISTS device_snap (
-   id             serial primary key,
-   created        timestamp default current_timestamp,
-   modified       timestamp default current_timestamp,
-   device_id      int references device not null,
-   name           varchar(200) not null,
-   installed_size int default 0,
-   installed_date timestamp default current_timestamp,
-   status         varchar(200) default '',
-   channel        varchar(200) default '',
-   confinement    varchar(200) default '',
-   version        varchar(200) default '',
-   revision       int default 0,
-   devmode        bool default false,
-   config         text default ''
-)
-`
-
-const createDeviceSnapIndexSQL = "CREATE UNIQUE INDEX IF NOT EXISTS device_snap_idx ON device_snap (device_id, name)"
+package sqlstore
 
 const upsertDeviceSnapSQL = `
 INSERT INTO device_snap(device_id, name, installed_size, installed_date, status, channel, confinement, version, revision, devmode, config)
@@ -65,5 +44,24 @@ from device_snap
 where device_id=$1
 order by name`
 
+const listOrgDeviceSnapSQL = `
+select s.id, s.created, s.modified, s.device_id, s.name, s.installed_size, s.installed_date, s.status, s.channel, s.confinement, s.version, s.revision, s.devmode, s.config
+from device_snap s
+inner join device d on d.id=s.device_id
+where d.org_id=$1
+order by s.device_id, s.name`
+
+const inventoryDeviceSnapSQL = `
+select s.name, s.version, s.revision, s.channel, s.status, count(*)
+from device_snap s
+inner join device d on d.id=s.device_id
+where d.org_id=$1
+group by s.name, s.version, s.revision, s.channel, s.status
+order by s.name, s.version, s.revision, s.channel, s.status`
+
 const deleteDeviceSnapSQL = `
 delete from device_snap where device_id=$1`
+
+const listDeviceSnapNameSQL = "select name from device_snap where device_id=$1"
+
+const deleteDeviceSnapNameSQL = "delete from device_snap where device_id=$1 and name=$2"
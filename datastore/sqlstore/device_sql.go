@@ -17,40 +17,30 @@
  * along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
 
-package postgres
-
-const createDeviceTableSQL = `
-CREATE TABLE IF NOT EXISTS device (
-   id             serial primary key,
-   created        timestamp default current_timestamp,
-   lastrefresh    timestamp default current_timestamp,
-   org_id         varchar(200) not null,
-   device_id      varchar(200) unique not null,
-   brand          varchar(200) not null,
-   model          varchar(200) not null,
-   serial         varchar(200) not null,
-   store_id       varchar(200) not null,
-   device_key     text,
-   active         bool default true
-)
-`
+package sqlstore
 
 const createDeviceSQL = `
-insert into device (org_id, device_id, brand, model, serial, store_id, device_key)
-values ($1,$2,$3,$4,$5,$6,$7) RETURNING id`
+insert into device (org_id, device_id, brand, model, serial, store_id, device_key, created, lastrefresh)
+values ($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING id`
 
 const getDeviceSQL = `
 select id, created, lastrefresh, org_id, device_id, brand, model, serial, store_id, device_key, active
 from device
 where device_id=$1`
 
-const listDeviceSQL = `
-select id, created, lastrefresh, org_id, device_id, brand, model, serial, store_id, device_key, active
-from device
-where org_id=$1
-order by brand, model, serial`
+const listDeviceBaseSQL = `
+select d.id, d.created, d.lastrefresh, d.org_id, d.device_id, d.brand, d.model, d.serial, d.store_id, d.device_key, d.active
+from device d`
 
 const pingDeviceSQL = `
 update device
 set lastrefresh=$2
 where device_id=$1`
+
+// pingDeviceBatchSQL updates the last refresh of several devices from a list of
+// (device_id, refresh) values e.g. values ($1,$2),($3,$4)
+const pingDeviceBatchSQL = `
+update device
+set lastrefresh=v.column2
+from (values %s) as v
+where device.device_id=v.column1`
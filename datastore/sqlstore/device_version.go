@@ -0,0 +1,82 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlstore
+
+import (
+	"context"
+	"github.com/canonical/iot-devicetwin/datastore"
+	"log"
+)
+
+// DeviceVersionGet fetches a device version details from the database
+func (db *DataStore) DeviceVersionGet(ctx context.Context, deviceID int64) (datastore.DeviceVersion, error) {
+	item := datastore.DeviceVersion{}
+	row := db.QueryRowContext(ctx, getDeviceVersionSQL, deviceID)
+	err := row.Scan(&item.ID, &item.DeviceID, &item.Version, &item.Series, &item.OSID, &item.OSVersionID, &item.OnClassic, &item.KernelVersion)
+	if err != nil {
+		log.Printf("Error retrieving device version: %v\n", err)
+		return item, notFound(err, "device version with device ID `%d` not found", deviceID)
+	}
+	return item, nil
+}
+
+// DeviceVersionListForOrg lists the device version details of the devices of an organization
+func (db *DataStore) DeviceVersionListForOrg(ctx context.Context, orgID string) ([]datastore.DeviceVersion, error) {
+	rows, err := db.QueryContext(ctx, listOrgDeviceVersionSQL, orgID)
+	if err != nil {
+		log.Printf("Error retrieving device versions: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []datastore.DeviceVersion{}
+	for rows.Next() {
+		item := datastore.DeviceVersion{}
+		err := rows.Scan(&item.ID, &item.DeviceID, &item.Version, &item.Series, &item.OSID, &item.OSVersionID, &item.OnClassic, &item.KernelVersion)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, item)
+	}
+
+	return versions, rows.Err()
+}
+
+// DeviceVersionUpsert creates or updates a device version record
+func (db *DataStore) DeviceVersionUpsert(ctx context.Context, dv datastore.DeviceVersion) error {
+	var id int64
+	err := db.QueryRowContext(ctx, upsertDeviceVersionSQL, dv.DeviceID, dv.Version, dv.Series, dv.OSID, dv.OSVersionID, dv.OnClassic, dv.KernelVersion).Scan(&id)
+	if err != nil {
+		log.Printf("Error creating device version: %v\n", err)
+	}
+
+	return err
+}
+
+// DeviceVersionDelete removes a device version
+func (db *DataStore) DeviceVersionDelete(ctx context.Context, id int64) error {
+	result, err := db.ExecContext(ctx, deleteDeviceVersionSQL, id)
+	if err != nil {
+		log.Printf("Error deleting the device version: %v\n", err)
+		return err
+	}
+
+	return affected(result, "cannot find device version with ID %d", id)
+}
@@ -17,26 +17,20 @@
  * along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
 
-package postgres
-
-const createDeviceVersionTableSQL = `
-CREATE TABLE IF NOT EXISTS device_version (
-   id             serial primary key,
-   device_id      int references device not null unique,
-   version        varchar(200) not null,
-   series         varchar(200) default '',
-   os_id          varchar(200) default '',
-   os_version_id  varchar(200) default '',
-   on_classic     bool default false,
-   kernel_version varchar(200) default ''
-)
-`
+package sqlstore
 
 const getDeviceVersionSQL = `
 select id, device_id, version, series, os_id, os_version_id, on_classic, kernel_version
 from device_version
 where device_id=$1`
 
+const listOrgDeviceVersionSQL = `
+select v.id, v.device_id, v.version, v.series, v.os_id, v.os_version_id, v.on_classic, v.kernel_version
+from device_version v
+inner join device d on d.id=v.device_id
+where d.org_id=$1
+order by v.device_id`
+
 const upsertDeviceVersionSQL = `
 INSERT INTO device_version (device_id, version, series, os_id, os_version_id, on_classic, kernel_version)
 VALUES($1,$2,$3,$4,$5,$6,$7)
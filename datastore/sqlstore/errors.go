@@ -0,0 +1,55 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/canonical/iot-devicetwin/datastore"
+)
+
+// notFound converts a query that returns no rows to a not found error
+func notFound(err error, format string, a ...interface{}) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return datastore.NotFound(format, a...)
+	}
+	return err
+}
+
+// conflict converts a duplicate key to a conflict error
+func (db *DataStore) conflict(err error, format string, a ...interface{}) error {
+	if err != nil && db.dialect.IsDuplicate != nil && db.dialect.IsDuplicate(err) {
+		return datastore.Conflict(format, a...)
+	}
+	return err
+}
+
+// affected checks that a statement changed a record, returning a not found error if it did not
+func affected(result sql.Result, format string, a ...interface{}) error {
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return datastore.NotFound(format, a...)
+	}
+	return nil
+}
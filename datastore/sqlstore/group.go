@@ -0,0 +1,281 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/canonical/iot-devicetwin/datastore"
+	"log"
+)
+
+// GroupCreate creates a new group for an organization
+func (db *DataStore) GroupCreate(ctx context.Context, grp datastore.Group) (int64, error) {
+	rule, err := encodeGroupRule(grp.Rule)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = db.QueryRowContext(ctx, createOrgGroupSQL, grp.OrganisationID, grp.Name, grp.Description, parentID(grp.ParentID), rule).Scan(&id)
+	if err != nil {
+		log.Printf("Error creating group %s/%s: %v\n", grp.OrganisationID, grp.Name, err)
+		return id, db.conflict(err, "group `%s` already exists for organization `%s`", grp.Name, grp.OrganisationID)
+	}
+
+	return id, nil
+}
+
+// GroupUpdate updates the name, description, parent and rule of a group
+func (db *DataStore) GroupUpdate(ctx context.Context, grp datastore.Group) error {
+	rule, err := encodeGroupRule(grp.Rule)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.ExecContext(ctx, updateOrgGroupSQL, grp.ID, grp.Name, grp.Description, parentID(grp.ParentID), rule)
+	if err != nil {
+		log.Printf("Error updating group %s/%s: %v\n", grp.OrganisationID, grp.Name, err)
+		return db.conflict(err, "group `%s` already exists for organization `%s`", grp.Name, grp.OrganisationID)
+	}
+	return affected(result, "error cannot find group with ID %d", grp.ID)
+}
+
+// GroupDelete deletes a group and its device links. The child groups are moved to the parent of the group
+func (db *DataStore) GroupDelete(ctx context.Context, orgID, name string) error {
+	grp, err := db.GroupGet(ctx, orgID, name)
+	if err != nil {
+		return fmt.Errorf("error finding group: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range []struct {
+		sql  string
+		args []interface{}
+	}{
+		{deleteGroupLinksSQL, []interface{}{grp.ID}},
+		{reparentOrgGroupSQL, []interface{}{grp.ID, parentID(grp.ParentID)}},
+		{deleteOrgGroupSQL, []interface{}{grp.ID}},
+	} {
+		if _, err := tx.ExecContext(ctx, db.rebind(stmt.sql), stmt.args...); err != nil {
+			_ = tx.Rollback()
+			log.Printf("Error deleting group %s/%s: %v\n", orgID, name, err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// parentID converts the ID of a parent group, using null for a top-level group
+func parentID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id > 0}
+}
+
+// encodeGroupRule serializes the rule of a group, using an empty string for static groups
+func encodeGroupRule(rule datastore.GroupRule) (string, error) {
+	if !rule.Dynamic() {
+		return "", nil
+	}
+	b, err := json.Marshal(rule)
+	return string(b), err
+}
+
+// scanGroup reads a group record, decoding its rule
+func scanGroup(row interface{ Scan(...interface{}) error }) (datastore.Group, error) {
+	item := datastore.Group{}
+	var rule string
+	err := row.Scan(&item.ID, &item.Created, &item.Modified, &item.OrganisationID, &item.Name, &item.Description, &item.ParentID, &rule)
+	if err != nil {
+		return item, err
+	}
+	if len(rule) > 0 {
+		err = json.Unmarshal([]byte(rule), &item.Rule)
+	}
+	return item, err
+}
+
+// GroupList lists the groups for an organization
+func (db *DataStore) GroupList(ctx context.Context, orgID string) ([]datastore.Group, error) {
+	rows, err := db.QueryContext(ctx, listOrgGroupSQL, orgID)
+	if err != nil {
+		log.Printf("Error retrieving groups: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []datastore.Group{}
+	for rows.Next() {
+		item, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, item)
+	}
+
+	return groups, nil
+}
+
+// GroupGet fetches a group
+func (db *DataStore) GroupGet(ctx context.Context, orgID, name string) (datastore.Group, error) {
+	item, err := scanGroup(db.QueryRowContext(ctx, getOrgGroupSQL, orgID, name))
+	if err != nil {
+		log.Printf("Error retrieving group `%s`: %v\n", name, err)
+		return item, notFound(err, "error cannot find group `%s`", name)
+	}
+	return item, nil
+}
+
+// GroupLinkDevice links a device to a group
+func (db *DataStore) GroupLinkDevice(ctx context.Context, orgID, name, deviceID string) error {
+	// Get the group record
+	grp, err := db.GroupGet(ctx, orgID, name)
+	if err != nil {
+		return fmt.Errorf("error finding group: %w", err)
+	}
+
+	// Get the device
+	device, err := db.DeviceGet(ctx, deviceID)
+	if err != nil {
+		return fmt.Errorf("error finding device: %w", err)
+	}
+	if device.OrganisationID != orgID {
+		return datastore.NotFound("error finding device: cannot find device `%s`", deviceID)
+	}
+
+	// Create the group link record, unless the device is already linked
+	_, err = db.ExecContext(ctx, createGroupDeviceLinkSQL, orgID, grp.ID, device.ID)
+	return err
+}
+
+// GroupUnlinkDevice unlinks a device from a group
+func (db *DataStore) GroupUnlinkDevice(ctx context.Context, orgID, name, deviceID string) error {
+	// Get the group record
+	grp, err := db.GroupGet(ctx, orgID, name)
+	if err != nil {
+		return fmt.Errorf("error finding group: %w", err)
+	}
+
+	// Get the device
+	device, err := db.DeviceGet(ctx, deviceID)
+	if err != nil {
+		return fmt.Errorf("error finding device: %w", err)
+	}
+	if device.OrganisationID != orgID {
+		return datastore.NotFound("error finding device: cannot find device `%s`", deviceID)
+	}
+
+	// Delete the group link record
+	_, err = db.ExecContext(ctx, deleteGroupDeviceLinkSQL, grp.ID, device.ID)
+	return err
+}
+
+// GroupLinkList lists the devices that are linked to the static groups of an organization,
+// without the devices that are members through a child group
+func (db *DataStore) GroupLinkList(ctx context.Context, orgID string) ([]datastore.GroupDeviceLink, error) {
+	rows, err := db.QueryContext(ctx, listGroupDeviceLinkSQL, orgID)
+	if err != nil {
+		log.Printf("Error retrieving group links: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []datastore.GroupDeviceLink{}
+	for rows.Next() {
+		item := datastore.GroupDeviceLink{}
+		if err := rows.Scan(&item.ID, &item.Created, &item.OrganisationID, &item.GroupID, &item.DeviceID); err != nil {
+			return nil, err
+		}
+		links = append(links, item)
+	}
+
+	return links, rows.Err()
+}
+
+// GroupGetDevices retrieves the devices for a group
+func (db *DataStore) GroupGetDevices(ctx context.Context, orgID, name string, query datastore.DeviceQuery) ([]datastore.Device, error) {
+	// Get the group record
+	grp, err := db.GroupGet(ctx, orgID, name)
+	if err != nil {
+		return nil, fmt.Errorf("error finding group: %w", err)
+	}
+
+	member, err := db.membership(ctx, grp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the devices for the group
+	stmt, args, err := db.buildDeviceQuery(orgID, query, member)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := db.listDevices(ctx, stmt, args...)
+	if err != nil {
+		log.Printf("Error retrieving devices for group: %v\n", err)
+	}
+	return devices, err
+}
+
+// GroupGetExcludedDevices retrieves the devices not in a group
+func (db *DataStore) GroupGetExcludedDevices(ctx context.Context, orgID, name string, query datastore.DeviceQuery) ([]datastore.Device, error) {
+	// Get the group record
+	grp, err := db.GroupGet(ctx, orgID, name)
+	if err != nil {
+		return nil, fmt.Errorf("error finding group: %w", err)
+	}
+
+	member, err := db.membership(ctx, grp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the devices of the organization that are not in the group
+	stmt, args, err := db.buildDeviceQuery(orgID, query, func(q *deviceQuery) string {
+		return "not " + member(q)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := db.listDevices(ctx, stmt, args...)
+	if err != nil {
+		log.Printf("Error retrieving devices for group: %v\n", err)
+	}
+	return devices, err
+}
+
+// membership generates the condition for a device to be a member of a group,
+// including the members of its child groups
+func (db *DataStore) membership(ctx context.Context, grp datastore.Group) (func(q *deviceQuery) string, error) {
+	groups, err := db.GroupList(ctx, grp.OrganisationID)
+	if err != nil {
+		return nil, fmt.Errorf("error finding groups: %w", err)
+	}
+
+	return memberCondition(datastore.GroupSubtree(groups, grp.ID))
+}
@@ -0,0 +1,61 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlstore
+
+const createOrgGroupSQL = `
+insert into org_group (org_id, name, description, parent_id, rule)
+values ($1,$2,$3,$4,$5) RETURNING id`
+
+const updateOrgGroupSQL = `
+update org_group
+set name=$2, description=$3, parent_id=$4, rule=$5, modified=current_timestamp
+where id=$1`
+
+const deleteOrgGroupSQL = "delete from org_group where id=$1"
+
+const reparentOrgGroupSQL = "update org_group set parent_id=$2, modified=current_timestamp where parent_id=$1"
+
+const deleteGroupLinksSQL = "delete from group_device_link where group_id=$1"
+
+const listOrgGroupSQL = `
+select id, created, modified, org_id, name, description, coalesce(parent_id, 0), rule
+from org_group
+where org_id=$1
+order by name`
+
+const getOrgGroupSQL = `
+select id, created, modified, org_id, name, description, coalesce(parent_id, 0), rule
+from org_group
+where org_id=$1 and name=$2`
+
+const createGroupDeviceLinkSQL = `
+insert into group_device_link (org_id, group_id, device_id)
+values ($1,$2,$3)
+on conflict (group_id, device_id) do nothing`
+
+const deleteGroupDeviceLinkSQL = `delete from group_device_link where group_id=$1 and device_id=$2`
+
+const listGroupDeviceLinkSQL = `
+select id, created, org_id, group_id, device_id
+from group_device_link
+where org_id=$1
+order by group_id, device_id`
+
+const groupDeviceLinkConditionSQL = "exists (select 1 from group_device_link lnk where lnk.device_id=d.id and lnk.group_id in (%s))"
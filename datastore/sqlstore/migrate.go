@@ -0,0 +1,134 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// Migration is a versioned change to the database schema, with the statements to apply and revert it
+type Migration struct {
+	Version     int
+	Description string
+	Up          []string
+	Down        []string
+}
+
+// schema returns the migrations for the database
+func (db *DataStore) schema() []Migration {
+	return db.dialect.Migrations
+}
+
+// LatestSchemaVersion is the version of the schema expected by the data store
+func (db *DataStore) LatestSchemaVersion() int {
+	return len(db.schema())
+}
+
+// SchemaVersion returns the version of the database schema, which is zero for an empty database
+func (db *DataStore) SchemaVersion() (int, error) {
+	if _, err := db.Exec(createSchemaVersionTableSQL); err != nil {
+		return 0, fmt.Errorf("error creating the schema version table: %v", err)
+	}
+
+	var version int
+	err := db.QueryRow(getSchemaVersionSQL).Scan(&version)
+	return version, err
+}
+
+// Migrate upgrades the database schema to the latest version
+func (db *DataStore) Migrate() error {
+	return db.MigrateTo(db.LatestSchemaVersion())
+}
+
+// MigrateTo upgrades or downgrades the database schema to a version. The migrations run
+// in a single transaction, so the schema is left unchanged when any of them fails.
+func (db *DataStore) MigrateTo(target int) error {
+	if target < 0 || target > db.LatestSchemaVersion() {
+		return fmt.Errorf("invalid schema version %d, the latest is %d", target, db.LatestSchemaVersion())
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := db.migrateTx(tx, target); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateTx applies or reverts the migrations within a transaction
+func (db *DataStore) migrateTx(tx *sql.Tx, target int) error {
+	if _, err := tx.Exec(createSchemaVersionTableSQL); err != nil {
+		return fmt.Errorf("error creating the schema version table: %v", err)
+	}
+
+	if len(db.dialect.LockSchemaSQL) > 0 {
+		if _, err := tx.Exec(db.dialect.LockSchemaSQL); err != nil {
+			return fmt.Errorf("error locking the schema version table: %v", err)
+		}
+	}
+
+	var current int
+	if err := tx.QueryRow(getSchemaVersionSQL).Scan(&current); err != nil {
+		return fmt.Errorf("error reading the schema version: %v", err)
+	}
+	schema := db.schema()
+	if current > len(schema) {
+		return fmt.Errorf("the schema version %d is newer than the latest supported version %d", current, len(schema))
+	}
+
+	// Apply the migrations up to the target version
+	for i := current; i < target; i++ {
+		m := schema[i]
+		log.Printf("Applying schema migration %d: %s\n", m.Version, m.Description)
+		if err := execMigration(tx, m.Version, m.Up); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(db.rebind(createSchemaVersionSQL), m.Version, m.Description); err != nil {
+			return fmt.Errorf("error recording schema version %d: %v", m.Version, err)
+		}
+	}
+
+	// Revert the migrations down to the target version, newest first
+	for i := current - 1; i >= target; i-- {
+		m := schema[i]
+		log.Printf("Reverting schema migration %d: %s\n", m.Version, m.Description)
+		if err := execMigration(tx, m.Version, m.Down); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(db.rebind(deleteSchemaVersionSQL), m.Version); err != nil {
+			return fmt.Errorf("error removing schema version %d: %v", m.Version, err)
+		}
+	}
+	return nil
+}
+
+func execMigration(tx *sql.Tx, version int, statements []string) error {
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("error in schema migration %d: %v", version, err)
+		}
+	}
+	return nil
+}
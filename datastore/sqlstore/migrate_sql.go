@@ -0,0 +1,33 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlstore
+
+const createSchemaVersionTableSQL = `
+CREATE TABLE IF NOT EXISTS schema_version (
+   version        int primary key,
+   description    varchar(200) not null,
+   applied        timestamp default current_timestamp
+)`
+
+const getSchemaVersionSQL = "select coalesce(max(version), 0) from schema_version"
+
+const createSchemaVersionSQL = "insert into schema_version (version, description) values ($1,$2)"
+
+const deleteSchemaVersionSQL = "delete from schema_version where version=$1"
@@ -0,0 +1,98 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlstore
+
+import (
+	"context"
+	"github.com/canonical/iot-devicetwin/datastore"
+	"log"
+)
+
+// SecretList lists the stored values of the sensitive fields
+func (db *DataStore) SecretList(ctx context.Context) ([]datastore.Secret, error) {
+	secrets := []datastore.Secret{}
+
+	rows, err := db.QueryContext(ctx, listDeviceKeySecretSQL)
+	if err != nil {
+		log.Printf("Error retrieving device keys: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		s := datastore.Secret{Kind: datastore.SecretDeviceKey}
+		if err := rows.Scan(&s.ID, &s.Owner, &s.Value); err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	snapRows, err := db.QueryContext(ctx, listSnapConfigSecretSQL)
+	if err != nil {
+		log.Printf("Error retrieving snap configs: %v\n", err)
+		return nil, err
+	}
+	defer snapRows.Close()
+	for snapRows.Next() {
+		var (
+			deviceID int64
+			name     string
+		)
+		s := datastore.Secret{Kind: datastore.SecretSnapConfig}
+		if err := snapRows.Scan(&s.ID, &deviceID, &name, &s.Value); err != nil {
+			return nil, err
+		}
+		s.Owner = datastore.SnapConfigOwner(deviceID, name)
+		secrets = append(secrets, s)
+	}
+
+	return secrets, snapRows.Err()
+}
+
+// SecretUpdate stores new values of sensitive fields in a single transaction
+func (db *DataStore) SecretUpdate(ctx context.Context, secrets []datastore.Secret) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range secrets {
+		var stmt string
+		switch s.Kind {
+		case datastore.SecretDeviceKey:
+			stmt = updateDeviceKeySecretSQL
+		case datastore.SecretSnapConfig:
+			stmt = updateSnapConfigSecretSQL
+		default:
+			_ = tx.Rollback()
+			return datastore.Invalid("invalid secret kind `%s`", s.Kind)
+		}
+
+		if _, err := tx.ExecContext(ctx, db.rebind(stmt), s.ID, s.Value); err != nil {
+			_ = tx.Rollback()
+			log.Printf("Error updating the %s secret %d: %v\n", s.Kind, s.ID, err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
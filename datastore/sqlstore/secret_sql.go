@@ -0,0 +1,28 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlstore
+
+const listDeviceKeySecretSQL = "select id, device_id, device_key from device where coalesce(device_key, '')<>''"
+
+const listSnapConfigSecretSQL = "select id, device_id, name, config from device_snap where coalesce(config, '')<>''"
+
+const updateDeviceKeySecretSQL = "update device set device_key=$2 where id=$1"
+
+const updateSnapConfigSecretSQL = "update device_snap set config=$2 where id=$1"
@@ -0,0 +1,127 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Dialect holds the differences between the databases that share the SQL data store. The
+// statements are written for postgreSQL, with `$N` parameters
+type Dialect struct {
+	// Driver is the name of the database/sql driver
+	Driver string
+	// Migrations are the ordered changes to the database schema
+	Migrations []Migration
+	// Configure sets up a new database connection, when it is needed
+	Configure func(db *sql.DB) error
+	// Rebind converts the parameters of a statement, when the database does not use `$N`
+	Rebind func(query string) string
+	// UTC stores the timestamps in UTC, for databases that store them as text
+	UTC bool
+	// TimestampCast gives a type to the timestamp parameters that are not typed by their column
+	TimestampCast string
+	// IsDuplicate checks whether an error is a duplicate key
+	IsDuplicate func(err error) bool
+	// LockSchemaSQL stops other instances migrating the schema at the same time, when the
+	// transactions do not have exclusive access already
+	LockSchemaSQL string
+}
+
+// DataStore is the SQL implementation of a data store, for postgreSQL and SQLite databases
+type DataStore struct {
+	dialect Dialect
+	*sql.DB
+}
+
+// OpenDataStore returns a new database connection, with the latest schema. Each call opens
+// its own connection, so stores of different dialects and data sources can be open together
+func OpenDataStore(dialect Dialect, dataSource string) *DataStore {
+	// Open the database
+	db, err := Open(dialect, dataSource)
+	if err != nil {
+		log.Fatalf("Error opening the database: %v\n", err)
+	}
+
+	// Upgrade the schema to the latest version
+	if err := db.Migrate(); err != nil {
+		log.Fatalf("Error migrating the database schema: %v\n", err)
+	}
+	return db
+}
+
+// Open returns an open database connection, without migrating the schema
+func Open(dialect Dialect, dataSource string) (*DataStore, error) {
+	// Open the database connection
+	db, err := sql.Open(dialect.Driver, dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check that we have a valid database connection
+	err = db.Ping()
+	if err != nil {
+		return nil, fmt.Errorf("error accessing the database: %v", err)
+	}
+
+	if dialect.Configure != nil {
+		if err := dialect.Configure(db); err != nil {
+			return nil, err
+		}
+	}
+
+	return &DataStore{dialect, db}, nil
+}
+
+// rebind converts the parameters of a statement for the database.
+// Statements run in a transaction must be converted by the caller.
+func (db *DataStore) rebind(query string) string {
+	if db.dialect.Rebind != nil {
+		return db.dialect.Rebind(query)
+	}
+	return query
+}
+
+// ExecContext executes a statement, converting its parameters for the database
+func (db *DataStore) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.ExecContext(ctx, db.rebind(query), args...)
+}
+
+// QueryContext runs a query that returns rows, converting its parameters for the database
+func (db *DataStore) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.QueryContext(ctx, db.rebind(query), args...)
+}
+
+// QueryRowContext runs a query that returns a single row, converting its parameters for the database
+func (db *DataStore) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRowContext(ctx, db.rebind(query), args...)
+}
+
+// timeArg converts a timestamp parameter for the database
+func (db *DataStore) timeArg(t time.Time) time.Time {
+	if db.dialect.UTC {
+		return t.UTC()
+	}
+	return t
+}
@@ -129,3 +129,11 @@ type Action struct {
 	Status         string    `json:"status"`
 	Message        string    `json:"message"`
 }
+
+// ActionQuery holds the time range and limit for an action log listing. The range includes
+// From and excludes To, and a zero time leaves that end of the range open
+type ActionQuery struct {
+	From  time.Time
+	To    time.Time
+	Limit int
+}
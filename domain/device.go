@@ -30,14 +30,36 @@ type Health struct {
 
 // Device holds the details of a device
 type Device struct {
-	OrganizationID string        `json:"orgId"`
-	DeviceID       string        `json:"deviceId"`
-	Brand          string        `json:"brand"`
-	Model          string        `json:"model"`
-	SerialNumber   string        `json:"serial"`
-	StoreID        string        `json:"store"`
-	DeviceKey      string        `json:"deviceKey"`
-	Version        DeviceVersion `json:"version"`
-	Created        time.Time     `json:"created"`
-	LastRefresh    time.Time     `json:"lastRefresh"`
+	OrganizationID string            `json:"orgId"`
+	DeviceID       string            `json:"deviceId"`
+	Brand          string            `json:"brand"`
+	Model          string            `json:"model"`
+	SerialNumber   string            `json:"serial"`
+	StoreID        string            `json:"store"`
+	DeviceKey      string            `json:"deviceKey,omitempty"`
+	Version        DeviceVersion     `json:"version"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Created        time.Time         `json:"created"`
+	LastRefresh    time.Time         `json:"lastRefresh"`
+}
+
+// DeviceQuery holds the filters, sort order and page for a device listing
+type DeviceQuery struct {
+	Brand         string
+	Model         string
+	Series        string
+	Snap          string
+	SnapRevision  int
+	Group         string
+	Presence      string
+	LabelSelector string
+	Sort          string
+	Cursor        string
+	Limit         int
+}
+
+// DevicePage is a page of devices from a listing, with the cursor for the next page
+type DevicePage struct {
+	Devices []Device `json:"devices"`
+	Next    string   `json:"next,omitempty"`
 }
@@ -21,6 +21,19 @@ package domain
 
 // Group is the definition of a group of devices
 type Group struct {
-	OrganizationID string `json:"orgid"`
-	Name           string `json:"name"`
+	OrganizationID string     `json:"orgid"`
+	Name           string     `json:"name"`
+	Description    string     `json:"description,omitempty"`
+	Parent         string     `json:"parent,omitempty"`
+	Rule           *GroupRule `json:"rule,omitempty"`
+}
+
+// GroupRule is the membership rule of a dynamic group. The devices that match all
+// the criteria are the members of the group, rather than the devices linked to it
+type GroupRule struct {
+	Brand         string `json:"brand,omitempty"`
+	Model         string `json:"model,omitempty"`
+	Series        string `json:"series,omitempty"`
+	Snap          string `json:"snap,omitempty"`
+	LabelSelector string `json:"labelSelector,omitempty"`
 }
@@ -0,0 +1,29 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package domain
+
+// SnapInventory is the distribution of an installed snap across the devices of an organization
+type SnapInventory struct {
+	Name      string         `json:"name"`
+	Devices   int            `json:"devices"`
+	Versions  map[string]int `json:"versions"`
+	Revisions map[int]int    `json:"revisions"`
+	Channels  map[string]int `json:"channels"`
+	Statuses  map[string]int `json:"statuses"`
+}
@@ -0,0 +1,71 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package domain
+
+import "time"
+
+// Stats holds the statistics of the running service
+type Stats struct {
+	Cache    CacheStats   `json:"cache"`
+	MQTT     MQTTStats    `json:"mqtt"`
+	Messages MessageStats `json:"messages"`
+}
+
+// CacheStats holds the statistics of the device twin caches
+type CacheStats struct {
+	Devices  CacheCounts `json:"devices"`
+	Versions CacheCounts `json:"versions"`
+}
+
+// CacheCounts holds the size of a cache and the counts of its lookups
+type CacheCounts struct {
+	Size      int    `json:"size"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// MQTTStats holds the state of the connection to the MQTT broker
+type MQTTStats struct {
+	Connected       bool      `json:"connected"`
+	Connects        uint64    `json:"connects"`
+	Reconnects      uint64    `json:"reconnects"`
+	ConnectionsLost uint64    `json:"connectionsLost"`
+	Subscriptions   int       `json:"subscriptions"`
+	PublishErrors   uint64    `json:"publishErrors"`
+	LastConnected   time.Time `json:"lastConnected"`
+	LastLost        time.Time `json:"lastLost"`
+	LastError       string    `json:"lastError,omitempty"`
+}
+
+// MessageStats holds the state of the workers that handle the messages from the devices. The
+// wait is the time a message is queued before a worker takes it, and the latency the time to
+// handle it, in milliseconds
+type MessageStats struct {
+	Workers      int     `json:"workers"`
+	QueueSize    int     `json:"queueSize"`
+	Queued       int     `json:"queued"`
+	Processed    uint64  `json:"processed"`
+	Blocked      uint64  `json:"blocked"`
+	Dropped      uint64  `json:"dropped"`
+	AvgWaitMS    float64 `json:"avgWaitMs"`
+	AvgLatencyMS float64 `json:"avgLatencyMs"`
+	MaxLatencyMS float64 `json:"maxLatencyMs"`
+}
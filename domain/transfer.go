@@ -0,0 +1,57 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package domain
+
+import "time"
+
+// ExportVersion is the version of the export format. It is increased when a change to the
+// format would stop an older release from importing the data correctly
+const ExportVersion = 1
+
+// Export holds the records of an organization, to copy them to another data store
+type Export struct {
+	Version        int            `json:"version"`
+	OrganizationID string         `json:"orgId"`
+	Exported       time.Time      `json:"exported"`
+	Devices        []ExportDevice `json:"devices"`
+	Groups         []Group        `json:"groups"`
+	Links          []GroupLink    `json:"links"`
+	Actions        []Action       `json:"actions"`
+}
+
+// ExportDevice is a device with its OS details, labels and installed snaps
+type ExportDevice struct {
+	Device
+	Snaps []DeviceSnap `json:"snaps,omitempty"`
+}
+
+// GroupLink is a device linked to a static group
+type GroupLink struct {
+	Group    string `json:"group"`
+	DeviceID string `json:"deviceId"`
+}
+
+// ImportSummary is the number of records imported for an organization
+type ImportSummary struct {
+	Devices int `json:"devices"`
+	Groups  int `json:"groups"`
+	Links   int `json:"links"`
+	Actions int `json:"actions"`
+}
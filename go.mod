@@ -1,13 +1,16 @@
 module github.com/canonical/iot-devicetwin
 
-go 1.13
+// The MQTT v5 client, paho.golang, needs go 1.15 or later and the SQLite driver needs go 1.16
+go 1.16
 
 require (
 	github.com/alexkohler/nakedret v1.0.0 // indirect
 	github.com/canonical/iot-identity v0.0.0-20210408072605-83f114f75fbe
+	github.com/eclipse/paho.golang v0.11.0
 	github.com/eclipse/paho.mqtt.golang v1.2.0
 	github.com/gorilla/mux v1.8.0
 	github.com/lib/pq v1.10.0
+	github.com/mattn/go-sqlite3 v1.14.17
 	github.com/segmentio/ksuid v1.0.3
 	github.com/stretchr/testify v1.7.0
 	golang.org/x/net v0.0.0-20201021035429-f5854403a974 // indirect
@@ -19,9 +19,12 @@
 
 package controller
 
-import "github.com/canonical/iot-devicetwin/domain"
+import (
+	"context"
+	"github.com/canonical/iot-devicetwin/domain"
+)
 
 // ActionList gets the action log for a device
-func (srv *Service) ActionList(orgID, clientID string) ([]domain.Action, error) {
-	return srv.DeviceTwin.ActionList(orgID, clientID)
+func (srv *Service) ActionList(ctx context.Context, orgID, clientID string, query domain.ActionQuery) ([]domain.Action, error) {
+	return srv.DeviceTwin.ActionList(ctx, orgID, clientID, query)
 }
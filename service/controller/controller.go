@@ -20,6 +20,7 @@
 package controller
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/canonical/iot-devicetwin/config"
@@ -29,34 +30,43 @@ import (
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 	"github.com/segmentio/ksuid"
 	"log"
-	"strings"
 )
 
 // Controller interface for the service
 type Controller interface {
 	// MQTT handlers
-	HealthHandler(client MQTT.Client, msg MQTT.Message)
-	ActionHandler(client MQTT.Client, msg MQTT.Message)
+	HealthHandler(cclient MQTT.Client, msg MQTT.Message)
+	ActionHandler(cclient MQTT.Client, msg MQTT.Message)
 
 	// Passthrough to the device twin service
-	DeviceSnaps(orgID, clientID string) ([]domain.DeviceSnap, error)
-	DeviceList(orgID string) ([]domain.Device, error)
-	DeviceGet(orgID, clientID string) (domain.Device, error)
-	GroupCreate(orgID, name string) error
-	GroupList(orgID string) ([]domain.Group, error)
-	GroupGet(orgID, name string) (domain.Group, error)
-	GroupLinkDevice(orgID, name, clientID string) error
-	GroupUnlinkDevice(orgID, name, clientID string) error
-	GroupGetDevices(orgID, name string) ([]domain.Device, error)
-	GroupGetExcludedDevices(orgID, name string) ([]domain.Device, error)
+	DeviceSnaps(ctx context.Context, orgID, clientID string) ([]domain.DeviceSnap, error)
+	SnapInventory(ctx context.Context, orgID string) ([]domain.SnapInventory, error)
+	DeviceList(ctx context.Context, orgID string, query domain.DeviceQuery) (domain.DevicePage, error)
+	DeviceGet(ctx context.Context, orgID, clientID string) (domain.Device, error)
+	DeviceLabelsSet(ctx context.Context, orgID, clientID string, labels map[string]string) error
+	DeviceLabelDelete(ctx context.Context, orgID, clientID, key string) error
+	GroupCreate(ctx context.Context, orgID string, group domain.Group) error
+	GroupList(ctx context.Context, orgID string) ([]domain.Group, error)
+	GroupGet(ctx context.Context, orgID, name string) (domain.Group, error)
+	GroupUpdate(ctx context.Context, orgID, name string, group domain.Group) error
+	GroupDelete(ctx context.Context, orgID, name string) error
+	GroupLinkDevice(ctx context.Context, orgID, name, clientID string) error
+	GroupUnlinkDevice(ctx context.Context, orgID, name, clientID string) error
+	GroupLinkDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (int, error)
+	GroupUnlinkDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (int, error)
+	GroupGetDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (domain.DevicePage, error)
+	GroupGetExcludedDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (domain.DevicePage, error)
+	Export(ctx context.Context, orgID string, w devicetwin.ExportWriter) error
+	Import(ctx context.Context, orgID string, data domain.Export) (domain.ImportSummary, error)
+	Stats() domain.Stats
 
 	// Actions on a device
-	DeviceSnapList(orgID, clientID string) error
-	DeviceSnapInstall(orgID, clientID, snap string) error
-	DeviceSnapRemove(orgID, clientID, snap string) error
-	DeviceSnapUpdate(orgID, clientID, snap, action string) error
-	DeviceSnapConf(orgID, clientID, snap, settings string) error
-	ActionList(orgID, clientID string) ([]domain.Action, error)
+	DeviceSnapList(ctx context.Context, orgID, clientID string) error
+	DeviceSnapInstall(ctx context.Context, orgID, clientID, snap string) error
+	DeviceSnapRemove(ctx context.Context, orgID, clientID, snap string) error
+	DeviceSnapUpdate(ctx context.Context, orgID, clientID, snap, action string) error
+	DeviceSnapConf(ctx context.Context, orgID, clientID, snap, settings string) error
+	ActionList(ctx context.Context, orgID, clientID string, query domain.ActionQuery) ([]domain.Action, error)
 }
 
 // Service implementation of the devicetwin service use cases
@@ -64,6 +74,8 @@ type Service struct {
 	Settings   *config.Settings
 	MQTT       mqtt.Connect
 	DeviceTwin devicetwin.DeviceTwin
+
+	workers *pool // nil to handle the messages in the callbacks of the MQTT client
 }
 
 // NewService creates an implementation of the devicetwin use cases
@@ -73,6 +85,9 @@ func NewService(settings *config.Settings, m mqtt.Connect, twin devicetwin.Devic
 		MQTT:       m,
 		DeviceTwin: twin,
 	}
+	if settings.Workers > 0 {
+		srv.workers = newPool(settings.Workers, settings.QueueSize)
+	}
 
 	// Setup the MQTT client and handle pub/sub from here... as the MQTT and DeviceTwin services are mutually dependent
 	// This service plugs them together
@@ -84,19 +99,17 @@ func NewService(settings *config.Settings, m mqtt.Connect, twin devicetwin.Devic
 
 // SubscribeToActions subscribes to the published topics from the devices
 func (srv *Service) SubscribeToActions() error {
-	const (
-		topicActions = "devices/pub/+"
-		topicHealth  = "devices/health/+"
-	)
+	topicHealth := srv.Settings.Topics.Health.SharedFilter(srv.Settings.ShareGroup)
+	topicActions := srv.Settings.Topics.Actions.SharedFilter(srv.Settings.ShareGroup)
 
 	// Subscribe to the device health messages
-	if err := srv.MQTT.Subscribe(topicHealth, srv.HealthHandler); err != nil {
+	if err := srv.MQTT.Subscribe(topicHealth, srv.queue(srv.HealthHandler)); err != nil {
 		log.Printf("Error subscribing to topic `%s`: %v", topicHealth, err)
 		return err
 	}
 
 	// Subscribe to the device action responses
-	if err := srv.MQTT.Subscribe(topicActions, srv.ActionHandler); err != nil {
+	if err := srv.MQTT.Subscribe(topicActions, srv.queue(srv.ActionHandler)); err != nil {
 		log.Printf("Error subscribing to topic `%s`: %v", topicActions, err)
 		return err
 	}
@@ -104,9 +117,41 @@ func (srv *Service) SubscribeToActions() error {
 	return nil
 }
 
+// queue wraps a message handler to run it on the workers, in order for each device
+func (srv *Service) queue(handler MQTT.MessageHandler) MQTT.MessageHandler {
+	if srv.workers == nil {
+		return handler
+	}
+	return func(client MQTT.Client, msg MQTT.Message) {
+		srv.workers.submit(srv.deviceID(msg), task{handler: handler, client: client, msg: msg})
+	}
+}
+
+// deviceID gets the device ID from the topic of a message, or the topic when it does not
+// match the templates, so the health messages and action responses of a device share a worker
+func (srv *Service) deviceID(msg MQTT.Message) string {
+	for _, topic := range []config.Topic{srv.Settings.Topics.Health, srv.Settings.Topics.Actions} {
+		if _, deviceID, ok := topic.Match(msg.Topic()); ok {
+			return deviceID
+		}
+	}
+	return msg.Topic()
+}
+
+// Close waits for the workers to handle the queued messages. The MQTT connection must be closed
+// first, so no more messages are queued
+func (srv *Service) Close() {
+	if srv.workers != nil {
+		srv.workers.close()
+	}
+}
+
 // ActionHandler is the handler for the main subscription topic
 func (srv *Service) ActionHandler(client MQTT.Client, msg MQTT.Message) {
-	clientID := getClientID(msg)
+	_, clientID, ok := getClientID(srv.Settings.Topics.Actions, msg)
+	if !ok {
+		return
+	}
 	log.Printf("Action response from %s", clientID)
 
 	// Parse the body
@@ -116,6 +161,15 @@ func (srv *Service) ActionHandler(client MQTT.Client, msg MQTT.Message) {
 		return
 	}
 
+	// With MQTT v5, the correlation data identifies the action, so a device can omit the ID
+	if props := mqtt.MessageProperties(msg); len(props.CorrelationID) > 0 {
+		if len(a.ID) > 0 && a.ID != props.CorrelationID {
+			log.Printf("Action ID mismatch for %s: %s and %s", clientID, a.ID, props.CorrelationID)
+			return
+		}
+		a.ID = props.CorrelationID
+	}
+
 	// Check if there is an error and handle it
 	if !a.Success {
 		log.Printf("Error in action `%s`: (%s) %s", a.Action, a.ID, a.Message)
@@ -123,14 +177,19 @@ func (srv *Service) ActionHandler(client MQTT.Client, msg MQTT.Message) {
 	}
 
 	// Handle the action
-	if err := srv.DeviceTwin.ActionResponse(clientID, a.ID, a.Action, msg.Payload()); err != nil {
+	ctx, cancel := srv.messageContext()
+	defer cancel()
+	if err := srv.DeviceTwin.ActionResponse(ctx, clientID, a.ID, a.Action, msg.Payload()); err != nil {
 		log.Printf("Error with action `%s`: %v", a.Action, err)
 	}
 }
 
 // HealthHandler is the handler for the devices health messages
 func (srv *Service) HealthHandler(client MQTT.Client, msg MQTT.Message) {
-	clientID := getClientID(msg)
+	orgID, clientID, ok := getClientID(srv.Settings.Topics.Health, msg)
+	if !ok {
+		return
+	}
 	log.Printf("Health update from %s", clientID)
 
 	// Parse the body
@@ -146,8 +205,22 @@ func (srv *Service) HealthHandler(client MQTT.Client, msg MQTT.Message) {
 		return
 	}
 
+	// Check that the organization ID matches, when it is in the topic
+	if srv.Settings.Topics.Health.HasOrg() && orgID != h.OrganizationID {
+		log.Printf("Organization ID mismatch for %s: %s and %s", clientID, orgID, h.OrganizationID)
+		return
+	}
+
+	// Check that the organization ID matches, when it is a property of the message
+	if props := mqtt.MessageProperties(msg); len(props.OrgID) > 0 && props.OrgID != h.OrganizationID {
+		log.Printf("Organization ID mismatch for %s: %s and %s", clientID, props.OrgID, h.OrganizationID)
+		return
+	}
+
 	// Update the device record
-	if err := srv.DeviceTwin.HealthHandler(h); err == nil {
+	ctx, cancel := srv.messageContext()
+	defer cancel()
+	if err := srv.DeviceTwin.HealthHandler(ctx, h); err == nil {
 		// Exit if successful
 		return
 	}
@@ -156,7 +229,7 @@ func (srv *Service) HealthHandler(client MQTT.Client, msg MQTT.Message) {
 	act := domain.SubscribeAction{
 		Action: "device",
 	}
-	if err := srv.triggerActionOnDevice(h.OrganizationID, h.DeviceID, act); err != nil {
+	if err := srv.triggerActionOnDevice(ctx, h.OrganizationID, h.DeviceID, act); err != nil {
 		log.Printf("Triggering action: %v", err)
 	}
 
@@ -164,23 +237,31 @@ func (srv *Service) HealthHandler(client MQTT.Client, msg MQTT.Message) {
 	act = domain.SubscribeAction{
 		Action: "list",
 	}
-	if err := srv.triggerActionOnDevice(h.OrganizationID, h.DeviceID, act); err != nil {
+	if err := srv.triggerActionOnDevice(ctx, h.OrganizationID, h.DeviceID, act); err != nil {
 		log.Printf("Triggering action: %v", err)
 	}
 }
 
-// getClientID sets the client ID from the topic
-func getClientID(msg MQTT.Message) string {
-	parts := strings.Split(msg.Topic(), "/")
-	if len(parts) != 3 {
-		log.Printf("Error in health message: expected 4 parts, got %d", len(parts))
-		return ""
+// messageContext creates the context for handling a message from a device, with the deadline
+// for the calls to the device twin service
+func (srv *Service) messageContext() (context.Context, context.CancelFunc) {
+	if srv.Settings.Timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), srv.Settings.Timeout)
+}
+
+// getClientID gets the organization and client IDs from the topic, which must match the template
+func getClientID(topic config.Topic, msg MQTT.Message) (string, string, bool) {
+	orgID, clientID, ok := topic.Match(msg.Topic())
+	if !ok {
+		log.Printf("Error in message: the topic `%s` does not match `%s`", msg.Topic(), topic)
 	}
-	return parts[2]
+	return orgID, clientID, ok
 }
 
 // triggerActionOnDevice triggers an action on the device via MQTT
-func (srv *Service) triggerActionOnDevice(orgID, deviceID string, act domain.SubscribeAction) error {
+func (srv *Service) triggerActionOnDevice(ctx context.Context, orgID, deviceID string, act domain.SubscribeAction) error {
 	// Generate a request ID
 	id := ksuid.New()
 	act.ID = id.String()
@@ -192,16 +273,22 @@ func (srv *Service) triggerActionOnDevice(orgID, deviceID string, act domain.Sub
 		return err
 	}
 
-	// Publish the request
-	t := fmt.Sprintf("devices/sub/%s", deviceID)
-	err = srv.MQTT.Publish(t, string(data))
+	// Publish the request, with MQTT v5 the response is correlated by the action ID
+	err = srv.MQTT.Request(mqtt.Request{
+		Topic:         srv.Settings.Topics.Subscribe.Format(orgID, deviceID),
+		Payload:       data,
+		ResponseTopic: srv.Settings.Topics.Actions.Format(orgID, deviceID),
+		CorrelationID: act.ID,
+		OrgID:         orgID,
+		Expiry:        srv.Settings.ActionExpiry,
+	})
 	if err != nil {
 		log.Printf("Error in publish: %v", err)
-		return fmt.Errorf("error in publish: %v", err)
+		return fmt.Errorf("error in publish: %w", err)
 	}
 
 	// Log the request
-	return srv.DeviceTwin.ActionCreate(orgID, deviceID, act)
+	return srv.DeviceTwin.ActionCreate(ctx, orgID, deviceID, act)
 }
 
 func serializePayload(act domain.SubscribeAction) ([]byte, error) {
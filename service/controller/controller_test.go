@@ -20,9 +20,13 @@
 package controller
 
 import (
+	"context"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/domain"
 	"github.com/canonical/iot-devicetwin/service/devicetwin"
 	"github.com/canonical/iot-devicetwin/service/mqtt"
 	MQTT "github.com/eclipse/paho.mqtt.golang"
@@ -53,14 +57,38 @@ func TestService_SubscribeToActions(t *testing.T) {
 	}
 }
 
+func TestService_SubscribeToActions_Shared(t *testing.T) {
+	tests := []struct {
+		name  string
+		group string
+		want  []string
+	}{
+		{"not-shared", "", []string{"devices/health/+", "devices/pub/+"}},
+		{"shared", "devicetwin", []string{"$share/devicetwin/devices/health/+", "$share/devicetwin/devices/pub/+"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := config.TestConfig()
+			s.ShareGroup = tt.group
+			m := &mqtt.MockConnect{}
+			NewService(s, m, &devicetwin.MockDeviceTwin{})
+			if !reflect.DeepEqual(m.Topics, tt.want) {
+				t.Errorf("Service.SubscribeToActions() topics = %v, want %v", m.Topics, tt.want)
+			}
+		})
+	}
+}
+
 func TestService_ActionHandler(t *testing.T) {
 	m1 := []byte(`{"success": false, "message": "MOCK error"}`)
 	m2 := []byte(`{"success": true, "action": "invalid"}`)
+	m3 := []byte(`{"success": true, "action": "list"}`)
+	m4 := []byte(`{"id": "a1", "success": true, "action": "list"}`)
 
 	type fields struct {
 		Settings   *config.Settings
 		MQTT       mqtt.Connect
-		DeviceTwin devicetwin.DeviceTwin
+		DeviceTwin *devicetwin.MockDeviceTwin
 	}
 	type args struct {
 		client MQTT.Client
@@ -70,19 +98,26 @@ func TestService_ActionHandler(t *testing.T) {
 		name   string
 		fields fields
 		args   args
+		want   []string
 	}{
-		{"valid", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{}}},
-		{"error-response", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{Message: m1}}},
-		{"invalid-action", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{Message: m2}}},
+		{"valid", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{Message: m4}}, []string{"a1"}},
+		{"invalid-message", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{}}, nil},
+		{"error-response", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{Message: m1}}, nil},
+		{"invalid-action", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{Message: m2}}, nil},
+		{"correlation", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{Message: m3, Props: mqtt.Properties{CorrelationID: "a2"}}}, []string{"a2"}},
+		{"correlation-same-id", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{Message: m4, Props: mqtt.Properties{CorrelationID: "a1"}}}, []string{"a1"}},
+		{"correlation-mismatch", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{Message: m4, Props: mqtt.Properties{CorrelationID: "a2"}}}, nil},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(tt.fields.Settings, tt.fields.MQTT, tt.fields.DeviceTwin)
 			srv.ActionHandler(tt.args.client, tt.args.msg)
+			if got := tt.fields.DeviceTwin.Responses; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ActionHandler() = %v, want %v", got, tt.want)
+			}
 		})
 	}
 }
-
 func TestService_HealthHandler(t *testing.T) {
 	m1 := []byte(`{"orgId": "abc", "deviceId": "aa111"}`)
 	m2 := []byte(`{"orgId": "abc", "deviceId": "invalid"}`)
@@ -106,7 +141,11 @@ func TestService_HealthHandler(t *testing.T) {
 		{"valid", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{Message: m1}}, 0},
 		{"invalid-message", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{}}, 0},
 		{"invalid-clientID", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{Message: m2}}, 0},
-		{"new-clientID", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{Message: m3, TopicPath: "device/health/new-device"}}, 2},
+		{"new-clientID", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{Message: m3, TopicPath: "devices/health/new-device"}}, 2},
+		{"new-clientID-disconnected", fields{settings, &mqtt.MockConnect{Disconnected: true}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{Message: m3, TopicPath: "devices/health/new-device"}}, 0},
+		{"invalid-topic", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{Message: m3, TopicPath: "other/health/new-device"}}, 0},
+		{"org-property", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{Message: m3, TopicPath: "devices/health/new-device", Props: mqtt.Properties{OrgID: "abc"}}}, 2},
+		{"org-property-mismatch", fields{settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{}}, args{&mqtt.MockClient{}, &mqtt.MockMessage{Message: m3, TopicPath: "devices/health/new-device", Props: mqtt.Properties{OrgID: "other"}}}, 0},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -121,21 +160,51 @@ func TestService_HealthHandler(t *testing.T) {
 	}
 }
 
+func TestService_triggerActionOnDevice(t *testing.T) {
+	s := config.TestConfig()
+	s.ActionExpiry = time.Minute
+	m := &mqtt.MockConnect{}
+	twin := &devicetwin.MockDeviceTwin{}
+	srv := NewService(s, m, twin)
+
+	if err := srv.triggerActionOnDevice(context.Background(), "abc", "aa111", domain.SubscribeAction{Action: "list"}); err != nil {
+		t.Fatalf("triggerActionOnDevice() error = %v", err)
+	}
+	if len(m.Requests) != 1 || len(twin.Actions) != 1 {
+		t.Fatalf("triggerActionOnDevice() requests = %d, actions = %d, want 1", len(m.Requests), len(twin.Actions))
+	}
+
+	req := m.Requests[0]
+	if req.Topic != "devices/sub/aa111" || req.ResponseTopic != "devices/pub/aa111" {
+		t.Errorf("triggerActionOnDevice() topics = %s and %s", req.Topic, req.ResponseTopic)
+	}
+	if req.CorrelationID != twin.Actions[0] || req.OrgID != "abc" || req.Expiry != time.Minute {
+		t.Errorf("triggerActionOnDevice() request = %+v, want the action ID %s", req, twin.Actions[0])
+	}
+}
+
 func Test_getClientID(t *testing.T) {
 	type args struct {
-		msg MQTT.Message
+		topic config.Topic
+		msg   MQTT.Message
 	}
 	tests := []struct {
-		name string
-		args args
-		want string
+		name   string
+		args   args
+		org    string
+		want   string
+		wantOK bool
 	}{
-		{"valid", args{&mqtt.MockMessage{}}, "aa111"},
+		{"valid", args{settings.Topics.Actions, &mqtt.MockMessage{}}, "", "aa111", true},
+		{"valid-org", args{config.MustParseTopic("acme", "{org}/devices/{id}/pub"), &mqtt.MockMessage{TopicPath: "acme/abc/devices/aa111/pub"}}, "abc", "aa111", true},
+		{"invalid-level", args{settings.Topics.Health, &mqtt.MockMessage{}}, "", "", false},
+		{"invalid-length", args{settings.Topics.Actions, &mqtt.MockMessage{TopicPath: "acme/devices/pub/aa111"}}, "", "", false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := getClientID(tt.args.msg); got != tt.want {
-				t.Errorf("getClientID() = %v, want %v", got, tt.want)
+			org, got, ok := getClientID(tt.args.topic, tt.args.msg)
+			if ok != tt.wantOK || org != tt.org || got != tt.want {
+				t.Errorf("getClientID() = %v, %v, %v, want %v, %v, %v", org, got, ok, tt.org, tt.want, tt.wantOK)
 			}
 		})
 	}
@@ -19,14 +19,27 @@
 
 package controller
 
-import "github.com/canonical/iot-devicetwin/domain"
+import (
+	"context"
+	"github.com/canonical/iot-devicetwin/domain"
+)
 
 // DeviceGet gets the device from the database cache
-func (srv *Service) DeviceGet(orgID, clientID string) (domain.Device, error) {
-	return srv.DeviceTwin.DeviceGet(orgID, clientID)
+func (srv *Service) DeviceGet(ctx context.Context, orgID, clientID string) (domain.Device, error) {
+	return srv.DeviceTwin.DeviceGet(ctx, orgID, clientID)
+}
+
+// DeviceLabelsSet creates or updates labels on a device
+func (srv *Service) DeviceLabelsSet(ctx context.Context, orgID, clientID string, labels map[string]string) error {
+	return srv.DeviceTwin.DeviceLabelsSet(ctx, orgID, clientID, labels)
+}
+
+// DeviceLabelDelete removes a label from a device
+func (srv *Service) DeviceLabelDelete(ctx context.Context, orgID, clientID, key string) error {
+	return srv.DeviceTwin.DeviceLabelDelete(ctx, orgID, clientID, key)
 }
 
 // DeviceList gets the devices from the database cache
-func (srv *Service) DeviceList(orgID string) ([]domain.Device, error) {
-	return srv.DeviceTwin.DeviceList(orgID)
+func (srv *Service) DeviceList(ctx context.Context, orgID string, query domain.DeviceQuery) (domain.DevicePage, error) {
+	return srv.DeviceTwin.DeviceList(ctx, orgID, query)
 }
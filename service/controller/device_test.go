@@ -20,8 +20,10 @@
 package controller
 
 import (
+	"context"
 	"testing"
 
+	"github.com/canonical/iot-devicetwin/domain"
 	"github.com/canonical/iot-devicetwin/service/devicetwin"
 	"github.com/canonical/iot-devicetwin/service/mqtt"
 )
@@ -41,7 +43,7 @@ func TestService_DeviceGet(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
-			got, err := srv.DeviceGet(tt.args.orgID, tt.args.clientID)
+			got, err := srv.DeviceGet(context.Background(), tt.args.orgID, tt.args.clientID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Service.DeviceGet() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -69,13 +71,13 @@ func TestService_DeviceList(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
-			got, err := srv.DeviceList(tt.args.orgID)
+			got, err := srv.DeviceList(context.Background(), tt.args.orgID, domain.DeviceQuery{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Service.DeviceList() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if len(got) != tt.want {
-				t.Errorf("Service.DeviceList() = %v, want %v", len(got), tt.want)
+			if len(got.Devices) != tt.want {
+				t.Errorf("Service.DeviceList() = %v, want %v", len(got.Devices), tt.want)
 			}
 		})
 	}
@@ -19,39 +19,62 @@
 
 package controller
 
-import "github.com/canonical/iot-devicetwin/domain"
+import (
+	"context"
+	"github.com/canonical/iot-devicetwin/domain"
+)
 
 // GroupCreate creates a device group
-func (srv *Service) GroupCreate(orgID, name string) error {
-	return srv.DeviceTwin.GroupCreate(orgID, name)
+func (srv *Service) GroupCreate(ctx context.Context, orgID string, group domain.Group) error {
+	return srv.DeviceTwin.GroupCreate(ctx, orgID, group)
 }
 
 // GroupList lists the groups for an organization
-func (srv *Service) GroupList(orgID string) ([]domain.Group, error) {
-	return srv.DeviceTwin.GroupList(orgID)
+func (srv *Service) GroupList(ctx context.Context, orgID string) ([]domain.Group, error) {
+	return srv.DeviceTwin.GroupList(ctx, orgID)
 }
 
 // GroupGet retrieves a device group
-func (srv *Service) GroupGet(orgID, name string) (domain.Group, error) {
-	return srv.DeviceTwin.GroupGet(orgID, name)
+func (srv *Service) GroupGet(ctx context.Context, orgID, name string) (domain.Group, error) {
+	return srv.DeviceTwin.GroupGet(ctx, orgID, name)
+}
+
+// GroupUpdate renames a group or changes its description, parent or rule
+func (srv *Service) GroupUpdate(ctx context.Context, orgID, name string, group domain.Group) error {
+	return srv.DeviceTwin.GroupUpdate(ctx, orgID, name, group)
+}
+
+// GroupDelete deletes a device group
+func (srv *Service) GroupDelete(ctx context.Context, orgID, name string) error {
+	return srv.DeviceTwin.GroupDelete(ctx, orgID, name)
 }
 
 // GroupLinkDevice links a device to a group
-func (srv *Service) GroupLinkDevice(orgID, name, clientID string) error {
-	return srv.DeviceTwin.GroupLinkDevice(orgID, name, clientID)
+func (srv *Service) GroupLinkDevice(ctx context.Context, orgID, name, clientID string) error {
+	return srv.DeviceTwin.GroupLinkDevice(ctx, orgID, name, clientID)
 }
 
 // GroupUnlinkDevice unlinks a device from a group
-func (srv *Service) GroupUnlinkDevice(orgID, name, clientID string) error {
-	return srv.DeviceTwin.GroupUnlinkDevice(orgID, name, clientID)
+func (srv *Service) GroupUnlinkDevice(ctx context.Context, orgID, name, clientID string) error {
+	return srv.DeviceTwin.GroupUnlinkDevice(ctx, orgID, name, clientID)
+}
+
+// GroupLinkDevices links the devices that match a label selector to a group
+func (srv *Service) GroupLinkDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (int, error) {
+	return srv.DeviceTwin.GroupLinkDevices(ctx, orgID, name, query)
+}
+
+// GroupUnlinkDevices unlinks the devices that match a label selector from a group
+func (srv *Service) GroupUnlinkDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (int, error) {
+	return srv.DeviceTwin.GroupUnlinkDevices(ctx, orgID, name, query)
 }
 
 // GroupGetDevices retrieves the devices from a group
-func (srv *Service) GroupGetDevices(orgID, name string) ([]domain.Device, error) {
-	return srv.DeviceTwin.GroupGetDevices(orgID, name)
+func (srv *Service) GroupGetDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (domain.DevicePage, error) {
+	return srv.DeviceTwin.GroupGetDevices(ctx, orgID, name, query)
 }
 
 // GroupGetExcludedDevices retrieves the devices not in a group
-func (srv *Service) GroupGetExcludedDevices(orgID, name string) ([]domain.Device, error) {
-	return srv.DeviceTwin.GroupGetExcludedDevices(orgID, name)
+func (srv *Service) GroupGetExcludedDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (domain.DevicePage, error) {
+	return srv.DeviceTwin.GroupGetExcludedDevices(ctx, orgID, name, query)
 }
@@ -20,8 +20,10 @@
 package controller
 
 import (
+	"context"
 	"testing"
 
+	"github.com/canonical/iot-devicetwin/domain"
 	"github.com/canonical/iot-devicetwin/service/devicetwin"
 	"github.com/canonical/iot-devicetwin/service/mqtt"
 )
@@ -41,13 +43,39 @@ func TestService_GroupCreate(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
-			if err := srv.GroupCreate(tt.args.orgID, tt.args.name); (err != nil) != tt.wantErr {
+			if err := srv.GroupCreate(context.Background(), tt.args.orgID, domain.Group{Name: tt.args.name}); (err != nil) != tt.wantErr {
 				t.Errorf("Service.GroupCreate() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
 
+func TestService_GroupUpdateDelete(t *testing.T) {
+	type args struct {
+		orgID string
+		name  string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"valid", args{"abc", "workshop"}, false},
+		{"invalid", args{"abc", "invalid"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
+			if err := srv.GroupUpdate(context.Background(), tt.args.orgID, tt.args.name, domain.Group{Name: "garage"}); (err != nil) != tt.wantErr {
+				t.Errorf("Service.GroupUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := srv.GroupDelete(context.Background(), tt.args.orgID, tt.args.name); (err != nil) != tt.wantErr {
+				t.Errorf("Service.GroupDelete() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestService_GroupList(t *testing.T) {
 	type args struct {
 		orgID string
@@ -63,7 +91,7 @@ func TestService_GroupList(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
-			got, err := srv.GroupList(tt.args.orgID)
+			got, err := srv.GroupList(context.Background(), tt.args.orgID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Service.GroupList() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -90,7 +118,7 @@ func TestService_GroupGet(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
-			got, err := srv.GroupGet(tt.args.orgID, tt.args.name)
+			got, err := srv.GroupGet(context.Background(), tt.args.orgID, tt.args.name)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Service.GroupGet() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -120,7 +148,7 @@ func TestService_GroupLinkDevice(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
-			if err := srv.GroupLinkDevice(tt.args.orgID, tt.args.name, tt.args.clientID); (err != nil) != tt.wantErr {
+			if err := srv.GroupLinkDevice(context.Background(), tt.args.orgID, tt.args.name, tt.args.clientID); (err != nil) != tt.wantErr {
 				t.Errorf("Service.GroupLinkDevice() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -143,7 +171,7 @@ func TestService_GroupUnlinkDevice(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
-			if err := srv.GroupUnlinkDevice(tt.args.orgID, tt.args.name, tt.args.clientID); (err != nil) != tt.wantErr {
+			if err := srv.GroupUnlinkDevice(context.Background(), tt.args.orgID, tt.args.name, tt.args.clientID); (err != nil) != tt.wantErr {
 				t.Errorf("Service.GroupUnlinkDevice() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -166,13 +194,13 @@ func TestService_GroupGetDevices(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
-			got, err := srv.GroupGetDevices(tt.args.orgID, tt.args.name)
+			got, err := srv.GroupGetDevices(context.Background(), tt.args.orgID, tt.args.name, domain.DeviceQuery{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Service.GroupGetDevices() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if len(got) != tt.want {
-				t.Errorf("Service.GroupGetDevices() = %v, want %v", len(got), tt.want)
+			if len(got.Devices) != tt.want {
+				t.Errorf("Service.GroupGetDevices() = %v, want %v", len(got.Devices), tt.want)
 			}
 		})
 	}
@@ -194,13 +222,13 @@ func TestService_GroupGetExcludedDevices(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
-			got, err := srv.GroupGetExcludedDevices(tt.args.orgID, tt.args.name)
+			got, err := srv.GroupGetExcludedDevices(context.Background(), tt.args.orgID, tt.args.name, domain.DeviceQuery{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Service.GroupGetExcludedDevices() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if len(got) != tt.want {
-				t.Errorf("Service.GroupGetExcludedDevices() = %v, want %v", len(got), tt.want)
+			if len(got.Devices) != tt.want {
+				t.Errorf("Service.GroupGetExcludedDevices() = %v, want %v", len(got.Devices), tt.want)
 			}
 		})
 	}
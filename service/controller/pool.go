@@ -0,0 +1,153 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package controller
+
+import (
+	"github.com/canonical/iot-devicetwin/domain"
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+)
+
+// task is a message from a device waiting for a worker
+type task struct {
+	handler MQTT.MessageHandler
+	client  MQTT.Client
+	msg     MQTT.Message
+	queued  time.Time
+}
+
+// pool handles the messages from the devices with a fixed number of workers, so a burst of
+// messages does not stall the MQTT client. The messages of a device are queued to the same
+// worker, so they are handled in order, and the messages of different devices are handled in
+// parallel. When the queue of a worker is full, submitting a message blocks until the worker
+// catches up, which stops the MQTT client reading more messages from the broker
+type pool struct {
+	queues []chan task
+	wg     sync.WaitGroup
+
+	closing sync.RWMutex
+	closed  bool
+
+	lock    sync.Mutex
+	stats   domain.MessageStats
+	wait    time.Duration
+	latency time.Duration
+}
+
+func newPool(workers, queueSize int) *pool {
+	p := &pool{queues: make([]chan task, workers)}
+	p.stats.Workers = workers
+	p.stats.QueueSize = workers * queueSize
+
+	for i := range p.queues {
+		p.queues[i] = make(chan task, queueSize)
+		p.wg.Add(1)
+		go p.run(p.queues[i])
+	}
+	return p
+}
+
+// submit queues a message to the worker of the device
+func (p *pool) submit(deviceID string, t task) {
+	p.closing.RLock()
+	defer p.closing.RUnlock()
+	if p.closed {
+		log.Printf("Dropped the message on `%s` as the service is stopping", t.msg.Topic())
+		p.lock.Lock()
+		p.stats.Dropped++
+		p.lock.Unlock()
+		return
+	}
+
+	queue := p.queue(deviceID)
+	t.queued = time.Now()
+	select {
+	case queue <- t:
+	default:
+		p.lock.Lock()
+		p.stats.Blocked++
+		p.lock.Unlock()
+		queue <- t
+	}
+}
+
+// queue returns the queue of the worker of a device
+func (p *pool) queue(deviceID string) chan task {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(deviceID))
+	return p.queues[h.Sum32()%uint32(len(p.queues))]
+}
+
+// run handles the messages of a queue until it is closed
+func (p *pool) run(queue chan task) {
+	defer p.wg.Done()
+	for t := range queue {
+		start := time.Now()
+		t.handler(t.client, t.msg)
+		p.done(start.Sub(t.queued), time.Since(start))
+	}
+}
+
+func (p *pool) done(wait, latency time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stats.Processed++
+	p.wait += wait
+	p.latency += latency
+	if ms := milliseconds(latency); ms > p.stats.MaxLatencyMS {
+		p.stats.MaxLatencyMS = ms
+	}
+}
+
+// close stops accepting messages, and waits for the workers to handle the queued messages
+func (p *pool) close() {
+	p.closing.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	}
+	p.closing.Unlock()
+	p.wg.Wait()
+}
+
+// snapshot returns the state of the workers
+func (p *pool) snapshot() domain.MessageStats {
+	p.lock.Lock()
+	stats := p.stats
+	if stats.Processed > 0 {
+		stats.AvgWaitMS = milliseconds(p.wait) / float64(stats.Processed)
+		stats.AvgLatencyMS = milliseconds(p.latency) / float64(stats.Processed)
+	}
+	p.lock.Unlock()
+
+	for _, queue := range p.queues {
+		stats.Queued += len(queue)
+	}
+	return stats
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,183 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/service/devicetwin"
+	"github.com/canonical/iot-devicetwin/service/mqtt"
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestPool_Order(t *testing.T) {
+	p := newPool(4, 10)
+
+	var mu sync.Mutex
+	got := map[string][]int{}
+	want := map[string][]int{}
+	for i := 0; i < 50; i++ {
+		for _, id := range []string{"a111", "b222", "c333"} {
+			id, i := id, i
+			want[id] = append(want[id], i)
+			p.submit(id, task{msg: &mqtt.MockMessage{}, handler: func(MQTT.Client, MQTT.Message) {
+				mu.Lock()
+				defer mu.Unlock()
+				got[id] = append(got[id], i)
+			}})
+		}
+	}
+	p.close()
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pool order = %v, want %v", got, want)
+	}
+	if stats := p.snapshot(); stats.Processed != 150 || stats.Queued != 0 || stats.Workers != 4 || stats.QueueSize != 40 {
+		t.Errorf("pool stats = %+v", stats)
+	}
+}
+
+func TestPool_Parallel(t *testing.T) {
+	p := newPool(2, 10)
+	defer p.close()
+
+	// Find two devices that are handled by different workers
+	other := ""
+	for i := 0; len(other) == 0; i++ {
+		if id := fmt.Sprintf("device%d", i); p.queue(id) != p.queue("a111") {
+			other = id
+		}
+	}
+
+	// The first device waits for the second one, which would never run if they were serial
+	handled := make(chan struct{})
+	done := make(chan struct{})
+	p.submit("a111", task{msg: &mqtt.MockMessage{}, handler: func(MQTT.Client, MQTT.Message) {
+		<-handled
+		close(done)
+	}})
+	p.submit(other, task{msg: &mqtt.MockMessage{}, handler: func(MQTT.Client, MQTT.Message) {
+		close(handled)
+	}})
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the devices to be handled in parallel")
+	}
+}
+
+func TestPool_Backpressure(t *testing.T) {
+	p := newPool(1, 1)
+
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	handler := func(MQTT.Client, MQTT.Message) {
+		started <- struct{}{}
+		<-release
+	}
+
+	// The worker takes the first message and the second one fills the queue
+	p.submit("a111", task{msg: &mqtt.MockMessage{}, handler: handler})
+	<-started
+	p.submit("a111", task{msg: &mqtt.MockMessage{}, handler: handler})
+
+	// The third message waits for the worker
+	submitted := make(chan struct{})
+	go func() {
+		p.submit("a111", task{msg: &mqtt.MockMessage{}, handler: handler})
+		close(submitted)
+	}()
+	for i := 0; p.snapshot().Blocked == 0; i++ {
+		if i > 1000 {
+			t.Fatal("timeout waiting for the submit to block")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-submitted:
+		t.Fatal("submit() did not wait for the worker")
+	default:
+	}
+	if stats := p.snapshot(); stats.Queued != 1 || stats.Processed != 0 {
+		t.Errorf("pool stats = %+v", stats)
+	}
+
+	close(release)
+	<-submitted
+	p.close()
+
+	// The messages are dropped once the pool is closed
+	p.submit("a111", task{msg: &mqtt.MockMessage{}, handler: handler})
+	if stats := p.snapshot(); stats.Processed != 3 || stats.Blocked != 1 || stats.Dropped != 1 || stats.Queued != 0 {
+		t.Errorf("pool stats = %+v", stats)
+	}
+}
+
+func TestService_queue(t *testing.T) {
+	s := config.TestConfig()
+	s.Workers = 2
+	s.QueueSize = 10
+	srv := NewService(s, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
+
+	var mu sync.Mutex
+	topics := []string{}
+	handler := srv.queue(func(client MQTT.Client, msg MQTT.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		topics = append(topics, msg.Topic())
+	})
+	handler(nil, &mqtt.MockMessage{TopicPath: "devices/health/aa111"})
+	handler(nil, &mqtt.MockMessage{TopicPath: "devices/pub/aa111"})
+	srv.Close()
+
+	want := []string{"devices/health/aa111", "devices/pub/aa111"}
+	if !reflect.DeepEqual(topics, want) {
+		t.Errorf("queue() topics = %v, want %v", topics, want)
+	}
+	if stats := srv.Stats().Messages; stats.Processed != 2 || stats.Workers != 2 {
+		t.Errorf("Stats() messages = %+v", stats)
+	}
+}
+
+func TestService_deviceID(t *testing.T) {
+	tests := []struct {
+		name  string
+		topic string
+		want  string
+	}{
+		{"health", "devices/health/aa111", "aa111"},
+		{"action", "devices/pub/aa111", "aa111"},
+		{"other", "other/pub/aa111", "other/pub/aa111"},
+	}
+	srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := srv.deviceID(&mqtt.MockMessage{TopicPath: tt.topic}); got != tt.want {
+				t.Errorf("deviceID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -20,86 +20,94 @@
 package controller
 
 import (
-	"fmt"
+	"context"
+	"github.com/canonical/iot-devicetwin/datastore"
 	"github.com/canonical/iot-devicetwin/domain"
 	"time"
 )
 
 // DeviceSnaps gets the device's snaps from the database cache
-func (srv *Service) DeviceSnaps(orgID, clientID string) ([]domain.DeviceSnap, error) {
-	return srv.DeviceTwin.DeviceSnaps(orgID, clientID)
+func (srv *Service) DeviceSnaps(ctx context.Context, orgID, clientID string) ([]domain.DeviceSnap, error) {
+	return srv.DeviceTwin.DeviceSnaps(ctx, orgID, clientID)
+}
+
+// SnapInventory summarizes the snaps installed on the devices of an organization
+func (srv *Service) SnapInventory(ctx context.Context, orgID string) ([]domain.SnapInventory, error) {
+	return srv.DeviceTwin.SnapInventory(ctx, orgID)
 }
 
 // DeviceSnapList triggers listing snaps on a device
-func (srv *Service) DeviceSnapList(orgID, clientID string) error {
+func (srv *Service) DeviceSnapList(ctx context.Context, orgID, clientID string) error {
 	act := domain.SubscribeAction{
 		Action: "list",
 	}
-	return srv.deviceSnapAction(orgID, clientID, act)
+	return srv.deviceSnapAction(ctx, orgID, clientID, act)
 }
 
 // DeviceSnapInstall triggers installing a snap on a device
-func (srv *Service) DeviceSnapInstall(orgID, clientID, snap string) error {
+func (srv *Service) DeviceSnapInstall(ctx context.Context, orgID, clientID, snap string) error {
 	act := domain.SubscribeAction{
 		Action: "install",
 		Snap:   snap,
 	}
-	return srv.deviceSnapAction(orgID, clientID, act)
+	return srv.deviceSnapAction(ctx, orgID, clientID, act)
 }
 
 // DeviceSnapRemove triggers uninstalling a snap on a device
-func (srv *Service) DeviceSnapRemove(orgID, clientID, snap string) error {
+func (srv *Service) DeviceSnapRemove(ctx context.Context, orgID, clientID, snap string) error {
 	act := domain.SubscribeAction{
 		Action: "remove",
 		Snap:   snap,
 	}
-	return srv.deviceSnapAction(orgID, clientID, act)
+	return srv.deviceSnapAction(ctx, orgID, clientID, act)
 }
 
 // DeviceSnapUpdate triggers a snap update on a device
-func (srv *Service) DeviceSnapUpdate(orgID, clientID, snap, action string) error {
+func (srv *Service) DeviceSnapUpdate(ctx context.Context, orgID, clientID, snap, action string) error {
 	switch action {
 	case "enable", "disable", "refresh":
 		act := domain.SubscribeAction{
 			Action: action,
 			Snap:   snap,
 		}
-		return srv.deviceSnapAction(orgID, clientID, act)
+		return srv.deviceSnapAction(ctx, orgID, clientID, act)
 	default:
-		return fmt.Errorf("invalid update action `%s`", action)
+		return datastore.Invalid("invalid update action `%s`", action)
 	}
 }
 
 // DeviceSnapConf triggers a snap settings update on a device
-func (srv *Service) DeviceSnapConf(orgID, clientID, snap, settings string) error {
+func (srv *Service) DeviceSnapConf(ctx context.Context, orgID, clientID, snap, settings string) error {
 	// Trigger the update settings action on the device
 	act := domain.SubscribeAction{
 		Action: "setconf",
 		Snap:   snap,
 		Data:   settings,
 	}
-	return srv.deviceSnapAction(orgID, clientID, act)
+	return srv.deviceSnapAction(ctx, orgID, clientID, act)
 }
 
 // deviceSnapAction triggers a snap action on a device
-func (srv *Service) deviceSnapAction(orgID, clientID string, action domain.SubscribeAction) error {
+func (srv *Service) deviceSnapAction(ctx context.Context, orgID, clientID string, action domain.SubscribeAction) error {
 	// Validate the org and device ID
-	device, err := srv.DeviceTwin.DeviceGet(orgID, clientID)
+	device, err := srv.DeviceTwin.DeviceGet(ctx, orgID, clientID)
 	if err != nil {
 		return err
 	}
 
 	// Trigger the action on the device
-	err = srv.triggerActionOnDevice(device.OrganizationID, device.DeviceID, action)
+	err = srv.triggerActionOnDevice(ctx, device.OrganizationID, device.DeviceID, action)
 	if err != nil {
 		return err
 	}
 
 	// State of the snaps has changed, so request a snap list
 	if action.Action != "list" {
-		// Request the list action after a few seconds
+		// Request the list action after a few seconds, outliving the request that triggered it
 		time.AfterFunc(10*time.Second, func() {
-			_ = srv.DeviceSnapList(orgID, clientID)
+			ctx, cancel := srv.messageContext()
+			defer cancel()
+			_ = srv.DeviceSnapList(ctx, orgID, clientID)
 		})
 	}
 	return err
@@ -20,6 +20,7 @@
 package controller
 
 import (
+	"context"
 	"testing"
 
 	"github.com/canonical/iot-devicetwin/service/devicetwin"
@@ -42,7 +43,7 @@ func TestService_DeviceSnaps(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
-			got, err := srv.DeviceSnaps(tt.args.orgID, tt.args.clientID)
+			got, err := srv.DeviceSnaps(context.Background(), tt.args.orgID, tt.args.clientID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Service.DeviceSnaps() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -54,6 +55,31 @@ func TestService_DeviceSnaps(t *testing.T) {
 	}
 }
 
+func TestService_SnapInventory(t *testing.T) {
+	tests := []struct {
+		name    string
+		orgID   string
+		want    int
+		wantErr bool
+	}{
+		{"valid", "abc", 1, false},
+		{"invalid", "invalid", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
+			got, err := srv.SnapInventory(context.Background(), tt.orgID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Service.SnapInventory() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(got) != tt.want {
+				t.Errorf("Service.SnapInventory() = %v, want %v", len(got), tt.want)
+			}
+		})
+	}
+}
+
 func TestService_DeviceSnapInstall(t *testing.T) {
 	type args struct {
 		orgID    string
@@ -70,7 +96,7 @@ func TestService_DeviceSnapInstall(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
-			if err := srv.DeviceSnapInstall(tt.args.orgID, tt.args.clientID, tt.args.snap); (err != nil) != tt.wantErr {
+			if err := srv.DeviceSnapInstall(context.Background(), tt.args.orgID, tt.args.clientID, tt.args.snap); (err != nil) != tt.wantErr {
 				t.Errorf("Service.DeviceSnapInstall() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -93,7 +119,7 @@ func TestService_DeviceSnapRemove(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
-			if err := srv.DeviceSnapRemove(tt.args.orgID, tt.args.clientID, tt.args.snap); (err != nil) != tt.wantErr {
+			if err := srv.DeviceSnapRemove(context.Background(), tt.args.orgID, tt.args.clientID, tt.args.snap); (err != nil) != tt.wantErr {
 				t.Errorf("Service.DeviceSnapRemove() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -117,7 +143,7 @@ func TestService_DeviceSnapUpdate(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
-			if err := srv.DeviceSnapUpdate(tt.args.orgID, tt.args.clientID, tt.args.snap, tt.args.action); (err != nil) != tt.wantErr {
+			if err := srv.DeviceSnapUpdate(context.Background(), tt.args.orgID, tt.args.clientID, tt.args.snap, tt.args.action); (err != nil) != tt.wantErr {
 				t.Errorf("Service.DeviceSnapUpdate() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -141,7 +167,7 @@ func TestService_DeviceSnapConf(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
-			if err := srv.DeviceSnapConf(tt.args.orgID, tt.args.clientID, tt.args.snap, tt.args.settings); (err != nil) != tt.wantErr {
+			if err := srv.DeviceSnapConf(context.Background(), tt.args.orgID, tt.args.clientID, tt.args.snap, tt.args.settings); (err != nil) != tt.wantErr {
 				t.Errorf("Service.DeviceSnapConf() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -163,7 +189,7 @@ func TestService_DeviceSnapList(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(settings, &mqtt.MockConnect{}, &devicetwin.MockDeviceTwin{})
-			if err := srv.DeviceSnapList(tt.args.orgID, tt.args.clientID); (err != nil) != tt.wantErr {
+			if err := srv.DeviceSnapList(context.Background(), tt.args.orgID, tt.args.clientID); (err != nil) != tt.wantErr {
 				t.Errorf("Service.DeviceSnapList() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -0,0 +1,34 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package controller
+
+import "github.com/canonical/iot-devicetwin/domain"
+
+// Stats returns the statistics of the running service
+func (srv *Service) Stats() domain.Stats {
+	stats := domain.Stats{
+		Cache: srv.DeviceTwin.CacheStats(),
+		MQTT:  srv.MQTT.Status(),
+	}
+	if srv.workers != nil {
+		stats.Messages = srv.workers.snapshot()
+	}
+	return stats
+}
@@ -0,0 +1,36 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package controller
+
+import (
+	"context"
+	"github.com/canonical/iot-devicetwin/domain"
+	"github.com/canonical/iot-devicetwin/service/devicetwin"
+)
+
+// Export writes the records of an organization to import into another data store
+func (srv *Service) Export(ctx context.Context, orgID string, w devicetwin.ExportWriter) error {
+	return srv.DeviceTwin.Export(ctx, orgID, w)
+}
+
+// Import creates the exported records in an organization
+func (srv *Service) Import(ctx context.Context, orgID string, data domain.Export) (domain.ImportSummary, error) {
+	return srv.DeviceTwin.Import(ctx, orgID, data)
+}
@@ -20,13 +20,14 @@
 package devicetwin
 
 import (
+	"context"
 	"github.com/canonical/iot-devicetwin/datastore"
 	"github.com/canonical/iot-devicetwin/domain"
 	"time"
 )
 
 // ActionCreate logs an action
-func (srv *Service) ActionCreate(orgID, deviceID string, action domain.SubscribeAction) error {
+func (srv *Service) ActionCreate(ctx context.Context, orgID, deviceID string, action domain.SubscribeAction) error {
 	act := datastore.Action{
 		OrganizationID: orgID,
 		DeviceID:       deviceID,
@@ -36,19 +37,37 @@ func (srv *Service) ActionCreate(orgID, deviceID string, action domain.Subscribe
 		Created:        time.Now(),
 		Modified:       time.Now(),
 	}
-	_, err := srv.DB.ActionCreate(act)
-	return err
+	if _, err := srv.DB.ActionCreate(ctx, act); err != nil {
+		return err
+	}
+	srv.actionChanged(ctx, orgID, deviceID, act.ActionID, act.Status)
+	return nil
 }
 
 // ActionUpdate updates action
-func (srv *Service) ActionUpdate(actionID, status, message string) error {
-	return srv.DB.ActionUpdate(actionID, status, message)
+func (srv *Service) ActionUpdate(ctx context.Context, actionID, status, message string) error {
+	if err := srv.DB.ActionUpdate(ctx, actionID, status, message); err != nil {
+		return err
+	}
+	srv.actionChanged(ctx, "", "", actionID, status)
+	return nil
 }
 
-// ActionList lists actions for a device
-func (srv *Service) ActionList(orgID, deviceID string) ([]domain.Action, error) {
+// ActionList lists actions for a device, most recent first
+func (srv *Service) ActionList(ctx context.Context, orgID, deviceID string, query domain.ActionQuery) ([]domain.Action, error) {
 	list := []domain.Action{}
-	actions, err := srv.DB.ActionListForDevice(orgID, deviceID)
+	if query.Limit < 0 || query.Limit > MaxPageSize {
+		return list, datastore.Invalid("invalid limit `%d`, the maximum is %d", query.Limit, MaxPageSize)
+	}
+	if !query.From.IsZero() && !query.To.IsZero() && !query.From.Before(query.To) {
+		return list, datastore.Invalid("invalid time range, `from` must be before `to`")
+	}
+
+	actions, err := srv.DB.ActionListForDevice(ctx, orgID, deviceID, datastore.ActionQuery{
+		From:  query.From,
+		To:    query.To,
+		Limit: query.Limit,
+	})
 	if err != nil {
 		return list, err
 	}
@@ -20,7 +20,9 @@
 package devicetwin
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/canonical/iot-devicetwin/datastore"
 	"github.com/canonical/iot-devicetwin/domain"
@@ -28,7 +30,7 @@ import (
 )
 
 // actionDevice process the device info received from a device
-func (srv *Service) actionDevice(payload []byte) error {
+func (srv *Service) actionDevice(ctx context.Context, payload []byte) error {
 	// Parse the payload
 	d := domain.PublishDevice{}
 	if err := json.Unmarshal(payload, &d); err != nil {
@@ -36,12 +38,6 @@ func (srv *Service) actionDevice(payload []byte) error {
 		return fmt.Errorf("error in device action message: %v", err)
 	}
 
-	// Get the device details and create/update the device
-	_, err := srv.DB.DeviceGet(d.Result.DeviceID)
-	if err == nil {
-		return fmt.Errorf("error in device action: device already exists")
-	}
-
 	// Device does not exit, so create
 	device := datastore.Device{
 		OrganisationID: d.Result.OrganizationID,
@@ -52,7 +48,7 @@ func (srv *Service) actionDevice(payload []byte) error {
 		DeviceKey:      d.Result.DeviceKey,
 		StoreID:        d.Result.StoreID,
 	}
-	deviceID, err := srv.DB.DeviceCreate(device)
+	deviceID, err := srv.createDevice(ctx, device)
 	if err != nil {
 		return fmt.Errorf("error in device action: %v", err)
 	}
@@ -71,12 +67,45 @@ func (srv *Service) actionDevice(payload []byte) error {
 		OnClassic:     d.Result.Version.OnClassic,
 		KernelVersion: d.Result.Version.KernelVersion,
 	}
-	err = srv.DB.DeviceVersionUpsert(version)
-	return err
+	if err := srv.DB.DeviceVersionUpsert(ctx, version); err != nil {
+		return err
+	}
+	srv.versionChanged(ctx, device.OrganisationID, device.DeviceID, deviceID)
+	return nil
+}
+
+// createDevice creates the device from a device response. A response that is delivered again
+// finds the device it created, which is only an error if the record is for another device
+func (srv *Service) createDevice(ctx context.Context, device datastore.Device) (int64, error) {
+	existing, err := srv.device(ctx, device.DeviceID)
+	if err != nil && !errors.Is(err, datastore.ErrNotFound) {
+		return 0, err
+	}
+	if err != nil {
+		id, err := srv.DB.DeviceCreate(ctx, device)
+		if err == nil {
+			srv.deviceChanged(ctx, device.OrganisationID, device.DeviceID)
+			return id, nil
+		}
+		if !errors.Is(err, datastore.ErrConflict) {
+			return 0, err
+		}
+
+		// Created by a copy of the response handled at the same time
+		if existing, err = srv.DB.DeviceGet(ctx, device.DeviceID); err != nil {
+			return 0, err
+		}
+	}
+
+	if existing.OrganisationID != device.OrganisationID || existing.Brand != device.Brand ||
+		existing.Model != device.Model || existing.SerialNumber != device.SerialNumber {
+		return 0, fmt.Errorf("device already exists")
+	}
+	return existing.ID, nil
 }
 
 // actionList process the list of snaps received from a device
-func (srv *Service) actionList(clientID string, payload []byte) error {
+func (srv *Service) actionList(ctx context.Context, clientID string, payload []byte) error {
 	// Parse the payload
 	p := domain.PublishSnaps{}
 	if err := json.Unmarshal(payload, &p); err != nil {
@@ -85,17 +114,13 @@ func (srv *Service) actionList(clientID string, payload []byte) error {
 	}
 
 	// Get the device details
-	device, err := srv.DB.DeviceGet(clientID)
+	device, err := srv.device(ctx, clientID)
 	if err != nil {
 		return fmt.Errorf("cannot find device with ID `%s`", clientID)
 	}
 
-	// Delete the existing snap list for the device
-	if err := srv.DB.DeviceSnapDelete(device.ID); err != nil {
-		return fmt.Errorf("error deleting snap records: %v", err)
-	}
-
-	// Add the installed snaps
+	// Replace the snap list for the device with the installed snaps
+	snaps := []datastore.DeviceSnap{}
 	for _, s := range p.Result {
 		snap := datastore.DeviceSnap{
 			//Created       time.Time
@@ -112,17 +137,17 @@ func (srv *Service) actionList(clientID string, payload []byte) error {
 			Devmode:       s.Devmode,
 			Config:        s.Config,
 		}
-
-		if err := srv.DB.DeviceSnapUpsert(snap); err != nil {
-			return err
-		}
+		snaps = append(snaps, snap)
 	}
 
+	if err := srv.DB.DeviceSnapReplace(ctx, device.ID, snaps); err != nil {
+		return fmt.Errorf("error replacing snap records: %v", err)
+	}
 	return nil
 }
 
 // actionForSnap process the snap response from an action (install, remove, refresh...)
-func (srv *Service) actionForSnap(clientID, action string, payload []byte) (string, error) {
+func (srv *Service) actionForSnap(ctx context.Context, clientID, action string, payload []byte) (string, error) {
 	// Parse the payload
 	p := domain.PublishSnapTask{}
 	if err := json.Unmarshal(payload, &p); err != nil {
@@ -135,7 +160,7 @@ func (srv *Service) actionForSnap(clientID, action string, payload []byte) (stri
 }
 
 // actionConf process the snap response from a conf action
-func (srv *Service) actionConf(clientID string, payload []byte) error {
+func (srv *Service) actionConf(ctx context.Context, clientID string, payload []byte) error {
 	// Parse the payload
 	p := domain.PublishSnap{}
 	if err := json.Unmarshal(payload, &p); err != nil {
@@ -144,12 +169,13 @@ func (srv *Service) actionConf(clientID string, payload []byte) error {
 	}
 
 	// Get the device details
-	device, err := srv.DB.DeviceGet(clientID)
+	device, err := srv.device(ctx, clientID)
 	if err != nil {
 		return fmt.Errorf("cannot find device with ID `%s`", clientID)
 	}
 
-	// Create/update the installed snap details with the current config
+	// Create/update the installed snap details with the current config. The snap is upserted
+	// on its own, so a list response that is handled at the same time is not overwritten
 	snap := datastore.DeviceSnap{
 		//Created       time.Time
 		//Modified      time.Time
@@ -166,11 +192,11 @@ func (srv *Service) actionConf(clientID string, payload []byte) error {
 		Config:        p.Result.Config,
 	}
 
-	return srv.DB.DeviceSnapUpsert(snap)
+	return srv.DB.DeviceSnapUpsert(ctx, snap)
 }
 
 // actionServer process the response from a server action
-func (srv *Service) actionServer(clientID string, payload []byte) error {
+func (srv *Service) actionServer(ctx context.Context, clientID string, payload []byte) error {
 	// Parse the payload
 	p := domain.PublishDeviceVersion{}
 	if err := json.Unmarshal(payload, &p); err != nil {
@@ -179,7 +205,7 @@ func (srv *Service) actionServer(clientID string, payload []byte) error {
 	}
 
 	// Get the device details
-	device, err := srv.DB.DeviceGet(clientID)
+	device, err := srv.device(ctx, clientID)
 	if err != nil {
 		return fmt.Errorf("cannot find device with ID `%s`", clientID)
 	}
@@ -195,5 +221,9 @@ func (srv *Service) actionServer(clientID string, payload []byte) error {
 		KernelVersion: p.Result.KernelVersion,
 	}
 
-	return srv.DB.DeviceVersionUpsert(dv)
+	if err := srv.DB.DeviceVersionUpsert(ctx, dv); err != nil {
+		return err
+	}
+	srv.versionChanged(ctx, device.OrganisationID, device.DeviceID, device.ID)
+	return nil
 }
@@ -20,15 +20,20 @@
 package devicetwin
 
 import (
+	"context"
 	"github.com/canonical/iot-devicetwin/config"
 	"github.com/canonical/iot-devicetwin/datastore/memory"
+	"github.com/canonical/iot-devicetwin/domain"
 	"testing"
+	"time"
 )
 
 func TestService_ActionList(t *testing.T) {
+	now := time.Now()
 	type args struct {
 		orgID    string
 		deviceID string
+		query    domain.ActionQuery
 	}
 	tests := []struct {
 		name    string
@@ -36,13 +41,17 @@ func TestService_ActionList(t *testing.T) {
 		want    int
 		wantErr bool
 	}{
-		{"valid", args{"abc", "c333"}, 2, false},
-		{"valid", args{"abc", "a111"}, 0, false},
+		{"valid", args{"abc", "c333", domain.ActionQuery{}}, 2, false},
+		{"valid", args{"abc", "a111", domain.ActionQuery{}}, 0, false},
+		{"valid-limit", args{"abc", "c333", domain.ActionQuery{Limit: 1}}, 1, false},
+		{"valid-range", args{"abc", "c333", domain.ActionQuery{From: now.Add(-time.Hour), To: now}}, 0, false},
+		{"invalid-limit", args{"abc", "c333", domain.ActionQuery{Limit: -1}}, 0, true},
+		{"invalid-range", args{"abc", "c333", domain.ActionQuery{From: now, To: now.Add(-time.Hour)}}, 0, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(config.TestConfig(), memory.NewStore())
-			got, err := srv.ActionList(tt.args.orgID, tt.args.deviceID)
+			got, err := srv.ActionList(context.Background(), tt.args.orgID, tt.args.deviceID, tt.args.query)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ActionList() error = %v, wantErr %v", err, tt.wantErr)
 				return
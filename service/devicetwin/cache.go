@@ -0,0 +1,216 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package devicetwin
+
+import (
+	"container/list"
+	"context"
+	"github.com/canonical/iot-devicetwin/datastore"
+	"github.com/canonical/iot-devicetwin/domain"
+	"sync"
+	"time"
+)
+
+// lruCache is a least recently used cache of records, which expire after the time to live.
+// The cache is disabled when the size or the time to live is zero
+type lruCache struct {
+	lock      sync.Mutex
+	size      int
+	ttl       time.Duration
+	now       func() time.Time
+	items     map[interface{}]*list.Element
+	order     *list.List // most recently used first
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+type cacheEntry struct {
+	key     interface{}
+	value   interface{}
+	expires time.Time
+}
+
+func newLRUCache(size int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		size:  size,
+		ttl:   ttl,
+		now:   time.Now,
+		items: map[interface{}]*list.Element{},
+		order: list.New(),
+	}
+}
+
+func (c *lruCache) enabled() bool {
+	return c.size > 0 && c.ttl > 0
+}
+
+// get fetches a record from the cache, unless it has expired
+func (c *lruCache) get(key interface{}) (interface{}, bool) {
+	if !c.enabled() {
+		return nil, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if !c.now().Before(e.expires) {
+		c.order.Remove(el)
+		delete(c.items, key)
+		c.misses++
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	c.hits++
+	return e.value, true
+}
+
+// put adds or replaces a record, evicting the least recently used record when the cache is full
+func (c *lruCache) put(key, value interface{}) {
+	if !c.enabled() {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	expires := c.now().Add(c.ttl)
+	if el, ok := c.items[key]; ok {
+		el.Value = &cacheEntry{key: key, value: value, expires: expires}
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, value: value, expires: expires})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+		c.evictions++
+	}
+}
+
+// update changes a cached record in place, keeping its expiry and its place in the order.
+// Nothing is done when the record is not cached
+func (c *lruCache) update(key interface{}, change func(value interface{}) interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*cacheEntry)
+		el.Value = &cacheEntry{key: key, value: change(e.value), expires: e.expires}
+	}
+}
+
+// remove invalidates a record
+func (c *lruCache) remove(key interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.order.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+// clear removes all the records
+func (c *lruCache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.items = map[interface{}]*list.Element{}
+	c.order.Init()
+}
+
+// stats returns the size of the cache and the counts of the lookups
+func (c *lruCache) stats() domain.CacheCounts {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return domain.CacheCounts{
+		Size:      c.order.Len(),
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// device fetches a device through the cache. Missing devices are not cached, so a device
+// that is registered by another instance of the service is found straight away
+func (srv *Service) device(ctx context.Context, deviceID string) (datastore.Device, error) {
+	if d, ok := srv.devices.get(deviceID); ok {
+		return d.(datastore.Device), nil
+	}
+
+	d, err := srv.DB.DeviceGet(ctx, deviceID)
+	if err != nil {
+		return d, err
+	}
+	srv.devices.put(deviceID, d)
+	return d, nil
+}
+
+// deviceVersion fetches the OS details of a device through the cache
+func (srv *Service) deviceVersion(ctx context.Context, id int64) (datastore.DeviceVersion, error) {
+	if dv, ok := srv.versions.get(id); ok {
+		return dv.(datastore.DeviceVersion), nil
+	}
+
+	dv, err := srv.DB.DeviceVersionGet(ctx, id)
+	if err != nil {
+		return dv, err
+	}
+	srv.versions.put(id, dv)
+	return dv, nil
+}
+
+// invalidateDevice removes a device from the cache after it has changed
+func (srv *Service) invalidateDevice(deviceID string) {
+	srv.devices.remove(deviceID)
+}
+
+// refreshDevice sets the last refresh of a cached device, unless it has a later refresh
+func (srv *Service) refreshDevice(deviceID string, refresh time.Time) {
+	srv.devices.update(deviceID, func(value interface{}) interface{} {
+		d := value.(datastore.Device)
+		if refresh.After(d.LastRefresh) {
+			d.LastRefresh = refresh
+		}
+		return d
+	})
+}
+
+// invalidateVersion removes the OS details of a device from the cache after they have changed
+func (srv *Service) invalidateVersion(id int64) {
+	srv.versions.remove(id)
+}
+
+// CacheStats returns the hit and miss counts of the device caches
+func (srv *Service) CacheStats() domain.CacheStats {
+	return domain.CacheStats{
+		Devices:  srv.devices.stats(),
+		Versions: srv.versions.stats(),
+	}
+}
@@ -0,0 +1,153 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package devicetwin
+
+import (
+	"context"
+	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/datastore/memory"
+	"github.com/canonical/iot-devicetwin/domain"
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := newLRUCache(2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.put("a", 1)
+	c.put("b", 2)
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Errorf("lruCache.get() = %v, %v, want 1", v, ok)
+	}
+
+	// b is the least recently used, so it is evicted
+	c.put("c", 3)
+	if _, ok := c.get("b"); ok {
+		t.Error("lruCache.get() expected b to be evicted")
+	}
+
+	c.put("a", 4)
+	if v, ok := c.get("a"); !ok || v != 4 {
+		t.Errorf("lruCache.get() = %v, %v, want 4", v, ok)
+	}
+
+	// An update keeps the expiry, and does not add a missing record
+	double := func(v interface{}) interface{} { return v.(int) * 2 }
+	c.update("c", double)
+	c.update("b", double)
+	if v, ok := c.get("c"); !ok || v != 6 {
+		t.Errorf("lruCache.update() = %v, %v, want 6", v, ok)
+	}
+	if _, ok := c.get("b"); ok {
+		t.Error("lruCache.update() expected b to stay missing")
+	}
+
+	c.remove("a")
+	if _, ok := c.get("a"); ok {
+		t.Error("lruCache.get() expected a to be removed")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := c.get("c"); ok {
+		t.Error("lruCache.get() expected c to expire")
+	}
+
+	want := domain.CacheCounts{Size: 0, Hits: 3, Misses: 4, Evictions: 1}
+	if got := c.stats(); got != want {
+		t.Errorf("lruCache.stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestLRUCache_Disabled(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		ttl  time.Duration
+	}{
+		{"no-size", 0, time.Minute},
+		{"no-ttl", 10, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newLRUCache(tt.size, tt.ttl)
+			c.put("a", 1)
+			if _, ok := c.get("a"); ok {
+				t.Error("lruCache.get() expected the cache to be disabled")
+			}
+			if got := c.stats(); got != (domain.CacheCounts{}) {
+				t.Errorf("lruCache.stats() = %+v, want no lookups", got)
+			}
+		})
+	}
+}
+
+func TestService_DeviceGetCached(t *testing.T) {
+	settings := config.TestConfig()
+	settings.CacheSize = 10
+	settings.CacheTTL = time.Minute
+	db := &countingStore{DataStore: memory.NewStore()}
+	srv := NewService(settings, db)
+
+	for i := 0; i < 3; i++ {
+		if _, err := srv.DeviceGet(context.Background(), "abc", "c333"); err != nil {
+			t.Fatalf("Service.DeviceGet() error = %v", err)
+		}
+	}
+	if db.gets != 1 {
+		t.Errorf("Service.DeviceGet() device lookups = %d, want 1", db.gets)
+	}
+
+	// Missing devices are not cached
+	for i := 0; i < 2; i++ {
+		if _, err := srv.DeviceGet(context.Background(), "abc", "d444"); err == nil {
+			t.Fatal("Service.DeviceGet() expected an error")
+		}
+	}
+	if db.gets != 3 {
+		t.Errorf("Service.DeviceGet() device lookups = %d, want 3", db.gets)
+	}
+
+	// The OS details are invalidated when the device reports them
+	payload := []byte(`{"id":"a1", "action":"server", "success":true, "message":"", "result": {"deviceId":"c333", "osVersionId":"core-123", "series":"20", "kernelVersion":"kernel-123"}}`)
+	if err := srv.ActionResponse(context.Background(), "c333", "a1", "server", payload); err != nil {
+		t.Fatalf("Service.ActionResponse() error = %v", err)
+	}
+	device, err := srv.DeviceGet(context.Background(), "abc", "c333")
+	if err != nil || device.Version.Series != "20" {
+		t.Errorf("Service.DeviceGet() version = %+v, %v, want series 20", device.Version, err)
+	}
+
+	// The last refresh is invalidated when the device reports its health
+	refresh := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	if err := srv.HealthHandler(context.Background(), domain.Health{OrganizationID: "abc", DeviceID: "c333", Refresh: refresh}); err != nil {
+		t.Fatalf("Service.HealthHandler() error = %v", err)
+	}
+	device, err = srv.DeviceGet(context.Background(), "abc", "c333")
+	if err != nil || !device.LastRefresh.Equal(refresh) {
+		t.Errorf("Service.DeviceGet() last refresh = %v, %v, want %v", device.LastRefresh, err, refresh)
+	}
+
+	stats := srv.CacheStats()
+	if stats.Devices.Size != 1 || stats.Devices.Hits == 0 || stats.Versions.Misses == 0 {
+		t.Errorf("Service.CacheStats() = %+v", stats)
+	}
+}
@@ -20,28 +20,39 @@
 package devicetwin
 
 import (
-	"fmt"
+	"context"
 	"github.com/canonical/iot-devicetwin/datastore"
 	"github.com/canonical/iot-devicetwin/domain"
+	"strings"
+	"time"
 )
 
+// MaxPageSize is the largest page of devices that can be requested
+const MaxPageSize = 1000
+
+// DefaultPageSize is the page of devices returned when a sort or cursor is requested without a limit
+const DefaultPageSize = 100
+
+// presenceWindow is the period since the last heartbeat in which a device is considered online
+const presenceWindow = 5 * time.Minute
+
 // DeviceGet fetches a device details from the database cache
-func (srv *Service) DeviceGet(orgID, clientID string) (domain.Device, error) {
+func (srv *Service) DeviceGet(ctx context.Context, orgID, clientID string) (domain.Device, error) {
 	// Get the device
-	d, err := srv.DB.DeviceGet(clientID)
+	d, err := srv.device(ctx, clientID)
 	if err != nil {
 		return domain.Device{}, err
 	}
 
 	// Validate the supplied orgid
 	if d.OrganisationID != orgID {
-		return domain.Device{}, fmt.Errorf("the organization ID does not match the device")
+		return domain.Device{}, datastore.Forbidden("the organization ID does not match the device")
 	}
 
 	device := dataToDomainDevice(d)
 
 	// Get the details of the server (OS)
-	dv, err := srv.DB.DeviceVersionGet(d.ID)
+	dv, err := srv.deviceVersion(ctx, d.ID)
 	if err == nil {
 		// We have the OS details, so use them
 		device.Version = domain.DeviceVersion{
@@ -55,21 +66,110 @@ func (srv *Service) DeviceGet(orgID, clientID string) (domain.Device, error) {
 		}
 	}
 
+	// Get the labels for the device
+	labels, err := srv.DB.DeviceLabelList(ctx, d.ID)
+	if err != nil {
+		return domain.Device{}, err
+	}
+	if len(labels) > 0 {
+		device.Labels = map[string]string{}
+		for _, l := range labels {
+			device.Labels[l.Key] = l.Value
+		}
+	}
+
 	return device, nil
 }
 
 // DeviceList fetches devices from the database cache
-func (srv *Service) DeviceList(orgID string) ([]domain.Device, error) {
-	dd, err := srv.DB.DeviceList(orgID)
+func (srv *Service) DeviceList(ctx context.Context, orgID string, query domain.DeviceQuery) (domain.DevicePage, error) {
+	return listDevices(query, func(q datastore.DeviceQuery) ([]datastore.Device, error) {
+		return srv.DB.DeviceList(ctx, orgID, q)
+	})
+}
+
+// listDevices fetches a page of devices, requesting an extra record to know if there is a next page.
+// The default page size applies when a client pages through the devices with a sort or a cursor but
+// no limit. A listing without any of them returns all the devices, as it did before the paging
+func listDevices(query domain.DeviceQuery, list func(datastore.DeviceQuery) ([]datastore.Device, error)) (domain.DevicePage, error) {
+	if query.Limit == 0 && (len(query.Sort) > 0 || len(query.Cursor) > 0) {
+		query.Limit = DefaultPageSize
+	}
+
+	q, err := dataDeviceQuery(query)
 	if err != nil {
-		return nil, err
+		return domain.DevicePage{}, err
+	}
+	if q.Limit > 0 {
+		q.Limit++
+	}
+
+	dd, err := list(q)
+	if err != nil {
+		return domain.DevicePage{}, err
+	}
+
+	page := domain.DevicePage{Devices: []domain.Device{}}
+	if query.Limit > 0 && len(dd) > query.Limit {
+		dd = dd[:query.Limit]
+		page.Next = datastore.EncodeCursor(dd[len(dd)-1])
 	}
 
-	devices := []domain.Device{}
 	for _, d := range dd {
-		devices = append(devices, dataToDomainDevice(d))
+		page.Devices = append(page.Devices, dataToDomainDevice(d))
+	}
+	return page, nil
+}
+
+// dataDeviceQuery validates a device listing query and converts it for the data store
+func dataDeviceQuery(query domain.DeviceQuery) (datastore.DeviceQuery, error) {
+	q := datastore.DeviceQuery{
+		Brand:        query.Brand,
+		Model:        query.Model,
+		Series:       query.Series,
+		Snap:         query.Snap,
+		SnapRevision: query.SnapRevision,
+		Group:        query.Group,
+		Presence:     query.Presence,
+		SortBy:       strings.TrimPrefix(query.Sort, "-"),
+		SortDesc:     strings.HasPrefix(query.Sort, "-"),
+		Limit:        query.Limit,
+	}
+
+	if q.SnapRevision < 0 || (q.SnapRevision > 0 && len(q.Snap) == 0) {
+		return q, datastore.Invalid("invalid snap revision `%d`, a snap is required", query.SnapRevision)
+	}
+
+	if !datastore.ValidSort(q.SortBy) {
+		return q, datastore.Invalid("invalid sort order `%s`", query.Sort)
+	}
+
+	switch q.Presence {
+	case "":
+	case datastore.PresenceOnline, datastore.PresenceOffline:
+		q.PresenceCutoff = time.Now().Add(-presenceWindow)
+	default:
+		return q, datastore.Invalid("invalid presence `%s`", query.Presence)
+	}
+
+	if q.Limit < 0 || q.Limit > MaxPageSize {
+		return q, datastore.Invalid("invalid limit `%d`, the maximum is %d", query.Limit, MaxPageSize)
+	}
+
+	labels, err := datastore.ParseLabelSelector(query.LabelSelector)
+	if err != nil {
+		return q, err
+	}
+	q.Labels = labels
+
+	if len(query.Cursor) > 0 {
+		after, err := datastore.DecodeCursor(query.Cursor)
+		if err != nil {
+			return q, err
+		}
+		q.After = after
 	}
-	return devices, nil
+	return q, nil
 }
 
 func dataToDomainDevice(d datastore.Device) domain.Device {
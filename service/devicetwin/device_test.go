@@ -20,10 +20,15 @@
 package devicetwin
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/datastore"
 	"github.com/canonical/iot-devicetwin/datastore/memory"
+	"github.com/canonical/iot-devicetwin/domain"
 )
 
 func TestService_DeviceGet(t *testing.T) {
@@ -44,7 +49,7 @@ func TestService_DeviceGet(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(config.TestConfig(), memory.NewStore())
-			got, err := srv.DeviceGet(tt.args.orgID, tt.args.clientID)
+			got, err := srv.DeviceGet(context.Background(), tt.args.orgID, tt.args.clientID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Service.DeviceGet() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -62,28 +67,101 @@ func TestService_DeviceGet(t *testing.T) {
 func TestService_DeviceList(t *testing.T) {
 	type args struct {
 		orgID string
+		query domain.DeviceQuery
 	}
 	tests := []struct {
-		name    string
-		args    args
-		want    int
-		wantErr bool
+		name     string
+		args     args
+		want     int
+		wantNext bool
+		wantErr  bool
 	}{
-		{"valid", args{"abc"}, 3, false},
-		{"valid-no-devices", args{"none"}, 0, false},
-		{"invalid", args{"invalid"}, 0, true},
+		{"valid", args{"abc", domain.DeviceQuery{}}, 3, false, false},
+		{"valid-no-devices", args{"none", domain.DeviceQuery{}}, 0, false, false},
+		{"invalid", args{"invalid", domain.DeviceQuery{}}, 0, false, true},
+		{"valid-filter", args{"abc", domain.DeviceQuery{Model: "drone-1000", Sort: "-serial"}}, 2, false, false},
+		{"valid-page", args{"abc", domain.DeviceQuery{Limit: 2}}, 2, true, false},
+		{"valid-last-page", args{"abc", domain.DeviceQuery{Limit: 3}}, 3, false, false},
+		{"invalid-sort", args{"abc", domain.DeviceQuery{Sort: "invalid"}}, 0, false, true},
+		{"invalid-presence", args{"abc", domain.DeviceQuery{Presence: "invalid"}}, 0, false, true},
+		{"invalid-limit", args{"abc", domain.DeviceQuery{Limit: MaxPageSize + 1}}, 0, false, true},
+		{"invalid-cursor", args{"abc", domain.DeviceQuery{Cursor: "invalid"}}, 0, false, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			srv := NewService(config.TestConfig(), memory.NewStore())
-			got, err := srv.DeviceList(tt.args.orgID)
+			srv := NewService(config.TestConfig(), newFailingStore())
+			got, err := srv.DeviceList(context.Background(), tt.args.orgID, tt.args.query)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Service.DeviceList() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if len(got) != tt.want {
-				t.Errorf("Service.DeviceList() = %v, want %v", len(got), tt.want)
+			if len(got.Devices) != tt.want {
+				t.Errorf("Service.DeviceList() = %v, want %v", len(got.Devices), tt.want)
+			}
+			if (len(got.Next) > 0) != tt.wantNext {
+				t.Errorf("Service.DeviceList() next = %v, wantNext %v", got.Next, tt.wantNext)
 			}
 		})
 	}
 }
+
+func TestService_DeviceListPaging(t *testing.T) {
+	srv := NewService(config.TestConfig(), memory.NewStore())
+
+	query := domain.DeviceQuery{Sort: "deviceId", Limit: 1}
+	got := []string{}
+	for i := 0; i < 5; i++ {
+		page, err := srv.DeviceList(context.Background(), "abc", query)
+		if err != nil {
+			t.Fatalf("Service.DeviceList() error = %v", err)
+		}
+		for _, d := range page.Devices {
+			got = append(got, d.DeviceID)
+		}
+		if len(page.Next) == 0 {
+			break
+		}
+		query.Cursor = page.Next
+	}
+
+	want := []string{"a111", "b222", "c333"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Service.DeviceList() pages = %v, want %v", got, want)
+	}
+}
+
+func TestService_DeviceListDefaultPage(t *testing.T) {
+	db := memory.NewEmptyStore()
+	for i := 0; i < DefaultPageSize+1; i++ {
+		device := datastore.Device{OrganisationID: "abc", DeviceID: fmt.Sprintf("d%03d", i), Brand: "example", Model: "drone-1000"}
+		if _, err := db.DeviceCreate(context.Background(), device); err != nil {
+			t.Fatalf("DeviceCreate() error = %v", err)
+		}
+	}
+	srv := NewService(config.TestConfig(), db)
+
+	// An unpaged listing returns all the devices
+	page, err := srv.DeviceList(context.Background(), "abc", domain.DeviceQuery{})
+	if err != nil {
+		t.Fatalf("Service.DeviceList() error = %v", err)
+	}
+	if len(page.Devices) != DefaultPageSize+1 || len(page.Next) != 0 {
+		t.Fatalf("Service.DeviceList() = %d devices, next %q, want all the devices", len(page.Devices), page.Next)
+	}
+
+	page, err = srv.DeviceList(context.Background(), "abc", domain.DeviceQuery{Sort: "deviceId"})
+	if err != nil {
+		t.Fatalf("Service.DeviceList() error = %v", err)
+	}
+	if len(page.Devices) != DefaultPageSize || len(page.Next) == 0 {
+		t.Fatalf("Service.DeviceList() = %d devices, next %q, want %d and a cursor", len(page.Devices), page.Next, DefaultPageSize)
+	}
+
+	page, err = srv.DeviceList(context.Background(), "abc", domain.DeviceQuery{Sort: "deviceId", Cursor: page.Next})
+	if err != nil {
+		t.Fatalf("Service.DeviceList() error = %v", err)
+	}
+	if len(page.Devices) != 1 || len(page.Next) != 0 {
+		t.Errorf("Service.DeviceList() = %d devices, next %q, want the last device", len(page.Devices), page.Next)
+	}
+}
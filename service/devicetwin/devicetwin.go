@@ -20,84 +20,142 @@
 package devicetwin
 
 import (
+	"context"
 	"fmt"
 	"github.com/canonical/iot-devicetwin/config"
 	"github.com/canonical/iot-devicetwin/datastore"
 	"github.com/canonical/iot-devicetwin/domain"
+	"github.com/canonical/iot-devicetwin/service/events"
+	"github.com/canonical/iot-devicetwin/service/leader"
 	"log"
 )
 
 // DeviceTwin interface for the service
 type DeviceTwin interface {
-	HealthHandler(payload domain.Health) error
-	ActionResponse(clientID, actionID, action string, payload []byte) error // process a response from a device
+	HealthHandler(ctx context.Context, payload domain.Health) error
+	ActionResponse(ctx context.Context, clientID, actionID, action string, payload []byte) error // process a response from a device
 
-	ActionCreate(orgID, deviceID string, act domain.SubscribeAction) error
-	ActionUpdate(actionID, status, message string) error
-	ActionList(orgID, deviceID string) ([]domain.Action, error)
+	ActionCreate(ctx context.Context, orgID, deviceID string, act domain.SubscribeAction) error
+	ActionUpdate(ctx context.Context, actionID, status, message string) error
+	ActionList(ctx context.Context, orgID, deviceID string, query domain.ActionQuery) ([]domain.Action, error)
 
-	DeviceSnaps(orgID, clientID string) ([]domain.DeviceSnap, error)
+	DeviceSnaps(ctx context.Context, orgID, clientID string) ([]domain.DeviceSnap, error)
 
-	DeviceList(orgID string) ([]domain.Device, error)
-	DeviceGet(orgID, clientID string) (domain.Device, error)
+	SnapInventory(ctx context.Context, orgID string) ([]domain.SnapInventory, error)
 
-	GroupCreate(orgID, name string) error
-	GroupList(orgID string) ([]domain.Group, error)
-	GroupGet(orgID, name string) (domain.Group, error)
-	GroupLinkDevice(orgID, name, clientID string) error
-	GroupUnlinkDevice(orgID, name, clientID string) error
-	GroupGetDevices(orgID, name string) ([]domain.Device, error)
-	GroupGetExcludedDevices(orgID, name string) ([]domain.Device, error)
+	DeviceList(ctx context.Context, orgID string, query domain.DeviceQuery) (domain.DevicePage, error)
+	DeviceGet(ctx context.Context, orgID, clientID string) (domain.Device, error)
+	DeviceLabelsSet(ctx context.Context, orgID, clientID string, labels map[string]string) error
+	DeviceLabelDelete(ctx context.Context, orgID, clientID, key string) error
+
+	GroupCreate(ctx context.Context, orgID string, group domain.Group) error
+	GroupList(ctx context.Context, orgID string) ([]domain.Group, error)
+	GroupGet(ctx context.Context, orgID, name string) (domain.Group, error)
+	GroupUpdate(ctx context.Context, orgID, name string, group domain.Group) error
+	GroupDelete(ctx context.Context, orgID, name string) error
+	GroupLinkDevice(ctx context.Context, orgID, name, clientID string) error
+	GroupUnlinkDevice(ctx context.Context, orgID, name, clientID string) error
+	GroupLinkDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (int, error)
+	GroupUnlinkDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (int, error)
+	GroupGetDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (domain.DevicePage, error)
+	GroupGetExcludedDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (domain.DevicePage, error)
+
+	Export(ctx context.Context, orgID string, w ExportWriter) error
+	Import(ctx context.Context, orgID string, data domain.Export) (domain.ImportSummary, error)
+
+	CacheStats() domain.CacheStats
 }
 
 // Service implementation of the identity use cases
 type Service struct {
-	Settings *config.Settings
-	DB       datastore.DataStore
+	Settings   *config.Settings
+	DB         datastore.DataStore
+	Events     events.Bus
+	heartbeats *heartbeats
+	devices    *lruCache // device records by device ID
+	versions   *lruCache // device OS details by device record ID
 }
 
-// NewService creates an implementation of the device twin use cases
+// NewService creates an implementation of the device twin use cases, for a single instance
 func NewService(settings *config.Settings, db datastore.DataStore) *Service {
-	return &Service{
-		Settings: settings,
-		DB:       db,
+	return NewServiceWithEvents(settings, db, events.NewMemoryBus())
+}
+
+// NewServiceWithEvents creates an implementation of the device twin use cases that publishes
+// the changes to the device twins on an event bus, and follows the changes from other instances
+func NewServiceWithEvents(settings *config.Settings, db datastore.DataStore, bus events.Bus) *Service {
+	srv := &Service{
+		Settings:   settings,
+		DB:         db,
+		Events:     bus,
+		heartbeats: newHeartbeats(settings.CacheSize),
+		devices:    newLRUCache(settings.CacheSize, settings.CacheTTL),
+		versions:   newLRUCache(settings.CacheSize, settings.CacheTTL),
 	}
+	bus.Subscribe(srv.handleEvent)
+	return srv
 }
 
-// HealthHandler handles a health update from a device
-func (srv *Service) HealthHandler(payload domain.Health) error {
-	// Check that we have the device
-	_, err := srv.DB.DeviceGet(payload.DeviceID)
-	if err != nil {
-		// Request the device details to be published as we don't have it
-		return err
+// RegisterJobs adds the background jobs of the service. The action purge runs once in the
+// cluster. The heartbeats are written by every instance, as each instance buffers the health
+// messages that it receives from the broker
+func (srv *Service) RegisterJobs(jobs *leader.Jobs) {
+	jobs.Register("action-purge", srv.RunPurge)
+	jobs.RegisterLocal("heartbeats", srv.RunHeartbeats)
+}
+
+// HealthHandler handles a health update from a device. The last refresh is buffered and written
+// in batches when a heartbeat interval is set, otherwise it is written straight away. An error is
+// returned when we don't have the device, so its details can be requested
+func (srv *Service) HealthHandler(ctx context.Context, payload domain.Health) error {
+	if srv.Settings.Heartbeat <= 0 {
+		// Update the last refresh on the device, which fails if we don't have it
+		if err := srv.DB.DevicePing(ctx, payload.DeviceID, payload.Refresh); err != nil {
+			return err
+		}
+		srv.refreshDevice(payload.DeviceID, payload.Refresh)
+		return nil
+	}
+
+	// Check that we have the device, unless it is known from a previous health update
+	if !srv.heartbeats.isKnown(payload.DeviceID) {
+		if _, err := srv.device(ctx, payload.DeviceID); err != nil {
+			return err
+		}
 	}
 
-	// Update the last refresh on the device
-	return srv.DB.DevicePing(payload.DeviceID, payload.Refresh)
+	srv.heartbeats.add(payload.DeviceID, payload.Refresh)
+	return nil
 }
 
 // ActionResponse handles action response from a device
-func (srv *Service) ActionResponse(clientID, actionID, action string, payload []byte) error {
+func (srv *Service) ActionResponse(ctx context.Context, clientID, actionID, action string, payload []byte) error {
 	var (
 		err     error
 		status  = "complete"
 		message = ""
 	)
 
+	// The broker delivers the messages at least once, so skip a response to an action that
+	// has already been handled, which could overwrite newer details from the device
+	if act, err := srv.DB.ActionGet(ctx, actionID); err == nil && act.Status != "requested" {
+		log.Printf("Skipping response to action `%s` (%s), already %s", actionID, action, act.Status)
+		return nil
+	}
+
 	// Act based on the message action
 	switch action {
 	case "device":
-		err = srv.actionDevice(payload)
+		err = srv.actionDevice(ctx, payload)
 	case "list":
-		err = srv.actionList(clientID, payload)
+		err = srv.actionList(ctx, clientID, payload)
 	case "install", "remove", "refresh", "revert", "enable", "disable", "setconf":
-		message, err = srv.actionForSnap(clientID, action, payload)
+		message, err = srv.actionForSnap(ctx, clientID, action, payload)
 	case "conf", "info":
-		err = srv.actionConf(clientID, payload)
+		err = srv.actionConf(ctx, clientID, payload)
 	//case "ack":
 	case "server":
-		err = srv.actionServer(clientID, payload)
+		err = srv.actionServer(ctx, clientID, payload)
 	default:
 		return fmt.Errorf("error unhandled action `%s`", action)
 	}
@@ -107,7 +165,7 @@ func (srv *Service) ActionResponse(clientID, actionID, action string, payload []
 		status = "error"
 		message = err.Error()
 	}
-	e := srv.ActionUpdate(actionID, status, message)
+	e := srv.ActionUpdate(ctx, actionID, status, message)
 	if e != nil {
 		log.Printf("Error updating action `%s`: %v", actionID, e)
 	}
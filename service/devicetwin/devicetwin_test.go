@@ -20,13 +20,54 @@
 package devicetwin
 
 import (
+	"context"
+	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/datastore"
 	"github.com/canonical/iot-devicetwin/datastore/memory"
 	"github.com/canonical/iot-devicetwin/domain"
 )
 
+// failingStore is a data store that fails to list or create the records of the `invalid` organization
+type failingStore struct {
+	datastore.DataStore
+}
+
+func newFailingStore() failingStore {
+	return failingStore{memory.NewStore()}
+}
+
+func (db failingStore) DeviceList(ctx context.Context, orgID string, query datastore.DeviceQuery) ([]datastore.Device, error) {
+	if orgID == "invalid" {
+		return nil, fmt.Errorf("MOCK list error")
+	}
+	return db.DataStore.DeviceList(ctx, orgID, query)
+}
+
+func (db failingStore) SnapInventory(ctx context.Context, orgID string) ([]datastore.SnapCount, error) {
+	if orgID == "invalid" {
+		return nil, fmt.Errorf("MOCK inventory error")
+	}
+	return db.DataStore.SnapInventory(ctx, orgID)
+}
+
+func (db failingStore) GroupCreate(ctx context.Context, grp datastore.Group) (int64, error) {
+	if grp.OrganisationID == "invalid" {
+		return 0, fmt.Errorf("MOCK create error")
+	}
+	return db.DataStore.GroupCreate(ctx, grp)
+}
+
+func (db failingStore) GroupList(ctx context.Context, orgID string) ([]datastore.Group, error) {
+	if orgID == "invalid" {
+		return nil, fmt.Errorf("MOCK list error")
+	}
+	return db.DataStore.GroupList(ctx, orgID)
+}
+
 func TestService_HealthHandler(t *testing.T) {
 	h1 := domain.Health{OrganizationID: "abc", DeviceID: "a111"}
 	h2 := domain.Health{OrganizationID: "abc", DeviceID: "invalid"}
@@ -44,7 +85,7 @@ func TestService_HealthHandler(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(config.TestConfig(), memory.NewStore())
-			if err := srv.HealthHandler(tt.args.payload); (err != nil) != tt.wantErr {
+			if err := srv.HealthHandler(context.Background(), tt.args.payload); (err != nil) != tt.wantErr {
 				t.Errorf("Service.HealthHandler() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -95,13 +136,114 @@ func TestService_ActionResponse(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(config.TestConfig(), memory.NewStore())
-			if err := srv.ActionResponse(tt.args.clientID, "a1", tt.args.action, tt.args.payload); (err != nil) != tt.wantErr {
+			if err := srv.ActionResponse(context.Background(), tt.args.clientID, "a1", tt.args.action, tt.args.payload); (err != nil) != tt.wantErr {
 				t.Errorf("Service.ActionResponse() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
 
+func TestService_ActionResponseSnaps(t *testing.T) {
+	list := []byte(`{"id":"a1", "action":"list", "success":true, "message":"", "result": [{"name":"abc", "status":"active", "version":"1.0"}, {"name":"alpaca", "status":"active", "version":"2.3"}]}`)
+	conf := []byte(`{"id":"a2", "action":"conf", "success":true, "message":"", "result": {"name":"abc", "status":"active", "version":"1.0", "config":"{\"title\": \"Jack\"}"}}`)
+
+	srv := NewService(config.TestConfig(), memory.NewStore())
+	if err := srv.ActionResponse(context.Background(), "a111", "a1", "list", list); err != nil {
+		t.Fatalf("Service.ActionResponse() list error = %v", err)
+	}
+	if err := srv.ActionResponse(context.Background(), "a111", "a2", "conf", conf); err != nil {
+		t.Fatalf("Service.ActionResponse() conf error = %v", err)
+	}
+
+	snaps, err := srv.DeviceSnaps(context.Background(), "abc", "a111")
+	if err != nil {
+		t.Fatalf("Service.DeviceSnaps() error = %v", err)
+	}
+	if len(snaps) != 2 {
+		t.Fatalf("Service.DeviceSnaps() got %d snaps, want 2", len(snaps))
+	}
+	for _, s := range snaps {
+		if s.Name == "abc" && s.Config != `{"title": "Jack"}` {
+			t.Errorf("Service.DeviceSnaps() config = %v, want the updated config", s.Config)
+		}
+	}
+}
+
+func TestService_ActionResponseRedelivered(t *testing.T) {
+	device := []byte(`{"id":"r1", "action":"device", "success":true, "message":"", "result": {"orgId":"abc", "deviceId":"d444", "brand":"example", "model":"drone-1000", "serial":"d444"}}`)
+	list := []byte(`{"id":"r2", "action":"list", "success":true, "message":"", "result": [{"name":"abc", "status":"active", "version":"1.0"}]}`)
+	newer := []byte(`{"id":"r3", "action":"list", "success":true, "message":"", "result": [{"name":"abc", "status":"active", "version":"2.0"}]}`)
+	ctx := context.Background()
+
+	srv := NewService(config.TestConfig(), memory.NewStore())
+	for _, id := range []string{"r1", "r2", "r3"} {
+		if err := srv.ActionCreate(ctx, "abc", "d444", domain.SubscribeAction{ID: id, Action: "list"}); err != nil {
+			t.Fatalf("Service.ActionCreate() error = %v", err)
+		}
+	}
+
+	// Each response is delivered twice, and the list that was already handled is delivered again last
+	for _, r := range []struct {
+		actionID string
+		action   string
+		payload  []byte
+	}{
+		{"r1", "device", device}, {"r1", "device", device},
+		{"r2", "list", list}, {"r2", "list", list},
+		{"r3", "list", newer}, {"r2", "list", list},
+	} {
+		if err := srv.ActionResponse(ctx, "d444", r.actionID, r.action, r.payload); err != nil {
+			t.Fatalf("Service.ActionResponse() %s error = %v", r.actionID, err)
+		}
+	}
+
+	snaps, err := srv.DeviceSnaps(ctx, "abc", "d444")
+	if err != nil || len(snaps) != 1 || snaps[0].Version != "2.0" {
+		t.Errorf("Service.DeviceSnaps() = %+v, %v, want the newer list", snaps, err)
+	}
+	actions, err := srv.ActionList(ctx, "abc", "d444", domain.ActionQuery{})
+	if err != nil {
+		t.Fatalf("Service.ActionList() error = %v", err)
+	}
+	for _, a := range actions {
+		if a.Status != "complete" {
+			t.Errorf("Service.ActionList() action %s = %s, want complete", a.ActionID, a.Status)
+		}
+	}
+}
+
+func TestService_createDevice(t *testing.T) {
+	ctx := context.Background()
+	srv := NewService(config.TestConfig(), memory.NewStore())
+	existing, err := srv.DB.DeviceGet(ctx, "a111")
+	if err != nil {
+		t.Fatalf("DeviceGet() error = %v", err)
+	}
+	other := existing
+	other.SerialNumber = "other"
+
+	tests := []struct {
+		name    string
+		device  datastore.Device
+		wantID  int64
+		wantErr bool
+	}{
+		{"same-device", existing, existing.ID, false},
+		{"other-device", other, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := srv.createDevice(ctx, tt.device)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Service.createDevice() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if id != tt.wantID {
+				t.Errorf("Service.createDevice() = %v, want %v", id, tt.wantID)
+			}
+		})
+	}
+}
+
 func TestService_ActionCreate(t *testing.T) {
 	a1 := domain.SubscribeAction{
 		ID:     "aa1234",
@@ -123,7 +265,7 @@ func TestService_ActionCreate(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(config.TestConfig(), memory.NewStore())
-			if err := srv.ActionCreate(tt.args.orgID, tt.args.deviceID, tt.args.action); (err != nil) != tt.wantErr {
+			if err := srv.ActionCreate(context.Background(), tt.args.orgID, tt.args.deviceID, tt.args.action); (err != nil) != tt.wantErr {
 				t.Errorf("Service.ActionCreate() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -134,6 +276,7 @@ func TestService_GroupWorkflow(t *testing.T) {
 	type args struct {
 		orgID string
 		name  string
+		rule  *domain.GroupRule
 	}
 	tests := []struct {
 		name    string
@@ -141,20 +284,33 @@ func TestService_GroupWorkflow(t *testing.T) {
 		count   int
 		wantErr bool
 	}{
-		{"valid", args{"abc", "test-group"}, 2, false},
-		{"invalid", args{"invalid", "test-group"}, 0, true},
+		{"valid", args{"abc", "test-group", nil}, 2, false},
+		{"valid-dynamic", args{"abc", "test-group", &domain.GroupRule{Model: "drone-1000"}}, 2, false},
+		{"invalid", args{"invalid", "test-group", nil}, 0, true},
+		{"invalid-empty-rule", args{"abc", "test-group", &domain.GroupRule{}}, 1, true},
+		{"invalid-selector", args{"abc", "test-group", &domain.GroupRule{LabelSelector: "site in berlin"}}, 1, true},
+		{"invalid-blank-selector", args{"abc", "test-group", &domain.GroupRule{LabelSelector: " , "}}, 1, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			srv := NewService(config.TestConfig(), memory.NewStore())
-			if err := srv.GroupCreate(tt.args.orgID, tt.args.name); (err != nil) != tt.wantErr {
+			srv := NewService(config.TestConfig(), newFailingStore())
+			if err := srv.GroupCreate(context.Background(), tt.args.orgID, domain.Group{Name: tt.args.name, Rule: tt.args.rule}); (err != nil) != tt.wantErr {
 				t.Errorf("Service.GroupCreate() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
-			groups, err := srv.GroupList(tt.args.orgID)
-			if (err != nil) != tt.wantErr {
+			groups, err := srv.GroupList(context.Background(), tt.args.orgID)
+			if (err != nil) != (tt.args.orgID == "invalid") {
 				t.Errorf("Service.GroupList() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if tt.args.rule != nil && !tt.wantErr {
+				g, err := srv.GroupGet(context.Background(), tt.args.orgID, tt.args.name)
+				if err != nil || g.Rule == nil || g.Rule.Model != tt.args.rule.Model {
+					t.Errorf("Service.GroupGet() = %v, %v, want rule %v", g, err, tt.args.rule)
+				}
+				if err := srv.GroupLinkDevice(context.Background(), tt.args.orgID, tt.args.name, "c333"); err == nil {
+					t.Error("Service.GroupLinkDevice() expected error for dynamic group")
+				}
+			}
 			if len(groups) != tt.count {
 				t.Errorf("Service.GroupList() count = %v, wantErr %v", len(groups), tt.count)
 			}
@@ -162,6 +318,100 @@ func TestService_GroupWorkflow(t *testing.T) {
 	}
 }
 
+func TestService_GroupUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		group   string
+		update  domain.Group
+		want    domain.Group
+		wantErr bool
+	}{
+		{"valid-rename", "workshop", domain.Group{Name: "garage", Description: "Main garage"}, domain.Group{OrganizationID: "abc", Name: "garage", Description: "Main garage"}, false},
+		{"valid-parent", "workshop", domain.Group{Parent: "site"}, domain.Group{OrganizationID: "abc", Name: "workshop", Parent: "site"}, false},
+		{"valid-rule", "dynamic", domain.Group{Rule: &domain.GroupRule{Snap: "example-snap"}}, domain.Group{OrganizationID: "abc", Name: "dynamic", Rule: &domain.GroupRule{Snap: "example-snap"}}, false},
+		{"invalid-rename-exists", "workshop", domain.Group{Name: "site"}, domain.Group{}, true},
+		{"invalid-cycle", "workshop", domain.Group{Parent: "dynamic"}, domain.Group{}, true},
+		{"invalid-self", "workshop", domain.Group{Parent: "workshop"}, domain.Group{}, true},
+		{"invalid-parent", "workshop", domain.Group{Parent: "does-not-exist"}, domain.Group{}, true},
+		{"invalid-static-rule", "workshop", domain.Group{Rule: &domain.GroupRule{Model: "drone-1000"}}, domain.Group{}, true},
+		{"invalid-group", "does-not-exist", domain.Group{Name: "garage"}, domain.Group{}, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewService(config.TestConfig(), memory.NewStore())
+			_ = srv.GroupCreate(context.Background(), "abc", domain.Group{Name: "site"})
+			_ = srv.GroupCreate(context.Background(), "abc", domain.Group{Name: "dynamic", Parent: "workshop", Rule: &domain.GroupRule{Model: "drone-1000"}})
+
+			err := srv.GroupUpdate(context.Background(), "abc", tt.group, tt.update)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Service.GroupUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got, err := srv.GroupGet(context.Background(), "abc", tt.want.Name)
+			if err != nil {
+				t.Fatalf("Service.GroupGet() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Service.GroupGet() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_GroupHierarchy(t *testing.T) {
+	srv := NewService(config.TestConfig(), memory.NewStore())
+	if err := srv.GroupCreate(context.Background(), "abc", domain.Group{Name: "site"}); err != nil {
+		t.Fatalf("Service.GroupCreate() error = %v", err)
+	}
+	if err := srv.GroupUpdate(context.Background(), "abc", "workshop", domain.Group{Parent: "site"}); err != nil {
+		t.Fatalf("Service.GroupUpdate() error = %v", err)
+	}
+	if err := srv.GroupCreate(context.Background(), "abc", domain.Group{Name: "servers", Parent: "site", Rule: &domain.GroupRule{Brand: "canonical"}}); err != nil {
+		t.Fatalf("Service.GroupCreate() error = %v", err)
+	}
+
+	// The members of the child groups are members of the parent
+	page, err := srv.GroupGetDevices(context.Background(), "abc", "site", domain.DeviceQuery{})
+	if err != nil || len(page.Devices) != 2 {
+		t.Errorf("Service.GroupGetDevices() = %v, %v, want 2 devices", len(page.Devices), err)
+	}
+	page, err = srv.GroupGetExcludedDevices(context.Background(), "abc", "site", domain.DeviceQuery{})
+	if err != nil || len(page.Devices) != 1 {
+		t.Errorf("Service.GroupGetExcludedDevices() = %v, %v, want 1 device", len(page.Devices), err)
+	}
+
+	// Deleting the parent moves the children to the top level
+	if err := srv.GroupDelete(context.Background(), "abc", "site"); err != nil {
+		t.Fatalf("Service.GroupDelete() error = %v", err)
+	}
+	if _, err := srv.GroupGet(context.Background(), "abc", "site"); err == nil {
+		t.Error("Service.GroupGet() expected error for deleted group")
+	}
+	g, err := srv.GroupGet(context.Background(), "abc", "workshop")
+	if err != nil || len(g.Parent) > 0 {
+		t.Errorf("Service.GroupGet() = %v, %v, want no parent", g, err)
+	}
+
+	// Deleting a group removes its device links
+	if err := srv.GroupDelete(context.Background(), "abc", "workshop"); err != nil {
+		t.Fatalf("Service.GroupDelete() error = %v", err)
+	}
+	if err := srv.GroupCreate(context.Background(), "abc", domain.Group{Name: "workshop"}); err != nil {
+		t.Fatalf("Service.GroupCreate() error = %v", err)
+	}
+	page, err = srv.GroupGetDevices(context.Background(), "abc", "workshop", domain.DeviceQuery{})
+	if err != nil || len(page.Devices) != 0 {
+		t.Errorf("Service.GroupGetDevices() = %v, %v, want no devices", len(page.Devices), err)
+	}
+	if err := srv.GroupDelete(context.Background(), "abc", "does-not-exist"); err == nil {
+		t.Error("Service.GroupDelete() expected error for unknown group")
+	}
+}
+
 func TestService_GroupLinkWorkflow(t *testing.T) {
 	type args struct {
 		orgID    string
@@ -182,7 +432,7 @@ func TestService_GroupLinkWorkflow(t *testing.T) {
 			srv := NewService(config.TestConfig(), memory.NewStore())
 
 			// Get a group
-			group, err := srv.GroupGet(tt.args.orgID, tt.args.name)
+			group, err := srv.GroupGet(context.Background(), tt.args.orgID, tt.args.name)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Service.GroupGet() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -193,34 +443,34 @@ func TestService_GroupLinkWorkflow(t *testing.T) {
 			}
 
 			// Link a device to a group
-			if err := srv.GroupLinkDevice(tt.args.orgID, tt.args.name, tt.args.deviceID); (err != nil) != tt.wantErr {
+			if err := srv.GroupLinkDevice(context.Background(), tt.args.orgID, tt.args.name, tt.args.deviceID); (err != nil) != tt.wantErr {
 				t.Errorf("Service.GroupLinkDevice() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
 			// Get the devices for the group
-			devices, err := srv.GroupGetDevices(tt.args.orgID, tt.args.name)
+			devices, err := srv.GroupGetDevices(context.Background(), tt.args.orgID, tt.args.name, domain.DeviceQuery{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Service.GroupGetDevices() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			if len(devices) != tt.count {
-				t.Errorf("Service.GroupGetDevices() count = %v, wantErr %v", len(devices), tt.count)
+			if len(devices.Devices) != tt.count {
+				t.Errorf("Service.GroupGetDevices() count = %v, wantErr %v", len(devices.Devices), tt.count)
 			}
 
 			// Unlink a device from a group
-			if err := srv.GroupUnlinkDevice(tt.args.orgID, tt.args.name, tt.args.deviceID); (err != nil) != tt.wantErr {
+			if err := srv.GroupUnlinkDevice(context.Background(), tt.args.orgID, tt.args.name, tt.args.deviceID); (err != nil) != tt.wantErr {
 				t.Errorf("Service.GroupUnlinkDevice() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
 			// Get the devices for the group
-			devices2, err := srv.GroupGetDevices(tt.args.orgID, tt.args.name)
+			devices2, err := srv.GroupGetDevices(context.Background(), tt.args.orgID, tt.args.name, domain.DeviceQuery{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Service.GroupGetDevices() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if tt.count > 0 {
 				tt.count = tt.count - 1
 			}
-			if len(devices2) != tt.count {
-				t.Errorf("Service.GroupGetDevices() count = %v, wantErr %v", len(devices2), tt.count)
+			if len(devices2.Devices) != tt.count {
+				t.Errorf("Service.GroupGetDevices() count = %v, wantErr %v", len(devices2.Devices), tt.count)
 			}
 		})
 	}
@@ -0,0 +1,77 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package devicetwin
+
+import (
+	"context"
+	"github.com/canonical/iot-devicetwin/service/events"
+	"log"
+	"time"
+)
+
+// deviceChanged invalidates a cached device, and notifies the other instances
+func (srv *Service) deviceChanged(ctx context.Context, orgID, deviceID string) {
+	srv.invalidateDevice(deviceID)
+	srv.publish(ctx, events.Event{Kind: events.KindDevice, OrgID: orgID, DeviceID: deviceID})
+}
+
+// versionChanged invalidates the cached OS details of a device, and notifies the other instances
+func (srv *Service) versionChanged(ctx context.Context, orgID, deviceID string, id int64) {
+	srv.invalidateVersion(id)
+	srv.publish(ctx, events.Event{Kind: events.KindVersion, OrgID: orgID, DeviceID: deviceID, ID: id})
+}
+
+// devicesPinged sets the last refresh of the cached devices after it is written. The presence of
+// the devices is not broadcast, as every device sends a health message at each interval, so the
+// other instances see the last refresh once their cached records expire
+func (srv *Service) devicesPinged(refreshes map[string]time.Time) {
+	for id, refresh := range refreshes {
+		srv.refreshDevice(id, refresh)
+	}
+}
+
+// actionChanged notifies the instances of a new action or a change to its status
+func (srv *Service) actionChanged(ctx context.Context, orgID, deviceID, actionID, status string) {
+	srv.publish(ctx, events.Event{Kind: events.KindAction, OrgID: orgID, DeviceID: deviceID, ActionID: actionID, Status: status})
+}
+
+// publish sends an event, logging the failures as the change has already been made. The other
+// instances see the change once their cached records expire
+func (srv *Service) publish(ctx context.Context, e events.Event) {
+	e.Source = srv.Settings.MQTTConnect.ClientID
+	if err := srv.Events.Publish(ctx, e); err != nil {
+		log.Printf("Error publishing the %s event: %v", e.Kind, err)
+	}
+}
+
+// handleEvent invalidates the cached records that were changed by an instance
+func (srv *Service) handleEvent(e events.Event) {
+	switch e.Kind {
+	case events.KindDevice:
+		srv.invalidateDevice(e.DeviceID)
+		srv.heartbeats.forget(e.DeviceID)
+	case events.KindVersion:
+		srv.invalidateVersion(e.ID)
+	case events.KindReset:
+		srv.devices.clear()
+		srv.versions.clear()
+		srv.heartbeats.known.clear()
+	}
+}
@@ -0,0 +1,98 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package devicetwin
+
+import (
+	"context"
+	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/datastore/memory"
+	"github.com/canonical/iot-devicetwin/domain"
+	"github.com/canonical/iot-devicetwin/service/events"
+	"testing"
+	"time"
+)
+
+func TestService_Events(t *testing.T) {
+	settings := config.TestConfig()
+	settings.CacheSize = 10
+	settings.CacheTTL = time.Hour
+
+	// Two instances sharing a data store and an event bus
+	db := memory.NewStore()
+	bus := events.NewMemoryBus()
+	srv1 := NewServiceWithEvents(settings, db, bus)
+	srv2 := NewServiceWithEvents(settings, db, bus)
+
+	var published []events.Event
+	bus.Subscribe(func(e events.Event) { published = append(published, e) })
+
+	for _, srv := range []*Service{srv1, srv2} {
+		if _, err := srv.DeviceGet(context.Background(), "abc", "c333"); err != nil {
+			t.Fatalf("Service.DeviceGet() error = %v", err)
+		}
+	}
+
+	// A change by one instance is seen by the other
+	payload := []byte(`{"id":"a1", "action":"server", "success":true, "message":"", "result": {"deviceId":"c333", "osVersionId":"core-123", "series":"20", "kernelVersion":"kernel-123"}}`)
+	if err := srv2.ActionResponse(context.Background(), "c333", "a1", "server", payload); err != nil {
+		t.Fatalf("Service.ActionResponse() error = %v", err)
+	}
+	refresh := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	if err := srv2.HealthHandler(context.Background(), domain.Health{OrganizationID: "abc", DeviceID: "c333", Refresh: refresh}); err != nil {
+		t.Fatalf("Service.HealthHandler() error = %v", err)
+	}
+
+	device, err := srv1.DeviceGet(context.Background(), "abc", "c333")
+	if err != nil {
+		t.Fatalf("Service.DeviceGet() error = %v", err)
+	}
+	if device.Version.Series != "20" {
+		t.Errorf("Service.DeviceGet() = %+v, want the changes from the other instance", device)
+	}
+
+	// The last refresh is updated in the cache of the instance, without an event
+	device, err = srv2.DeviceGet(context.Background(), "abc", "c333")
+	if err != nil {
+		t.Fatalf("Service.DeviceGet() error = %v", err)
+	}
+	if !device.LastRefresh.Equal(refresh) || srv2.CacheStats().Devices.Size != 1 {
+		t.Errorf("Service.DeviceGet() = %+v, want the cached device with the last refresh", device)
+	}
+
+	kinds := []string{}
+	for _, e := range published {
+		kinds = append(kinds, e.Kind)
+	}
+	want := []string{events.KindVersion, events.KindAction}
+	if len(kinds) != len(want) || kinds[0] != want[0] || kinds[1] != want[1] {
+		t.Errorf("Service published = %v, want %v", kinds, want)
+	}
+	if published[0].Source != settings.MQTTConnect.ClientID || published[0].DeviceID != "c333" {
+		t.Errorf("Service published = %+v", published[0])
+	}
+
+	// A reset drops the cached records
+	if err := bus.Publish(context.Background(), events.Event{Kind: events.KindReset}); err != nil {
+		t.Fatalf("MemoryBus.Publish() error = %v", err)
+	}
+	if stats := srv1.CacheStats(); stats.Devices.Size != 0 || stats.Versions.Size != 0 {
+		t.Errorf("Service.CacheStats() = %+v, want empty caches after a reset", stats)
+	}
+}
@@ -19,78 +19,292 @@
 
 package devicetwin
 
-import "github.com/canonical/iot-devicetwin/domain"
+import (
+	"context"
+	"fmt"
+	"github.com/canonical/iot-devicetwin/datastore"
+	"github.com/canonical/iot-devicetwin/domain"
+)
 
-// GroupCreate creates a device group
-func (srv *Service) GroupCreate(orgID, name string) error {
-	_, err := srv.DB.GroupCreate(orgID, name)
+// GroupCreate creates a device group. A group with a rule is dynamic and its
+// members are the devices that match the rule
+func (srv *Service) GroupCreate(ctx context.Context, orgID string, group domain.Group) error {
+	g := datastore.Group{
+		OrganisationID: orgID,
+		Name:           group.Name,
+		Description:    group.Description,
+	}
+
+	if group.Rule != nil {
+		rule, err := dataGroupRule(group.Name, group.Rule)
+		if err != nil {
+			return err
+		}
+		g.Rule = rule
+	}
+
+	if len(group.Parent) > 0 {
+		parent, err := srv.DB.GroupGet(ctx, orgID, group.Parent)
+		if err != nil {
+			return fmt.Errorf("error finding parent group: %w", err)
+		}
+		g.ParentID = parent.ID
+	}
+
+	_, err := srv.DB.GroupCreate(ctx, g)
 	return err
 }
 
+// GroupUpdate renames a group or changes its description, parent or rule. The description
+// and parent are replaced, while an empty name or rule keeps the current one. The rule can
+// only be changed for a dynamic group, as a static group is defined by its device links
+func (srv *Service) GroupUpdate(ctx context.Context, orgID, name string, group domain.Group) error {
+	groups, err := srv.DB.GroupList(ctx, orgID)
+	if err != nil {
+		return err
+	}
+
+	g, err := srv.DB.GroupGet(ctx, orgID, name)
+	if err != nil {
+		return err
+	}
+
+	if len(group.Name) > 0 {
+		g.Name = group.Name
+	}
+	g.Description = group.Description
+
+	g.ParentID = 0
+	if len(group.Parent) > 0 {
+		parent, err := srv.DB.GroupGet(ctx, orgID, group.Parent)
+		if err != nil {
+			return fmt.Errorf("error finding parent group: %w", err)
+		}
+
+		// The parent cannot be the group itself or one of its descendants
+		for _, child := range datastore.GroupSubtree(groups, g.ID) {
+			if child.ID == parent.ID {
+				return datastore.Invalid("group `%s` cannot be a child of `%s`", name, group.Parent)
+			}
+		}
+		g.ParentID = parent.ID
+	}
+
+	if group.Rule != nil {
+		if !g.Rule.Dynamic() {
+			return datastore.Invalid("group `%s` is static, its rule cannot be set", name)
+		}
+		rule, err := dataGroupRule(name, group.Rule)
+		if err != nil {
+			return err
+		}
+		g.Rule = rule
+	}
+
+	return srv.DB.GroupUpdate(ctx, g)
+}
+
+// GroupDelete deletes a device group, unlinking its devices
+func (srv *Service) GroupDelete(ctx context.Context, orgID, name string) error {
+	return srv.DB.GroupDelete(ctx, orgID, name)
+}
+
+// dataGroupRule validates and converts the rule of a dynamic group
+func dataGroupRule(name string, r *domain.GroupRule) (datastore.GroupRule, error) {
+	rule := datastore.GroupRule{
+		Brand:         r.Brand,
+		Model:         r.Model,
+		Series:        r.Series,
+		Snap:          r.Snap,
+		LabelSelector: r.LabelSelector,
+	}
+	if !rule.Dynamic() {
+		return rule, datastore.Invalid("the rule for group `%s` must have at least one criterion", name)
+	}
+	q, err := rule.Query()
+	if err != nil {
+		return rule, datastore.Invalid("invalid rule for group `%s`: %v", name, err)
+	}
+
+	// A label selector of only separators parses to no requirements, which would match every device
+	if len(q.Brand) == 0 && len(q.Model) == 0 && len(q.Series) == 0 && len(q.Snap) == 0 && len(q.Labels) == 0 {
+		return rule, datastore.Invalid("the rule for group `%s` must have at least one criterion", name)
+	}
+	return rule, nil
+}
+
+// groupFromData converts a group record to the domain group, using the groups
+// of the organization to find the name of its parent
+func groupFromData(g datastore.Group, groups []datastore.Group) domain.Group {
+	group := domain.Group{
+		OrganizationID: g.OrganisationID,
+		Name:           g.Name,
+		Description:    g.Description,
+	}
+	for _, p := range groups {
+		if g.ParentID > 0 && p.ID == g.ParentID {
+			group.Parent = p.Name
+		}
+	}
+	if g.Rule.Dynamic() {
+		group.Rule = &domain.GroupRule{
+			Brand:         g.Rule.Brand,
+			Model:         g.Rule.Model,
+			Series:        g.Rule.Series,
+			Snap:          g.Rule.Snap,
+			LabelSelector: g.Rule.LabelSelector,
+		}
+	}
+	return group
+}
+
+// staticGroup checks that the membership of a group is managed by linking devices
+func (srv *Service) staticGroup(ctx context.Context, orgID, name string) error {
+	g, err := srv.DB.GroupGet(ctx, orgID, name)
+	if err != nil {
+		return err
+	}
+	if g.Rule.Dynamic() {
+		return datastore.Invalid("group `%s` is dynamic, its devices cannot be linked or unlinked", name)
+	}
+	return nil
+}
+
 // GroupList lists groups for an organization
-func (srv *Service) GroupList(orgID string) ([]domain.Group, error) {
-	gg, err := srv.DB.GroupList(orgID)
+func (srv *Service) GroupList(ctx context.Context, orgID string) ([]domain.Group, error) {
+	gg, err := srv.DB.GroupList(ctx, orgID)
 	if err != nil {
 		return nil, err
 	}
 
 	groups := []domain.Group{}
 	for _, g := range gg {
-		groups = append(groups, domain.Group{
-			OrganizationID: g.OrganisationID,
-			Name:           g.Name,
-		})
+		groups = append(groups, groupFromData(g, gg))
 	}
 	return groups, nil
 }
 
 // GroupGet retrieves a device group
-func (srv *Service) GroupGet(orgID, name string) (domain.Group, error) {
-	g, err := srv.DB.GroupGet(orgID, name)
+func (srv *Service) GroupGet(ctx context.Context, orgID, name string) (domain.Group, error) {
+	g, err := srv.DB.GroupGet(ctx, orgID, name)
 	if err != nil {
 		return domain.Group{}, err
 	}
 
-	return domain.Group{
-		OrganizationID: g.OrganisationID,
-		Name:           g.Name,
-	}, nil
+	groups, err := srv.DB.GroupList(ctx, orgID)
+	if err != nil {
+		return domain.Group{}, err
+	}
+
+	return groupFromData(g, groups), nil
 }
 
 // GroupLinkDevice links a device to a group
-func (srv *Service) GroupLinkDevice(orgID, name, clientID string) error {
-	return srv.DB.GroupLinkDevice(orgID, name, clientID)
+func (srv *Service) GroupLinkDevice(ctx context.Context, orgID, name, clientID string) error {
+	if err := srv.staticGroup(ctx, orgID, name); err != nil {
+		return err
+	}
+	return srv.DB.GroupLinkDevice(ctx, orgID, name, clientID)
 }
 
 // GroupUnlinkDevice unlinks a device from a group
-func (srv *Service) GroupUnlinkDevice(orgID, name, clientID string) error {
-	return srv.DB.GroupUnlinkDevice(orgID, name, clientID)
+func (srv *Service) GroupUnlinkDevice(ctx context.Context, orgID, name, clientID string) error {
+	if err := srv.staticGroup(ctx, orgID, name); err != nil {
+		return err
+	}
+	return srv.DB.GroupUnlinkDevice(ctx, orgID, name, clientID)
 }
 
-// GroupGetDevices retrieves the devices from a group
-func (srv *Service) GroupGetDevices(orgID, name string) ([]domain.Device, error) {
-	dd, err := srv.DB.GroupGetDevices(orgID, name)
+// GroupLinkDevices links the devices that match a label selector to a group
+func (srv *Service) GroupLinkDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (int, error) {
+	q, err := bulkDeviceQuery(query)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+
+	if err := srv.staticGroup(ctx, orgID, name); err != nil {
+		return 0, err
 	}
 
-	devices := []domain.Device{}
-	for _, d := range dd {
-		devices = append(devices, dataToDomainDevice(d))
+	devices, err := srv.DB.GroupGetExcludedDevices(ctx, orgID, name, q)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, d := range devices {
+		if err := srv.DB.GroupLinkDevice(ctx, orgID, name, d.DeviceID); err != nil {
+			return i, err
+		}
 	}
-	return devices, nil
+	return len(devices), nil
 }
 
-// GroupGetExcludedDevices retrieves the devices not in a group
-func (srv *Service) GroupGetExcludedDevices(orgID, name string) ([]domain.Device, error) {
-	dd, err := srv.DB.GroupGetExcludedDevices(orgID, name)
+// GroupUnlinkDevices unlinks the devices that match a label selector from a group. Only the
+// devices that are linked to the group itself are unlinked and counted, not the members of its
+// child groups
+func (srv *Service) GroupUnlinkDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (int, error) {
+	q, err := bulkDeviceQuery(query)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	devices := []domain.Device{}
-	for _, d := range dd {
-		devices = append(devices, dataToDomainDevice(d))
+	if err := srv.staticGroup(ctx, orgID, name); err != nil {
+		return 0, err
 	}
-	return devices, nil
+	group, err := srv.DB.GroupGet(ctx, orgID, name)
+	if err != nil {
+		return 0, err
+	}
+	links, err := srv.DB.GroupLinkList(ctx, orgID)
+	if err != nil {
+		return 0, err
+	}
+	linked := map[int64]bool{}
+	for _, l := range links {
+		if l.GroupID == group.ID {
+			linked[l.DeviceID] = true
+		}
+	}
+
+	devices, err := srv.DB.GroupGetDevices(ctx, orgID, name, q)
+	if err != nil {
+		return 0, err
+	}
+
+	unlinked := 0
+	for _, d := range devices {
+		if !linked[d.ID] {
+			continue
+		}
+		if err := srv.DB.GroupUnlinkDevice(ctx, orgID, name, d.DeviceID); err != nil {
+			return unlinked, err
+		}
+		unlinked++
+	}
+	return unlinked, nil
+}
+
+// bulkDeviceQuery converts the query for a bulk operation, which applies to every matching device
+func bulkDeviceQuery(query domain.DeviceQuery) (datastore.DeviceQuery, error) {
+	if len(query.LabelSelector) == 0 {
+		return datastore.DeviceQuery{}, datastore.Invalid("a label selector is required for a bulk operation")
+	}
+
+	query.Cursor = ""
+	query.Limit = 0
+	return dataDeviceQuery(query)
+}
+
+// GroupGetDevices retrieves the devices from a group
+func (srv *Service) GroupGetDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (domain.DevicePage, error) {
+	return listDevices(query, func(q datastore.DeviceQuery) ([]datastore.Device, error) {
+		return srv.DB.GroupGetDevices(ctx, orgID, name, q)
+	})
+}
+
+// GroupGetExcludedDevices retrieves the devices not in a group
+func (srv *Service) GroupGetExcludedDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (domain.DevicePage, error) {
+	return listDevices(query, func(q datastore.DeviceQuery) ([]datastore.Device, error) {
+		return srv.DB.GroupGetExcludedDevices(ctx, orgID, name, q)
+	})
 }
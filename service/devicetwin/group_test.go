@@ -20,8 +20,11 @@
 package devicetwin
 
 import (
+	"context"
 	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/datastore"
 	"github.com/canonical/iot-devicetwin/datastore/memory"
+	"github.com/canonical/iot-devicetwin/domain"
 	"testing"
 )
 
@@ -42,14 +45,88 @@ func TestService_GroupGetExcludedDevices(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(config.TestConfig(), memory.NewStore())
-			got, err := srv.GroupGetExcludedDevices(tt.args.orgID, tt.args.name)
+			got, err := srv.GroupGetExcludedDevices(context.Background(), tt.args.orgID, tt.args.name, domain.DeviceQuery{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Service.GroupGetExcludedDevices() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if len(got) != tt.want {
-				t.Errorf("Service.GroupGetExcludedDevices() = %v, want %v", len(got), tt.want)
+			if len(got.Devices) != tt.want {
+				t.Errorf("Service.GroupGetExcludedDevices() = %v, want %v", len(got.Devices), tt.want)
 			}
 		})
 	}
 }
+
+func TestService_GroupLinkDevices(t *testing.T) {
+	type args struct {
+		orgID string
+		name  string
+		query domain.DeviceQuery
+	}
+	tests := []struct {
+		name       string
+		args       args
+		linked     int
+		unlinked   int
+		groupCount int
+		wantErr    bool
+	}{
+		{"valid", args{"abc", "workshop", domain.DeviceQuery{LabelSelector: "site in (berlin,london)"}}, 1, 2, 0, false},
+		{"valid-no-match", args{"abc", "workshop", domain.DeviceQuery{LabelSelector: "site=paris"}}, 0, 0, 1, false},
+		{"invalid-no-selector", args{"abc", "workshop", domain.DeviceQuery{}}, 0, 0, 1, true},
+		{"invalid-selector", args{"abc", "workshop", domain.DeviceQuery{LabelSelector: "site in ("}}, 0, 0, 1, true},
+		{"invalid-group", args{"abc", "does-not-exist", domain.DeviceQuery{LabelSelector: "site"}}, 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewService(config.TestConfig(), memory.NewStore())
+			got, err := srv.GroupLinkDevices(context.Background(), tt.args.orgID, tt.args.name, tt.args.query)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Service.GroupLinkDevices() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.linked {
+				t.Errorf("Service.GroupLinkDevices() = %v, want %v", got, tt.linked)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got, err = srv.GroupUnlinkDevices(context.Background(), tt.args.orgID, tt.args.name, tt.args.query)
+			if err != nil {
+				t.Errorf("Service.GroupUnlinkDevices() error = %v", err)
+			}
+			if got != tt.unlinked {
+				t.Errorf("Service.GroupUnlinkDevices() = %v, want %v", got, tt.unlinked)
+			}
+
+			devices, _ := srv.GroupGetDevices(context.Background(), tt.args.orgID, tt.args.name, domain.DeviceQuery{})
+			if len(devices.Devices) != tt.groupCount {
+				t.Errorf("Service.GroupGetDevices() = %v, want %v", len(devices.Devices), tt.groupCount)
+			}
+		})
+	}
+}
+
+func TestService_GroupUnlinkDevicesChild(t *testing.T) {
+	srv := NewService(config.TestConfig(), memory.NewStore())
+	ctx := context.Background()
+
+	// b222 is a member of the workshop through a child group
+	if _, err := srv.DB.GroupCreate(ctx, datastore.Group{OrganisationID: "abc", Name: "bench", ParentID: 1}); err != nil {
+		t.Fatalf("GroupCreate() error = %v", err)
+	}
+	if err := srv.DB.GroupLinkDevice(ctx, "abc", "bench", "b222"); err != nil {
+		t.Fatalf("GroupLinkDevice() error = %v", err)
+	}
+
+	got, err := srv.GroupUnlinkDevices(ctx, "abc", "workshop", domain.DeviceQuery{LabelSelector: "site"})
+	if err != nil || got != 1 {
+		t.Errorf("Service.GroupUnlinkDevices() = %v, %v, want only the linked device", got, err)
+	}
+
+	devices, _ := srv.GroupGetDevices(ctx, "abc", "workshop", domain.DeviceQuery{})
+	if len(devices.Devices) != 1 || devices.Devices[0].DeviceID != "b222" {
+		t.Errorf("Service.GroupGetDevices() = %v, want the device of the child group", devices.Devices)
+	}
+}
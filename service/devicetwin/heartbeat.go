@@ -0,0 +1,161 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package devicetwin
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// heartbeatBufferSize is the number of devices with a buffered heartbeat that triggers a write
+// before the end of the heartbeat interval
+const heartbeatBufferSize = 10000
+
+// knownDeviceTTL is how long a device stays known after its last health message, which is longer
+// than the interval at which the devices send their health messages
+const knownDeviceTTL = time.Hour
+
+// heartbeats buffers the last refresh of the devices from their health messages, so they are
+// written to the data store in batches. It also caches the IDs of the devices that are known to
+// be in the data store, so their health messages do not need a lookup. The known devices are
+// bounded by the size of the device cache, and are forgotten when they stop reporting
+type heartbeats struct {
+	lock    sync.Mutex
+	pending map[string]time.Time
+	known   *lruCache
+	full    chan struct{}
+}
+
+func newHeartbeats(size int) *heartbeats {
+	return &heartbeats{
+		pending: map[string]time.Time{},
+		known:   newLRUCache(size, knownDeviceTTL),
+		full:    make(chan struct{}, 1),
+	}
+}
+
+// isKnown checks if a device is known to be in the data store
+func (hb *heartbeats) isKnown(deviceID string) bool {
+	_, ok := hb.known.get(deviceID)
+	return ok
+}
+
+// forget drops a known device, e.g. after it has been deleted
+func (hb *heartbeats) forget(deviceID string) {
+	hb.known.remove(deviceID)
+}
+
+// add buffers the heartbeat of a known device, keeping the latest refresh of the device
+func (hb *heartbeats) add(deviceID string, refresh time.Time) {
+	hb.known.put(deviceID, true)
+
+	hb.lock.Lock()
+	defer hb.lock.Unlock()
+
+	if last, ok := hb.pending[deviceID]; ok && last.After(refresh) {
+		return
+	}
+	hb.pending[deviceID] = refresh
+
+	if len(hb.pending) >= heartbeatBufferSize {
+		select {
+		case hb.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// take removes the buffered heartbeats
+func (hb *heartbeats) take() map[string]time.Time {
+	hb.lock.Lock()
+	defer hb.lock.Unlock()
+
+	pending := hb.pending
+	hb.pending = map[string]time.Time{}
+	return pending
+}
+
+// restore buffers heartbeats again after a failed write, unless there is a later heartbeat
+func (hb *heartbeats) restore(pending map[string]time.Time) {
+	hb.lock.Lock()
+	defer hb.lock.Unlock()
+
+	for id, refresh := range pending {
+		if last, ok := hb.pending[id]; !ok || refresh.After(last) {
+			hb.pending[id] = refresh
+		}
+	}
+}
+
+// FlushHeartbeats writes the buffered heartbeats of the devices to the data store. The heartbeats
+// are kept in the buffer if the write fails, so they are written with the next batch
+func (srv *Service) FlushHeartbeats(ctx context.Context) error {
+	pending := srv.heartbeats.take()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if err := srv.DB.DevicePingBatch(ctx, pending); err != nil {
+		srv.heartbeats.restore(pending)
+		return err
+	}
+	srv.devicesPinged(pending)
+	return nil
+}
+
+// RunHeartbeats writes the buffered heartbeats at the heartbeat interval, or sooner when the buffer
+// is full, until the context is cancelled. The remaining heartbeats are written before it returns
+func (srv *Service) RunHeartbeats(ctx context.Context) {
+	if srv.Settings.Heartbeat <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(srv.Settings.Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			srv.flushOnShutdown()
+			return
+		case <-ticker.C:
+		case <-srv.heartbeats.full:
+		}
+
+		if err := srv.FlushHeartbeats(ctx); err != nil {
+			log.Printf("Error writing the device heartbeats: %v", err)
+		}
+	}
+}
+
+// flushOnShutdown writes the remaining heartbeats, with the deadline for handling a message
+// as the context of the service has been cancelled
+func (srv *Service) flushOnShutdown() {
+	ctx, cancel := context.WithCancel(context.Background())
+	if srv.Settings.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), srv.Settings.Timeout)
+	}
+	defer cancel()
+
+	if err := srv.FlushHeartbeats(ctx); err != nil {
+		log.Printf("Error writing the device heartbeats on shutdown: %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package devicetwin
+
+import (
+	"context"
+	"fmt"
+	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/datastore"
+	"github.com/canonical/iot-devicetwin/datastore/memory"
+	"github.com/canonical/iot-devicetwin/domain"
+	"github.com/canonical/iot-devicetwin/service/events"
+	"testing"
+	"time"
+)
+
+// countingStore counts the device lookups and ping batches, and can fail the ping batches
+type countingStore struct {
+	datastore.DataStore
+	gets      int
+	batches   int
+	failBatch bool
+}
+
+func (db *countingStore) DeviceGet(ctx context.Context, id string) (datastore.Device, error) {
+	db.gets++
+	return db.DataStore.DeviceGet(ctx, id)
+}
+
+func (db *countingStore) DevicePingBatch(ctx context.Context, refreshes map[string]time.Time) error {
+	if db.failBatch {
+		return fmt.Errorf("MOCK error writing the heartbeats")
+	}
+	db.batches++
+	return db.DataStore.DevicePingBatch(ctx, refreshes)
+}
+
+func heartbeatService() (*Service, *countingStore) {
+	settings := config.TestConfig()
+	settings.Heartbeat = time.Hour
+	settings.CacheSize = 10
+	db := &countingStore{DataStore: memory.NewStore()}
+	return NewService(settings, db), db
+}
+
+func checkRefresh(t *testing.T, db datastore.DataStore, deviceID string, want time.Time) {
+	t.Helper()
+	device, err := db.DeviceGet(context.Background(), deviceID)
+	if err != nil {
+		t.Fatalf("DeviceGet() error = %v", err)
+	}
+	if !device.LastRefresh.Equal(want) {
+		t.Errorf("last refresh of %s = %v, want %v", deviceID, device.LastRefresh, want)
+	}
+}
+
+func TestService_HealthHandlerBatched(t *testing.T) {
+	srv, db := heartbeatService()
+	t1 := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Minute)
+
+	health := []struct {
+		payload domain.Health
+		wantErr bool
+	}{
+		{domain.Health{OrganizationID: "abc", DeviceID: "a111", Refresh: t1}, false},
+		{domain.Health{OrganizationID: "abc", DeviceID: "a111", Refresh: t2}, false},
+		{domain.Health{OrganizationID: "abc", DeviceID: "b222", Refresh: t2}, false},
+		{domain.Health{OrganizationID: "abc", DeviceID: "b222", Refresh: t1}, false},
+		{domain.Health{OrganizationID: "abc", DeviceID: "invalid", Refresh: t1}, true},
+		{domain.Health{OrganizationID: "abc", DeviceID: "invalid", Refresh: t2}, true},
+	}
+	for _, h := range health {
+		if err := srv.HealthHandler(context.Background(), h.payload); (err != nil) != h.wantErr {
+			t.Errorf("Service.HealthHandler() error = %v, wantErr %v", err, h.wantErr)
+		}
+	}
+
+	// The known devices are only looked up once, and the unknown device every time
+	if db.gets != 4 {
+		t.Errorf("Service.HealthHandler() device lookups = %d, want 4", db.gets)
+	}
+	if db.batches != 0 {
+		t.Errorf("Service.HealthHandler() wrote %d batches before the flush", db.batches)
+	}
+
+	if err := srv.FlushHeartbeats(context.Background()); err != nil {
+		t.Fatalf("Service.FlushHeartbeats() error = %v", err)
+	}
+	if err := srv.FlushHeartbeats(context.Background()); err != nil {
+		t.Fatalf("Service.FlushHeartbeats() error = %v", err)
+	}
+	if db.batches != 1 {
+		t.Errorf("Service.FlushHeartbeats() batches = %d, want 1", db.batches)
+	}
+	checkRefresh(t, db.DataStore, "a111", t2)
+	checkRefresh(t, db.DataStore, "b222", t2)
+
+	// The known devices are not looked up again after the write
+	if err := srv.HealthHandler(context.Background(), domain.Health{OrganizationID: "abc", DeviceID: "a111", Refresh: t2}); err != nil {
+		t.Errorf("Service.HealthHandler() error = %v", err)
+	}
+	if db.gets != 4 {
+		t.Errorf("Service.HealthHandler() device lookups after the flush = %d, want 4", db.gets)
+	}
+
+	// A changed or deleted device is looked up again, as are all the devices after a reset
+	srv.handleEvent(events.Event{Kind: events.KindDevice, OrgID: "abc", DeviceID: "a111"})
+	_ = srv.HealthHandler(context.Background(), domain.Health{OrganizationID: "abc", DeviceID: "a111", Refresh: t2})
+	_ = srv.HealthHandler(context.Background(), domain.Health{OrganizationID: "abc", DeviceID: "b222", Refresh: t2})
+	if db.gets != 5 {
+		t.Errorf("Service.HealthHandler() device lookups after a device event = %d, want 5", db.gets)
+	}
+	srv.handleEvent(events.Event{Kind: events.KindReset})
+	_ = srv.HealthHandler(context.Background(), domain.Health{OrganizationID: "abc", DeviceID: "b222", Refresh: t2})
+	if db.gets != 6 {
+		t.Errorf("Service.HealthHandler() device lookups after a reset = %d, want 6", db.gets)
+	}
+}
+
+func TestHeartbeats_KnownBounded(t *testing.T) {
+	hb := newHeartbeats(2)
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	hb.known.now = func() time.Time { return now }
+
+	for _, id := range []string{"a111", "b222", "c333"} {
+		hb.add(id, now)
+	}
+	if hb.isKnown("a111") || !hb.isKnown("b222") || !hb.isKnown("c333") {
+		t.Error("heartbeats.isKnown() expected the least recently reported device to be dropped")
+	}
+
+	now = now.Add(knownDeviceTTL)
+	if hb.isKnown("c333") {
+		t.Error("heartbeats.isKnown() expected a device that stopped reporting to be forgotten")
+	}
+}
+
+func TestService_FlushHeartbeatsError(t *testing.T) {
+	srv, db := heartbeatService()
+	t1 := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Minute)
+
+	_ = srv.HealthHandler(context.Background(), domain.Health{OrganizationID: "abc", DeviceID: "a111", Refresh: t2})
+	_ = srv.HealthHandler(context.Background(), domain.Health{OrganizationID: "abc", DeviceID: "b222", Refresh: t1})
+
+	db.failBatch = true
+	if err := srv.FlushHeartbeats(context.Background()); err == nil {
+		t.Fatal("Service.FlushHeartbeats() expected an error")
+	}
+
+	// The failed heartbeats are written with the next batch, unless there is a later one
+	_ = srv.HealthHandler(context.Background(), domain.Health{OrganizationID: "abc", DeviceID: "a111", Refresh: t1})
+	_ = srv.HealthHandler(context.Background(), domain.Health{OrganizationID: "abc", DeviceID: "b222", Refresh: t2})
+	db.failBatch = false
+	if err := srv.FlushHeartbeats(context.Background()); err != nil {
+		t.Fatalf("Service.FlushHeartbeats() error = %v", err)
+	}
+	checkRefresh(t, db.DataStore, "a111", t2)
+	checkRefresh(t, db.DataStore, "b222", t2)
+}
+
+func TestService_RunHeartbeats(t *testing.T) {
+	srv, db := heartbeatService()
+	refresh := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.RunHeartbeats(ctx)
+		close(done)
+	}()
+
+	if err := srv.HealthHandler(context.Background(), domain.Health{OrganizationID: "abc", DeviceID: "a111", Refresh: refresh}); err != nil {
+		t.Fatalf("Service.HealthHandler() error = %v", err)
+	}
+
+	// The buffered heartbeats are written when the service stops
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Service.RunHeartbeats() did not stop")
+	}
+	checkRefresh(t, db.DataStore, "a111", refresh)
+}
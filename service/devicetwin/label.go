@@ -0,0 +1,70 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package devicetwin
+
+import (
+	"context"
+	"github.com/canonical/iot-devicetwin/datastore"
+)
+
+// DeviceLabelsSet creates or updates labels on a device
+func (srv *Service) DeviceLabelsSet(ctx context.Context, orgID, clientID string, labels map[string]string) error {
+	device, err := srv.deviceForOrg(ctx, orgID, clientID)
+	if err != nil {
+		return err
+	}
+
+	// Validate all the labels before making any changes
+	for k, v := range labels {
+		if err := datastore.ValidateLabel(k, v); err != nil {
+			return err
+		}
+	}
+
+	for k, v := range labels {
+		if err := srv.DB.DeviceLabelSet(ctx, device.ID, k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeviceLabelDelete removes a label from a device
+func (srv *Service) DeviceLabelDelete(ctx context.Context, orgID, clientID, key string) error {
+	device, err := srv.deviceForOrg(ctx, orgID, clientID)
+	if err != nil {
+		return err
+	}
+
+	return srv.DB.DeviceLabelDelete(ctx, device.ID, key)
+}
+
+// deviceForOrg fetches a device, checking that it belongs to the organization
+func (srv *Service) deviceForOrg(ctx context.Context, orgID, clientID string) (datastore.Device, error) {
+	device, err := srv.device(ctx, clientID)
+	if err != nil {
+		return device, err
+	}
+
+	if device.OrganisationID != orgID {
+		return device, datastore.Forbidden("the organization ID does not match the device")
+	}
+	return device, nil
+}
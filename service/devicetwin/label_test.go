@@ -0,0 +1,79 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package devicetwin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/datastore/memory"
+)
+
+func TestService_DeviceLabelsSet(t *testing.T) {
+	type args struct {
+		orgID    string
+		clientID string
+		labels   map[string]string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"valid", args{"abc", "c333", map[string]string{"site": "paris", "hw-rev": "3"}}, false},
+		{"invalid-label", args{"abc", "c333", map[string]string{"site": "not valid"}}, true},
+		{"invalid-device", args{"abc", "invalid", map[string]string{"site": "paris"}}, true},
+		{"invalid-orgid", args{"invalid", "c333", map[string]string{"site": "paris"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewService(config.TestConfig(), memory.NewStore())
+			if err := srv.DeviceLabelsSet(context.Background(), tt.args.orgID, tt.args.clientID, tt.args.labels); (err != nil) != tt.wantErr {
+				t.Errorf("Service.DeviceLabelsSet() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			device, err := srv.DeviceGet(context.Background(), tt.args.orgID, tt.args.clientID)
+			if err != nil {
+				t.Errorf("Service.DeviceGet() error = %v", err)
+				return
+			}
+			for k, v := range tt.args.labels {
+				if device.Labels[k] != v {
+					t.Errorf("Service.DeviceGet() label %s = %v, want %v", k, device.Labels[k], v)
+				}
+			}
+
+			for k := range tt.args.labels {
+				if err := srv.DeviceLabelDelete(context.Background(), tt.args.orgID, tt.args.clientID, k); err != nil {
+					t.Errorf("Service.DeviceLabelDelete() error = %v", err)
+				}
+			}
+			device, _ = srv.DeviceGet(context.Background(), tt.args.orgID, tt.args.clientID)
+			if len(device.Labels) != 0 {
+				t.Errorf("Service.DeviceLabelDelete() labels = %v, want none", device.Labels)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package devicetwin
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/canonical/iot-devicetwin/datastore"
+	"github.com/canonical/iot-devicetwin/domain"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"time"
+)
+
+// purgeBatchSize is the number of actions archived and removed at a time
+var purgeBatchSize = 1000
+
+// RunPurge purges the expired actions at the retention interval, until the context is cancelled
+func (srv *Service) RunPurge(ctx context.Context) {
+	r := srv.Settings.Retention
+	if r.Interval <= 0 || (!r.Default.Enabled() && len(r.Orgs) == 0) {
+		return
+	}
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		n, err := srv.PurgeActions(ctx)
+		if err != nil {
+			log.Printf("Error purging the action log: %v", err)
+		} else if n > 0 {
+			log.Printf("Purged %d actions from the action log", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeActions removes the actions that are outside the retention policy of their organization,
+// archiving them first when an archive directory is configured. Returns the number of actions removed
+func (srv *Service) PurgeActions(ctx context.Context) (int, error) {
+	orgs, err := srv.DB.ActionOrgList(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	count := 0
+	for _, orgID := range orgs {
+		policy := srv.Settings.Retention.Policy(orgID)
+		if !policy.Enabled() {
+			continue
+		}
+
+		var before time.Time
+		if policy.Days > 0 {
+			before = now.AddDate(0, 0, -policy.Days)
+		}
+		// The expired actions are archived and removed a page at a time, so the first page
+		// of the remaining actions is the next one
+		for page := 1; ; page++ {
+			actions, err := srv.DB.ActionListExpired(ctx, orgID, before, policy.Actions, purgeBatchSize)
+			if err != nil {
+				return count, fmt.Errorf("error finding the expired actions for `%s`: %w", orgID, err)
+			}
+			if len(actions) == 0 {
+				break
+			}
+
+			// The actions are only removed once they are safely archived
+			if dir := srv.Settings.Retention.ArchiveDir; len(dir) > 0 {
+				if err := archiveActions(dir, orgID, now, page, actions); err != nil {
+					return count, fmt.Errorf("error archiving the actions for `%s`: %w", orgID, err)
+				}
+			}
+
+			ids := []int64{}
+			for _, a := range actions {
+				ids = append(ids, a.ID)
+			}
+			if err := srv.DB.ActionDelete(ctx, ids); err != nil {
+				return count, fmt.Errorf("error removing the actions for `%s`: %w", orgID, err)
+			}
+			count += len(ids)
+
+			if len(actions) < purgeBatchSize {
+				break
+			}
+		}
+	}
+
+	return count, nil
+}
+
+// archiveActions writes a page of actions to a compressed JSON-lines file in the organization's
+// directory, named after the time of the purge and the page
+func archiveActions(dir, orgID string, now time.Time, page int, actions []datastore.Action) error {
+	orgDir := path.Join(dir, url.PathEscape(orgID))
+	if err := os.MkdirAll(orgDir, 0700); err != nil {
+		return err
+	}
+
+	p := path.Join(orgDir, fmt.Sprintf("actions-%s-%d.jsonl.gz", now.UTC().Format("20060102T150405.000000000Z"), page))
+	tmp := p + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := writeActions(f, actions); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// writeActions compresses the actions as JSON lines and syncs the file to disk
+func writeActions(f *os.File, actions []datastore.Action) error {
+	zw := gzip.NewWriter(f)
+	enc := json.NewEncoder(zw)
+	for _, a := range actions {
+		err := enc.Encode(domain.Action{
+			Created:        a.Created,
+			Modified:       a.Modified,
+			OrganizationID: a.OrganizationID,
+			DeviceID:       a.DeviceID,
+			ActionID:       a.ActionID,
+			Action:         a.Action,
+			Status:         a.Status,
+			Message:        a.Message,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
@@ -0,0 +1,236 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package devicetwin
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/datastore"
+	"github.com/canonical/iot-devicetwin/datastore/memory"
+	"github.com/canonical/iot-devicetwin/domain"
+	"github.com/canonical/iot-devicetwin/service/leader"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestService_PurgeActions(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		retention config.Retention
+		want      int
+		remaining map[string]int
+	}{
+		{"disabled", config.Retention{}, 0, map[string]int{"a111": 3, "b222": 1, "c333": 1}},
+		{"days", config.Retention{Default: config.RetentionPolicy{Days: 7}}, 3, map[string]int{"a111": 2, "b222": 0, "c333": 0}},
+		{"actions", config.Retention{Default: config.RetentionPolicy{Actions: 1}}, 2, map[string]int{"a111": 1, "b222": 1, "c333": 1}},
+		{"org", config.Retention{Orgs: map[string]config.RetentionPolicy{"xyz": {Days: 7}}}, 1, map[string]int{"a111": 3, "b222": 1, "c333": 0}},
+		{"org-replaces-default", config.Retention{Default: config.RetentionPolicy{Days: 7}, Orgs: map[string]config.RetentionPolicy{"abc": {Actions: 2}}}, 2,
+			map[string]int{"a111": 2, "b222": 1, "c333": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := memory.NewEmptyStore()
+			for _, a := range []datastore.Action{
+				{OrganizationID: "abc", DeviceID: "a111", ActionID: "a1", Created: now.AddDate(0, 0, -9)},
+				{OrganizationID: "abc", DeviceID: "a111", ActionID: "a2", Created: now.AddDate(0, 0, -5)},
+				{OrganizationID: "abc", DeviceID: "a111", ActionID: "a3", Created: now.AddDate(0, 0, -1)},
+				{OrganizationID: "abc", DeviceID: "b222", ActionID: "b1", Created: now.AddDate(0, 0, -8)},
+				{OrganizationID: "xyz", DeviceID: "c333", ActionID: "c1", Created: now.AddDate(0, 0, -9)},
+			} {
+				_, _ = db.ActionCreate(context.Background(), a)
+			}
+
+			settings := config.TestConfig()
+			settings.Retention = tt.retention
+			srv := NewService(settings, db)
+
+			got, err := srv.PurgeActions(context.Background())
+			if err != nil {
+				t.Fatalf("PurgeActions() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("PurgeActions() = %v, want %v", got, tt.want)
+			}
+			for _, a := range []struct{ orgID, deviceID string }{{"abc", "a111"}, {"abc", "b222"}, {"xyz", "c333"}} {
+				actions, _ := db.ActionListForDevice(context.Background(), a.orgID, a.deviceID, datastore.ActionQuery{})
+				if len(actions) != tt.remaining[a.deviceID] {
+					t.Errorf("PurgeActions() left %d actions for %s, want %d", len(actions), a.deviceID, tt.remaining[a.deviceID])
+				}
+			}
+		})
+	}
+}
+
+func TestService_PurgeActionsArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devicetwin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := memory.NewStore()
+	settings := config.TestConfig()
+	settings.Retention = config.Retention{Default: config.RetentionPolicy{Actions: 1}, ArchiveDir: dir}
+	srv := NewService(settings, db)
+
+	got, err := srv.PurgeActions(context.Background())
+	if err != nil || got != 1 {
+		t.Fatalf("PurgeActions() = %v, %v, want 1", got, err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "abc", "actions-*.jsonl.gz"))
+	if len(files) != 1 {
+		t.Fatalf("PurgeActions() archived %d files, want 1", len(files))
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Error reading archive: %v", err)
+	}
+
+	lines := 0
+	scanner := bufio.NewScanner(zr)
+	for scanner.Scan() {
+		a := domain.Action{}
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			t.Fatalf("Error in archived action: %v", err)
+		}
+		if a.OrganizationID != "abc" || a.DeviceID != "c333" {
+			t.Errorf("PurgeActions() archived %+v", a)
+		}
+		lines++
+	}
+	if lines != 1 {
+		t.Errorf("PurgeActions() archived %d actions, want 1", lines)
+	}
+}
+
+func TestService_PurgeActionsPages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devicetwin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	batch := purgeBatchSize
+	purgeBatchSize = 2
+	defer func() { purgeBatchSize = batch }()
+
+	now := time.Now()
+	db := memory.NewEmptyStore()
+	for _, id := range []string{"a1", "a2", "a3", "a4", "a5"} {
+		_, _ = db.ActionCreate(context.Background(), datastore.Action{OrganizationID: "abc", DeviceID: "a111", ActionID: id, Created: now.AddDate(0, 0, -9)})
+	}
+	_, _ = db.ActionCreate(context.Background(), datastore.Action{OrganizationID: "abc", DeviceID: "a111", ActionID: "a6", Created: now})
+
+	settings := config.TestConfig()
+	settings.Retention = config.Retention{Default: config.RetentionPolicy{Days: 7}, ArchiveDir: dir}
+	srv := NewService(settings, db)
+
+	got, err := srv.PurgeActions(context.Background())
+	if err != nil || got != 5 {
+		t.Fatalf("PurgeActions() = %v, %v, want 5", got, err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "abc", "actions-*.jsonl.gz"))
+	if len(files) != 3 {
+		t.Errorf("PurgeActions() archived %d files, want 3", len(files))
+	}
+	actions, _ := db.ActionListForDevice(context.Background(), "abc", "a111", datastore.ActionQuery{})
+	if len(actions) != 1 || actions[0].ActionID != "a6" {
+		t.Errorf("PurgeActions() left %v, want a6", actions)
+	}
+}
+
+func TestService_PurgeActionsArchiveError(t *testing.T) {
+	f, err := ioutil.TempFile("", "devicetwin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	defer os.Remove(f.Name())
+
+	// The archive directory cannot be created, so the actions are kept
+	db := memory.NewStore()
+	settings := config.TestConfig()
+	settings.Retention = config.Retention{Default: config.RetentionPolicy{Actions: 1}, ArchiveDir: f.Name()}
+	srv := NewService(settings, db)
+
+	if _, err := srv.PurgeActions(context.Background()); err == nil {
+		t.Error("PurgeActions() expected error")
+	}
+	if len(db.Actions) != 2 {
+		t.Errorf("PurgeActions() left %d actions, want 2", len(db.Actions))
+	}
+}
+
+// recordingElector runs the jobs straight away, recording their names
+type recordingElector struct {
+	names []string
+}
+
+func (e *recordingElector) Run(ctx context.Context, name string, job leader.Job) {
+	e.names = append(e.names, name)
+	job(ctx)
+}
+
+func (e *recordingElector) Close() error { return nil }
+
+func TestService_RegisterJobs(t *testing.T) {
+	settings := config.TestConfig()
+	settings.Retention = config.Retention{Default: config.RetentionPolicy{Actions: 1}, Interval: time.Hour}
+	settings.Heartbeat = time.Hour
+	db := memory.NewStore()
+	srv := NewService(settings, db)
+	refresh := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	if err := srv.HealthHandler(context.Background(), domain.Health{OrganizationID: "abc", DeviceID: "c333", Refresh: refresh}); err != nil {
+		t.Fatalf("Service.HealthHandler() error = %v", err)
+	}
+
+	elector := &recordingElector{}
+	jobs := leader.NewJobs(elector)
+	srv.RegisterJobs(jobs)
+
+	// The purge and the heartbeats run until the context is cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	jobs.Run(ctx)
+
+	if len(elector.names) != 1 || elector.names[0] != "action-purge" {
+		t.Errorf("Service.RegisterJobs() = %v, want the action purge", elector.names)
+	}
+	actions, _ := db.ActionListForDevice(context.Background(), "abc", "c333", datastore.ActionQuery{})
+	if len(actions) != 1 {
+		t.Errorf("Service.RegisterJobs() purge kept %d actions, want 1", len(actions))
+	}
+
+	// The heartbeats run without an election, and are written when they are stopped
+	checkRefresh(t, db, "c333", refresh)
+}
@@ -20,23 +20,24 @@
 package devicetwin
 
 import (
-	"fmt"
+	"context"
+	"github.com/canonical/iot-devicetwin/datastore"
 	"github.com/canonical/iot-devicetwin/domain"
 )
 
 // DeviceSnaps fetches the snaps for a device
-func (srv *Service) DeviceSnaps(orgID, clientID string) ([]domain.DeviceSnap, error) {
-	device, err := srv.DB.DeviceGet(clientID)
+func (srv *Service) DeviceSnaps(ctx context.Context, orgID, clientID string) ([]domain.DeviceSnap, error) {
+	device, err := srv.device(ctx, clientID)
 	if err != nil {
 		return nil, err
 	}
 
 	// Validate the supplied orgid
 	if device.OrganisationID != orgID {
-		return nil, fmt.Errorf("the organization ID does not match the device")
+		return nil, datastore.Forbidden("the organization ID does not match the device")
 	}
 
-	snaps, err := srv.DB.DeviceSnapList(device.ID)
+	snaps, err := srv.DB.DeviceSnapList(ctx, device.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -60,3 +61,34 @@ func (srv *Service) DeviceSnaps(orgID, clientID string) ([]domain.DeviceSnap, er
 	}
 	return installed, nil
 }
+
+// SnapInventory summarizes the snaps installed on the devices of an organization, with
+// the number of devices for each version, revision, channel and status
+func (srv *Service) SnapInventory(ctx context.Context, orgID string) ([]domain.SnapInventory, error) {
+	counts, err := srv.DB.SnapInventory(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	inventory := []domain.SnapInventory{}
+	for _, c := range counts {
+		// The counts are ordered by snap name
+		if len(inventory) == 0 || inventory[len(inventory)-1].Name != c.Name {
+			inventory = append(inventory, domain.SnapInventory{
+				Name:      c.Name,
+				Versions:  map[string]int{},
+				Revisions: map[int]int{},
+				Channels:  map[string]int{},
+				Statuses:  map[string]int{},
+			})
+		}
+
+		snap := &inventory[len(inventory)-1]
+		snap.Devices += c.Devices
+		snap.Versions[c.Version] += c.Devices
+		snap.Revisions[c.Revision] += c.Devices
+		snap.Channels[c.Channel] += c.Devices
+		snap.Statuses[c.Status] += c.Devices
+	}
+	return inventory, nil
+}
@@ -20,8 +20,12 @@
 package devicetwin
 
 import (
+	"context"
 	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/datastore"
 	"github.com/canonical/iot-devicetwin/datastore/memory"
+	"github.com/canonical/iot-devicetwin/domain"
+	"reflect"
 	"testing"
 )
 
@@ -43,7 +47,7 @@ func TestService_DeviceSnaps(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := NewService(config.TestConfig(), memory.NewStore())
-			got, err := srv.DeviceSnaps(tt.args.orgID, tt.args.clientID)
+			got, err := srv.DeviceSnaps(context.Background(), tt.args.orgID, tt.args.clientID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Service.DeviceSnaps() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -58,3 +62,60 @@ func TestService_DeviceSnaps(t *testing.T) {
 		})
 	}
 }
+
+func TestService_SnapInventory(t *testing.T) {
+	tests := []struct {
+		name    string
+		orgID   string
+		want    []domain.SnapInventory
+		devices int
+		wantErr bool
+	}{
+		{"valid", "abc", []domain.SnapInventory{
+			{
+				Name:      "core",
+				Devices:   3,
+				Versions:  map[string]int{"16-2.41": 2, "16-2.42": 1},
+				Revisions: map[int]int{12: 2, 13: 1},
+				Channels:  map[string]int{"stable": 2, "beta": 1},
+				Statuses:  map[string]int{"active": 3},
+			},
+			{
+				Name:      "example-snap",
+				Devices:   1,
+				Versions:  map[string]int{"": 1},
+				Revisions: map[int]int{0: 1},
+				Channels:  map[string]int{"": 1},
+				Statuses:  map[string]int{"active": 1},
+			},
+		}, 2, false},
+		{"valid-empty", "def", []domain.SnapInventory{}, 0, false},
+		{"invalid-orgid", "invalid", nil, 0, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			db := newFailingStore()
+			for _, id := range []int64{1, 2} {
+				_ = db.DeviceSnapUpsert(context.Background(), datastore.DeviceSnap{DeviceID: id, Name: "core", Version: "16-2.41", Revision: 12, Channel: "stable", Status: "active"})
+			}
+			_ = db.DeviceSnapUpsert(context.Background(), datastore.DeviceSnap{DeviceID: 3, Name: "core", Version: "16-2.42", Revision: 13, Channel: "beta", Status: "active"})
+
+			srv := NewService(config.TestConfig(), db)
+			got, err := srv.SnapInventory(context.Background(), tt.orgID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Service.SnapInventory() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Service.SnapInventory() = %v, want %v", got, tt.want)
+			}
+
+			// Drill down to the devices on a revision
+			page, err := srv.DeviceList(context.Background(), tt.orgID, domain.DeviceQuery{Snap: "core", SnapRevision: 12})
+			if !tt.wantErr && (err != nil || len(page.Devices) != tt.devices) {
+				t.Errorf("Service.DeviceList() = %v, %v, want %v", len(page.Devices), err, tt.devices)
+			}
+		})
+	}
+}
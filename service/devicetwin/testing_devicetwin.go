@@ -20,17 +20,20 @@
 package devicetwin
 
 import (
+	"context"
 	"fmt"
+	"github.com/canonical/iot-devicetwin/datastore"
 	"github.com/canonical/iot-devicetwin/domain"
 )
 
 // MockDeviceTwin mocks a device twin service
 type MockDeviceTwin struct {
-	Actions []string
+	Actions   []string
+	Responses []string // the IDs of the handled action responses
 }
 
 // HealthHandler mocks the health handler
-func (twin *MockDeviceTwin) HealthHandler(payload domain.Health) error {
+func (twin *MockDeviceTwin) HealthHandler(ctx context.Context, payload domain.Health) error {
 	if payload.DeviceID == "invalid" || payload.DeviceID == "new-device" {
 		return fmt.Errorf("MOCK error in health handler")
 	}
@@ -38,15 +41,16 @@ func (twin *MockDeviceTwin) HealthHandler(payload domain.Health) error {
 }
 
 // ActionResponse mocks the action handler
-func (twin *MockDeviceTwin) ActionResponse(clientID, actionID, action string, payload []byte) error {
+func (twin *MockDeviceTwin) ActionResponse(ctx context.Context, clientID, actionID, action string, payload []byte) error {
 	if action == "invalid" {
 		return fmt.Errorf("MOCK error in action")
 	}
+	twin.Responses = append(twin.Responses, actionID)
 	return nil
 }
 
 // DeviceSnaps mocks the snap list
-func (twin *MockDeviceTwin) DeviceSnaps(orgID, clientID string) ([]domain.DeviceSnap, error) {
+func (twin *MockDeviceTwin) DeviceSnaps(ctx context.Context, orgID, clientID string) ([]domain.DeviceSnap, error) {
 	if clientID == "invalid" {
 		return nil, fmt.Errorf("MOCK snaps list")
 	}
@@ -55,8 +59,25 @@ func (twin *MockDeviceTwin) DeviceSnaps(orgID, clientID string) ([]domain.Device
 	}, nil
 }
 
+// SnapInventory mocks the snap inventory
+func (twin *MockDeviceTwin) SnapInventory(ctx context.Context, orgID string) ([]domain.SnapInventory, error) {
+	if orgID == "invalid" {
+		return nil, fmt.Errorf("MOCK snap inventory")
+	}
+	return []domain.SnapInventory{
+		{
+			Name:      "example-snap",
+			Devices:   1,
+			Versions:  map[string]int{"1.0": 1},
+			Revisions: map[int]int{12: 1},
+			Channels:  map[string]int{"stable": 1},
+			Statuses:  map[string]int{"active": 1},
+		},
+	}, nil
+}
+
 // ActionCreate mocks the action log creation
-func (twin *MockDeviceTwin) ActionCreate(orgID, deviceID string, act domain.SubscribeAction) error {
+func (twin *MockDeviceTwin) ActionCreate(ctx context.Context, orgID, deviceID string, act domain.SubscribeAction) error {
 	if deviceID == "invalid" {
 		return fmt.Errorf("MOCK action log create")
 	}
@@ -68,22 +89,25 @@ func (twin *MockDeviceTwin) ActionCreate(orgID, deviceID string, act domain.Subs
 }
 
 // ActionUpdate mocks the action log update
-func (twin *MockDeviceTwin) ActionUpdate(actionID, status, message string) error {
+func (twin *MockDeviceTwin) ActionUpdate(ctx context.Context, actionID, status, message string) error {
 	return nil
 }
 
 // ActionList mocks the action log list
-func (twin *MockDeviceTwin) ActionList(orgID, clientID string) ([]domain.Action, error) {
+func (twin *MockDeviceTwin) ActionList(ctx context.Context, orgID, clientID string, query domain.ActionQuery) ([]domain.Action, error) {
 	if clientID == "invalid" {
 		return nil, fmt.Errorf("MOCK error action list")
 	}
+	if query.Limit < 0 {
+		return nil, datastore.Invalid("MOCK error action list limit")
+	}
 	return []domain.Action{}, nil
 }
 
 // DeviceGet mocks fetching a device
-func (twin *MockDeviceTwin) DeviceGet(orgID, clientID string) (domain.Device, error) {
+func (twin *MockDeviceTwin) DeviceGet(ctx context.Context, orgID, clientID string) (domain.Device, error) {
 	if clientID == "invalid" {
-		return domain.Device{}, fmt.Errorf("MOCK error device get")
+		return domain.Device{}, datastore.NotFound("MOCK error device get")
 	}
 	return domain.Device{
 		OrganizationID: "abc",
@@ -95,13 +119,32 @@ func (twin *MockDeviceTwin) DeviceGet(orgID, clientID string) (domain.Device, er
 	}, nil
 }
 
+// DeviceLabelsSet mocks setting labels on a device
+func (twin *MockDeviceTwin) DeviceLabelsSet(ctx context.Context, orgID, clientID string, labels map[string]string) error {
+	if clientID == "invalid" {
+		return fmt.Errorf("MOCK error device labels set")
+	}
+	return nil
+}
+
+// DeviceLabelDelete mocks removing a label from a device
+func (twin *MockDeviceTwin) DeviceLabelDelete(ctx context.Context, orgID, clientID, key string) error {
+	if clientID == "invalid" {
+		return fmt.Errorf("MOCK error device label delete")
+	}
+	return nil
+}
+
 // DeviceList mocks fetching devices for an organization
-func (twin *MockDeviceTwin) DeviceList(orgID string) ([]domain.Device, error) {
+func (twin *MockDeviceTwin) DeviceList(ctx context.Context, orgID string, query domain.DeviceQuery) (domain.DevicePage, error) {
 	if orgID == "invalid" {
-		return nil, fmt.Errorf("MOCK error device list")
+		return domain.DevicePage{}, fmt.Errorf("MOCK error device list")
+	}
+	if query.Sort == "invalid" {
+		return domain.DevicePage{}, datastore.Invalid("MOCK error device list")
 	}
 
-	return []domain.Device{
+	return domain.DevicePage{Devices: []domain.Device{
 		{OrganizationID: "abc",
 			DeviceID:     "c333",
 			Brand:        "canonical",
@@ -109,11 +152,11 @@ func (twin *MockDeviceTwin) DeviceList(orgID string) ([]domain.Device, error) {
 			SerialNumber: "d75f7300-abbf-4c11-bf0a-8b7103038490",
 			DeviceKey:    "CCCCCCCCC",
 		},
-	}, nil
+	}}, nil
 }
 
 // GroupCreate mocks creating a group
-func (twin *MockDeviceTwin) GroupCreate(orgID, name string) error {
+func (twin *MockDeviceTwin) GroupCreate(ctx context.Context, orgID string, group domain.Group) error {
 	if orgID == "invalid" {
 		return fmt.Errorf("MOCK error group create")
 	}
@@ -121,7 +164,7 @@ func (twin *MockDeviceTwin) GroupCreate(orgID, name string) error {
 }
 
 // GroupList mocks listing groups
-func (twin *MockDeviceTwin) GroupList(orgID string) ([]domain.Group, error) {
+func (twin *MockDeviceTwin) GroupList(ctx context.Context, orgID string) ([]domain.Group, error) {
 	if orgID == "invalid" {
 		return nil, fmt.Errorf("MOCK error group list")
 	}
@@ -131,17 +174,42 @@ func (twin *MockDeviceTwin) GroupList(orgID string) ([]domain.Group, error) {
 }
 
 // GroupGet mocks fetching a group
-func (twin *MockDeviceTwin) GroupGet(orgID, name string) (domain.Group, error) {
-	if orgID == "invalid" || name == "invalid" {
-		return domain.Group{}, fmt.Errorf("MOCK error group device unlink")
+func (twin *MockDeviceTwin) GroupGet(ctx context.Context, orgID, name string) (domain.Group, error) {
+	if orgID == "invalid" {
+		return domain.Group{}, fmt.Errorf("MOCK error group get")
+	}
+	if name == "invalid" {
+		return domain.Group{}, datastore.NotFound("MOCK error group get")
 	}
 	return domain.Group{
 		OrganizationID: "abc", Name: "workshop",
 	}, nil
 }
 
+// GroupUpdate mocks updating a group
+func (twin *MockDeviceTwin) GroupUpdate(ctx context.Context, orgID, name string, group domain.Group) error {
+	if orgID == "invalid" {
+		return fmt.Errorf("MOCK error group update")
+	}
+	if name == "invalid" {
+		return datastore.NotFound("MOCK error group update")
+	}
+	return nil
+}
+
+// GroupDelete mocks deleting a group
+func (twin *MockDeviceTwin) GroupDelete(ctx context.Context, orgID, name string) error {
+	if orgID == "invalid" {
+		return fmt.Errorf("MOCK error group delete")
+	}
+	if name == "invalid" {
+		return datastore.NotFound("MOCK error group delete")
+	}
+	return nil
+}
+
 // GroupLinkDevice mocks linking a device to a group
-func (twin *MockDeviceTwin) GroupLinkDevice(orgID, name, clientID string) error {
+func (twin *MockDeviceTwin) GroupLinkDevice(ctx context.Context, orgID, name, clientID string) error {
 	if orgID == "invalid" || name == "invalid" || clientID == "invalid" {
 		return fmt.Errorf("MOCK error group device link")
 	}
@@ -149,19 +217,35 @@ func (twin *MockDeviceTwin) GroupLinkDevice(orgID, name, clientID string) error
 }
 
 // GroupUnlinkDevice mocks unlinking a device from a group
-func (twin *MockDeviceTwin) GroupUnlinkDevice(orgID, name, clientID string) error {
+func (twin *MockDeviceTwin) GroupUnlinkDevice(ctx context.Context, orgID, name, clientID string) error {
 	if orgID == "invalid" || name == "invalid" || clientID == "invalid" {
 		return fmt.Errorf("MOCK error group device unlink")
 	}
 	return nil
 }
 
+// GroupLinkDevices mocks linking the devices that match a selector to a group
+func (twin *MockDeviceTwin) GroupLinkDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (int, error) {
+	if orgID == "invalid" || name == "invalid" || len(query.LabelSelector) == 0 {
+		return 0, fmt.Errorf("MOCK error group devices link")
+	}
+	return 1, nil
+}
+
+// GroupUnlinkDevices mocks unlinking the devices that match a selector from a group
+func (twin *MockDeviceTwin) GroupUnlinkDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (int, error) {
+	if orgID == "invalid" || name == "invalid" || len(query.LabelSelector) == 0 {
+		return 0, fmt.Errorf("MOCK error group devices unlink")
+	}
+	return 1, nil
+}
+
 // GroupGetDevices mocks retrieving the devices for a group
-func (twin *MockDeviceTwin) GroupGetDevices(orgID, name string) ([]domain.Device, error) {
+func (twin *MockDeviceTwin) GroupGetDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (domain.DevicePage, error) {
 	if orgID == "invalid" || name == "invalid" {
-		return nil, fmt.Errorf("MOCK error group devices")
+		return domain.DevicePage{}, fmt.Errorf("MOCK error group devices")
 	}
-	return []domain.Device{
+	return domain.DevicePage{Devices: []domain.Device{
 		{OrganizationID: "abc",
 			DeviceID:     "c333",
 			Brand:        "canonical",
@@ -169,15 +253,15 @@ func (twin *MockDeviceTwin) GroupGetDevices(orgID, name string) ([]domain.Device
 			SerialNumber: "d75f7300-abbf-4c11-bf0a-8b7103038490",
 			DeviceKey:    "CCCCCCCCC",
 		},
-	}, nil
+	}}, nil
 }
 
 // GroupGetExcludedDevices mocks retrieving the devices not in a group
-func (twin *MockDeviceTwin) GroupGetExcludedDevices(orgID, name string) ([]domain.Device, error) {
+func (twin *MockDeviceTwin) GroupGetExcludedDevices(ctx context.Context, orgID, name string, query domain.DeviceQuery) (domain.DevicePage, error) {
 	if orgID == "invalid" || name == "invalid" {
-		return nil, fmt.Errorf("MOCK error group excluded devices")
+		return domain.DevicePage{}, fmt.Errorf("MOCK error group excluded devices")
 	}
-	return []domain.Device{
+	return domain.DevicePage{Devices: []domain.Device{
 		{OrganizationID: "abc",
 			DeviceID:     "b222",
 			Brand:        "example",
@@ -192,5 +276,39 @@ func (twin *MockDeviceTwin) GroupGetExcludedDevices(orgID, name string) ([]domai
 			SerialNumber: "d75f7300-abbf-4c11-bf0a-8b7103038490",
 			DeviceKey:    "CCCCCCCCC",
 		},
-	}, nil
+	}}, nil
+}
+
+// Export mocks exporting an organization
+func (twin *MockDeviceTwin) Export(ctx context.Context, orgID string, w ExportWriter) error {
+	if orgID == "invalid" {
+		return fmt.Errorf("MOCK error export")
+	}
+	device := domain.Device{OrganizationID: orgID, DeviceID: "a111", Brand: "example", Model: "drone-1000", DeviceKey: "AAAAAAAAA", Labels: map[string]string{"site": "berlin"}}
+	if err := w.WriteDevice(domain.ExportDevice{Device: device}); err != nil {
+		return err
+	}
+	if err := w.WriteGroup(domain.Group{OrganizationID: orgID, Name: "workshop"}); err != nil {
+		return err
+	}
+	return w.WriteLink(domain.GroupLink{Group: "workshop", DeviceID: "a111"})
+}
+
+// Import mocks importing an organization
+func (twin *MockDeviceTwin) Import(ctx context.Context, orgID string, data domain.Export) (domain.ImportSummary, error) {
+	if orgID == "invalid" {
+		return domain.ImportSummary{}, fmt.Errorf("MOCK error import")
+	}
+	if data.Version != domain.ExportVersion {
+		return domain.ImportSummary{}, datastore.Invalid("MOCK error import version")
+	}
+	return domain.ImportSummary{Devices: len(data.Devices), Groups: len(data.Groups), Links: len(data.Links), Actions: len(data.Actions)}, nil
+}
+
+// CacheStats mocks the statistics of the device caches
+func (twin *MockDeviceTwin) CacheStats() domain.CacheStats {
+	return domain.CacheStats{
+		Devices:  domain.CacheCounts{Size: 2, Hits: 10, Misses: 2},
+		Versions: domain.CacheCounts{Size: 1, Hits: 4, Misses: 3},
+	}
 }
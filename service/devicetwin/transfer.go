@@ -0,0 +1,679 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package devicetwin
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/canonical/iot-devicetwin/datastore"
+	"github.com/canonical/iot-devicetwin/domain"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// deviceColumns are the columns of the devices CSV, in order
+var deviceColumns = []string{"deviceId", "brand", "model", "serial", "store", "deviceKey", "created", "lastRefresh", "labels"}
+
+// ExportWriter writes the records of an export as they are read from the data store, so the
+// export of an organization is not held in memory. The devices are written first, then the
+// groups, the group links and the actions
+type ExportWriter interface {
+	WriteDevice(d domain.ExportDevice) error
+	WriteGroup(g domain.Group) error
+	WriteLink(l domain.GroupLink) error
+	WriteAction(a domain.Action) error
+}
+
+// ExportEncoder is an export writer for a file format, which is closed to complete the file
+type ExportEncoder interface {
+	ExportWriter
+	Close() error
+}
+
+// NewExportEncoder creates the encoder for an export format: versioned JSON, or CSV with just the devices
+func NewExportEncoder(w io.Writer, orgID, format string) ExportEncoder {
+	if format == "csv" {
+		return NewCSVExportWriter(w)
+	}
+	return NewJSONExportWriter(w, orgID)
+}
+
+// Export writes the devices, groups and action log of an organization, including the device
+// keys and snap configs, so they can be imported into another data store. Each kind of record
+// is read for the whole organization in a single query
+func (srv *Service) Export(ctx context.Context, orgID string, w ExportWriter) error {
+	devices, err := srv.DB.DeviceList(ctx, orgID, datastore.DeviceQuery{SortBy: datastore.SortCreated})
+	if err != nil {
+		return err
+	}
+	versions, err := srv.DB.DeviceVersionListForOrg(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	labels, err := srv.DB.DeviceLabelListForOrg(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	snaps, err := srv.DB.DeviceSnapListForOrg(ctx, orgID)
+	if err != nil {
+		return err
+	}
+
+	deviceIDs := map[int64]string{}
+	for _, d := range devices {
+		deviceIDs[d.ID] = d.DeviceID
+	}
+	deviceVersions := map[int64]datastore.DeviceVersion{}
+	for _, v := range versions {
+		deviceVersions[v.DeviceID] = v
+	}
+	deviceLabels := map[int64]map[string]string{}
+	for _, l := range labels {
+		if deviceLabels[l.DeviceID] == nil {
+			deviceLabels[l.DeviceID] = map[string]string{}
+		}
+		deviceLabels[l.DeviceID][l.Key] = l.Value
+	}
+	deviceSnaps := map[int64][]domain.DeviceSnap{}
+	for _, s := range snaps {
+		deviceSnaps[s.DeviceID] = append(deviceSnaps[s.DeviceID], domain.DeviceSnap{
+			DeviceID:      deviceIDs[s.DeviceID],
+			Name:          s.Name,
+			InstalledSize: s.InstalledSize,
+			InstalledDate: s.InstalledDate,
+			Status:        s.Status,
+			Channel:       s.Channel,
+			Confinement:   s.Confinement,
+			Version:       s.Version,
+			Revision:      s.Revision,
+			Devmode:       s.Devmode,
+			Config:        s.Config,
+		})
+	}
+
+	for _, d := range devices {
+		device := dataToDomainDevice(d)
+		if dv, ok := deviceVersions[d.ID]; ok {
+			device.Version = domain.DeviceVersion{
+				DeviceID:      d.DeviceID,
+				Version:       dv.Version,
+				Series:        dv.Series,
+				OSID:          dv.OSID,
+				OSVersionID:   dv.OSVersionID,
+				OnClassic:     dv.OnClassic,
+				KernelVersion: dv.KernelVersion,
+			}
+		}
+		device.Labels = deviceLabels[d.ID]
+
+		if err := w.WriteDevice(domain.ExportDevice{Device: device, Snaps: deviceSnaps[d.ID]}); err != nil {
+			return err
+		}
+	}
+
+	groups, err := srv.DB.GroupList(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	groupNames := map[int64]string{}
+	for _, g := range groups {
+		groupNames[g.ID] = g.Name
+		if err := w.WriteGroup(groupFromData(g, groups)); err != nil {
+			return err
+		}
+	}
+
+	links, err := srv.DB.GroupLinkList(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	for _, l := range links {
+		if len(groupNames[l.GroupID]) == 0 || len(deviceIDs[l.DeviceID]) == 0 {
+			continue
+		}
+		if err := w.WriteLink(domain.GroupLink{Group: groupNames[l.GroupID], DeviceID: deviceIDs[l.DeviceID]}); err != nil {
+			return err
+		}
+	}
+
+	// The action log is exported oldest first
+	actions, err := srv.DB.ActionListForOrg(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	for _, a := range actions {
+		err := w.WriteAction(domain.Action{
+			Created:        a.Created,
+			Modified:       a.Modified,
+			OrganizationID: a.OrganizationID,
+			DeviceID:       a.DeviceID,
+			ActionID:       a.ActionID,
+			Action:         a.Action,
+			Status:         a.Status,
+			Message:        a.Message,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Import creates the exported devices, groups and action log in an organization. The data
+// is checked before any records are created, and the devices must not belong to another
+// organization. Each step creates a record or updates the one from an earlier import, so an
+// import that fails part way through is safe to run again. The summary counts the exported
+// records, including those that were already imported
+func (srv *Service) Import(ctx context.Context, orgID string, data domain.Export) (domain.ImportSummary, error) {
+	summary := domain.ImportSummary{}
+	if data.Version != domain.ExportVersion {
+		return summary, datastore.Invalid("unsupported export version %d, expected %d", data.Version, domain.ExportVersion)
+	}
+
+	groups, existing, err := srv.importOrder(ctx, orgID, data)
+	if err != nil {
+		return summary, err
+	}
+
+	for _, d := range data.Devices {
+		if err := srv.importDevice(ctx, orgID, d); err != nil {
+			return summary, err
+		}
+		summary.Devices++
+	}
+
+	// The parents are created before their child groups
+	groupIDs := map[string]int64{}
+	for _, g := range groups {
+		grp := datastore.Group{OrganisationID: orgID, Name: g.Name, Description: g.Description, ParentID: groupIDs[g.Parent]}
+		if g.Rule != nil {
+			grp.Rule, _ = dataGroupRule(g.Name, g.Rule)
+		}
+		if id, ok := existing[g.Name]; ok {
+			grp.ID = id
+			if err := srv.DB.GroupUpdate(ctx, grp); err != nil {
+				return summary, err
+			}
+		} else if grp.ID, err = srv.DB.GroupCreate(ctx, grp); err != nil {
+			return summary, err
+		}
+		groupIDs[g.Name] = grp.ID
+		summary.Groups++
+	}
+
+	for _, l := range data.Links {
+		if err := srv.DB.GroupLinkDevice(ctx, orgID, l.Group, l.DeviceID); err != nil {
+			return summary, err
+		}
+		summary.Links++
+	}
+
+	for _, a := range data.Actions {
+		// The actions from an earlier import are not repeated
+		if act, err := srv.DB.ActionGet(ctx, a.ActionID); err == nil && act.OrganizationID == orgID {
+			summary.Actions++
+			continue
+		} else if err != nil && !errors.Is(err, datastore.ErrNotFound) {
+			return summary, err
+		}
+
+		act := datastore.Action{
+			Created:        a.Created,
+			Modified:       a.Modified,
+			OrganizationID: orgID,
+			DeviceID:       a.DeviceID,
+			ActionID:       a.ActionID,
+			Action:         a.Action,
+			Status:         a.Status,
+			Message:        a.Message,
+		}
+		if _, err := srv.DB.ActionCreate(ctx, act); err != nil {
+			return summary, err
+		}
+		summary.Actions++
+	}
+
+	return summary, nil
+}
+
+// importOrder checks the data to import against the organization, returning the groups
+// ordered so that each parent comes before its child groups, and the IDs of the groups
+// that already exist
+func (srv *Service) importOrder(ctx context.Context, orgID string, data domain.Export) ([]domain.Group, map[string]int64, error) {
+	devices := map[string]bool{}
+	for _, d := range data.Devices {
+		if len(d.DeviceID) == 0 {
+			return nil, nil, datastore.Invalid("a device has no device ID")
+		}
+		if devices[d.DeviceID] {
+			return nil, nil, datastore.Invalid("device `%s` is exported more than once", d.DeviceID)
+		}
+		devices[d.DeviceID] = true
+
+		for k, v := range d.Labels {
+			if err := datastore.ValidateLabel(k, v); err != nil {
+				return nil, nil, err
+			}
+		}
+		if device, err := srv.DB.DeviceGet(ctx, d.DeviceID); err == nil && device.OrganisationID != orgID {
+			return nil, nil, datastore.Conflict("device `%s` already exists in another organization", d.DeviceID)
+		} else if err != nil && !errors.Is(err, datastore.ErrNotFound) {
+			return nil, nil, err
+		}
+	}
+
+	pending := map[string]domain.Group{}
+	existing := map[string]int64{}
+	for _, g := range data.Groups {
+		if len(g.Name) == 0 {
+			return nil, nil, datastore.Invalid("a group has no name")
+		}
+		if _, ok := pending[g.Name]; ok {
+			return nil, nil, datastore.Invalid("group `%s` is exported more than once", g.Name)
+		}
+		if g.Rule != nil {
+			if _, err := dataGroupRule(g.Name, g.Rule); err != nil {
+				return nil, nil, err
+			}
+		}
+		if grp, err := srv.DB.GroupGet(ctx, orgID, g.Name); err == nil {
+			existing[g.Name] = grp.ID
+		} else if !errors.Is(err, datastore.ErrNotFound) {
+			return nil, nil, err
+		}
+		pending[g.Name] = g
+	}
+
+	for _, g := range data.Groups {
+		if _, ok := pending[g.Parent]; len(g.Parent) > 0 && !ok {
+			return nil, nil, datastore.Invalid("the parent of group `%s` is `%s`, which is not exported", g.Name, g.Parent)
+		}
+	}
+
+	for _, l := range data.Links {
+		g, ok := pending[l.Group]
+		if !ok {
+			return nil, nil, datastore.Invalid("the link of device `%s` is to group `%s`, which is not exported", l.DeviceID, l.Group)
+		}
+		if g.Rule != nil {
+			return nil, nil, datastore.Invalid("group `%s` is dynamic, its devices cannot be linked", l.Group)
+		}
+		if !devices[l.DeviceID] {
+			return nil, nil, datastore.Invalid("the link to group `%s` is for device `%s`, which is not exported", l.Group, l.DeviceID)
+		}
+	}
+
+	// Order the groups, keeping the exported order where the parents allow it
+	ordered := []domain.Group{}
+	created := map[string]bool{}
+	for len(ordered) < len(data.Groups) {
+		progress := false
+		for _, g := range data.Groups {
+			if created[g.Name] || (len(g.Parent) > 0 && !created[g.Parent]) {
+				continue
+			}
+			ordered = append(ordered, g)
+			created[g.Name] = true
+			progress = true
+		}
+		if !progress {
+			return nil, nil, datastore.Invalid("the parents of the groups form a cycle")
+		}
+	}
+	return ordered, existing, nil
+}
+
+// importDevice creates a device with its OS details, labels and snaps. A device from an
+// earlier import is kept, and its details are updated
+func (srv *Service) importDevice(ctx context.Context, orgID string, d domain.ExportDevice) error {
+	device, err := srv.DB.DeviceGet(ctx, d.DeviceID)
+	if errors.Is(err, datastore.ErrNotFound) {
+		device.ID, err = srv.DB.DeviceCreate(ctx, datastore.Device{
+			OrganisationID: orgID,
+			DeviceID:       d.DeviceID,
+			Brand:          d.Brand,
+			Model:          d.Model,
+			SerialNumber:   d.SerialNumber,
+			StoreID:        d.StoreID,
+			DeviceKey:      d.DeviceKey,
+			Created:        d.Created,
+			LastRefresh:    d.LastRefresh,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	id := device.ID
+	srv.deviceChanged(ctx, orgID, d.DeviceID)
+
+	if len(d.Version.DeviceID) > 0 {
+		err := srv.DB.DeviceVersionUpsert(ctx, datastore.DeviceVersion{
+			DeviceID:      id,
+			Version:       d.Version.Version,
+			Series:        d.Version.Series,
+			OSID:          d.Version.OSID,
+			OSVersionID:   d.Version.OSVersionID,
+			OnClassic:     d.Version.OnClassic,
+			KernelVersion: d.Version.KernelVersion,
+		})
+		if err != nil {
+			return err
+		}
+		srv.versionChanged(ctx, orgID, d.DeviceID, id)
+	}
+
+	for k, v := range d.Labels {
+		if err := srv.DB.DeviceLabelSet(ctx, id, k, v); err != nil {
+			return err
+		}
+	}
+
+	if len(d.Snaps) == 0 {
+		return nil
+	}
+	snaps := []datastore.DeviceSnap{}
+	for _, s := range d.Snaps {
+		snaps = append(snaps, datastore.DeviceSnap{
+			DeviceID:      id,
+			Name:          s.Name,
+			InstalledSize: s.InstalledSize,
+			InstalledDate: s.InstalledDate,
+			Status:        s.Status,
+			Channel:       s.Channel,
+			Confinement:   s.Confinement,
+			Version:       s.Version,
+			Revision:      s.Revision,
+			Devmode:       s.Devmode,
+			Config:        s.Config,
+		})
+	}
+	return srv.DB.DeviceSnapReplace(ctx, id, snaps)
+}
+
+// exportSections are the lists of records in a JSON export, in the order they are written
+var exportSections = []string{"devices", "groups", "links", "actions"}
+
+// exportHeader is the start of a JSON export, before the lists of records
+type exportHeader struct {
+	Version        int       `json:"version"`
+	OrganizationID string    `json:"orgId"`
+	Exported       time.Time `json:"exported"`
+}
+
+// JSONExportWriter writes an export as versioned JSON, with a line for each record as it is
+// exported. The lists of records that are not written are empty
+type JSONExportWriter struct {
+	w       io.Writer
+	header  exportHeader
+	section int
+	records int
+}
+
+// NewJSONExportWriter creates a writer for the versioned JSON export of an organization
+func NewJSONExportWriter(w io.Writer, orgID string) *JSONExportWriter {
+	return &JSONExportWriter{
+		w:       w,
+		header:  exportHeader{Version: domain.ExportVersion, OrganizationID: orgID, Exported: time.Now().UTC()},
+		section: -1,
+	}
+}
+
+// WriteDevice writes a device with its OS details, labels and snaps
+func (e *JSONExportWriter) WriteDevice(d domain.ExportDevice) error {
+	return e.write(0, d)
+}
+
+// WriteGroup writes a group
+func (e *JSONExportWriter) WriteGroup(g domain.Group) error {
+	return e.write(1, g)
+}
+
+// WriteLink writes a device link to a static group
+func (e *JSONExportWriter) WriteLink(l domain.GroupLink) error {
+	return e.write(2, l)
+}
+
+// WriteAction writes an action from the log
+func (e *JSONExportWriter) WriteAction(a domain.Action) error {
+	return e.write(3, a)
+}
+
+// Close completes the JSON document
+func (e *JSONExportWriter) Close() error {
+	if err := e.open(len(exportSections)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "\n}\n")
+	return err
+}
+
+// write adds a record to a list of the export
+func (e *JSONExportWriter) write(section int, v interface{}) error {
+	if err := e.open(section); err != nil {
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	sep := ",\n"
+	if e.records == 0 {
+		sep = "\n"
+	}
+	e.records++
+	_, err = fmt.Fprintf(e.w, "%s%s", sep, b)
+	return err
+}
+
+// open starts a list of records, writing the header and closing the lists before it
+func (e *JSONExportWriter) open(section int) error {
+	if section < e.section {
+		return fmt.Errorf("the %s must be exported before the %s", exportSections[section], exportSections[e.section])
+	}
+
+	buf := &bytes.Buffer{}
+	if e.section < 0 {
+		header, err := json.Marshal(e.header)
+		if err != nil {
+			return err
+		}
+		buf.Write(bytes.TrimSuffix(header, []byte("}")))
+	}
+	for e.section < section {
+		if e.section >= 0 {
+			buf.WriteString("\n]")
+		}
+		e.section++
+		e.records = 0
+		if e.section < len(exportSections) {
+			fmt.Fprintf(buf, ",\n%q:[", exportSections[e.section])
+		}
+	}
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// CSVExportWriter writes the devices of an export as CSV with a header row, as each device is
+// exported. The labels are in a single column, in the form `key=value,key=value`. The other
+// records are left out
+type CSVExportWriter struct {
+	cw     *csv.Writer
+	header bool
+}
+
+// NewCSVExportWriter creates a writer for the devices CSV
+func NewCSVExportWriter(w io.Writer) *CSVExportWriter {
+	return &CSVExportWriter{cw: csv.NewWriter(w)}
+}
+
+// WriteDevice writes a device record
+func (e *CSVExportWriter) WriteDevice(d domain.ExportDevice) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+
+	labels := []string{}
+	for k, v := range d.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+
+	return e.cw.Write([]string{d.DeviceID, d.Brand, d.Model, d.SerialNumber, d.StoreID, d.DeviceKey,
+		formatCSVTime(d.Created), formatCSVTime(d.LastRefresh), strings.Join(labels, ",")})
+}
+
+// WriteGroup leaves out a group, as the CSV only has the devices
+func (e *CSVExportWriter) WriteGroup(g domain.Group) error {
+	return nil
+}
+
+// WriteLink leaves out a group link, as the CSV only has the devices
+func (e *CSVExportWriter) WriteLink(l domain.GroupLink) error {
+	return nil
+}
+
+// WriteAction leaves out an action, as the CSV only has the devices
+func (e *CSVExportWriter) WriteAction(a domain.Action) error {
+	return nil
+}
+
+// Close writes the buffered records
+func (e *CSVExportWriter) Close() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.cw.Flush()
+	return e.cw.Error()
+}
+
+func (e *CSVExportWriter) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	return e.cw.Write(deviceColumns)
+}
+
+// ReadDevicesCSV reads the devices from CSV with a header row. The columns can be in
+// any order, and only the deviceId, brand and model columns are required
+func ReadDevicesCSV(r io.Reader) ([]domain.ExportDevice, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, datastore.Invalid("invalid devices CSV: %v", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for name := range columns {
+		if !validColumn(name) {
+			return nil, datastore.Invalid("invalid devices CSV: unknown column `%s`", name)
+		}
+	}
+	for _, name := range deviceColumns[:3] {
+		if _, ok := columns[name]; !ok {
+			return nil, datastore.Invalid("invalid devices CSV: the `%s` column is missing", name)
+		}
+	}
+
+	devices := []domain.ExportDevice{}
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, datastore.Invalid("invalid devices CSV: %v", err)
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		d := domain.ExportDevice{Device: domain.Device{
+			DeviceID:     field("deviceId"),
+			Brand:        field("brand"),
+			Model:        field("model"),
+			SerialNumber: field("serial"),
+			StoreID:      field("store"),
+			DeviceKey:    field("deviceKey"),
+		}}
+		if d.Created, err = parseCSVTime(field("created")); err != nil {
+			return nil, datastore.Invalid("invalid devices CSV: line %d: invalid created time: %v", line, err)
+		}
+		if d.LastRefresh, err = parseCSVTime(field("lastRefresh")); err != nil {
+			return nil, datastore.Invalid("invalid devices CSV: line %d: invalid last refresh time: %v", line, err)
+		}
+		for _, label := range strings.Split(field("labels"), ",") {
+			if len(label) == 0 {
+				continue
+			}
+			if d.Labels == nil {
+				d.Labels = map[string]string{}
+			}
+			kv := strings.SplitN(label, "=", 2)
+			if len(kv) == 1 {
+				kv = append(kv, "")
+			}
+			d.Labels[kv[0]] = kv[1]
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+// validColumn checks that a column of the devices CSV is known
+func validColumn(name string) bool {
+	for _, c := range deviceColumns {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// formatCSVTime formats a time for CSV, leaving it empty when it is not set
+func formatCSVTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseCSVTime parses a time from CSV, where an empty time is not set
+func parseCSVTime(s string) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
@@ -0,0 +1,348 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package devicetwin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/datastore"
+	"github.com/canonical/iot-devicetwin/datastore/memory"
+	"github.com/canonical/iot-devicetwin/domain"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testExport is an export of organization abc, in the order that it is exported
+func testExport() domain.Export {
+	created := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	refresh := time.Date(2020, 2, 1, 12, 0, 0, 0, time.UTC)
+	return domain.Export{
+		Version:        domain.ExportVersion,
+		OrganizationID: "abc",
+		Devices: []domain.ExportDevice{
+			{
+				Device: domain.Device{
+					OrganizationID: "abc", DeviceID: "a111", Brand: "example", Model: "drone-1000", SerialNumber: "DR1000A111", StoreID: "example-store", DeviceKey: "AAAAAAAAA",
+					Version: domain.DeviceVersion{DeviceID: "a111", Version: "2.42", Series: "18", OSID: "ubuntu-core", OSVersionID: "18", KernelVersion: "4.15.0"},
+					Labels:  map[string]string{"site": "berlin", "floor": "2"},
+					Created: created, LastRefresh: refresh,
+				},
+				Snaps: []domain.DeviceSnap{
+					{DeviceID: "a111", Name: "core", Version: "16-2.42", Revision: 8039, Channel: "stable", Status: "active"},
+					{DeviceID: "a111", Name: "helloworld", Version: "6.4", Revision: 29, Channel: "stable", Status: "active", Config: `{"title":"hello"}`},
+				},
+			},
+			{
+				Device: domain.Device{
+					OrganizationID: "abc", DeviceID: "b222", Brand: "example", Model: "drone-1000", SerialNumber: "DR1000B222", DeviceKey: "BBBBBBBBB",
+					Created: created.Add(time.Hour), LastRefresh: refresh,
+				},
+			},
+		},
+		Groups: []domain.Group{
+			{OrganizationID: "abc", Name: "drones", Rule: &domain.GroupRule{Brand: "example", LabelSelector: "site=berlin"}},
+			{OrganizationID: "abc", Name: "site", Description: "The site"},
+			{OrganizationID: "abc", Name: "workshop", Parent: "site"},
+		},
+		Links: []domain.GroupLink{
+			{Group: "site", DeviceID: "b222"},
+			{Group: "workshop", DeviceID: "a111"},
+		},
+		Actions: []domain.Action{
+			{OrganizationID: "abc", DeviceID: "a111", ActionID: "a1", Action: "list", Status: "complete", Created: created, Modified: created},
+			{OrganizationID: "abc", DeviceID: "b222", ActionID: "b1", Action: "list", Status: "requested", Created: created, Modified: created},
+			{OrganizationID: "abc", DeviceID: "a111", ActionID: "a2", Action: "install", Status: "error", Message: "failed", Created: refresh, Modified: refresh},
+		},
+	}
+}
+
+// exportJSON exports an organization as JSON, decoding the export
+func exportJSON(srv *Service, orgID string) (domain.Export, error) {
+	buf := &bytes.Buffer{}
+	enc := NewJSONExportWriter(buf, orgID)
+	if err := srv.Export(context.Background(), orgID, enc); err != nil {
+		return domain.Export{}, err
+	}
+	if err := enc.Close(); err != nil {
+		return domain.Export{}, err
+	}
+
+	data := domain.Export{}
+	err := json.NewDecoder(buf).Decode(&data)
+	return data, err
+}
+
+func TestService_ExportImport(t *testing.T) {
+	srv := NewService(config.TestConfig(), memory.NewEmptyStore())
+	want := testExport()
+
+	summary, err := srv.Import(context.Background(), "abc", want)
+	if err != nil {
+		t.Fatalf("Service.Import() error = %v", err)
+	}
+	if summary != (domain.ImportSummary{Devices: 2, Groups: 3, Links: 2, Actions: 3}) {
+		t.Errorf("Service.Import() = %+v", summary)
+	}
+
+	got, err := exportJSON(srv, "abc")
+	if err != nil {
+		t.Fatalf("Service.Export() error = %v", err)
+	}
+	if got.Exported.IsZero() {
+		t.Error("Service.Export() expected the time of the export")
+	}
+	got.Exported = want.Exported
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Service.Export() = %+v\nwant %+v", got, want)
+	}
+
+	// Import into another organization of an empty store
+	other := NewService(config.TestConfig(), memory.NewEmptyStore())
+	if _, err := other.Import(context.Background(), "xyz", got); err != nil {
+		t.Fatalf("Service.Import() error = %v", err)
+	}
+	device, err := other.DeviceGet(context.Background(), "xyz", "a111")
+	if err != nil || device.Labels["site"] != "berlin" || device.Version.Series != "18" {
+		t.Errorf("Service.Import() device = %+v, %v", device, err)
+	}
+	actions, err := other.ActionList(context.Background(), "xyz", "a111", domain.ActionQuery{})
+	if err != nil || len(actions) != 2 || actions[0].ActionID != "a2" {
+		t.Errorf("Service.Import() actions = %+v, %v", actions, err)
+	}
+}
+
+func TestService_ExportSeeded(t *testing.T) {
+	srv := NewService(config.TestConfig(), memory.NewStore())
+
+	got, err := exportJSON(srv, "abc")
+	if err != nil {
+		t.Fatalf("Service.Export() error = %v", err)
+	}
+	if len(got.Devices) != 3 || len(got.Groups) != 1 || len(got.Actions) != 2 {
+		t.Errorf("Service.Export() = %d devices, %d groups, %d actions", len(got.Devices), len(got.Groups), len(got.Actions))
+	}
+	if !reflect.DeepEqual(got.Links, []domain.GroupLink{{Group: "workshop", DeviceID: "a111"}}) {
+		t.Errorf("Service.Export() links = %v", got.Links)
+	}
+
+	got, err = exportJSON(srv, "none")
+	if err != nil || got.OrganizationID != "none" || len(got.Devices) != 0 || len(got.Groups) != 0 {
+		t.Errorf("Service.Export() = %+v, %v, want an empty export", got, err)
+	}
+}
+
+func TestService_ImportInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(data *domain.Export)
+		wantErr error
+	}{
+		{"version", func(data *domain.Export) { data.Version = 0 }, datastore.ErrInvalid},
+		{"device-exists", func(data *domain.Export) { data.Devices[1].DeviceID, data.Links[0].DeviceID = "c333", "c333" }, nil},
+		{"device-other-org", func(data *domain.Export) { data.Devices[0].DeviceID = "x999" }, datastore.ErrConflict},
+		{"device-twice", func(data *domain.Export) { data.Devices[1].DeviceID = "a111" }, datastore.ErrInvalid},
+		{"device-no-id", func(data *domain.Export) { data.Devices[1].DeviceID = "" }, datastore.ErrInvalid},
+		{"label", func(data *domain.Export) { data.Devices[0].Labels["site"] = "not valid" }, datastore.ErrInvalid},
+		{"group-exists", func(data *domain.Export) { data.Groups = append(data.Groups, domain.Group{Name: "lab"}) }, nil},
+		{"group-twice", func(data *domain.Export) { data.Groups[0].Name = "site" }, datastore.ErrInvalid},
+		{"group-rule", func(data *domain.Export) { data.Groups[0].Rule = &domain.GroupRule{} }, datastore.ErrInvalid},
+		{"group-parent", func(data *domain.Export) { data.Groups[1].Parent = "missing" }, datastore.ErrInvalid},
+		{"group-cycle", func(data *domain.Export) { data.Groups[1].Parent = "workshop" }, datastore.ErrInvalid},
+		{"group-order", func(data *domain.Export) {
+			data.Groups[0], data.Groups[2] = data.Groups[2], data.Groups[0]
+		}, nil},
+		{"link-group", func(data *domain.Export) { data.Links[0].Group = "missing" }, datastore.ErrInvalid},
+		{"link-dynamic", func(data *domain.Export) { data.Links[0].Group = "drones" }, datastore.ErrInvalid},
+		{"link-device", func(data *domain.Export) { data.Links[0].DeviceID = "c333" }, datastore.ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := memory.NewEmptyStore()
+			for _, d := range []datastore.Device{
+				{OrganisationID: "abc", DeviceID: "c333", Brand: "example", Model: "drone-1000"},
+				{OrganisationID: "xyz", DeviceID: "x999", Brand: "example", Model: "drone-1000"},
+			} {
+				if _, err := db.DeviceCreate(context.Background(), d); err != nil {
+					t.Fatalf("Store.DeviceCreate() error = %v", err)
+				}
+			}
+			if _, err := db.GroupCreate(context.Background(), datastore.Group{OrganisationID: "abc", Name: "lab"}); err != nil {
+				t.Fatalf("Store.GroupCreate() error = %v", err)
+			}
+			srv := NewService(config.TestConfig(), db)
+
+			data := testExport()
+			tt.change(&data)
+			_, err := srv.Import(context.Background(), "abc", data)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("Service.Import() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Service.Import() error = %v, want %v", err, tt.wantErr)
+			}
+
+			// Nothing is created when the data is invalid
+			if _, err := db.DeviceGet(context.Background(), "b222"); err == nil {
+				t.Error("Service.Import() created a device")
+			}
+			groups, _ := db.GroupList(context.Background(), "abc")
+			if len(groups) != 1 {
+				t.Errorf("Service.Import() groups = %d, want 1", len(groups))
+			}
+		})
+	}
+}
+
+func TestService_ImportAgain(t *testing.T) {
+	db := memory.NewEmptyStore()
+	srv := NewService(config.TestConfig(), db)
+	data := testExport()
+
+	// An import that stopped after the devices is completed by running it again
+	partial := data
+	partial.Groups, partial.Links, partial.Actions = nil, nil, nil
+	if _, err := srv.Import(context.Background(), "abc", partial); err != nil {
+		t.Fatalf("Service.Import() error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		got, err := srv.Import(context.Background(), "abc", data)
+		if err != nil {
+			t.Fatalf("Service.Import() error = %v", err)
+		}
+		want := domain.ImportSummary{Devices: len(data.Devices), Groups: len(data.Groups), Links: len(data.Links), Actions: len(data.Actions)}
+		if got != want {
+			t.Errorf("Service.Import() = %+v, want %+v", got, want)
+		}
+	}
+
+	devices, _ := db.DeviceList(context.Background(), "abc", datastore.DeviceQuery{})
+	groups, _ := db.GroupList(context.Background(), "abc")
+	links, _ := db.GroupLinkList(context.Background(), "abc")
+	actions, _ := db.ActionListForDevice(context.Background(), "abc", "a111", datastore.ActionQuery{})
+	if len(devices) != len(data.Devices) || len(groups) != len(data.Groups) || len(links) != len(data.Links) || len(actions) != 2 {
+		t.Errorf("Service.Import() = %d devices, %d groups, %d links, %d actions", len(devices), len(groups), len(links), len(actions))
+	}
+}
+
+func TestDevicesCSV(t *testing.T) {
+	devices := testExport().Devices
+	for i := range devices {
+		// Only the device records are in the CSV
+		devices[i].OrganizationID = ""
+		devices[i].Version = domain.DeviceVersion{}
+		devices[i].Snaps = nil
+	}
+
+	buf := &bytes.Buffer{}
+	enc := NewCSVExportWriter(buf)
+	for _, d := range devices {
+		if err := enc.WriteDevice(d); err != nil {
+			t.Fatalf("CSVExportWriter.WriteDevice() error = %v", err)
+		}
+	}
+	if err := enc.WriteGroup(domain.Group{Name: "site"}); err != nil {
+		t.Fatalf("CSVExportWriter.WriteGroup() error = %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("CSVExportWriter.Close() error = %v", err)
+	}
+	want := "deviceId,brand,model,serial,store,deviceKey,created,lastRefresh,labels\n" +
+		"a111,example,drone-1000,DR1000A111,example-store,AAAAAAAAA,2020-01-01T12:00:00Z,2020-02-01T12:00:00Z,\"floor=2,site=berlin\"\n" +
+		"b222,example,drone-1000,DR1000B222,,BBBBBBBBB,2020-01-01T13:00:00Z,2020-02-01T12:00:00Z,\n"
+	if buf.String() != want {
+		t.Errorf("CSVExportWriter = %s, want %s", buf.String(), want)
+	}
+
+	got, err := ReadDevicesCSV(buf)
+	if err != nil {
+		t.Fatalf("ReadDevicesCSV() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, devices) {
+		t.Errorf("ReadDevicesCSV() = %+v, want %+v", got, devices)
+	}
+
+	tests := []struct {
+		name    string
+		csv     string
+		want    int
+		wantErr bool
+	}{
+		{"minimal", "model,deviceId,brand\ndrone-1000,a111,example\n", 1, false},
+		{"empty", "deviceId,brand,model\n", 0, false},
+		{"no-header", "", 0, true},
+		{"missing-column", "deviceId,brand\na111,example\n", 0, true},
+		{"unknown-column", "deviceId,brand,model,colour\na111,example,drone-1000,red\n", 0, true},
+		{"invalid-time", "deviceId,brand,model,created\na111,example,drone-1000,yesterday\n", 0, true},
+		{"invalid-row", "deviceId,brand,model\na111,example\n", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadDevicesCSV(bytes.NewBufferString(tt.csv))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadDevicesCSV() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("ReadDevicesCSV() = %d devices, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONExportWriter(t *testing.T) {
+	want := testExport()
+
+	buf := &bytes.Buffer{}
+	enc := NewJSONExportWriter(buf, "abc")
+	if err := enc.WriteDevice(want.Devices[0]); err != nil {
+		t.Fatalf("JSONExportWriter.WriteDevice() error = %v", err)
+	}
+	for _, a := range want.Actions {
+		if err := enc.WriteAction(a); err != nil {
+			t.Fatalf("JSONExportWriter.WriteAction() error = %v", err)
+		}
+	}
+	if err := enc.WriteGroup(want.Groups[0]); err == nil {
+		t.Error("JSONExportWriter.WriteGroup() expected an error after the actions")
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("JSONExportWriter.Close() error = %v", err)
+	}
+
+	got := domain.Export{}
+	if err := json.NewDecoder(buf).Decode(&got); err != nil {
+		t.Fatalf("JSONExportWriter = %s, error = %v", buf.String(), err)
+	}
+	if got.Version != domain.ExportVersion || got.OrganizationID != "abc" || got.Exported.IsZero() {
+		t.Errorf("JSONExportWriter header = %+v", got)
+	}
+	if !reflect.DeepEqual(got.Devices, want.Devices[:1]) || !reflect.DeepEqual(got.Actions, want.Actions) {
+		t.Errorf("JSONExportWriter = %+v, want the device and actions", got)
+	}
+	if got.Groups == nil || len(got.Groups) != 0 || got.Links == nil || len(got.Links) != 0 {
+		t.Errorf("JSONExportWriter = %+v, want empty groups and links", got)
+	}
+}
@@ -0,0 +1,97 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package events broadcasts the changes to the device twins between the instances of the
+// service, so they can invalidate their caches and notify their subscribers
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// The kinds of event
+const (
+	KindDevice  = "device"  // a device was created or changed
+	KindVersion = "version" // the OS details of a device changed
+	KindAction  = "action"  // an action was created or its status changed
+	KindReset   = "reset"   // events may have been missed, so everything derived from them must be dropped
+)
+
+// Event is a change to the device twins
+type Event struct {
+	Kind     string `json:"kind"`
+	Source   string `json:"source,omitempty"` // the instance that published the event
+	OrgID    string `json:"orgId,omitempty"`
+	DeviceID string `json:"deviceId,omitempty"`
+	ID       int64  `json:"id,omitempty"` // the record ID of the device
+	ActionID string `json:"actionId,omitempty"`
+	Status   string `json:"status,omitempty"`
+}
+
+// Handler is called with each event. Events may be delivered more than once, and the events
+// published by an instance are also delivered to that instance, so handlers must be idempotent
+type Handler func(e Event)
+
+// Bus is the interface to publish and subscribe to the events
+type Bus interface {
+	Publish(ctx context.Context, e Event) error
+	Subscribe(handler Handler) (unsubscribe func())
+	Close() error
+}
+
+// subscribers holds the handlers of a bus
+type subscribers struct {
+	lock     sync.RWMutex
+	next     int
+	handlers map[int]Handler
+}
+
+// Subscribe adds a handler for the events, returning the function to remove it
+func (s *subscribers) Subscribe(handler Handler) func() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.handlers == nil {
+		s.handlers = map[int]Handler{}
+	}
+	id := s.next
+	s.next++
+	s.handlers[id] = handler
+
+	return func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		delete(s.handlers, id)
+	}
+}
+
+// deliver calls the handlers with an event
+func (s *subscribers) deliver(e Event) {
+	s.lock.RLock()
+	handlers := make([]Handler, 0, len(s.handlers))
+	for _, h := range s.handlers {
+		handlers = append(handlers, h)
+	}
+	s.lock.RUnlock()
+
+	for _, h := range handlers {
+		h(e)
+	}
+}
@@ -0,0 +1,44 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package events
+
+import "context"
+
+// MemoryBus delivers the events within the process, for the data stores that are not
+// shared between instances of the service
+type MemoryBus struct {
+	subscribers
+}
+
+// NewMemoryBus creates an in-process event bus
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+// Publish delivers an event to the subscribers before returning
+func (bus *MemoryBus) Publish(ctx context.Context, e Event) error {
+	bus.deliver(e)
+	return nil
+}
+
+// Close does nothing, as there is no connection to close
+func (bus *MemoryBus) Close() error {
+	return nil
+}
@@ -0,0 +1,53 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package events
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMemoryBus(t *testing.T) {
+	bus := NewMemoryBus()
+	var first, second []Event
+	bus.Subscribe(func(e Event) { first = append(first, e) })
+	unsubscribe := bus.Subscribe(func(e Event) { second = append(second, e) })
+
+	e1 := Event{Kind: KindDevice, OrgID: "abc", DeviceID: "a111"}
+	e2 := Event{Kind: KindAction, OrgID: "abc", DeviceID: "a111", ActionID: "a1", Status: "complete"}
+	if err := bus.Publish(context.Background(), e1); err != nil {
+		t.Fatalf("MemoryBus.Publish() error = %v", err)
+	}
+	unsubscribe()
+	if err := bus.Publish(context.Background(), e2); err != nil {
+		t.Fatalf("MemoryBus.Publish() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(first, []Event{e1, e2}) {
+		t.Errorf("MemoryBus.Publish() first = %v", first)
+	}
+	if !reflect.DeepEqual(second, []Event{e1}) {
+		t.Errorf("MemoryBus.Publish() second = %v, want only the event before unsubscribing", second)
+	}
+	if err := bus.Close(); err != nil {
+		t.Errorf("MemoryBus.Close() error = %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Channel is the postgres notification channel of the events
+const Channel = "devicetwin_events"
+
+const (
+	minReconnect   = 10 * time.Second
+	maxReconnect   = time.Minute
+	pingInterval   = 90 * time.Second
+	connectTimeout = 30 * time.Second
+)
+
+const notifySQL = "select pg_notify($1, $2)"
+
+// PostgresBus broadcasts the events to the instances of the service that share a postgres
+// database, using LISTEN/NOTIFY. Notifications are only delivered to the connected listeners,
+// so a reset event is delivered when the listener reconnects
+type PostgresBus struct {
+	subscribers
+	db       *sql.DB
+	listener *pq.Listener
+	done     chan struct{}
+}
+
+// NewPostgresBus connects to the database and starts listening for the events
+func NewPostgresBus(dataSource string) (*PostgresBus, error) {
+	db, err := sql.Open("postgres", dataSource)
+	if err != nil {
+		return nil, fmt.Errorf("error opening the database for the events: %v", err)
+	}
+
+	// The listener retries until it connects, so check the database is reachable first
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("error connecting to the database for the events: %v", err)
+	}
+
+	listener := pq.NewListener(dataSource, minReconnect, maxReconnect, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Error in the events listener: %v", err)
+		}
+	})
+	if err := listener.Listen(Channel); err != nil {
+		_ = listener.Close()
+		_ = db.Close()
+		return nil, fmt.Errorf("error listening for the events: %v", err)
+	}
+
+	bus := &PostgresBus{db: db, listener: listener, done: make(chan struct{})}
+	go bus.run()
+	return bus, nil
+}
+
+// Publish notifies the listeners of all the instances of an event
+func (bus *PostgresBus) Publish(ctx context.Context, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = bus.db.ExecContext(ctx, notifySQL, Channel, string(b))
+	return err
+}
+
+// Close stops listening for the events
+func (bus *PostgresBus) Close() error {
+	err := bus.listener.Close()
+	<-bus.done
+	if e := bus.db.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// run delivers the notifications to the subscribers until the listener is closed
+func (bus *PostgresBus) run() {
+	defer close(bus.done)
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case n, ok := <-bus.listener.Notify:
+			if !ok {
+				return
+			}
+			if n == nil {
+				// The connection was re-established, and notifications may have been missed
+				bus.deliver(Event{Kind: KindReset})
+				continue
+			}
+
+			e := Event{}
+			if err := json.Unmarshal([]byte(n.Extra), &e); err != nil {
+				log.Printf("Error in event notification: %v", err)
+				continue
+			}
+			bus.deliver(e)
+		case <-ticker.C:
+			// Check the connection, so a lost connection is noticed while it is idle
+			go func() {
+				if err := bus.listener.Ping(); err != nil {
+					log.Printf("Error checking the events listener: %v", err)
+				}
+			}()
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package events
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// postgresDataSourceEnv is the environment variable with the data source of a test database
+const postgresDataSourceEnv = "DEVICETWIN_TEST_POSTGRES"
+
+func TestPostgresBus(t *testing.T) {
+	dataSource := os.Getenv(postgresDataSourceEnv)
+	if len(dataSource) == 0 {
+		t.Skipf("%s is not set", postgresDataSourceEnv)
+	}
+
+	// Two instances of the service
+	sender, err := NewPostgresBus(dataSource)
+	if err != nil {
+		t.Fatalf("NewPostgresBus() error = %v", err)
+	}
+	defer sender.Close()
+	receiver, err := NewPostgresBus(dataSource)
+	if err != nil {
+		t.Fatalf("NewPostgresBus() error = %v", err)
+	}
+	defer receiver.Close()
+
+	received := make(chan Event, 1)
+	receiver.Subscribe(func(e Event) { received <- e })
+
+	want := Event{Kind: KindVersion, Source: "devicetwin-a", OrgID: "abc", DeviceID: "a111", ID: 1}
+	if err := sender.Publish(context.Background(), want); err != nil {
+		t.Fatalf("PostgresBus.Publish() error = %v", err)
+	}
+
+	select {
+	case got := <-received:
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("PostgresBus.Publish() = %v, want %v", got, want)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("PostgresBus.Publish() event was not received")
+	}
+}
+
+func TestNewPostgresBus_Invalid(t *testing.T) {
+	if _, err := NewPostgresBus("host=/nonexistent sslmode=disable connect_timeout=1"); err == nil {
+		t.Error("NewPostgresBus() expected an error")
+	}
+}
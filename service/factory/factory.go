@@ -23,21 +23,102 @@ import (
 	"fmt"
 	"github.com/canonical/iot-devicetwin/config"
 	"github.com/canonical/iot-devicetwin/datastore"
+	"github.com/canonical/iot-devicetwin/datastore/crypt"
 	"github.com/canonical/iot-devicetwin/datastore/memory"
 	"github.com/canonical/iot-devicetwin/datastore/postgres"
+	"github.com/canonical/iot-devicetwin/datastore/sqlite"
+	"github.com/canonical/iot-devicetwin/service/events"
+	"github.com/canonical/iot-devicetwin/service/leader"
+	"github.com/canonical/iot-devicetwin/service/mqtt"
 )
 
+// memoryDataSource is the data source for an empty memory store that is not persisted
+const memoryDataSource = ":memory:"
+
 // CreateDataStore is the factory method to create a data store
 func CreateDataStore(settings *config.Settings) (datastore.DataStore, error) {
 	var db datastore.DataStore
 	switch settings.Driver {
 	case "memory":
-		db = memory.NewStore()
+		switch settings.DataSource {
+		case "":
+			db = memory.NewStore()
+		case memoryDataSource:
+			db = memory.NewEmptyStore()
+		default:
+			mem, err := memory.OpenStore(settings.DataSource)
+			if err != nil {
+				return nil, fmt.Errorf("error opening memory store: %v", err)
+			}
+			db = mem
+		}
 	case "postgres":
-		db = postgres.OpenDataStore(settings.Driver, settings.DataSource)
+		db = postgres.OpenDataStore(settings.DataSource)
+	case "sqlite":
+		if len(settings.DataSource) == 0 {
+			return nil, fmt.Errorf("the sqlite driver requires the path to the database file as the data source")
+		}
+		db = sqlite.OpenDataStore(settings.DataSource)
 	default:
 		return nil, fmt.Errorf("unknown data store driver: %v", settings.Driver)
 	}
 
+	// Encrypt the sensitive fields at rest with the configured secret
+	if len(settings.KeySecret) > 0 {
+		keys, err := crypt.NewKeyring(append([]string{settings.KeySecret}, settings.OldSecrets...)...)
+		if err != nil {
+			return nil, fmt.Errorf("error creating the encryption keys: %v", err)
+		}
+		db = crypt.NewStore(db, keys)
+	}
+
 	return db, nil
 }
+
+// CreateEventBus is the factory method to create the bus for the events between the instances
+// of the service. Only a postgres database can be shared by several instances
+func CreateEventBus(settings *config.Settings) (events.Bus, error) {
+	if settings.Driver != "postgres" {
+		return events.NewMemoryBus(), nil
+	}
+
+	bus, err := events.NewPostgresBus(settings.DataSource)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the event bus: %v", err)
+	}
+	return bus, nil
+}
+
+// CreateElector is the factory method to create the leader election for the background jobs,
+// which run once across the instances of the service that share a postgres database
+func CreateElector(settings *config.Settings) (leader.Elector, error) {
+	if settings.Driver != "postgres" {
+		return leader.NewLocalElector(), nil
+	}
+
+	elector, err := leader.NewPostgresElector(settings.DataSource)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting for the leader election: %v", err)
+	}
+	return elector, nil
+}
+
+// CreateMQTT is the factory method to connect to the MQTT broker with the version of the protocol
+func CreateMQTT(settings *config.Settings) (mqtt.Connect, error) {
+	switch settings.MQTTVersion {
+	case 0, 3:
+		m, err := mqtt.GetConnection(settings)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	case 5:
+		m, err := mqtt.NewConnection5(settings)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unknown MQTT version: %v", settings.MQTTVersion)
+	}
+}
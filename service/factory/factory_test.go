@@ -20,24 +20,38 @@
 package factory
 
 import (
+	"os"
+	"path"
 	"testing"
 
 	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/datastore/crypt"
 )
 
 func TestCreateDataStore(t *testing.T) {
 	tests := []struct {
-		name    string
-		driver  string
-		wantErr bool
+		name       string
+		driver     string
+		dataSource string
+		wantErr    bool
 	}{
-		{"valid", "memory", false},
-		{"invalid", "invalid", true},
+		{"valid", "memory", "", false},
+		{"valid-memory-empty", "memory", ":memory:", false},
+		{"valid-memory-file", "memory", path.Join(os.TempDir(), "devicetwin-factory-test.json"), false},
+		{"invalid-memory-file", "memory", path.Join(os.TempDir(), "missing", "devicetwin.json"), true},
+		{"valid-sqlite", "sqlite", path.Join(os.TempDir(), "devicetwin-factory-test.db"), false},
+		{"invalid-sqlite-no-file", "sqlite", "", true},
+		{"invalid", "invalid", "", true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			settings := config.TestConfig()
 			settings.Driver = tt.driver
+			settings.DataSource = tt.dataSource
+			if len(tt.dataSource) > 0 {
+				defer os.Remove(tt.dataSource)
+				defer os.Remove(tt.dataSource + ".journal")
+			}
 
 			_, err := CreateDataStore(settings)
 			if (err != nil) != tt.wantErr {
@@ -47,3 +61,120 @@ func TestCreateDataStore(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateDataStore_Encryption(t *testing.T) {
+	tests := []struct {
+		name       string
+		secret     string
+		oldSecrets []string
+		encrypted  bool
+		wantErr    bool
+	}{
+		{"valid-no-secret", "", nil, false, false},
+		{"valid-secret", "secret", nil, true, false},
+		{"valid-old-secrets", "secret", []string{"previous"}, true, false},
+		{"invalid-old-secret", "secret", []string{""}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := config.TestConfig()
+			settings.KeySecret = tt.secret
+			settings.OldSecrets = tt.oldSecrets
+
+			db, err := CreateDataStore(settings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateDataStore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, ok := db.(*crypt.Store); ok != tt.encrypted {
+				t.Errorf("CreateDataStore() encrypted = %v, want %v", ok, tt.encrypted)
+			}
+		})
+	}
+}
+
+func TestCreateEventBus(t *testing.T) {
+	tests := []struct {
+		name       string
+		driver     string
+		dataSource string
+		wantErr    bool
+	}{
+		{"valid-memory", "memory", "", false},
+		{"valid-sqlite", "sqlite", path.Join(os.TempDir(), "devicetwin-factory-test.db"), false},
+		{"invalid-postgres", "postgres", "host=/nonexistent sslmode=disable connect_timeout=1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := config.TestConfig()
+			settings.Driver = tt.driver
+			settings.DataSource = tt.dataSource
+
+			bus, err := CreateEventBus(settings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateEventBus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if bus != nil {
+				_ = bus.Close()
+			}
+		})
+	}
+}
+
+func TestCreateElector(t *testing.T) {
+	tests := []struct {
+		name       string
+		driver     string
+		dataSource string
+		wantErr    bool
+	}{
+		{"valid-memory", "memory", "", false},
+		{"valid-sqlite", "sqlite", path.Join(os.TempDir(), "devicetwin-factory-test.db"), false},
+		{"invalid-postgres", "postgres", "host=/nonexistent sslmode=disable connect_timeout=1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := config.TestConfig()
+			settings.Driver = tt.driver
+			settings.DataSource = tt.dataSource
+
+			elector, err := CreateElector(settings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateElector() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if elector != nil {
+				_ = elector.Close()
+			}
+		})
+	}
+}
+
+func TestCreateMQTT(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   int
+		transport string
+		port      string
+	}{
+		{"invalid-version", 4, "tcp", "1883"},
+		{"invalid-transport-v3", 3, "invalid", "1883"},
+		{"invalid-transport-v5", 5, "invalid", "1883"},
+		{"invalid-broker-v5", 5, "tcp", "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := config.TestConfig()
+			settings.MQTTVersion = tt.version
+			settings.Transport = tt.transport
+			settings.MQTTUrl = "127.0.0.1"
+			settings.MQTTPort = tt.port
+
+			m, err := CreateMQTT(settings)
+			if err == nil {
+				t.Fatalf("CreateMQTT() expected error, got none")
+			}
+			if m != nil {
+				t.Errorf("CreateMQTT() = %v, want nil", m)
+			}
+		})
+	}
+}
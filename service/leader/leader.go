@@ -0,0 +1,100 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package leader elects one instance of the service to run each background job, so the
+// jobs run once in the cluster rather than once in each instance. The local jobs run on
+// every instance
+package leader
+
+import (
+	"context"
+	"sync"
+)
+
+// Job is a background job, which runs until its context is cancelled
+type Job func(ctx context.Context)
+
+// Elector runs the jobs on the instance that is elected as their leader
+type Elector interface {
+	// Run runs a job while this instance is its leader, until the context is cancelled. The context
+	// of the job is cancelled when the leadership is lost, and the instance tries to become the
+	// leader again. Run returns when the job returns while it is the leader
+	Run(ctx context.Context, name string, job Job)
+	Close() error
+}
+
+// Jobs holds the background jobs that are registered by the services
+type Jobs struct {
+	elector Elector
+	lock    sync.Mutex
+	jobs    map[string]Job
+	local   map[string]Job
+}
+
+// NewJobs creates the registry of background jobs, which are run with an elector
+func NewJobs(elector Elector) *Jobs {
+	return &Jobs{elector: elector, jobs: map[string]Job{}, local: map[string]Job{}}
+}
+
+// Register adds a job that runs once in the cluster, which replaces a job with the same name
+func (j *Jobs) Register(name string, job Job) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.jobs[name] = job
+}
+
+// RegisterLocal adds a job that runs on every instance without an election, for the work on
+// the state that is held by each instance. It replaces a local job with the same name
+func (j *Jobs) RegisterLocal(name string, job Job) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.local[name] = job
+}
+
+// Run runs the local jobs, and each job when this instance is its leader, until the context
+// is cancelled and the jobs have returned
+func (j *Jobs) Run(ctx context.Context) {
+	j.lock.Lock()
+	jobs := map[string]Job{}
+	for name, job := range j.jobs {
+		jobs[name] = job
+	}
+	local := []Job{}
+	for _, job := range j.local {
+		local = append(local, job)
+	}
+	j.lock.Unlock()
+
+	wg := sync.WaitGroup{}
+	for name, job := range jobs {
+		wg.Add(1)
+		go func(name string, job Job) {
+			defer wg.Done()
+			j.elector.Run(ctx, name, job)
+		}(name, job)
+	}
+	for _, job := range local {
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			job(ctx)
+		}(job)
+	}
+	wg.Wait()
+}
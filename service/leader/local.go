@@ -0,0 +1,81 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package leader
+
+import (
+	"context"
+	"sync"
+)
+
+// LocalElector elects the leaders within the process, for the data stores that are not shared
+// between instances of the service. A job runs at most once at a time for each name
+type LocalElector struct {
+	lock sync.Mutex
+	held map[string]chan struct{} // closed when the leader of the job stops
+}
+
+// NewLocalElector creates an in-process elector
+func NewLocalElector() *LocalElector {
+	return &LocalElector{held: map[string]chan struct{}{}}
+}
+
+// Run runs a job when no other job with the same name is running in the process
+func (e *LocalElector) Run(ctx context.Context, name string, job Job) {
+	released, ok := e.acquire(ctx, name)
+	if !ok {
+		return
+	}
+	defer e.release(name, released)
+
+	job(ctx)
+}
+
+// acquire waits until the job can run, or the context is cancelled
+func (e *LocalElector) acquire(ctx context.Context, name string) (chan struct{}, bool) {
+	for {
+		e.lock.Lock()
+		held, ok := e.held[name]
+		if !ok {
+			released := make(chan struct{})
+			e.held[name] = released
+			e.lock.Unlock()
+			return released, true
+		}
+		e.lock.Unlock()
+
+		select {
+		case <-held:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// Close does nothing, as there is no connection to close
+func (e *LocalElector) Close() error {
+	return nil
+}
+
+func (e *LocalElector) release(name string, released chan struct{}) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	delete(e.held, name)
+	close(released)
+}
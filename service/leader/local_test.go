@@ -0,0 +1,116 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package leader
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLocalElector_Failover(t *testing.T) {
+	e := NewLocalElector()
+	started := make(chan string, 2)
+	job := func(instance string) Job {
+		return func(ctx context.Context) {
+			started <- instance
+			<-ctx.Done()
+		}
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	go e.Run(ctx1, "purge", job("first"))
+	if got := waitFor(t, started); got != "first" {
+		t.Fatalf("LocalElector.Run() leader = %s, want first", got)
+	}
+
+	// The second instance only runs the job when the first stops
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx2, "purge", job("second"))
+		close(done)
+	}()
+	select {
+	case got := <-started:
+		t.Fatalf("LocalElector.Run() %s started while the first was the leader", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel1()
+	if got := waitFor(t, started); got != "second" {
+		t.Fatalf("LocalElector.Run() leader = %s, want second", got)
+	}
+	cancel2()
+	<-done
+}
+
+func TestLocalElector_Cancelled(t *testing.T) {
+	e := NewLocalElector()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A cancelled follower returns without running the job
+	released, _ := e.acquire(context.Background(), "purge")
+	e.Run(ctx, "purge", func(ctx context.Context) { t.Error("LocalElector.Run() ran the job") })
+	e.release("purge", released)
+
+	if err := e.Close(); err != nil {
+		t.Errorf("LocalElector.Close() error = %v", err)
+	}
+}
+
+func TestJobs_Run(t *testing.T) {
+	jobs := NewJobs(NewLocalElector())
+	lock := sync.Mutex{}
+	ran := map[string]bool{}
+	for _, name := range []string{"purge", "reconcile"} {
+		name := name
+		jobs.Register(name, func(ctx context.Context) {
+			lock.Lock()
+			ran[name] = true
+			lock.Unlock()
+		})
+	}
+	jobs.RegisterLocal("flush", func(ctx context.Context) {
+		lock.Lock()
+		ran["flush"] = true
+		lock.Unlock()
+	})
+
+	// Run returns once the jobs have returned
+	jobs.Run(context.Background())
+	if !ran["purge"] || !ran["reconcile"] || !ran["flush"] {
+		t.Errorf("Jobs.Run() ran = %v, want all the jobs", ran)
+	}
+}
+
+func waitFor(t *testing.T, started chan string) string {
+	t.Helper()
+	select {
+	case s := <-started:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job did not start")
+		return ""
+	}
+}
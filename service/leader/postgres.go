@@ -0,0 +1,173 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"time"
+
+	// The postgres driver for the elector's connections
+	_ "github.com/lib/pq"
+)
+
+const (
+	retryInterval  = 5 * time.Second // how often a follower tries to become the leader
+	checkInterval  = 5 * time.Second // how often the leader checks it still holds the lock
+	connectTimeout = 30 * time.Second
+)
+
+const (
+	tryLockSQL = "select pg_try_advisory_lock($1)"
+	unlockSQL  = "select pg_advisory_unlock($1)"
+)
+
+// PostgresElector elects the leaders of the instances that share a postgres database, with
+// session advisory locks. The leader holds a connection with the lock of the job, which
+// postgres releases when the connection is lost, e.g. when the leader dies, so another
+// instance takes over
+type PostgresElector struct {
+	db    *sql.DB
+	retry time.Duration
+	check time.Duration
+}
+
+// NewPostgresElector connects to the database for the leader election
+func NewPostgresElector(dataSource string) (*PostgresElector, error) {
+	db, err := sql.Open("postgres", dataSource)
+	if err != nil {
+		return nil, fmt.Errorf("error opening the database for the leader election: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("error connecting to the database for the leader election: %v", err)
+	}
+	return &PostgresElector{db: db, retry: retryInterval, check: checkInterval}, nil
+}
+
+// Close closes the connections to the database
+func (e *PostgresElector) Close() error {
+	return e.db.Close()
+}
+
+// Run runs a job while this instance holds the advisory lock of the job
+func (e *PostgresElector) Run(ctx context.Context, name string, job Job) {
+	key := lockKey(name)
+	for {
+		conn, err := e.lock(ctx, key)
+		if err != nil {
+			log.Printf("Error in the leader election of `%s`: %v", name, err)
+		}
+		if conn != nil {
+			log.Printf("Leading job `%s`", name)
+			if finished := e.lead(ctx, conn, key, job); finished {
+				return
+			}
+			log.Printf("Lost the leadership of job `%s`", name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.retry):
+		}
+	}
+}
+
+// lock tries to take the advisory lock on a dedicated connection, returning
+// the connection when this instance is the leader
+func (e *PostgresElector) lock(ctx context.Context, key int64) (*sql.Conn, error) {
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, tryLockSQL, key).Scan(&locked); err != nil || !locked {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// lead runs the job until it returns, the context is cancelled or the lock is lost.
+// Returns true when the job returned by itself while it was the leader
+func (e *PostgresElector) lead(ctx context.Context, conn *sql.Conn, key int64, job Job) bool {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		job(jobCtx)
+		close(done)
+	}()
+
+	ticker := time.NewTicker(e.check)
+	defer ticker.Stop()
+	finished := false
+loop:
+	for {
+		select {
+		case <-done:
+			finished = ctx.Err() == nil
+			break loop
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+			// The lock is lost with the connection
+			if err := conn.PingContext(ctx); err != nil {
+				log.Printf("Error checking the leader connection: %v", err)
+				break loop
+			}
+		}
+	}
+
+	// Stop the job before another instance can become the leader
+	cancel()
+	<-done
+	e.unlock(conn, key)
+	return finished
+}
+
+// unlock releases the lock and returns the connection to the pool, or closes it if it was lost
+func (e *PostgresElector) unlock(conn *sql.Conn, key int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	var unlocked bool
+	if err := conn.QueryRowContext(ctx, unlockSQL, key).Scan(&unlocked); err != nil {
+		// Discard the connection, so postgres releases the lock when it is closed
+		_ = conn.Raw(func(driverConn interface{}) error { return driver.ErrBadConn })
+	}
+	_ = conn.Close()
+}
+
+// lockKey is the advisory lock key of a job
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte("devicetwin/" + name))
+	return int64(h.Sum64())
+}
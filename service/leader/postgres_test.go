@@ -0,0 +1,105 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package leader
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// postgresDataSourceEnv is the environment variable with the data source of a test database
+const postgresDataSourceEnv = "DEVICETWIN_TEST_POSTGRES"
+
+func TestPostgresElector_Failover(t *testing.T) {
+	dataSource := os.Getenv(postgresDataSourceEnv)
+	if len(dataSource) == 0 {
+		t.Skipf("%s is not set", postgresDataSourceEnv)
+	}
+
+	// Two instances of the service, which check often
+	electors := []*PostgresElector{}
+	for i := 0; i < 2; i++ {
+		e, err := NewPostgresElector(dataSource)
+		if err != nil {
+			t.Fatalf("NewPostgresElector() error = %v", err)
+		}
+		e.retry = 50 * time.Millisecond
+		e.check = 50 * time.Millisecond
+		electors = append(electors, e)
+	}
+	defer electors[1].Close()
+
+	started := make(chan int, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i, e := range electors {
+		i := i
+		go e.Run(ctx, "test-failover", func(ctx context.Context) {
+			started <- i
+			<-ctx.Done()
+		})
+	}
+
+	first := waitForLeader(t, started)
+	select {
+	case i := <-started:
+		t.Fatalf("PostgresElector.Run() instance %d started while %d was the leader", i, first)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if first != 0 {
+		electors[0], electors[1] = electors[1], electors[0]
+	}
+
+	// The leader dies, closing its connections
+	if err := electors[0].Close(); err != nil {
+		t.Fatalf("PostgresElector.Close() error = %v", err)
+	}
+	if second := waitForLeader(t, started); second == first {
+		t.Errorf("PostgresElector.Run() leader = %d, want the other instance", second)
+	}
+}
+
+func TestNewPostgresElector_Invalid(t *testing.T) {
+	if _, err := NewPostgresElector("host=/nonexistent sslmode=disable connect_timeout=1"); err == nil {
+		t.Error("NewPostgresElector() expected an error")
+	}
+}
+
+func TestLockKey(t *testing.T) {
+	if lockKey("purge") != lockKey("purge") {
+		t.Error("lockKey() expected the same key for a job")
+	}
+	if lockKey("purge") == lockKey("reconcile") {
+		t.Error("lockKey() expected different keys for different jobs")
+	}
+}
+
+func waitForLeader(t *testing.T, started chan int) int {
+	t.Helper()
+	select {
+	case i := <-started:
+		return i
+	case <-time.After(10 * time.Second):
+		t.Fatal("no instance became the leader")
+		return -1
+	}
+}
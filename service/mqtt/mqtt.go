@@ -22,10 +22,14 @@ package mqtt
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/domain"
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 	"log"
+	"strings"
+	"time"
 )
 
 // Constants for connecting to the MQTT broker
@@ -36,18 +40,54 @@ const (
 	//QOSExactlyOnce = byte(2)
 )
 
+// publishTimeout is how long a publish waits for the broker to acknowledge it
+const publishTimeout = 10 * time.Second
+
+// ErrDisconnected is returned by a publish while the connection to the MQTT broker is down
+var ErrDisconnected = errors.New("not connected to the MQTT broker")
+
 var conn *Connection
 var client MQTT.Client
 
 // Connect is the interface for an MQTT connection
 type Connect interface {
 	Publish(topic, payload string) error
+	Request(req Request) error
 	Subscribe(topic string, callback MQTT.MessageHandler) error
+	Status() domain.MQTTStats
 	Close()
 }
 
-// Connection for MQTT protocol
+// Request is an action sent to a device. With MQTT v5, the response topic, the correlation ID
+// and the organization ID are sent as properties of the message, which the broker discards once
+// the expiry has passed. With MQTT v3.1.1, only the payload is sent
+type Request struct {
+	Topic         string
+	Payload       []byte
+	ResponseTopic string
+	CorrelationID string
+	OrgID         string
+	Expiry        time.Duration // zero for no expiry
+}
+
+// Properties are the MQTT v5 properties of a message from a device, which are empty with MQTT v3.1.1
+type Properties struct {
+	CorrelationID string
+	OrgID         string
+}
+
+// MessageProperties returns the MQTT v5 properties of a message
+func MessageProperties(msg MQTT.Message) Properties {
+	if m, ok := msg.(interface{ Properties() Properties }); ok {
+		return m.Properties()
+	}
+	return Properties{}
+}
+
+// Connection for MQTT protocol. The subscriptions are restored each time the client reconnects,
+// as the broker drops them with a clean session
 type Connection struct {
+	*state
 	client   MQTT.Client
 	clientID string
 }
@@ -55,17 +95,19 @@ type Connection struct {
 // GetConnection fetches or creates an MQTT connection
 func GetConnection(settings *config.Settings) (*Connection, error) {
 	if conn == nil {
+		// Create a new connection
+		c := &Connection{
+			state:    newState(),
+			clientID: settings.MQTTConnect.ClientID,
+		}
+
 		// Create the client
-		client, err := newClient(settings)
+		cli, err := newClient(settings, c)
 		if err != nil {
 			return nil, err
 		}
-
-		// Create a new connection
-		conn = &Connection{
-			client:   client,
-			clientID: settings.MQTTConnect.ClientID,
-		}
+		c.client = cli
+		conn = c
 	}
 
 	// Check that we have a live connection
@@ -75,6 +117,7 @@ func GetConnection(settings *config.Settings) (*Connection, error) {
 
 	// Connect to the MQTT broker
 	if token := conn.client.Connect(); token.Wait() && token.Error() != nil {
+		conn.failed(token.Error())
 		return nil, token.Error()
 	}
 
@@ -82,74 +125,108 @@ func GetConnection(settings *config.Settings) (*Connection, error) {
 }
 
 // newClient creates a new MQTT client
-func newClient(settings *config.Settings) (MQTT.Client, error) {
+func newClient(settings *config.Settings, c *Connection) (MQTT.Client, error) {
 	// Return the active client, if we have one
 	if client != nil {
 		return client, nil
 	}
 
 	// Generate a new MQTT client
-	url := fmt.Sprintf("ssl://%s:%s", settings.MQTTUrl, settings.MQTTPort)
-	log.Println("Connect to the MQTT broker", url)
-
-	// Generate the TLS config from the enrollment credentials
-	tlsConfig, err := newTLSConfig(settings)
+	url, err := brokerURL(settings)
 	if err != nil {
 		return nil, err
 	}
+	log.Println("Connect to the MQTT broker", url)
 
 	// Set up the MQTT client options
 	opts := MQTT.NewClientOptions()
 	opts.AddBroker(url)
 	opts.SetClientID(settings.MQTTConnect.ClientID)
-	opts.SetTLSConfig(tlsConfig)
+	opts.SetUsername(settings.MQTTConnect.Username)
+	opts.SetPassword(settings.MQTTConnect.Password)
 	opts.AutoReconnect = true
+	opts.SetOnConnectHandler(c.onConnect)
+	opts.SetConnectionLostHandler(c.onConnectionLost)
+
+	// Generate the TLS config from the enrollment credentials
+	if config.Transports[settings.Transport] {
+		tlsConfig, err := newTLSConfig(settings)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
 
 	client = MQTT.NewClient(opts)
 	return client, nil
 }
 
-// newTLSConfig sets up the certificates from the enrollment record
+// brokerURL returns the URL of the MQTT broker for the transport
+func brokerURL(settings *config.Settings) (string, error) {
+	switch settings.Transport {
+	case "ssl", "tcp":
+		return fmt.Sprintf("%s://%s:%s", settings.Transport, settings.MQTTUrl, settings.MQTTPort), nil
+	case "ws", "wss":
+		return fmt.Sprintf("%s://%s:%s/%s", settings.Transport, settings.MQTTUrl, settings.MQTTPort, strings.TrimPrefix(settings.MQTTPath, "/")), nil
+	default:
+		return "", fmt.Errorf("unknown MQTT transport `%s`", settings.Transport)
+	}
+}
+
+// newTLSConfig sets up the certificates from the enrollment record. The certificate of the broker
+// is verified against the root CA, for the server name when it is set or the host name of the URL
 func newTLSConfig(settings *config.Settings) (*tls.Config, error) {
 	certPool := x509.NewCertPool()
-	certPool.AppendCertsFromPEM(settings.MQTTConnect.RootCA)
+	if !certPool.AppendCertsFromPEM(settings.MQTTConnect.RootCA) {
+		return nil, fmt.Errorf("no certificates in the root CA of the MQTT broker")
+	}
 
-	// Import client certificate/key pair
-	cert, err := tls.X509KeyPair(settings.MQTTConnect.ClientCert, settings.MQTTConnect.ClientKey)
-	if err != nil {
-		return nil, err
+	tlsConfig := &tls.Config{
+		RootCAs:    certPool,
+		ServerName: settings.MQTTConnect.ServerName,
 	}
 
-	// Create tls.Config with desired TLS properties
-	return &tls.Config{
-		// RootCAs = certs used to verify server cert.
-		RootCAs: certPool,
-		// ClientAuth = whether to request cert from server.
-		// Since the server is set up for SSL, this happens
-		// anyways.
-		ClientAuth: tls.NoClientCert,
-		// ClientCAs = certs used to validate client cert.
-		ClientCAs: nil,
-		// InsecureSkipVerify = verify that cert contents
-		// match server. IP matches what is in cert etc.
-		InsecureSkipVerify: true,
-		// Certificates = list of certs client sends to server.
-		Certificates: []tls.Certificate{cert},
-	}, nil
-}
-
-// Publish sends data to the MQTT broker
+	// Import client certificate/key pair, unless the broker uses a username and password instead
+	if len(settings.MQTTConnect.ClientCert) > 0 || len(settings.MQTTConnect.ClientKey) > 0 {
+		cert, err := tls.X509KeyPair(settings.MQTTConnect.ClientCert, settings.MQTTConnect.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// Publish sends data to the MQTT broker. It fails straight away while the client is disconnected,
+// rather than queueing the message until the client reconnects
 func (c *Connection) Publish(topic, payload string) error {
+	if !c.client.IsConnectionOpen() {
+		c.publishFailed()
+		return ErrDisconnected
+	}
+
 	token := c.client.Publish(topic, QOSAtLeastOnce, false, payload)
-	token.Wait()
+	if !token.WaitTimeout(publishTimeout) {
+		c.publishFailed()
+		return fmt.Errorf("timeout publishing to `%s`", topic)
+	}
 	if token.Error() != nil {
+		c.publishFailed()
 		return token.Error()
 	}
 	return nil
 }
 
-// Subscribe starts a new subscription, providing a message handler for the topic
+// Request sends an action to a device. MQTT v3.1.1 has no message properties, so only the
+// payload is published
+func (c *Connection) Request(req Request) error {
+	return c.Publish(req.Topic, string(req.Payload))
+}
+
+// Subscribe starts a new subscription, providing a message handler for the topic. The
+// subscription is kept, to restore it when the client reconnects
 func (c *Connection) Subscribe(topic string, callback MQTT.MessageHandler) error {
+	c.subscribed(topic, callback)
 	token := c.client.Subscribe(topic, QOSAtLeastOnce, callback)
 	token.Wait()
 	if token.Error() != nil {
@@ -158,7 +235,31 @@ func (c *Connection) Subscribe(topic string, callback MQTT.MessageHandler) error
 	return nil
 }
 
+// Status returns the state of the connection to the MQTT broker
+func (c *Connection) Status() domain.MQTTStats {
+	status := c.stats()
+	status.Connected = c.client.IsConnectionOpen()
+	return status
+}
+
 // Close closes the connection to the MQTT broker
 func (c *Connection) Close() {
 	c.client.Disconnect(quiesce)
 }
+
+// onConnect restores the subscriptions when the client reconnects
+func (c *Connection) onConnect(client MQTT.Client) {
+	for topic, callback := range c.connected() {
+		token := client.Subscribe(topic, QOSAtLeastOnce, callback)
+		token.Wait()
+		if token.Error() != nil {
+			log.Printf("Error restoring the subscription to `%s`: %v", topic, token.Error())
+			c.failed(token.Error())
+		}
+	}
+}
+
+// onConnectionLost records the loss of the connection
+func (c *Connection) onConnectionLost(client MQTT.Client, err error) {
+	c.lost(err)
+}
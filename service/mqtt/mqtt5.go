@@ -0,0 +1,276 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/domain"
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"log"
+	"net/url"
+	"time"
+)
+
+// UserPropertyOrgID is the user property of an MQTT v5 message for the organization ID
+const UserPropertyOrgID = "orgId"
+
+// keepAlive is the interval, in seconds, of the pings to the MQTT broker
+const keepAlive = 30
+
+// Connection5 is a connection to the MQTT broker with MQTT v5. Actions are sent to devices with
+// the response topic and the correlation data, so a device does not need to know the topics of
+// the service and the response is matched to the action by the properties of the message.
+// The subscriptions are restored each time the client reconnects, as with Connection
+type Connection5 struct {
+	*state
+	manager *autopaho.ConnectionManager
+	router  *paho.StandardRouter
+	cancel  context.CancelFunc
+}
+
+// NewConnection5 connects to the MQTT broker with MQTT v5. The client reconnects by itself
+// once it is connected, but an error connecting the first time is returned
+func NewConnection5(settings *config.Settings) (*Connection5, error) {
+	u, err := brokerURL(settings)
+	if err != nil {
+		return nil, err
+	}
+	broker, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Connect to the MQTT broker with MQTT v5", u)
+
+	c := &Connection5{
+		state:  newState(),
+		router: paho.NewStandardRouter(),
+	}
+
+	// Report the first error connecting to the broker, rather than retrying
+	connectErrors := make(chan error, 1)
+
+	cfg := autopaho.ClientConfig{
+		BrokerUrls:     []*url.URL{broker},
+		KeepAlive:      keepAlive,
+		OnConnectionUp: c.onConnectionUp,
+		OnConnectError: func(err error) {
+			c.failed(err)
+			select {
+			case connectErrors <- err:
+			default:
+			}
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID:      settings.MQTTConnect.ClientID,
+			Router:        c.router,
+			OnClientError: c.lost,
+			OnServerDisconnect: func(d *paho.Disconnect) {
+				c.lost(fmt.Errorf("disconnected by the MQTT broker: reason code %d", d.ReasonCode))
+			},
+		},
+	}
+	if len(settings.MQTTConnect.Username) > 0 {
+		cfg.SetUsernamePassword(settings.MQTTConnect.Username, []byte(settings.MQTTConnect.Password))
+	}
+
+	// Generate the TLS config from the enrollment credentials
+	if config.Transports[settings.Transport] {
+		tlsConfig, err := newTLSConfig(settings)
+		if err != nil {
+			return nil, err
+		}
+		cfg.TlsCfg = tlsConfig
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	manager, err := autopaho.NewConnection(ctx, cfg)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	c.manager = manager
+	c.cancel = cancel
+
+	connected := make(chan struct{})
+	go func() {
+		if manager.AwaitConnection(ctx) == nil {
+			close(connected)
+		}
+	}()
+
+	select {
+	case <-connected:
+		return c, nil
+	case err := <-connectErrors:
+		cancel()
+		return nil, err
+	}
+}
+
+// Publish sends data to the MQTT broker, failing straight away while the client is disconnected
+func (c *Connection5) Publish(topic, payload string) error {
+	return c.publish(&paho.Publish{
+		QoS:     QOSAtLeastOnce,
+		Topic:   topic,
+		Payload: []byte(payload),
+	})
+}
+
+// Request sends an action to a device, with the response topic, the correlation ID, the
+// organization ID and the expiry as properties of the message
+func (c *Connection5) Request(req Request) error {
+	props := &paho.PublishProperties{
+		ResponseTopic: req.ResponseTopic,
+	}
+	if len(req.CorrelationID) > 0 {
+		props.CorrelationData = []byte(req.CorrelationID)
+	}
+	if len(req.OrgID) > 0 {
+		props.User.Add(UserPropertyOrgID, req.OrgID)
+	}
+	if req.Expiry > 0 {
+		expiry := uint32((req.Expiry + time.Second - 1) / time.Second)
+		props.MessageExpiry = &expiry
+	}
+
+	return c.publish(&paho.Publish{
+		QoS:        QOSAtLeastOnce,
+		Topic:      req.Topic,
+		Payload:    req.Payload,
+		Properties: props,
+	})
+}
+
+func (c *Connection5) publish(p *paho.Publish) error {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	if _, err := c.manager.Publish(ctx, p); err != nil {
+		c.publishFailed()
+		if errors.Is(err, autopaho.ConnectionDownError) {
+			return ErrDisconnected
+		}
+		return err
+	}
+	return nil
+}
+
+// Subscribe starts a new subscription, providing a message handler for the topic. The
+// subscription is kept, to restore it when the client reconnects
+func (c *Connection5) Subscribe(topic string, callback MQTT.MessageHandler) error {
+	c.subscribed(topic, callback)
+	c.router.RegisterHandler(topic, func(p *paho.Publish) {
+		callback(nil, &message5{p})
+	})
+	return subscribe(c.manager, topic)
+}
+
+func subscribe(manager *autopaho.ConnectionManager, topic string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	_, err := manager.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: map[string]paho.SubscribeOptions{
+			topic: {QoS: QOSAtLeastOnce},
+		},
+	})
+	return err
+}
+
+// Status returns the state of the connection to the MQTT broker
+func (c *Connection5) Status() domain.MQTTStats {
+	return c.stats()
+}
+
+// Close closes the connection to the MQTT broker
+func (c *Connection5) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), quiesce*time.Millisecond)
+	defer cancel()
+	if err := c.manager.Disconnect(ctx); err != nil {
+		log.Printf("Error disconnecting from the MQTT broker: %v", err)
+	}
+	c.cancel()
+}
+
+// onConnectionUp restores the subscriptions when the client reconnects. The handlers stay
+// registered with the router, so only the subscriptions are sent to the broker
+func (c *Connection5) onConnectionUp(manager *autopaho.ConnectionManager, connack *paho.Connack) {
+	for topic := range c.connected() {
+		if err := subscribe(manager, topic); err != nil {
+			log.Printf("Error restoring the subscription to `%s`: %v", topic, err)
+			c.failed(err)
+		}
+	}
+}
+
+// message5 adapts an MQTT v5 message to the message of the MQTT v3.1.1 client, so the
+// handlers are the same for both versions
+type message5 struct {
+	publish *paho.Publish
+}
+
+// Duplicate is not reported by the MQTT v5 client
+func (m *message5) Duplicate() bool {
+	return false
+}
+
+// Qos returns the QoS of the message
+func (m *message5) Qos() byte {
+	return m.publish.QoS
+}
+
+// Retained returns the retained flag of the message
+func (m *message5) Retained() bool {
+	return m.publish.Retain
+}
+
+// Topic returns the topic of the message
+func (m *message5) Topic() string {
+	return m.publish.Topic
+}
+
+// MessageID returns the packet ID of the message
+func (m *message5) MessageID() uint16 {
+	return m.publish.PacketID
+}
+
+// Payload returns the payload of the message
+func (m *message5) Payload() []byte {
+	return m.publish.Payload
+}
+
+// Ack is a no-op, as the client acknowledges the message
+func (m *message5) Ack() {}
+
+// Properties returns the correlation ID and the organization ID of the message
+func (m *message5) Properties() Properties {
+	if m.publish.Properties == nil {
+		return Properties{}
+	}
+	return Properties{
+		CorrelationID: string(m.publish.Properties.CorrelationData),
+		OrgID:         m.publish.Properties.User.Get(UserPropertyOrgID),
+	}
+}
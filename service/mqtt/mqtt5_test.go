@@ -0,0 +1,311 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mqtt
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-devicetwin/config"
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestConnection5(t *testing.T) {
+	broker := newTestBroker5(t)
+	defer broker.Close()
+
+	settings := config.TestConfig()
+	settings.Transport = "tcp"
+	settings.MQTTUrl = "127.0.0.1"
+	settings.MQTTPort = broker.port
+	settings.MQTTConnect.Username = "devicetwin"
+	settings.MQTTConnect.Password = "secret"
+
+	c, err := NewConnection5(settings)
+	if err != nil {
+		t.Fatalf("NewConnection5() error = %v", err)
+	}
+	defer c.Close()
+	connect := waitFor5(t, broker.connects).(*packets.Connect)
+	if connect.ClientID != "aaa" || connect.Username != "devicetwin" || string(connect.Password) != "secret" {
+		t.Errorf("NewConnection5() connect = %v", connect)
+	}
+
+	messages := make(chan MQTT.Message, 1)
+	if err := c.Subscribe("devices/pub/+", func(client MQTT.Client, msg MQTT.Message) { messages <- msg }); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if topic := waitFor(t, broker.subscribes); topic != "devices/pub/+" {
+		t.Errorf("Subscribe() topic = %v, want %v", topic, "devices/pub/+")
+	}
+
+	// The action is sent with the properties of the request
+	req := Request{
+		Topic:         "devices/sub/aa111",
+		Payload:       []byte(`{"action": "list"}`),
+		ResponseTopic: "devices/pub/aa111",
+		CorrelationID: "a1",
+		OrgID:         "abc",
+		Expiry:        90500 * time.Millisecond,
+	}
+	if err := c.Request(req); err != nil {
+		t.Fatalf("Request() error = %v", err)
+	}
+	publish := waitFor5(t, broker.publishes).(*packets.Publish)
+	props := publish.Properties
+	if publish.Topic != req.Topic || !bytes.Equal(publish.Payload, req.Payload) || publish.QoS != QOSAtLeastOnce {
+		t.Errorf("Request() publish = %v", publish)
+	}
+	if props.ResponseTopic != req.ResponseTopic || string(props.CorrelationData) != "a1" || props.MessageExpiry == nil || *props.MessageExpiry != 91 {
+		t.Errorf("Request() properties = %v", props)
+	}
+	if len(props.User) != 1 || props.User[0] != (packets.User{Key: UserPropertyOrgID, Value: "abc"}) {
+		t.Errorf("Request() user properties = %v", props.User)
+	}
+
+	// The response from the device is handled with its properties
+	broker.send(&packets.Publish{
+		Topic:   "devices/pub/aa111",
+		Payload: []byte(`{"success": true}`),
+		Properties: &packets.Properties{
+			CorrelationData: []byte("a1"),
+			User:            []packets.User{{Key: UserPropertyOrgID, Value: "abc"}},
+		},
+	})
+	msg := waitFor5(t, messages).(MQTT.Message)
+	if msg.Topic() != "devices/pub/aa111" || string(msg.Payload()) != `{"success": true}` {
+		t.Errorf("Subscribe() message = %s %s", msg.Topic(), msg.Payload())
+	}
+	if got := MessageProperties(msg); got != (Properties{CorrelationID: "a1", OrgID: "abc"}) {
+		t.Errorf("MessageProperties() = %+v", got)
+	}
+
+	// The client subscribes again when it reconnects
+	broker.drop()
+	waitFor5(t, broker.connects)
+	if topic := waitFor(t, broker.subscribes); topic != "devices/pub/+" {
+		t.Errorf("Reconnect() topic = %v, want %v", topic, "devices/pub/+")
+	}
+	if err := c.Publish("devices/sub/aa111", "{}"); err != nil {
+		t.Errorf("Publish() error = %v", err)
+	}
+	waitFor5(t, broker.publishes)
+
+	status := c.Status()
+	if !status.Connected || status.Connects != 2 || status.Reconnects != 1 || status.ConnectionsLost != 1 || status.Subscriptions != 1 {
+		t.Errorf("Status() = %+v", status)
+	}
+}
+
+func TestNewConnection5_invalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport string
+		port      string
+	}{
+		{"invalid-transport", "invalid", "1883"},
+		{"invalid-broker", "tcp", "1"},
+		{"invalid-tls", "ssl", "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := config.TestConfig()
+			settings.Transport = tt.transport
+			settings.MQTTUrl = "127.0.0.1"
+			settings.MQTTPort = tt.port
+			settings.MQTTConnect.RootCA = nil
+
+			if _, err := NewConnection5(settings); err == nil {
+				t.Error("NewConnection5() expected error, got none")
+			}
+		})
+	}
+}
+
+func TestMessageProperties(t *testing.T) {
+	props := &paho.PublishProperties{CorrelationData: []byte("a1")}
+	props.User.Add(UserPropertyOrgID, "abc")
+
+	tests := []struct {
+		name string
+		msg  MQTT.Message
+		want Properties
+	}{
+		{"v3", &MockMessage{}, Properties{}},
+		{"v5", &message5{&paho.Publish{Properties: props}}, Properties{CorrelationID: "a1", OrgID: "abc"}},
+		{"v5-no-properties", &message5{&paho.Publish{}}, Properties{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MessageProperties(tt.msg); got != tt.want {
+				t.Errorf("MessageProperties() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+type testBroker5 struct {
+	net.Listener
+	port       string
+	connects   chan interface{}
+	publishes  chan interface{}
+	subscribes chan string
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+// newTestBroker5 starts an MQTT v5 broker that acknowledges the connect, subscribe and
+// publish packets
+func newTestBroker5(t *testing.T) *testBroker5 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error starting the broker: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	b := &testBroker5{
+		Listener:   l,
+		port:       port,
+		connects:   make(chan interface{}, 10),
+		publishes:  make(chan interface{}, 10),
+		subscribes: make(chan string, 10),
+	}
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			b.conns = append(b.conns, c)
+			b.mu.Unlock()
+			go b.serve(packets.NewThreadSafeConn(c))
+		}
+	}()
+	return b
+}
+
+// send publishes a message to the clients
+func (b *testBroker5) send(p *packets.Publish) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range b.conns {
+		_, _ = p.WriteTo(c)
+	}
+}
+
+// drop closes the connections of the clients, as when the broker restarts
+func (b *testBroker5) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range b.conns {
+		_ = c.Close()
+	}
+	b.conns = nil
+}
+
+// Close stops the broker and closes the connections of the clients
+func (b *testBroker5) Close() error {
+	err := b.Listener.Close()
+	b.drop()
+	return err
+}
+
+func (b *testBroker5) serve(c net.Conn) {
+	defer c.Close()
+
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	p, err := packets.ReadPacket(c)
+	if err != nil {
+		return
+	}
+	connect, ok := p.Content.(*packets.Connect)
+	if !ok {
+		return
+	}
+	if _, err := packets.NewControlPacket(packets.CONNACK).WriteTo(c); err != nil {
+		return
+	}
+	b.connects <- connect
+
+	// Acknowledge the packets until the client disconnects
+	_ = c.SetDeadline(time.Time{})
+	for {
+		p, err := packets.ReadPacket(c)
+		if err != nil {
+			return
+		}
+
+		var reply *packets.ControlPacket
+		switch p := p.Content.(type) {
+		case *packets.Subscribe:
+			reply = packets.NewControlPacket(packets.SUBACK)
+			ack := reply.Content.(*packets.Suback)
+			ack.PacketID = p.PacketID
+			for topic, opts := range p.Subscriptions {
+				ack.Reasons = append(ack.Reasons, opts.QoS)
+				b.subscribes <- topic
+			}
+		case *packets.Publish:
+			b.publishes <- p
+			if p.QoS == 0 {
+				continue
+			}
+			reply = packets.NewControlPacket(packets.PUBACK)
+			reply.Content.(*packets.Puback).PacketID = p.PacketID
+		case *packets.Pingreq:
+			reply = packets.NewControlPacket(packets.PINGRESP)
+		case *packets.Disconnect:
+			return
+		default:
+			continue
+		}
+		if _, err := reply.WriteTo(c); err != nil {
+			return
+		}
+	}
+}
+
+// waitFor5 waits for a packet from the test broker, or a message from the client
+func waitFor5(t *testing.T, c interface{}) interface{} {
+	timeout := time.After(10 * time.Second)
+	switch c := c.(type) {
+	case chan interface{}:
+		select {
+		case v := <-c:
+			return v
+		case <-timeout:
+		}
+	case chan MQTT.Message:
+		select {
+		case v := <-c:
+			return v
+		case <-timeout:
+		}
+	}
+	t.Fatal("timeout waiting for the broker")
+	return nil
+}
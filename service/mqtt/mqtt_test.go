@@ -20,9 +20,22 @@
 package mqtt
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/canonical/iot-devicetwin/config"
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
 )
 
 func TestGetConnection(t *testing.T) {
@@ -89,3 +102,388 @@ func Test_newTLSConfig(t *testing.T) {
 		})
 	}
 }
+
+func Test_brokerURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport string
+		path      string
+		want      string
+		wantErr   bool
+	}{
+		{"ssl", "ssl", config.DefaultMQTTPath, "ssl://mqtt.example.com:8883", false},
+		{"tcp", "tcp", config.DefaultMQTTPath, "tcp://mqtt.example.com:8883", false},
+		{"ws", "ws", config.DefaultMQTTPath, "ws://mqtt.example.com:8883/mqtt", false},
+		{"wss", "wss", "/", "wss://mqtt.example.com:8883/", false},
+		{"invalid", "udp", config.DefaultMQTTPath, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := config.TestConfig()
+			settings.MQTTUrl = config.DefaultMQTTURL
+			settings.MQTTPort = config.DefaultMQTTPort
+			settings.Transport = tt.transport
+			settings.MQTTPath = tt.path
+
+			got, err := brokerURL(settings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("brokerURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("brokerURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetConnection_Broker(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	other := newTestCA(t, "Other CA")
+	serverCert := ca.issue(t, "mqtt", []string{"localhost", "mqtt.internal"}, x509.ExtKeyUsageServerAuth)
+	clientCert := ca.issue(t, "devicetwin", nil, x509.ExtKeyUsageClientAuth)
+
+	tests := []struct {
+		name       string
+		transport  string
+		host       string
+		rootCA     []byte
+		serverName string
+		username   string
+		wantErr    bool
+	}{
+		{"ssl", "ssl", "localhost", ca.certPEM, "", "", false},
+		{"ssl-server-name", "ssl", "127.0.0.1", ca.certPEM, "mqtt.internal", "", false},
+		{"ssl-username", "ssl", "localhost", ca.certPEM, "", "devicetwin", false},
+		{"tcp", "tcp", "127.0.0.1", nil, "", "devicetwin", false},
+		{"invalid-host", "ssl", "127.0.0.1", ca.certPEM, "", "", true},
+		{"invalid-server-name", "ssl", "localhost", ca.certPEM, "mqtt.example.com", "", true},
+		{"invalid-ca", "ssl", "localhost", other.certPEM, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tlsConfig *tls.Config
+			if config.Transports[tt.transport] {
+				pool := x509.NewCertPool()
+				pool.AddCert(ca.cert)
+				tlsConfig = &tls.Config{
+					Certificates: []tls.Certificate{serverCert.pair},
+					ClientAuth:   tls.RequireAndVerifyClientCert,
+					ClientCAs:    pool,
+				}
+			}
+			broker := newTestBroker(t, tlsConfig)
+			defer broker.Close()
+
+			settings := config.TestConfig()
+			settings.Transport = tt.transport
+			settings.MQTTUrl = tt.host
+			settings.MQTTPort = broker.port
+			settings.MQTTConnect.RootCA = tt.rootCA
+			settings.MQTTConnect.ClientCert = clientCert.certPEM
+			settings.MQTTConnect.ClientKey = clientCert.keyPEM
+			settings.MQTTConnect.ServerName = tt.serverName
+			settings.MQTTConnect.Username = tt.username
+			settings.MQTTConnect.Password = "secret"
+
+			conn, client = nil, nil
+			defer func() { conn, client = nil, nil }()
+
+			c, err := GetConnection(settings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetConnection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			defer c.Close()
+
+			connect := <-broker.connects
+			if connect.ClientIdentifier != settings.MQTTConnect.ClientID {
+				t.Errorf("GetConnection() client ID = %v, want %v", connect.ClientIdentifier, settings.MQTTConnect.ClientID)
+			}
+			if connect.Username != tt.username {
+				t.Errorf("GetConnection() username = %v, want %v", connect.Username, tt.username)
+			}
+			if len(tt.username) > 0 && string(connect.Password) != "secret" {
+				t.Errorf("GetConnection() password = %v, want %v", string(connect.Password), "secret")
+			}
+		})
+	}
+}
+
+func TestConnection_Reconnect(t *testing.T) {
+	broker := newTestBroker(t, nil)
+	defer broker.Close()
+
+	settings := config.TestConfig()
+	settings.Transport = "tcp"
+	settings.MQTTUrl = "127.0.0.1"
+	settings.MQTTPort = broker.port
+
+	conn, client = nil, nil
+	defer func() { conn, client = nil, nil }()
+
+	c, err := GetConnection(settings)
+	if err != nil {
+		t.Fatalf("GetConnection() error = %v", err)
+	}
+	defer c.Close()
+	<-broker.connects
+
+	if err := c.Subscribe("devices/pub/+", func(MQTT.Client, MQTT.Message) {}); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if topic := waitFor(t, broker.subscribes); topic != "devices/pub/+" {
+		t.Errorf("Subscribe() topic = %v, want %v", topic, "devices/pub/+")
+	}
+	if err := c.Publish("devices/sub/a111", "{}"); err != nil {
+		t.Errorf("Publish() error = %v", err)
+	}
+
+	// The broker restarts with a clean session, and the client subscribes again when it reconnects
+	broker.drop()
+	waitFor(t, broker.connects)
+	if topic := waitFor(t, broker.subscribes); topic != "devices/pub/+" {
+		t.Errorf("Reconnect() topic = %v, want %v", topic, "devices/pub/+")
+	}
+
+	status := c.Status()
+	if !status.Connected || status.Connects != 2 || status.Reconnects != 1 || status.ConnectionsLost != 1 || status.Subscriptions != 1 {
+		t.Errorf("Status() = %+v", status)
+	}
+	if len(status.LastError) == 0 || status.LastLost.IsZero() || status.LastConnected.Before(status.LastLost) {
+		t.Errorf("Status() = %+v, want the lost connection", status)
+	}
+}
+
+func TestConnection_PublishDisconnected(t *testing.T) {
+	c := &Connection{state: newState(), client: &MockClient{}}
+
+	if err := c.Publish("devices/sub/a111", "{}"); err != ErrDisconnected {
+		t.Errorf("Publish() error = %v, want %v", err, ErrDisconnected)
+	}
+	status := c.Status()
+	if status.Connected || status.PublishErrors != 1 {
+		t.Errorf("Status() = %+v", status)
+	}
+}
+
+// waitFor waits for a packet from the test broker
+func waitFor(t *testing.T, c interface{}) string {
+	timeout := time.After(10 * time.Second)
+	switch c := c.(type) {
+	case chan string:
+		select {
+		case v := <-c:
+			return v
+		case <-timeout:
+		}
+	case chan *packets.ConnectPacket:
+		select {
+		case v := <-c:
+			return v.ClientIdentifier
+		case <-timeout:
+		}
+	}
+	t.Fatal("timeout waiting for the broker")
+	return ""
+}
+
+func Test_newTLSConfig_invalid(t *testing.T) {
+	settings := config.TestConfig()
+	settings.MQTTConnect.RootCA = []byte("not a certificate")
+	if _, err := newTLSConfig(settings); err == nil {
+		t.Error("newTLSConfig() expected an error for an invalid root CA")
+	}
+
+	settings = config.TestConfig()
+	settings.MQTTConnect.ClientCert = nil
+	settings.MQTTConnect.ClientKey = nil
+	got, err := newTLSConfig(settings)
+	if err != nil {
+		t.Fatalf("newTLSConfig() error = %v", err)
+	}
+	if got.InsecureSkipVerify || len(got.Certificates) != 0 {
+		t.Errorf("newTLSConfig() = %v, want verification and no client certificate", got)
+	}
+}
+
+// testBroker is a local listener that accepts the MQTT connections
+type testBroker struct {
+	net.Listener
+	port       string
+	connects   chan *packets.ConnectPacket
+	subscribes chan string
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+// newTestBroker starts a broker that acknowledges the connect and subscribe packets, with TLS
+// unless the config is nil
+func newTestBroker(t *testing.T, tlsConfig *tls.Config) *testBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error starting the broker: %v", err)
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	b := &testBroker{Listener: l, port: port, connects: make(chan *packets.ConnectPacket, 10), subscribes: make(chan string, 10)}
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			b.conns = append(b.conns, c)
+			b.mu.Unlock()
+			go b.serve(c)
+		}
+	}()
+	return b
+}
+
+// drop closes the connections of the clients, as when the broker restarts
+func (b *testBroker) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range b.conns {
+		_ = c.Close()
+	}
+	b.conns = nil
+}
+
+// Close stops the broker and closes the connections of the clients
+func (b *testBroker) Close() error {
+	err := b.Listener.Close()
+	b.drop()
+	return err
+}
+
+func (b *testBroker) serve(c net.Conn) {
+	defer c.Close()
+
+	// The TLS handshake fails on the first read when the client rejects the broker
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	p, err := packets.ReadPacket(c)
+	if err != nil {
+		return
+	}
+	connect, ok := p.(*packets.ConnectPacket)
+	if !ok {
+		return
+	}
+	if err := packets.NewControlPacket(packets.Connack).Write(c); err != nil {
+		return
+	}
+	b.connects <- connect
+
+	// Acknowledge the packets until the client disconnects
+	_ = c.SetDeadline(time.Time{})
+	for {
+		p, err := packets.ReadPacket(c)
+		if err != nil {
+			return
+		}
+
+		var reply packets.ControlPacket
+		switch p := p.(type) {
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			ack.ReturnCodes = p.Qoss
+			reply = ack
+			for _, topic := range p.Topics {
+				b.subscribes <- topic
+			}
+		case *packets.PublishPacket:
+			ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+			ack.MessageID = p.MessageID
+			reply = ack
+		case *packets.PingreqPacket:
+			reply = packets.NewControlPacket(packets.Pingresp)
+		case *packets.DisconnectPacket:
+			return
+		default:
+			continue
+		}
+		if err := reply.Write(c); err != nil {
+			return
+		}
+	}
+}
+
+// testCert is a certificate and its key, in PEM and for TLS
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+	pair    tls.Certificate
+}
+
+func newTestCA(t *testing.T, name string) *testCert {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	return createTestCert(t, template, nil)
+}
+
+// issue creates a certificate signed by the CA
+func (ca *testCert) issue(t *testing.T, name string, hosts []string, usage x509.ExtKeyUsage) *testCert {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	return createTestCert(t, template, ca)
+}
+
+func createTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating the key: %v", err)
+	}
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("error creating the certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("error parsing the certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("error encoding the key: %v", err)
+	}
+
+	c := &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+	if c.pair, err = tls.X509KeyPair(c.certPEM, c.keyPEM); err != nil {
+		t.Fatalf("error loading the certificate: %v", err)
+	}
+	return c
+}
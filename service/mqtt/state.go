@@ -0,0 +1,107 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mqtt
+
+import (
+	"github.com/canonical/iot-devicetwin/domain"
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"log"
+	"sync"
+	"time"
+)
+
+// state tracks the subscriptions of a connection to the MQTT broker, to restore them when
+// the client reconnects, and the counts of its connections
+type state struct {
+	mu            sync.Mutex
+	subscriptions map[string]MQTT.MessageHandler
+	status        domain.MQTTStats
+}
+
+func newState() *state {
+	return &state{subscriptions: map[string]MQTT.MessageHandler{}}
+}
+
+// subscribed keeps a subscription
+func (s *state) subscribed(topic string, callback MQTT.MessageHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions[topic] = callback
+	s.status.Subscriptions = len(s.subscriptions)
+}
+
+// connected records a connection, returning the subscriptions to restore when it is a
+// reconnection. The handler of the first connection can run after the service has
+// subscribed, so its subscriptions are not restored
+func (s *state) connected() map[string]MQTT.MessageHandler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reconnect := s.status.Connects > 0
+	if reconnect {
+		s.status.Reconnects++
+	}
+	s.status.Connects++
+	s.status.Connected = true
+	s.status.LastConnected = time.Now()
+	if !reconnect {
+		return nil
+	}
+
+	subscriptions := make(map[string]MQTT.MessageHandler, len(s.subscriptions))
+	for topic, callback := range s.subscriptions {
+		subscriptions[topic] = callback
+	}
+	log.Printf("Reconnected to the MQTT broker, restoring %d subscriptions", len(subscriptions))
+	return subscriptions
+}
+
+// lost records the loss of the connection, which the client restores by itself. A client
+// can report several errors for the same loss, which are only counted once
+func (s *state) lost(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.LastError = err.Error()
+	if !s.status.Connected {
+		return
+	}
+	log.Printf("Lost the connection to the MQTT broker: %v", err)
+	s.status.Connected = false
+	s.status.ConnectionsLost++
+	s.status.LastLost = time.Now()
+}
+
+func (s *state) failed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.LastError = err.Error()
+}
+
+func (s *state) publishFailed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.PublishErrors++
+}
+
+// stats returns the state of the connection
+func (s *state) stats() domain.MQTTStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
@@ -20,6 +20,7 @@
 package mqtt
 
 import (
+	"github.com/canonical/iot-devicetwin/domain"
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 	"time"
 )
@@ -100,18 +101,40 @@ func (t *MockToken) Error() error {
 }
 
 // MockConnect is a mock MQTT connection
-type MockConnect struct{}
+type MockConnect struct {
+	Disconnected bool
+	Topics       []string  // the subscribed topics
+	Requests     []Request // the requests sent to devices
+}
 
 // Publish mocks a MQTT publish method
 func (c *MockConnect) Publish(topic, payload string) error {
+	if c.Disconnected {
+		return ErrDisconnected
+	}
+	return nil
+}
+
+// Request mocks a MQTT request to a device
+func (c *MockConnect) Request(req Request) error {
+	if c.Disconnected {
+		return ErrDisconnected
+	}
+	c.Requests = append(c.Requests, req)
 	return nil
 }
 
 // Subscribe mocks a MQTT subscribe method
 func (c *MockConnect) Subscribe(topic string, callback MQTT.MessageHandler) error {
+	c.Topics = append(c.Topics, topic)
 	return nil
 }
 
+// Status mocks the state of the MQTT connection
+func (c *MockConnect) Status() domain.MQTTStats {
+	return domain.MQTTStats{Connected: !c.Disconnected, Connects: 2, Reconnects: 1, ConnectionsLost: 1, Subscriptions: 2}
+}
+
 // Close mocks a MQTT close method
 func (c *MockConnect) Close() {}
 
@@ -119,6 +142,7 @@ func (c *MockConnect) Close() {}
 type MockMessage struct {
 	Message   []byte
 	TopicPath string
+	Props     Properties
 }
 
 // Duplicate mocks a duplicate message check
@@ -141,7 +165,7 @@ func (m *MockMessage) Topic() string {
 	if len(m.TopicPath) > 0 {
 		return m.TopicPath
 	}
-	return "device/pub/aa111"
+	return "devices/pub/aa111"
 }
 
 // MessageID mocks the message ID
@@ -158,3 +182,8 @@ func (m *MockMessage) Payload() []byte {
 func (m *MockMessage) Ack() {
 	panic("implement me")
 }
+
+// Properties mocks the MQTT v5 properties
+func (m *MockMessage) Properties() Properties {
+	return m.Props
+}
@@ -20,21 +20,62 @@
 package web
 
 import (
+	"fmt"
+	"github.com/canonical/iot-devicetwin/domain"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // ActionList is the API call to list actions for a device
 func (wb Service) ActionList(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	actions, err := wb.Controller.ActionList(vars["orgid"], vars["id"])
+	query, err := parseActionQuery(r)
+	if err != nil {
+		formatStandardResponse(CodeBadRequest, err.Error(), w)
+		return
+	}
+
+	actions, err := wb.Controller.ActionList(r.Context(), vars["orgid"], vars["id"], query)
 	if err != nil {
 		log.Printf("Error fetching the actions for `%s`: %v", vars["id"], err)
-		formatStandardResponse("ActionList", "Error fetching the actions", w)
+		formatErrorResponse(err, "Error fetching the actions", w)
 		return
 	}
 
 	formatActionsResponse(actions, w)
 }
+
+// parseActionQuery gets the time range and limit for an action log listing from the query string
+func parseActionQuery(r *http.Request) (domain.ActionQuery, error) {
+	values := r.URL.Query()
+	query := domain.ActionQuery{}
+
+	for _, p := range []struct {
+		name  string
+		value *time.Time
+	}{
+		{"from", &query.From},
+		{"to", &query.To},
+	} {
+		if v := values.Get(p.name); len(v) > 0 {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return query, fmt.Errorf("invalid %s `%s`: %v", p.name, v, err)
+			}
+			*p.value = t
+		}
+	}
+
+	if limit := values.Get("limit"); len(limit) > 0 {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return query, fmt.Errorf("invalid limit `%s`: %v", limit, err)
+		}
+		query.Limit = l
+	}
+	return query, nil
+}
@@ -32,7 +32,12 @@ func TestService_ActionList(t *testing.T) {
 		result string
 	}{
 		{"valid", "/v1/device/abc/c333/actions", 200, ""},
-		{"invalid", "/v1/device/abc/invalid/actions", 400, "ActionList"},
+		{"valid-query", "/v1/device/abc/c333/actions?from=2020-01-02T03:04:05Z&to=2020-02-01T00:00:00Z&limit=10", 200, ""},
+		{"invalid", "/v1/device/abc/invalid/actions", 500, "InternalError"},
+		{"invalid-from", "/v1/device/abc/c333/actions?from=yesterday", 400, "BadRequest"},
+		{"invalid-to", "/v1/device/abc/c333/actions?to=2020-02-01", 400, "BadRequest"},
+		{"invalid-limit-format", "/v1/device/abc/c333/actions?limit=ten", 400, "BadRequest"},
+		{"invalid-limit", "/v1/device/abc/c333/actions?limit=-1", 400, "BadRequest"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -20,35 +20,84 @@
 package web
 
 import (
+	"fmt"
+	"github.com/canonical/iot-devicetwin/domain"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
+	"strconv"
 )
 
 // DeviceGet is the API call to get a device
 func (wb Service) DeviceGet(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	device, err := wb.Controller.DeviceGet(vars["orgid"], vars["id"])
+	device, err := wb.Controller.DeviceGet(r.Context(), vars["orgid"], vars["id"])
 	if err != nil {
 		log.Printf("Error fetching the device `%s`: %v", vars["id"], err)
-		formatStandardResponse("DeviceGet", "Error fetching the device", w)
+		formatErrorResponse(err, "Error fetching the device", w)
 		return
 	}
 
-	formatDeviceResponse(device, w)
+	formatDeviceResponse(device, deviceKeyRequested(r), w)
 }
 
 // DeviceList is the API call to get devices
 func (wb Service) DeviceList(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	devices, err := wb.Controller.DeviceList(vars["orgid"])
+	query, err := parseDeviceQuery(r)
+	if err != nil {
+		log.Printf("Error parsing the device query for `%s`: %v", vars["orgid"], err)
+		formatStandardResponse(CodeBadRequest, "Error fetching devices", w)
+		return
+	}
+
+	devices, err := wb.Controller.DeviceList(r.Context(), vars["orgid"], query)
 	if err != nil {
 		log.Printf("Error fetching the device list for `%s`: %v", vars["orgid"], err)
-		formatStandardResponse("DeviceList", "Error fetching devices", w)
+		formatErrorResponse(err, "Error fetching devices", w)
 		return
 	}
 
-	formatDevicesResponse(devices, w)
+	formatDevicesResponse(devices, deviceKeyRequested(r), w)
+}
+
+// deviceKeyRequested checks if the device keys are requested in the query string, as they
+// are left out of the responses by default
+func deviceKeyRequested(r *http.Request) bool {
+	return r.URL.Query().Get("deviceKey") == "true"
+}
+
+// parseDeviceQuery gets the filters, sort order and page for a device listing from the query string
+func parseDeviceQuery(r *http.Request) (domain.DeviceQuery, error) {
+	values := r.URL.Query()
+	query := domain.DeviceQuery{
+		Brand:         values.Get("brand"),
+		Model:         values.Get("model"),
+		Series:        values.Get("series"),
+		Snap:          values.Get("snap"),
+		Group:         values.Get("group"),
+		Presence:      values.Get("presence"),
+		LabelSelector: values.Get("labelSelector"),
+		Sort:          values.Get("sort"),
+		Cursor:        values.Get("cursor"),
+	}
+
+	if limit := values.Get("limit"); len(limit) > 0 {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return query, fmt.Errorf("invalid limit `%s`: %v", limit, err)
+		}
+		query.Limit = l
+	}
+
+	if revision := values.Get("revision"); len(revision) > 0 {
+		rev, err := strconv.Atoi(revision)
+		if err != nil {
+			return query, fmt.Errorf("invalid revision `%s`: %v", revision, err)
+		}
+		query.SnapRevision = rev
+	}
+	return query, nil
 }
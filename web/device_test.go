@@ -21,6 +21,7 @@ package web
 
 import (
 	"github.com/canonical/iot-devicetwin/config"
+	"strings"
 	"testing"
 )
 
@@ -32,7 +33,7 @@ func TestService_DeviceGet(t *testing.T) {
 		result string
 	}{
 		{"valid", "/v1/device/abc/a111", 200, ""},
-		{"invalid", "/v1/device/abc/invalid", 400, "DeviceGet"},
+		{"invalid", "/v1/device/abc/invalid", 404, "NotFound"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -53,6 +54,33 @@ func TestService_DeviceGet(t *testing.T) {
 	}
 }
 
+func TestService_DeviceKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		withKey bool
+	}{
+		{"get", "/v1/device/abc/a111", false},
+		{"get-with-key", "/v1/device/abc/a111?deviceKey=true", true},
+		{"list", "/v1/device/abc", false},
+		{"list-with-key", "/v1/device/abc?deviceKey=true", true},
+		{"list-invalid-flag", "/v1/device/abc?deviceKey=yes", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewService(config.TestConfig(), testController())
+
+			w := sendRequest("GET", tt.url, nil, wb)
+			if w.Code != 200 {
+				t.Fatalf("Web.DeviceGet() got = %v, want %v", w.Code, 200)
+			}
+			if got := strings.Contains(w.Body.String(), `"deviceKey"`); got != tt.withKey {
+				t.Errorf("Web.DeviceGet() device key = %v, want %v", got, tt.withKey)
+			}
+		})
+	}
+}
+
 func TestService_DeviceList(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -61,7 +89,10 @@ func TestService_DeviceList(t *testing.T) {
 		result string
 	}{
 		{"valid", "/v1/device/abc", 200, ""},
-		{"invalid", "/v1/device/invalid", 400, "DeviceList"},
+		{"valid-query", "/v1/device/abc?model=drone-1000&sort=-lastRefresh&limit=10", 200, ""},
+		{"invalid", "/v1/device/invalid", 500, "InternalError"},
+		{"invalid-limit", "/v1/device/abc?limit=ten", 400, "BadRequest"},
+		{"invalid-sort", "/v1/device/abc?sort=invalid", 400, "BadRequest"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
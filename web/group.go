@@ -36,14 +36,14 @@ func (wb Service) GroupCreate(w http.ResponseWriter, r *http.Request) {
 	group, err := parseGroupRequest(r.Body)
 	if err != nil {
 		log.Printf("Error parsing the group for organization `%s`: %v", vars["orgid"], err)
-		formatStandardResponse("GroupCreate", "Error creating the group", w)
+		formatStandardResponse(CodeBadRequest, "Error creating the group", w)
 		return
 	}
 
-	err = wb.Controller.GroupCreate(vars["orgid"], group.Name)
+	err = wb.Controller.GroupCreate(r.Context(), vars["orgid"], group)
 	if err != nil {
 		log.Printf("Error creating the group for organization `%s`: %v", vars["orgid"], err)
-		formatStandardResponse("GroupCreate", "Error creating the group", w)
+		formatErrorResponse(err, "Error creating the group", w)
 		return
 	}
 
@@ -54,10 +54,10 @@ func (wb Service) GroupCreate(w http.ResponseWriter, r *http.Request) {
 func (wb Service) GroupList(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	groups, err := wb.Controller.GroupList(vars["orgid"])
+	groups, err := wb.Controller.GroupList(r.Context(), vars["orgid"])
 	if err != nil {
 		log.Printf("Error listing the groups for organization `%s`: %v", vars["orgid"], err)
-		formatStandardResponse("GroupList", "Error listing the groups", w)
+		formatErrorResponse(err, "Error listing the groups", w)
 		return
 	}
 
@@ -68,23 +68,57 @@ func (wb Service) GroupList(w http.ResponseWriter, r *http.Request) {
 func (wb Service) GroupGet(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	group, err := wb.Controller.GroupGet(vars["orgid"], vars["name"])
+	group, err := wb.Controller.GroupGet(r.Context(), vars["orgid"], vars["name"])
 	if err != nil {
 		log.Printf("Error fetching the group for organization `%s`: %v", vars["orgid"], err)
-		formatStandardResponse("GroupGet", "Error fetching the group", w)
+		formatErrorResponse(err, "Error fetching the group", w)
 		return
 	}
 
 	formatGroupResponse(group, w)
 }
 
+// GroupUpdate is the API call to rename a group or change its description, parent or rule
+func (wb Service) GroupUpdate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	defer r.Body.Close()
+	group, err := parseGroupRequest(r.Body)
+	if err != nil {
+		log.Printf("Error parsing the group `%s`: %v", vars["name"], err)
+		formatStandardResponse(CodeBadRequest, "Error updating the group", w)
+		return
+	}
+
+	if err := wb.Controller.GroupUpdate(r.Context(), vars["orgid"], vars["name"], group); err != nil {
+		log.Printf("Error updating the group `%s`: %v", vars["name"], err)
+		formatErrorResponse(err, "Error updating the group", w)
+		return
+	}
+
+	formatStandardResponse("", "", w)
+}
+
+// GroupDelete is the API call to delete a group
+func (wb Service) GroupDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := wb.Controller.GroupDelete(r.Context(), vars["orgid"], vars["name"]); err != nil {
+		log.Printf("Error deleting the group `%s`: %v", vars["name"], err)
+		formatErrorResponse(err, "Error deleting the group", w)
+		return
+	}
+
+	formatStandardResponse("", "", w)
+}
+
 // GroupLinkDevice is the API call to link a device to a group
 func (wb Service) GroupLinkDevice(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	if err := wb.Controller.GroupLinkDevice(vars["orgid"], vars["name"], vars["id"]); err != nil {
+	if err := wb.Controller.GroupLinkDevice(r.Context(), vars["orgid"], vars["name"], vars["id"]); err != nil {
 		log.Printf("Error linking the device and group `%s` - `%s`: %v", vars["id"], vars["name"], err)
-		formatStandardResponse("GroupLink", "Error linking the device to the group", w)
+		formatErrorResponse(err, "Error linking the device to the group", w)
 		return
 	}
 
@@ -95,41 +129,97 @@ func (wb Service) GroupLinkDevice(w http.ResponseWriter, r *http.Request) {
 func (wb Service) GroupUnlinkDevice(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	if err := wb.Controller.GroupUnlinkDevice(vars["orgid"], vars["name"], vars["id"]); err != nil {
+	if err := wb.Controller.GroupUnlinkDevice(r.Context(), vars["orgid"], vars["name"], vars["id"]); err != nil {
 		log.Printf("Error unlinking the device and group `%s` - `%s`: %v", vars["id"], vars["name"], err)
-		formatStandardResponse("GroupUnlink", "Error unlinking the device to the group", w)
+		formatErrorResponse(err, "Error unlinking the device to the group", w)
 		return
 	}
 
 	formatStandardResponse("", "", w)
 }
 
+// GroupLinkDevices is the API call to link the devices that match a label selector to a group
+func (wb Service) GroupLinkDevices(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	query, err := parseDeviceQuery(r)
+	if err != nil {
+		log.Printf("Error parsing the device query for group `%s`: %v", vars["name"], err)
+		formatStandardResponse(CodeBadRequest, "Error linking the devices to the group", w)
+		return
+	}
+
+	count, err := wb.Controller.GroupLinkDevices(r.Context(), vars["orgid"], vars["name"], query)
+	if err != nil {
+		log.Printf("Error linking the devices to group `%s`: %v", vars["name"], err)
+		formatErrorResponse(err, "Error linking the devices to the group", w)
+		return
+	}
+
+	formatCountResponse(count, w)
+}
+
+// GroupUnlinkDevices is the API call to unlink the devices that match a label selector from a group
+func (wb Service) GroupUnlinkDevices(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	query, err := parseDeviceQuery(r)
+	if err != nil {
+		log.Printf("Error parsing the device query for group `%s`: %v", vars["name"], err)
+		formatStandardResponse(CodeBadRequest, "Error unlinking the devices from the group", w)
+		return
+	}
+
+	count, err := wb.Controller.GroupUnlinkDevices(r.Context(), vars["orgid"], vars["name"], query)
+	if err != nil {
+		log.Printf("Error unlinking the devices from group `%s`: %v", vars["name"], err)
+		formatErrorResponse(err, "Error unlinking the devices from the group", w)
+		return
+	}
+
+	formatCountResponse(count, w)
+}
+
 // GroupGetDevices is the API call to get the devices for a group
 func (wb Service) GroupGetDevices(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	devices, err := wb.Controller.GroupGetDevices(vars["orgid"], vars["name"])
+	query, err := parseDeviceQuery(r)
+	if err != nil {
+		log.Printf("Error parsing the device query for group `%s`: %v", vars["name"], err)
+		formatStandardResponse(CodeBadRequest, "Error fetching the devices for the group", w)
+		return
+	}
+
+	devices, err := wb.Controller.GroupGetDevices(r.Context(), vars["orgid"], vars["name"], query)
 	if err != nil {
 		log.Printf("Error fetching the devices for group `%s`: %v", vars["name"], err)
-		formatStandardResponse("GroupDevices", "Error fetching the devices for the group", w)
+		formatErrorResponse(err, "Error fetching the devices for the group", w)
 		return
 	}
 
-	formatDevicesResponse(devices, w)
+	formatDevicesResponse(devices, deviceKeyRequested(r), w)
 }
 
 // GroupGetExcludedDevices is the API call to get the devices not in a group
 func (wb Service) GroupGetExcludedDevices(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	devices, err := wb.Controller.GroupGetExcludedDevices(vars["orgid"], vars["name"])
+	query, err := parseDeviceQuery(r)
+	if err != nil {
+		log.Printf("Error parsing the device query for group `%s`: %v", vars["name"], err)
+		formatStandardResponse(CodeBadRequest, "Error fetching the devices not in a group", w)
+		return
+	}
+
+	devices, err := wb.Controller.GroupGetExcludedDevices(r.Context(), vars["orgid"], vars["name"], query)
 	if err != nil {
 		log.Printf("Error fetching the devices for group `%s`: %v", vars["name"], err)
-		formatStandardResponse("GroupDevices", "Error fetching the devices not in a group", w)
+		formatErrorResponse(err, "Error fetching the devices not in a group", w)
 		return
 	}
 
-	formatDevicesResponse(devices, w)
+	formatDevicesResponse(devices, deviceKeyRequested(r), w)
 }
 
 func parseGroupRequest(r io.Reader) (domain.Group, error) {
@@ -41,8 +41,8 @@ func TestService_GroupCreate(t *testing.T) {
 		result string
 	}{
 		{"valid", "/v1/group/abc", "POST", a1, 200, ""},
-		{"invalid-org", "/v1/group/invalid", "POST", a2, 400, "GroupCreate"},
-		{"invalid-body", "/v1/group/abc", "POST", a3, 400, "GroupCreate"},
+		{"invalid-org", "/v1/group/invalid", "POST", a2, 500, "InternalError"},
+		{"invalid-body", "/v1/group/abc", "POST", a3, 400, "BadRequest"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -62,6 +62,41 @@ func TestService_GroupCreate(t *testing.T) {
 	}
 }
 
+func TestService_GroupUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		method string
+		data   io.Reader
+		code   int
+		result string
+	}{
+		{"valid", "/v1/group/abc/workshop", "PUT", strings.NewReader(`{"name":"garage", "description":"Main garage", "parent":"site"}`), 200, ""},
+		{"invalid-org", "/v1/group/invalid/workshop", "PUT", strings.NewReader(`{"name":"garage"}`), 500, "InternalError"},
+		{"invalid-name", "/v1/group/abc/invalid", "PUT", strings.NewReader(`{"name":"garage"}`), 404, "NotFound"},
+		{"invalid-body", "/v1/group/abc/workshop", "PUT", strings.NewReader(`\u1000`), 400, "BadRequest"},
+		{"valid-delete", "/v1/group/abc/workshop", "DELETE", nil, 200, ""},
+		{"invalid-delete-org", "/v1/group/invalid/workshop", "DELETE", nil, 500, "InternalError"},
+		{"invalid-delete-name", "/v1/group/abc/invalid", "DELETE", nil, 404, "NotFound"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewService(config.TestConfig(), testController())
+			w := sendRequest(tt.method, tt.url, tt.data, wb)
+			if w.Code != tt.code {
+				t.Errorf("Web.GroupUpdate() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseStandardResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.GroupUpdate() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.GroupUpdate() got = %v, want %v", resp.Code, tt.result)
+			}
+		})
+	}
+}
+
 func TestService_GroupList(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -72,7 +107,7 @@ func TestService_GroupList(t *testing.T) {
 		result string
 	}{
 		{"valid", "/v1/group/abc", "GET", nil, 200, ""},
-		{"valid-org", "/v1/group/invalid", "GET", nil, 400, "GroupList"},
+		{"valid-org", "/v1/group/invalid", "GET", nil, 500, "InternalError"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -102,8 +137,8 @@ func TestService_GroupGet(t *testing.T) {
 		result string
 	}{
 		{"valid", "/v1/group/abc/workshop", "GET", nil, 200, ""},
-		{"invalid-org", "/v1/group/invalid/workshop", "GET", nil, 400, "GroupGet"},
-		{"invalid-name", "/v1/group/abc/invalid", "GET", nil, 400, "GroupGet"},
+		{"invalid-org", "/v1/group/invalid/workshop", "GET", nil, 500, "InternalError"},
+		{"invalid-name", "/v1/group/abc/invalid", "GET", nil, 404, "NotFound"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -133,8 +168,8 @@ func TestService_GroupLinkDevice(t *testing.T) {
 		result string
 	}{
 		{"valid", "/v1/group/abc/workshop/c333", "POST", nil, 200, ""},
-		{"invalid-org", "/v1/group/invalid/workshop/c333", "POST", nil, 400, "GroupLink"},
-		{"invalid-name", "/v1/group/abc/invalid/c333", "POST", nil, 400, "GroupLink"},
+		{"invalid-org", "/v1/group/invalid/workshop/c333", "POST", nil, 500, "InternalError"},
+		{"invalid-name", "/v1/group/abc/invalid/c333", "POST", nil, 500, "InternalError"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -164,8 +199,8 @@ func TestService_GroupUnlinkDevice(t *testing.T) {
 		result string
 	}{
 		{"valid", "/v1/group/abc/workshop/c333", "DELETE", nil, 200, ""},
-		{"invalid-org", "/v1/group/invalid/workshop/c333", "DELETE", nil, 400, "GroupUnlink"},
-		{"invalid-name", "/v1/group/abc/invalid/c333", "DELETE", nil, 400, "GroupUnlink"},
+		{"invalid-org", "/v1/group/invalid/workshop/c333", "DELETE", nil, 500, "InternalError"},
+		{"invalid-name", "/v1/group/abc/invalid/c333", "DELETE", nil, 500, "InternalError"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -185,6 +220,38 @@ func TestService_GroupUnlinkDevice(t *testing.T) {
 	}
 }
 
+func TestService_GroupLinkDevices(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		method string
+		code   int
+		result string
+	}{
+		{"valid-link", "/v1/group/abc/workshop/devices?labelSelector=site%3Dberlin", "POST", 200, ""},
+		{"invalid-link-selector", "/v1/group/abc/workshop/devices", "POST", 500, "InternalError"},
+		{"invalid-link-limit", "/v1/group/abc/workshop/devices?labelSelector=site&limit=x", "POST", 400, "BadRequest"},
+		{"valid-unlink", "/v1/group/abc/workshop/devices?labelSelector=site%3Dberlin", "DELETE", 200, ""},
+		{"invalid-unlink-org", "/v1/group/invalid/workshop/devices?labelSelector=site", "DELETE", 500, "InternalError"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewService(config.TestConfig(), testController())
+			w := sendRequest(tt.method, tt.url, nil, wb)
+			if w.Code != tt.code {
+				t.Errorf("Web.GroupLinkDevices() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseStandardResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.GroupLinkDevices() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.GroupLinkDevices() got = %v, want %v", resp.Code, tt.result)
+			}
+		})
+	}
+}
+
 func TestService_GroupGetDevices(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -195,8 +262,8 @@ func TestService_GroupGetDevices(t *testing.T) {
 		result string
 	}{
 		{"valid", "/v1/group/abc/workshop/devices", "GET", nil, 200, ""},
-		{"invalid-org", "/v1/group/invalid/workshop/devices", "GET", nil, 400, "GroupDevices"},
-		{"invalid-name", "/v1/group/abc/invalid/devices", "GET", nil, 400, "GroupDevices"},
+		{"invalid-org", "/v1/group/invalid/workshop/devices", "GET", nil, 500, "InternalError"},
+		{"invalid-name", "/v1/group/abc/invalid/devices", "GET", nil, 500, "InternalError"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -226,8 +293,8 @@ func TestService_GroupGetExcludedDevices(t *testing.T) {
 		result string
 	}{
 		{"valid", "/v1/group/abc/workshop/devices/excluded", "GET", nil, 200, ""},
-		{"invalid-org", "/v1/group/invalid/workshop/devices/excluded", "GET", nil, 400, "GroupDevices"},
-		{"invalid-name", "/v1/group/abc/invalid/devices/excluded", "GET", nil, 400, "GroupDevices"},
+		{"invalid-org", "/v1/group/invalid/workshop/devices/excluded", "GET", nil, 500, "InternalError"},
+		{"invalid-name", "/v1/group/abc/invalid/devices/excluded", "GET", nil, 500, "InternalError"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,63 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package web
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// SnapInventory is the API call to summarize the snaps installed across the devices of an organization
+func (wb Service) SnapInventory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	snaps, err := wb.Controller.SnapInventory(r.Context(), vars["orgid"])
+	if err != nil {
+		log.Printf("Error fetching the snap inventory for `%s`: %v", vars["orgid"], err)
+		formatErrorResponse(err, "Error fetching the snap inventory", w)
+		return
+	}
+
+	formatInventoryResponse(snaps, w)
+}
+
+// SnapInventoryDevices is the API call to list the devices that have a snap installed,
+// optionally at a specific revision
+func (wb Service) SnapInventoryDevices(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	query, err := parseDeviceQuery(r)
+	if err != nil {
+		log.Printf("Error parsing the device query for snap `%s`: %v", vars["snap"], err)
+		formatStandardResponse(CodeBadRequest, "Error fetching the devices for the snap", w)
+		return
+	}
+	query.Snap = vars["snap"]
+
+	devices, err := wb.Controller.DeviceList(r.Context(), vars["orgid"], query)
+	if err != nil {
+		log.Printf("Error fetching the devices for snap `%s`: %v", vars["snap"], err)
+		formatErrorResponse(err, "Error fetching the devices for the snap", w)
+		return
+	}
+
+	formatDevicesResponse(devices, deviceKeyRequested(r), w)
+}
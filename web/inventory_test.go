@@ -0,0 +1,57 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package web
+
+import (
+	"testing"
+
+	"github.com/canonical/iot-devicetwin/config"
+)
+
+func TestService_SnapInventory(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		code   int
+		result string
+	}{
+		{"valid", "/v1/inventory/abc/snaps", 200, ""},
+		{"invalid-org", "/v1/inventory/invalid/snaps", 500, "InternalError"},
+		{"valid-devices", "/v1/inventory/abc/snaps/example-snap?revision=12", 200, ""},
+		{"invalid-devices-org", "/v1/inventory/invalid/snaps/example-snap", 500, "InternalError"},
+		{"invalid-revision", "/v1/inventory/abc/snaps/example-snap?revision=twelve", 400, "BadRequest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewService(config.TestConfig(), testController())
+
+			w := sendRequest("GET", tt.url, nil, wb)
+			if w.Code != tt.code {
+				t.Errorf("Web.SnapInventory() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseStandardResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.SnapInventory() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.SnapInventory() got = %v, want %v", resp.Code, tt.result)
+			}
+		})
+	}
+}
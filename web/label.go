@@ -0,0 +1,61 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+)
+
+// DeviceLabelsSet is the API call to create or update labels on a device
+func (wb Service) DeviceLabelsSet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	defer r.Body.Close()
+	labels := map[string]string{}
+	if err := json.NewDecoder(r.Body).Decode(&labels); err != nil {
+		log.Printf("Error parsing the labels for `%s`: %v", vars["id"], err)
+		formatStandardResponse(CodeBadRequest, "Error setting the device labels", w)
+		return
+	}
+
+	if err := wb.Controller.DeviceLabelsSet(r.Context(), vars["orgid"], vars["id"], labels); err != nil {
+		log.Printf("Error setting the labels for `%s`: %v", vars["id"], err)
+		formatErrorResponse(err, "Error setting the device labels", w)
+		return
+	}
+
+	formatStandardResponse("", "", w)
+}
+
+// DeviceLabelDelete is the API call to remove a label from a device
+func (wb Service) DeviceLabelDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := wb.Controller.DeviceLabelDelete(r.Context(), vars["orgid"], vars["id"], vars["key"]); err != nil {
+		log.Printf("Error removing the label `%s` for `%s`: %v", vars["key"], vars["id"], err)
+		formatErrorResponse(err, "Error removing the device label", w)
+		return
+	}
+
+	formatStandardResponse("", "", w)
+}
@@ -0,0 +1,61 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/canonical/iot-devicetwin/config"
+)
+
+func TestService_DeviceLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		method string
+		data   io.Reader
+		code   int
+		result string
+	}{
+		{"valid-set", "/v1/device/abc/a111/labels", "PUT", strings.NewReader(`{"site":"berlin"}`), 200, ""},
+		{"invalid-set", "/v1/device/abc/invalid/labels", "PUT", strings.NewReader(`{"site":"berlin"}`), 500, "InternalError"},
+		{"invalid-set-body", "/v1/device/abc/a111/labels", "PUT", strings.NewReader(`["site"]`), 400, "BadRequest"},
+		{"valid-delete", "/v1/device/abc/a111/labels/site", "DELETE", nil, 200, ""},
+		{"invalid-delete", "/v1/device/abc/invalid/labels/site", "DELETE", nil, 500, "InternalError"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewService(config.TestConfig(), testController())
+			w := sendRequest(tt.method, tt.url, tt.data, wb)
+			if w.Code != tt.code {
+				t.Errorf("Web.DeviceLabels() got = %v, want %v", w.Code, tt.code)
+			}
+			resp, err := parseStandardResponse(w.Body)
+			if err != nil {
+				t.Errorf("Web.DeviceLabels() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.DeviceLabels() got = %v, want %v", resp.Code, tt.result)
+			}
+		})
+	}
+}
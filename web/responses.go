@@ -21,7 +21,10 @@ package web
 
 import (
 	"encoding/json"
+	"errors"
+	"github.com/canonical/iot-devicetwin/datastore"
 	"github.com/canonical/iot-devicetwin/domain"
+	"github.com/canonical/iot-devicetwin/service/mqtt"
 	"log"
 	"net/http"
 )
@@ -29,6 +32,26 @@ import (
 // JSONHeader is the header for JSON responses
 const JSONHeader = "application/json; charset=UTF-8"
 
+// The machine-readable codes for the errors from the API, set in the code of the response
+const (
+	CodeBadRequest  = "BadRequest"
+	CodeNotFound    = "NotFound"
+	CodeConflict    = "Conflict"
+	CodeForbidden   = "Forbidden"
+	CodeInternal    = "InternalError"
+	CodeUnavailable = "Unavailable"
+)
+
+// codeStatus maps the error codes to the HTTP status of the response
+var codeStatus = map[string]int{
+	CodeBadRequest:  http.StatusBadRequest,
+	CodeNotFound:    http.StatusNotFound,
+	CodeConflict:    http.StatusConflict,
+	CodeForbidden:   http.StatusForbidden,
+	CodeInternal:    http.StatusInternalServerError,
+	CodeUnavailable: http.StatusServiceUnavailable,
+}
+
 // StandardResponse is the JSON response from an API method, indicating success or failure.
 type StandardResponse struct {
 	Code    string `json:"code"`
@@ -41,6 +64,12 @@ type SnapsResponse struct {
 	Snaps []domain.DeviceSnap `json:"snaps"`
 }
 
+// InventoryResponse is the JSON response to summarize the snaps of an organization
+type InventoryResponse struct {
+	StandardResponse
+	Snaps []domain.SnapInventory `json:"snaps"`
+}
+
 // DeviceResponse is the JSON response to get a device
 type DeviceResponse struct {
 	StandardResponse
@@ -51,6 +80,7 @@ type DeviceResponse struct {
 type DevicesResponse struct {
 	StandardResponse
 	Devices []domain.Device `json:"devices"`
+	Next    string          `json:"next,omitempty"`
 }
 
 // ActionsResponse is the JSON response to list actions for a device
@@ -71,19 +101,73 @@ type GroupResponse struct {
 	Group domain.Group `json:"group"`
 }
 
+// CountResponse is the JSON response from a bulk operation, with the number of records affected
+type CountResponse struct {
+	StandardResponse
+	Count int `json:"count"`
+}
+
+// ImportResponse is the JSON response from an import, with the number of records imported
+type ImportResponse struct {
+	StandardResponse
+	Imported domain.ImportSummary `json:"imported"`
+}
+
+// StatsResponse is the JSON response with the statistics of the service
+type StatsResponse struct {
+	StandardResponse
+	Stats domain.Stats `json:"stats"`
+}
+
+// HealthResponse is the JSON response with the state of the connections of the service
+type HealthResponse struct {
+	StandardResponse
+	MQTT domain.MQTTStats `json:"mqtt"`
+}
+
 // formatStandardResponse returns a JSON response from an API method, indicating success or failure
 func formatStandardResponse(code, message string, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", JSONHeader)
 	response := StandardResponse{Code: code, Message: message}
 
 	if len(code) > 0 {
-		w.WriteHeader(http.StatusBadRequest)
+		status, ok := codeStatus[code]
+		if !ok {
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
 	}
 
 	// Encode the response as JSON
 	encodeResponse(w, response)
 }
 
+// formatErrorResponse returns a JSON response for an error from the services, with the
+// status and code for the kind of error
+func formatErrorResponse(err error, message string, w http.ResponseWriter) {
+	formatStandardResponse(errorCode(err), message, w)
+}
+
+// errorCode gets the machine-readable code for an error from the services. Errors
+// of an unknown kind are unexpected failures, e.g. of the database. A request to a
+// device fails as unavailable while the service is disconnected from the broker
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, datastore.ErrNotFound):
+		return CodeNotFound
+	case errors.Is(err, datastore.ErrConflict):
+		return CodeConflict
+	case errors.Is(err, datastore.ErrForbidden):
+		return CodeForbidden
+	case errors.Is(err, datastore.ErrInvalid):
+		return CodeBadRequest
+	case errors.Is(err, mqtt.ErrDisconnected):
+		return CodeUnavailable
+	default:
+		return CodeInternal
+	}
+}
+
 // formatSnapsResponse returns a JSON response from a snap list API method
 func formatSnapsResponse(snaps []domain.DeviceSnap, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", JSONHeader)
@@ -93,19 +177,38 @@ func formatSnapsResponse(snaps []domain.DeviceSnap, w http.ResponseWriter) {
 	encodeResponse(w, response)
 }
 
-// formatDeviceResponse returns a JSON response from a device get API method
-func formatDeviceResponse(device domain.Device, w http.ResponseWriter) {
+// formatInventoryResponse returns a JSON response from a snap inventory API method
+func formatInventoryResponse(snaps []domain.SnapInventory, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", JSONHeader)
+	response := InventoryResponse{StandardResponse{}, snaps}
+
+	// Encode the response as JSON
+	encodeResponse(w, response)
+}
+
+// formatDeviceResponse returns a JSON response from a device get API method, leaving out
+// the device key unless it was requested
+func formatDeviceResponse(device domain.Device, withKey bool, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", JSONHeader)
+	if !withKey {
+		device.DeviceKey = ""
+	}
 	response := DeviceResponse{StandardResponse{}, device}
 
 	// Encode the response as JSON
 	encodeResponse(w, response)
 }
 
-// formatDevicesResponse returns a JSON response from a device list API method
-func formatDevicesResponse(devices []domain.Device, w http.ResponseWriter) {
+// formatDevicesResponse returns a JSON response from a device list API method, leaving out
+// the device keys unless they were requested
+func formatDevicesResponse(page domain.DevicePage, withKey bool, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", JSONHeader)
-	response := DevicesResponse{StandardResponse{}, devices}
+	if !withKey {
+		for i := range page.Devices {
+			page.Devices[i].DeviceKey = ""
+		}
+	}
+	response := DevicesResponse{StandardResponse{}, page.Devices, page.Next}
 
 	// Encode the response as JSON
 	encodeResponse(w, response)
@@ -138,6 +241,47 @@ func formatActionsResponse(actions []domain.Action, w http.ResponseWriter) {
 	encodeResponse(w, response)
 }
 
+// formatCountResponse returns a JSON response from a bulk operation API method
+func formatCountResponse(count int, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", JSONHeader)
+	response := CountResponse{StandardResponse{}, count}
+
+	// Encode the response as JSON
+	encodeResponse(w, response)
+}
+
+// formatImportResponse returns a JSON response from an import API method
+func formatImportResponse(summary domain.ImportSummary, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", JSONHeader)
+	response := ImportResponse{StandardResponse{}, summary}
+
+	// Encode the response as JSON
+	encodeResponse(w, response)
+}
+
+// formatStatsResponse returns a JSON response from the statistics API method
+func formatStatsResponse(stats domain.Stats, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", JSONHeader)
+	response := StatsResponse{StandardResponse{}, stats}
+
+	// Encode the response as JSON
+	encodeResponse(w, response)
+}
+
+// formatHealthResponse returns a JSON response from the health API method, which fails
+// while the service is disconnected from the MQTT broker
+func formatHealthResponse(stats domain.MQTTStats, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", JSONHeader)
+	response := HealthResponse{StandardResponse{}, stats}
+	if !stats.Connected {
+		response.StandardResponse = StandardResponse{Code: CodeUnavailable, Message: "not connected to the MQTT broker"}
+		w.WriteHeader(codeStatus[CodeUnavailable])
+	}
+
+	// Encode the response as JSON
+	encodeResponse(w, response)
+}
+
 func encodeResponse(w http.ResponseWriter, response interface{}) {
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
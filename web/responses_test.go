@@ -0,0 +1,63 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/canonical/iot-devicetwin/datastore"
+)
+
+func TestFormatErrorResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"not-found", datastore.NotFound("device with ID `a111` not found"), 404, CodeNotFound},
+		{"conflict", datastore.Conflict("group `workshop` already exists"), 409, CodeConflict},
+		{"forbidden", datastore.Forbidden("the organization ID does not match the device"), 403, CodeForbidden},
+		{"invalid", datastore.Invalid("invalid sort order `size`"), 400, CodeBadRequest},
+		{"wrapped", fmt.Errorf("error finding group: %w", datastore.NotFound("group not found")), 404, CodeNotFound},
+		{"unknown", fmt.Errorf("connection refused"), 500, CodeInternal},
+		{"deadline", context.DeadlineExceeded, 500, CodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			formatErrorResponse(tt.err, "Error message", w)
+
+			if w.Code != tt.status {
+				t.Errorf("formatErrorResponse() status = %v, want %v", w.Code, tt.status)
+			}
+			resp, err := parseStandardResponse(w.Body)
+			if err != nil {
+				t.Fatalf("Error parsing response: %v", err)
+			}
+			if resp.Code != tt.code || resp.Message != "Error message" {
+				t.Errorf("formatErrorResponse() = %v, want %v", resp, tt.code)
+			}
+		})
+	}
+}
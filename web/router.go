@@ -20,6 +20,7 @@
 package web
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"time"
@@ -37,6 +38,8 @@ func (wb Service) Router() *mux.Router {
 	router.Handle("/v1/device/{orgid}", Middleware(http.HandlerFunc(wb.DeviceList))).Methods("GET")
 	router.Handle("/v1/device/{orgid}/{id}", Middleware(http.HandlerFunc(wb.DeviceGet))).Methods("GET")
 	router.Handle("/v1/device/{orgid}/{id}/actions", Middleware(http.HandlerFunc(wb.ActionList))).Methods("GET")
+	router.Handle("/v1/device/{orgid}/{id}/labels", Middleware(http.HandlerFunc(wb.DeviceLabelsSet))).Methods("PUT")
+	router.Handle("/v1/device/{orgid}/{id}/labels/{key}", Middleware(http.HandlerFunc(wb.DeviceLabelDelete))).Methods("DELETE")
 
 	// Actions on a device
 	router.Handle("/v1/device/{orgid}/{id}/snaps/list", Middleware(http.HandlerFunc(wb.SnapListPublish))).Methods("POST")
@@ -45,15 +48,32 @@ func (wb Service) Router() *mux.Router {
 	router.Handle("/v1/device/{orgid}/{id}/snaps/{snap}/settings", Middleware(http.HandlerFunc(wb.SnapUpdateConf))).Methods("PUT")
 	router.Handle("/v1/device/{orgid}/{id}/snaps/{snap}/{action}", Middleware(http.HandlerFunc(wb.SnapUpdateAction))).Methods("PUT")
 
+	// Fleet-wide inventory
+	router.Handle("/v1/inventory/{orgid}/snaps", Middleware(http.HandlerFunc(wb.SnapInventory))).Methods("GET")
+	router.Handle("/v1/inventory/{orgid}/snaps/{snap}", Middleware(http.HandlerFunc(wb.SnapInventoryDevices))).Methods("GET")
+
 	// Actions on a group
 	router.Handle("/v1/group/{orgid}", Middleware(http.HandlerFunc(wb.GroupCreate))).Methods("POST")
 	router.Handle("/v1/group/{orgid}", Middleware(http.HandlerFunc(wb.GroupList))).Methods("GET")
 	router.Handle("/v1/group/{orgid}/{name}", Middleware(http.HandlerFunc(wb.GroupGet))).Methods("GET")
+	router.Handle("/v1/group/{orgid}/{name}", Middleware(http.HandlerFunc(wb.GroupUpdate))).Methods("PUT")
+	router.Handle("/v1/group/{orgid}/{name}", Middleware(http.HandlerFunc(wb.GroupDelete))).Methods("DELETE")
+	router.Handle("/v1/group/{orgid}/{name}/devices", Middleware(http.HandlerFunc(wb.GroupLinkDevices))).Methods("POST")
+	router.Handle("/v1/group/{orgid}/{name}/devices", Middleware(http.HandlerFunc(wb.GroupUnlinkDevices))).Methods("DELETE")
 	router.Handle("/v1/group/{orgid}/{name}/{id}", Middleware(http.HandlerFunc(wb.GroupLinkDevice))).Methods("POST")
 	router.Handle("/v1/group/{orgid}/{name}/{id}", Middleware(http.HandlerFunc(wb.GroupUnlinkDevice))).Methods("DELETE")
 	router.Handle("/v1/group/{orgid}/{name}/devices", Middleware(http.HandlerFunc(wb.GroupGetDevices))).Methods("GET")
 	router.Handle("/v1/group/{orgid}/{name}/devices/excluded", Middleware(http.HandlerFunc(wb.GroupGetExcludedDevices))).Methods("GET")
 
+	// Moving the records of an organization between data stores
+	router.Handle("/v1/admin/{orgid}/export", Middleware(http.HandlerFunc(wb.Export))).Methods("GET")
+	router.Handle("/v1/admin/{orgid}/import", Middleware(http.HandlerFunc(wb.Import))).Methods("POST")
+
+	// Statistics of the running service
+	router.Handle("/v1/admin/stats", Middleware(http.HandlerFunc(wb.Stats))).Methods("GET")
+	router.Handle("/v1/health", Middleware(http.HandlerFunc(wb.Health))).Methods("GET")
+
+	router.Use(wb.Deadline)
 	return router
 }
 
@@ -67,6 +87,21 @@ func Logger(start time.Time, r *http.Request) {
 	)
 }
 
+// Deadline limits the time for handling a request. The context of the request is cancelled
+// when the deadline passes or the client goes away, which aborts the calls to the data store
+func (wb Service) Deadline(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wb.Settings.Timeout <= 0 {
+			inner.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), wb.Settings.Timeout)
+		defer cancel()
+		inner.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 // Middleware to pre-process web service requests
 func Middleware(inner http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
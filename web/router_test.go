@@ -0,0 +1,58 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/canonical/iot-devicetwin/config"
+)
+
+func TestService_Deadline(t *testing.T) {
+	tests := []struct {
+		name         string
+		timeout      time.Duration
+		wantDeadline bool
+	}{
+		{"valid", 5 * time.Second, true},
+		{"valid-no-timeout", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := config.TestConfig()
+			settings.Timeout = tt.timeout
+			wb := NewService(settings, testController())
+
+			var hasDeadline bool
+			inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, hasDeadline = r.Context().Deadline()
+			})
+
+			r, _ := http.NewRequest("GET", "/v1/device/abc", nil)
+			wb.Deadline(inner).ServeHTTP(httptest.NewRecorder(), r)
+			if hasDeadline != tt.wantDeadline {
+				t.Errorf("Deadline() has deadline = %v, want %v", hasDeadline, tt.wantDeadline)
+			}
+		})
+	}
+}
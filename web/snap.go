@@ -30,10 +30,10 @@ import (
 func (wb Service) SnapList(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	installed, err := wb.Controller.DeviceSnaps(vars["orgid"], vars["id"])
+	installed, err := wb.Controller.DeviceSnaps(r.Context(), vars["orgid"], vars["id"])
 	if err != nil {
 		log.Println("Error fetching snaps for a device:", err)
-		formatStandardResponse("SnapList", "Error fetching snaps for the device", w)
+		formatErrorResponse(err, "Error fetching snaps for the device", w)
 		return
 	}
 
@@ -44,9 +44,9 @@ func (wb Service) SnapList(w http.ResponseWriter, r *http.Request) {
 func (wb Service) SnapListPublish(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	if err := wb.Controller.DeviceSnapList(vars["orgid"], vars["id"]); err != nil {
+	if err := wb.Controller.DeviceSnapList(r.Context(), vars["orgid"], vars["id"]); err != nil {
 		log.Println("Error requesting snap list for the device:", err)
-		formatStandardResponse("SnapList", "Error requesting snap list for the device", w)
+		formatErrorResponse(err, "Error requesting snap list for the device", w)
 		return
 	}
 
@@ -57,9 +57,9 @@ func (wb Service) SnapListPublish(w http.ResponseWriter, r *http.Request) {
 func (wb Service) SnapInstall(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	if err := wb.Controller.DeviceSnapInstall(vars["orgid"], vars["id"], vars["snap"]); err != nil {
+	if err := wb.Controller.DeviceSnapInstall(r.Context(), vars["orgid"], vars["id"], vars["snap"]); err != nil {
 		log.Println("Error requesting snap install for the device:", err)
-		formatStandardResponse("SnapInstall", "Error requesting snap install for the device", w)
+		formatErrorResponse(err, "Error requesting snap install for the device", w)
 		return
 	}
 
@@ -70,9 +70,9 @@ func (wb Service) SnapInstall(w http.ResponseWriter, r *http.Request) {
 func (wb Service) SnapRemove(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	if err := wb.Controller.DeviceSnapRemove(vars["orgid"], vars["id"], vars["snap"]); err != nil {
+	if err := wb.Controller.DeviceSnapRemove(r.Context(), vars["orgid"], vars["id"], vars["snap"]); err != nil {
 		log.Println("Error requesting snap remove for the device:", err)
-		formatStandardResponse("SnapRemove", "Error requesting snap remove for the device", w)
+		formatErrorResponse(err, "Error requesting snap remove for the device", w)
 		return
 	}
 
@@ -83,9 +83,9 @@ func (wb Service) SnapRemove(w http.ResponseWriter, r *http.Request) {
 func (wb Service) SnapUpdateAction(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	if err := wb.Controller.DeviceSnapUpdate(vars["orgid"], vars["id"], vars["snap"], vars["action"]); err != nil {
+	if err := wb.Controller.DeviceSnapUpdate(r.Context(), vars["orgid"], vars["id"], vars["snap"], vars["action"]); err != nil {
 		log.Println("Error requesting snap update for the device:", err)
-		formatStandardResponse("SnapUpdate", "Error requesting snap update for the device", w)
+		formatErrorResponse(err, "Error requesting snap update for the device", w)
 		return
 	}
 
@@ -99,14 +99,14 @@ func (wb Service) SnapUpdateConf(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		log.Println("Error reading snap config body:", err)
-		formatStandardResponse("SnapSetConf", "Error requesting snap settings update for the device", w)
+		formatStandardResponse(CodeBadRequest, "Error requesting snap settings update for the device", w)
 		return
 	}
 	defer r.Body.Close()
 
-	if err := wb.Controller.DeviceSnapConf(vars["orgid"], vars["id"], vars["snap"], string(body)); err != nil {
+	if err := wb.Controller.DeviceSnapConf(r.Context(), vars["orgid"], vars["id"], vars["snap"], string(body)); err != nil {
 		log.Println("Error requesting snap settings update for the device:", err)
-		formatStandardResponse("SnapSetConf", "Error requesting snap settings update for the device", w)
+		formatErrorResponse(err, "Error requesting snap settings update for the device", w)
 		return
 	}
 
@@ -42,7 +42,7 @@ func TestService_SnapList(t *testing.T) {
 		result string
 	}{
 		{"valid", "/v1/device/abc/a111/snaps", 200, ""},
-		{"invalid", "/v1/device/abc/invalid/snaps", 400, "SnapList"},
+		{"invalid", "/v1/device/abc/invalid/snaps", 500, "InternalError"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -74,20 +74,20 @@ func TestService_SnapActions(t *testing.T) {
 		result string
 	}{
 		{"valid-install", "/v1/device/abc/a111/snaps/helloworld", "POST", nil, 200, ""},
-		{"invalid-install", "/v1/device/abc/invalid/snaps/helloworld", "POST", nil, 400, "SnapInstall"},
+		{"invalid-install", "/v1/device/abc/invalid/snaps/helloworld", "POST", nil, 404, "NotFound"},
 
 		{"valid-remove", "/v1/device/abc/a111/snaps/helloworld", "DELETE", nil, 200, ""},
-		{"invalid-remove", "/v1/device/abc/invalid/snaps/helloworld", "DELETE", nil, 400, "SnapRemove"},
+		{"invalid-remove", "/v1/device/abc/invalid/snaps/helloworld", "DELETE", nil, 404, "NotFound"},
 
 		{"valid-update-enable", "/v1/device/abc/a111/snaps/helloworld/enable", "PUT", nil, 200, ""},
-		{"invalid-update-enable", "/v1/device/abc/invalid/snaps/helloworld/enable", "PUT", nil, 400, "SnapUpdate"},
+		{"invalid-update-enable", "/v1/device/abc/invalid/snaps/helloworld/enable", "PUT", nil, 404, "NotFound"},
 		{"valid-update-disable", "/v1/device/abc/a111/snaps/helloworld/disable", "PUT", nil, 200, ""},
-		{"invalid-update-disable", "/v1/device/abc/invalid/snaps/helloworld/disable", "PUT", nil, 400, "SnapUpdate"},
+		{"invalid-update-disable", "/v1/device/abc/invalid/snaps/helloworld/disable", "PUT", nil, 404, "NotFound"},
 		{"valid-update-refresh", "/v1/device/abc/a111/snaps/helloworld/refresh", "PUT", nil, 200, ""},
-		{"invalid-update-refresh", "/v1/device/abc/invalid/snaps/helloworld/refresh", "PUT", nil, 400, "SnapUpdate"},
-		{"invalid-update-invalid", "/v1/device/abc/a111/snaps/helloworld/invalid", "PUT", nil, 400, "SnapUpdate"},
+		{"invalid-update-refresh", "/v1/device/abc/invalid/snaps/helloworld/refresh", "PUT", nil, 404, "NotFound"},
+		{"invalid-update-invalid", "/v1/device/abc/a111/snaps/helloworld/invalid", "PUT", nil, 400, "BadRequest"},
 		{"valid-update-settings", "/v1/device/abc/a111/snaps/helloworld/settings", "PUT", strings.NewReader(settings1), 200, ""},
-		{"invalid-update-settings", "/v1/device/abc/invalid/snaps/helloworld/settings", "PUT", strings.NewReader(settings1), 400, "SnapSetConf"},
+		{"invalid-update-settings", "/v1/device/abc/invalid/snaps/helloworld/settings", "PUT", strings.NewReader(settings1), 404, "NotFound"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -106,3 +106,20 @@ func TestService_SnapActions(t *testing.T) {
 		})
 	}
 }
+
+func TestService_SnapActionsDisconnected(t *testing.T) {
+	ctrl := controller.NewService(config.TestConfig(), &mqtt.MockConnect{Disconnected: true}, &devicetwin.MockDeviceTwin{})
+	wb := NewService(config.TestConfig(), ctrl)
+
+	w := sendRequest("POST", "/v1/device/abc/a111/snaps/helloworld", nil, wb)
+	if w.Code != 503 {
+		t.Errorf("Web.SnapInstall() got = %v, want 503", w.Code)
+	}
+	resp, err := parseStandardResponse(w.Body)
+	if err != nil {
+		t.Errorf("Web.SnapInstall() got = %v", err)
+	}
+	if resp.Code != CodeUnavailable {
+		t.Errorf("Web.SnapInstall() got = %v, want %v", resp.Code, CodeUnavailable)
+	}
+}
@@ -0,0 +1,31 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package web
+
+import "net/http"
+
+// Stats is the API call to fetch the statistics of the running service
+func (wb Service) Stats(w http.ResponseWriter, r *http.Request) {
+	formatStatsResponse(wb.Controller.Stats(), w)
+}
+
+// Health is the API call to check that the service is connected to the MQTT broker
+func (wb Service) Health(w http.ResponseWriter, r *http.Request) {
+	formatHealthResponse(wb.Controller.Stats().MQTT, w)
+}
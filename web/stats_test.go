@@ -0,0 +1,84 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/service/controller"
+	"github.com/canonical/iot-devicetwin/service/devicetwin"
+	"github.com/canonical/iot-devicetwin/service/mqtt"
+)
+
+func TestService_Stats(t *testing.T) {
+	wb := NewService(config.TestConfig(), testController())
+
+	w := sendRequest("GET", "/v1/admin/stats", nil, wb)
+	if w.Code != 200 {
+		t.Fatalf("Web.Stats() got = %v, want 200", w.Code)
+	}
+
+	result := StatsResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("Web.Stats() error = %v", err)
+	}
+	if result.Stats.Cache.Devices.Hits != 10 || result.Stats.Cache.Versions.Misses != 3 {
+		t.Errorf("Web.Stats() = %+v", result.Stats)
+	}
+	if !result.Stats.MQTT.Connected || result.Stats.MQTT.Reconnects != 1 {
+		t.Errorf("Web.Stats() MQTT = %+v", result.Stats.MQTT)
+	}
+}
+
+func TestService_Health(t *testing.T) {
+	tests := []struct {
+		name         string
+		disconnected bool
+		code         int
+		result       string
+	}{
+		{"connected", false, 200, ""},
+		{"disconnected", true, 503, "Unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := controller.NewService(config.TestConfig(), &mqtt.MockConnect{Disconnected: tt.disconnected}, &devicetwin.MockDeviceTwin{})
+			wb := NewService(config.TestConfig(), ctrl)
+
+			w := sendRequest("GET", "/v1/health", nil, wb)
+			if w.Code != tt.code {
+				t.Fatalf("Web.Health() got = %v, want %v", w.Code, tt.code)
+			}
+
+			result := HealthResponse{}
+			if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+				t.Fatalf("Web.Health() error = %v", err)
+			}
+			if result.Code != tt.result {
+				t.Errorf("Web.Health() code = %v, want %v", result.Code, tt.result)
+			}
+			if result.MQTT.Connected == tt.disconnected || result.MQTT.Subscriptions != 2 {
+				t.Errorf("Web.Health() MQTT = %+v", result.MQTT)
+			}
+		})
+	}
+}
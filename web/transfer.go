@@ -0,0 +1,155 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/canonical/iot-devicetwin/domain"
+	"github.com/canonical/iot-devicetwin/service/devicetwin"
+	"github.com/gorilla/mux"
+)
+
+// CSVHeader is the header for CSV responses
+const CSVHeader = "text/csv; charset=UTF-8"
+
+// maxImportSize is the largest request body that can be imported
+const maxImportSize = 256 << 20
+
+// Export is the API call to download the records of an organization, as versioned JSON or as
+// CSV with just the devices. The device keys are left out unless they are requested
+func (wb Service) Export(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	format, err := transferFormat(r)
+	if err != nil {
+		log.Printf("Error exporting `%s`: %v", vars["orgid"], err)
+		formatStandardResponse(CodeBadRequest, "Error exporting the organization", w)
+		return
+	}
+
+	resp := &exportResponse{w: w, filename: "devicetwin-" + vars["orgid"] + "." + format, contentType: JSONHeader}
+	if format == "csv" {
+		resp.contentType = CSVHeader
+	}
+	enc := devicetwin.NewExportEncoder(resp, vars["orgid"], format)
+	if !deviceKeyRequested(r) {
+		enc = withoutDeviceKeys{enc}
+	}
+
+	err = wb.Controller.Export(r.Context(), vars["orgid"], enc)
+	if err == nil {
+		err = enc.Close()
+	}
+	if err != nil {
+		log.Printf("Error exporting `%s`: %v", vars["orgid"], err)
+		// The status cannot be changed once the export has started
+		if !resp.started {
+			formatErrorResponse(err, "Error exporting the organization", w)
+		}
+	}
+}
+
+// exportResponse streams an export as a file download, setting the headers on the first write
+type exportResponse struct {
+	w           http.ResponseWriter
+	filename    string
+	contentType string
+	started     bool
+}
+
+func (resp *exportResponse) Write(b []byte) (int, error) {
+	if !resp.started {
+		resp.started = true
+		resp.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", resp.filename))
+		resp.w.Header().Set("Content-Type", resp.contentType)
+	}
+	return resp.w.Write(b)
+}
+
+// withoutDeviceKeys leaves out the device keys of an export
+type withoutDeviceKeys struct {
+	devicetwin.ExportEncoder
+}
+
+func (e withoutDeviceKeys) WriteDevice(d domain.ExportDevice) error {
+	d.DeviceKey = ""
+	return e.ExportEncoder.WriteDevice(d)
+}
+
+// Import is the API call to create the records of an organization from an export, as versioned
+// JSON or as CSV with just the devices. The records of an earlier import are updated, so a failed
+// import can be run again
+func (wb Service) Import(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	defer r.Body.Close()
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	format, err := transferFormat(r)
+	if err != nil {
+		log.Printf("Error importing `%s`: %v", vars["orgid"], err)
+		formatStandardResponse(CodeBadRequest, "Error importing the organization", w)
+		return
+	}
+
+	data := domain.Export{}
+	if format == "csv" {
+		data.Version = domain.ExportVersion
+		data.Devices, err = devicetwin.ReadDevicesCSV(body)
+	} else {
+		err = json.NewDecoder(body).Decode(&data)
+	}
+	if err != nil {
+		log.Printf("Error parsing the import for `%s`: %v", vars["orgid"], err)
+		formatStandardResponse(CodeBadRequest, "Error importing the organization", w)
+		return
+	}
+
+	summary, err := wb.Controller.Import(r.Context(), vars["orgid"], data)
+	if err != nil {
+		log.Printf("Error importing `%s`: %v", vars["orgid"], err)
+		formatErrorResponse(err, "Error importing the organization", w)
+		return
+	}
+
+	formatImportResponse(summary, w)
+}
+
+// transferFormat gets the format of an export or import from the query string, or from the
+// content type of an import. The default is JSON
+func transferFormat(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 && strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		format = "csv"
+	}
+
+	switch format {
+	case "", "json":
+		return "json", nil
+	case "csv":
+		return "csv", nil
+	default:
+		return "", fmt.Errorf("invalid format `%s`, expected json or csv", format)
+	}
+}
@@ -0,0 +1,111 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * This file is part of the IoT Device Twin Service
+ * Copyright 2019 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License version 3, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY,
+ * SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/canonical/iot-devicetwin/config"
+	"github.com/canonical/iot-devicetwin/domain"
+)
+
+func TestService_Export(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		code        int
+		contentType string
+		body        string
+	}{
+		{"valid", "/v1/admin/abc/export", 200, JSONHeader, `"deviceId":"a111"`},
+		{"valid-json", "/v1/admin/abc/export?format=json", 200, JSONHeader, `"version":1`},
+		{"valid-key", "/v1/admin/abc/export?deviceKey=true", 200, JSONHeader, `"deviceKey":"AAAAAAAAA"`},
+		{"valid-csv", "/v1/admin/abc/export?format=csv", 200, CSVHeader, "a111,example,drone-1000,,,,,,site=berlin\n"},
+		{"valid-csv-key", "/v1/admin/abc/export?format=csv&deviceKey=true", 200, CSVHeader, "a111,example,drone-1000,,,AAAAAAAAA,,,site=berlin\n"},
+		{"invalid-format", "/v1/admin/abc/export?format=xml", 400, JSONHeader, "BadRequest"},
+		{"invalid-org", "/v1/admin/invalid/export", 500, JSONHeader, "InternalError"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewService(config.TestConfig(), testController())
+
+			w := sendRequest("GET", tt.url, nil, wb)
+			if w.Code != tt.code {
+				t.Errorf("Web.Export() got = %v, want %v", w.Code, tt.code)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Web.Export() content type = %v, want %v", got, tt.contentType)
+			}
+			if !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("Web.Export() body = %v, want %v", w.Body.String(), tt.body)
+			}
+			if tt.code == 200 && !strings.Contains(tt.url, "deviceKey") && strings.Contains(w.Body.String(), "AAAAAAAAA") {
+				t.Errorf("Web.Export() body = %v, want no device key", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestService_Import(t *testing.T) {
+	export, _ := json.Marshal(domain.Export{
+		Version: domain.ExportVersion,
+		Devices: []domain.ExportDevice{{Device: domain.Device{DeviceID: "a111"}}, {Device: domain.Device{DeviceID: "b222"}}},
+		Groups:  []domain.Group{{Name: "workshop"}},
+	})
+	tests := []struct {
+		name    string
+		url     string
+		data    string
+		code    int
+		result  string
+		devices int
+	}{
+		{"valid", "/v1/admin/abc/import", string(export), 200, "", 2},
+		{"valid-csv", "/v1/admin/abc/import?format=csv", "deviceId,brand,model\na111,example,drone-1000\n", 200, "", 1},
+		{"invalid-version", "/v1/admin/abc/import", `{"version":99}`, 400, "BadRequest", 0},
+		{"invalid-json", "/v1/admin/abc/import", `{`, 400, "BadRequest", 0},
+		{"invalid-csv", "/v1/admin/abc/import?format=csv", "deviceId,colour\na111,red\n", 400, "BadRequest", 0},
+		{"invalid-format", "/v1/admin/abc/import?format=xml", "", 400, "BadRequest", 0},
+		{"invalid-org", "/v1/admin/invalid/import", string(export), 500, "InternalError", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wb := NewService(config.TestConfig(), testController())
+
+			w := sendRequest("POST", tt.url, bytes.NewBufferString(tt.data), wb)
+			if w.Code != tt.code {
+				t.Errorf("Web.Import() got = %v, want %v", w.Code, tt.code)
+			}
+			resp := ImportResponse{}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Errorf("Web.Import() got = %v", err)
+			}
+			if resp.Code != tt.result {
+				t.Errorf("Web.Import() got = %v, want %v", resp.Code, tt.result)
+			}
+			if resp.Imported.Devices != tt.devices {
+				t.Errorf("Web.Import() devices = %v, want %v", resp.Imported.Devices, tt.devices)
+			}
+		})
+	}
+}